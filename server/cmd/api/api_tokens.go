@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func (app *application) listAPITokens(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	tokens, err := app.models.APITokens.FindAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAPIToken creates an API token for the current user. The token grants lasting access to
+// the account, so the user must reauthenticate, and its plaintext is only returned this once.
+func (app *application) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		reauthentication
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	token := &data.APIToken{
+		Name:   input.Name,
+		Scopes: input.Scopes,
+	}
+
+	v := validator.New()
+	if input.ExpiresInDays != nil {
+		v.Check(*input.ExpiresInDays >= 1, "expires_in_days", "Must be greater than zero")
+		v.Check(*input.ExpiresInDays <= 365, "expires_in_days", "Must not be more than 365")
+		token.Expiry = pgtype.Timestamptz{
+			Time:  time.Now().AddDate(0, 0, *input.ExpiresInDays),
+			Valid: true,
+		}
+	}
+
+	if data.ValidateAPIToken(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.checkReauthentication(w, r, input.reauthentication)
+	if !ok {
+		return
+	}
+
+	token.UserID = user.ID
+
+	err = app.models.APITokens.New(token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logAuthEvent(r, "api_token_created", "user_id", user.ID, "api_token_id", token.ID, "scopes", token.Scopes)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "token_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	err = app.models.APITokens.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logAuthEvent(r, "api_token_revoked", "user_id", user.ID, "api_token_id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
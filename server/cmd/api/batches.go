@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+)
+
+// readBatchActions reads and validates the actions of a batch request. It sends the error
+// response and returns nil if the request is invalid.
+func (app *application) readBatchActions(w http.ResponseWriter, r *http.Request, permittedActions ...string) []*data.BatchAction {
+	var input struct {
+		Actions []*data.BatchAction `json:"actions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil
+	}
+
+	v := validator.New()
+	if data.ValidateBatchActions(v, input.Actions, permittedActions...); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	// Results are reported by the server and are not accepted from the client
+	for _, action := range input.Actions {
+		action.Status = ""
+		action.Error = ""
+	}
+
+	return input.Actions
+}
+
+// batchFeedFollows follows and unfollows many feeds in a single transaction. The result of each
+// action is reported in the response, and actions on feeds which do not exist do not fail the batch.
+func (app *application) batchFeedFollows(w http.ResponseWriter, r *http.Request) {
+	actions := app.readBatchActions(w, r, data.BatchActionFollow, data.BatchActionUnfollow)
+	if actions == nil {
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	err := app.models.Batches.ApplyFeedFollowActions(user.ID, actions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var followedFeedIDs []int64
+	for _, action := range actions {
+		if action.Action == data.BatchActionFollow && action.Status == data.BatchStatusOK {
+			followedFeedIDs = append(followedFeedIDs, action.ID)
+		}
+	}
+	if len(followedFeedIDs) > 0 {
+		app.background(func() {
+			for _, feedID := range followedFeedIDs {
+				feed, err := app.models.Feeds.FindByID(feedID)
+				if err != nil {
+					app.logger.Error(err.Error())
+					continue
+				}
+				if app.isFeedStale(feed) {
+					app.RefreshFeed(feed)
+				}
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": actions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// batchWallFeeds adds feeds to and removes feeds from a wall in a single transaction
+func (app *application) batchWallFeeds(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	actions := app.readBatchActions(w, r, data.BatchActionAdd, data.BatchActionRemove)
+	if actions == nil {
+		return
+	}
+
+	if app.findWallForMember(w, r, wallID, data.WallRoleEditor) == nil {
+		return
+	}
+
+	err = app.models.Batches.ApplyWallFeedActions(wallID, actions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": actions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// batchItems saves, likes and marks many items as read (or undoes them) in a single transaction
+func (app *application) batchItems(w http.ResponseWriter, r *http.Request) {
+	actions := app.readBatchActions(w, r,
+		data.BatchActionSave, data.BatchActionUnsave,
+		data.BatchActionLike, data.BatchActionUnlike,
+		data.BatchActionRead, data.BatchActionUnread,
+	)
+	if actions == nil {
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	err := app.models.Batches.ApplyItemActions(user.ID, actions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": actions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
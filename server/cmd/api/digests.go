@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/aravindmathradan/semaphore/public"
+)
+
+const (
+	digestBatchSize   = 500
+	digestMaxAttempts = 3
+	// digestMaxDelay is how late a digest may be sent, e.g. after the server was down. Older
+	// digests are dropped, since a summary of yesterday is of little use by the evening.
+	digestMaxDelay = 6 * time.Hour
+	// digestSendsRetention is how long the log of sent digests is kept
+	digestSendsRetention = 90 * 24 * time.Hour
+)
+
+// digestItem is an item as listed in the digest email templates
+type digestItem struct {
+	Title     string
+	Link      string
+	FeedTitle string
+}
+
+func (app *application) SendDigests() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("digests scheduler shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			app.sendDueDigests()
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.digests.period)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
+
+// sendDueDigests sends the digests of all the subscriptions whose latest period has not been
+// sent yet
+func (app *application) sendDueDigests() {
+	err := app.models.DigestSends.Cleanup(time.Now().Add(-digestSendsRetention))
+	if err != nil {
+		app.logInternalError("app.models.DigestSends.Cleanup failed", err)
+	}
+
+	var afterUserID int64
+	for {
+		subscriptions, err := app.models.DigestSubscriptions.FindAfter(afterUserID, digestBatchSize)
+		if err != nil {
+			app.logInternalError("app.models.DigestSubscriptions.FindAfter failed", err)
+			return
+		}
+
+		for _, subscription := range subscriptions {
+			select {
+			case <-app.ctx.Done():
+				return
+			default:
+				app.sendDigest(subscription, time.Now())
+			}
+		}
+
+		if len(subscriptions) < digestBatchSize {
+			return
+		}
+		afterUserID = subscriptions[len(subscriptions)-1].UserID
+	}
+}
+
+// sendDigest sends the digest of the latest period of a subscription if it is due. The period is
+// claimed in the send log first, so it is sent at most once.
+func (app *application) sendDigest(subscription *data.DigestSubscription, now time.Time) {
+	periodStart, periodEnd := subscription.LatestPeriod(now)
+
+	if subscription.LastPeriodEnd.Valid && !subscription.LastPeriodEnd.Time.Before(periodEnd) {
+		return
+	}
+	// The first digest is the one of the first period which ends after the user subscribed
+	if periodEnd.Before(subscription.CreatedAt) || now.Sub(periodEnd) > digestMaxDelay {
+		return
+	}
+
+	sendID, claimed, err := app.models.DigestSends.Claim(subscription.UserID, periodStart, periodEnd, digestMaxAttempts)
+	if err != nil {
+		app.logInternalError("app.models.DigestSends.Claim failed", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	items, err := app.models.Items.FindForDigest(subscription.UserID, subscription.WallIDs, subscription.Content, periodStart, periodEnd, subscription.MaxItems)
+	if err != nil {
+		app.failDigestSend(sendID, fmt.Errorf("finding digest items failed: %w", err))
+		return
+	}
+
+	if len(items) == 0 {
+		err = app.models.DigestSends.MarkEmpty(sendID)
+		if err != nil {
+			app.logInternalError("app.models.DigestSends.MarkEmpty failed", err)
+		}
+		return
+	}
+
+	digestItems := make([]digestItem, len(items))
+	for i, item := range items {
+		feedTitle := item.Feed.Title
+		if item.Feed.DisplayTitle.Valid {
+			feedTitle = item.Feed.DisplayTitle.String
+		}
+		digestItems[i] = digestItem{
+			Title:     item.Title,
+			Link:      item.Link,
+			FeedTitle: feedTitle,
+		}
+	}
+
+	unsubscribeURL := app.digestUnsubscribeURL(subscription.UserID)
+	templateData := map[string]any{
+		"name":           subscription.User.FullName,
+		"username":       subscription.User.Username,
+		"frequency":      subscription.Frequency,
+		"content":        subscription.Content,
+		"items":          digestItems,
+		"unsubscribeURL": unsubscribeURL,
+	}
+	// Mail clients show an unsubscribe button for the List-Unsubscribe header, and POST to the
+	// URL without opening it when List-Unsubscribe-Post is set (RFC 8058)
+	headers := map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+
+	err = app.mailer.SendWithHeaders(subscription.User.Email, "digest.tmpl", templateData, headers)
+	if err != nil {
+		app.failDigestSend(sendID, fmt.Errorf("sending digest email failed: %w", err))
+		return
+	}
+
+	err = app.models.DigestSends.MarkSent(sendID, len(items))
+	if err != nil {
+		app.logInternalError("app.models.DigestSends.MarkSent failed", err)
+	}
+}
+
+func (app *application) failDigestSend(sendID int64, cause error) {
+	app.logInternalError("digest send failed", cause)
+
+	err := app.models.DigestSends.MarkFailed(sendID, cause.Error())
+	if err != nil {
+		app.logInternalError("app.models.DigestSends.MarkFailed failed", err)
+	}
+}
+
+func (app *application) digestUnsubscribeURL(userID int64) string {
+	token := data.NewDigestUnsubscribeToken(userID, []byte(app.config.digests.signingSecret))
+	return fmt.Sprintf("%s/public/digests/unsubscribe?token=%s", app.config.baseURL, url.QueryEscape(token))
+}
+
+func (app *application) getDigestSubscription(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	subscription, err := app.models.DigestSubscriptions.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"digest": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateDigestSubscription subscribes the current user to digests or replaces the preferences of
+// their subscription. Omitted fields are reset to their defaults.
+func (app *application) updateDigestSubscription(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	var input struct {
+		Frequency   string  `json:"frequency"`
+		Content     *string `json:"content"`
+		WallIDs     []int64 `json:"wall_ids"`
+		Timezone    *string `json:"timezone"`
+		SendHour    *int    `json:"send_hour"`
+		SendWeekday *int    `json:"send_weekday"`
+		MaxItems    *int    `json:"max_items"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	subscription := &data.DigestSubscription{
+		UserID:      user.ID,
+		Frequency:   input.Frequency,
+		Content:     data.DigestContentUnread,
+		WallIDs:     []int64{},
+		Timezone:    "UTC",
+		SendHour:    8,
+		SendWeekday: int(time.Monday),
+		MaxItems:    10,
+	}
+	if input.Content != nil {
+		subscription.Content = *input.Content
+	}
+	if input.WallIDs != nil {
+		subscription.WallIDs = input.WallIDs
+	}
+	if input.Timezone != nil {
+		subscription.Timezone = *input.Timezone
+	}
+	if input.SendHour != nil {
+		subscription.SendHour = *input.SendHour
+	}
+	if input.SendWeekday != nil {
+		subscription.SendWeekday = *input.SendWeekday
+	}
+	if input.MaxItems != nil {
+		subscription.MaxItems = *input.MaxItems
+	}
+
+	v := validator.New()
+	if data.ValidateDigestSubscription(v, subscription); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.DigestSubscriptions.Upsert(subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("wall_ids", "Must only contain walls you are a member of")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"digest": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteDigestSubscription(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	err := app.models.DigestSubscriptions.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsubscribeFromDigests unsubscribes the user of a signed unsubscribe token from digests. It
+// serves both the link in digest emails and the one-click unsubscribe POST of mail clients.
+func (app *application) unsubscribeFromDigests(w http.ResponseWriter, r *http.Request) {
+	token := app.readString(r.URL.Query(), "token", "")
+
+	userID, err := data.ParseDigestUnsubscribeToken(token, []byte(app.config.digests.signingSecret))
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.DigestSubscriptions.Delete(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	content, err := public.Html.ReadFile("html/pages/digest-unsubscribed.html")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(content)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/mailer"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	emailClaimLimit   = 20
+	emailLease        = 2 * time.Minute
+	emailMaxAttempts  = 8
+	emailRetryBackoff = time.Minute
+	// emailStuckAfter is how long an email may stay pending before it is listed as stuck
+	emailStuckAfter    = 15 * time.Minute
+	emailStuckPageSize = 100
+	// emailOutboxRetention is how long sent emails are kept in the outbox
+	emailOutboxRetention = 7 * 24 * time.Hour
+)
+
+// emailOutboxStore is the part of the outbox emails are dispatched with
+type emailOutboxStore interface {
+	ClaimDue(limit int, lease time.Duration) ([]*data.OutboxEmail, error)
+	MarkSent(id int64) error
+	MarkFailed(id int64, lastError string) error
+	Retry(id int64, lastError string, maxAttempts int, backoff time.Duration) error
+}
+
+// initMailer creates the mailer with the configured transport. Development setups can write
+// emails to a maildir or only log them, so that no SMTP server is needed.
+func (app *application) initMailer() error {
+	var transport mailer.Transport
+
+	switch app.config.mail.transport {
+	case "smtp":
+		transport = mailer.NewSMTPTransport(
+			app.config.smtp.host,
+			app.config.smtp.port,
+			app.config.smtp.username,
+			app.config.smtp.password,
+		)
+	case "file":
+		if app.config.mail.dir == "" {
+			return errors.New("mail-dir must be set for the file mail transport")
+		}
+		fileTransport, err := mailer.NewFileTransport(app.config.mail.dir)
+		if err != nil {
+			return err
+		}
+		transport = fileTransport
+	case "log":
+		transport = mailer.NewLogTransport(app.logger)
+	default:
+		return fmt.Errorf("unknown mail transport %q", app.config.mail.transport)
+	}
+
+	app.mailer = mailer.New(transport, app.config.smtp.sender, app.config.mail.templatesDir)
+	return nil
+}
+
+func (app *application) DispatchEmails() {
+	var lastCleanup time.Time
+
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("email dispatcher shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			if startTime.Sub(lastCleanup) > time.Hour {
+				err := app.models.EmailOutbox.Cleanup(startTime.Add(-emailOutboxRetention))
+				if err != nil {
+					app.logInternalError("app.models.EmailOutbox.Cleanup failed", err)
+				}
+				lastCleanup = startTime
+			}
+			app.dispatchDueEmails()
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.emails.dispatchPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
+
+// dispatchDueEmails sends the due emails of the outbox. Emails which the mail server rejects for
+// good are failed right away, others are retried with exponential backoff.
+func (app *application) dispatchDueEmails() {
+	for {
+		emails, err := app.emailOutbox.ClaimDue(emailClaimLimit, emailLease)
+		if err != nil {
+			app.logInternalError("app.models.EmailOutbox.ClaimDue failed", err)
+			return
+		}
+
+		for _, email := range emails {
+			err = app.mailer.Deliver(email.Recipient, email.Template, email.Data)
+			switch {
+			case err == nil:
+				err = app.emailOutbox.MarkSent(email.ID)
+				if err != nil {
+					app.logInternalError("app.models.EmailOutbox.MarkSent failed", err)
+				}
+			case mailer.IsPermanent(err):
+				app.logInternalError(fmt.Sprintf("sending email %d failed permanently", email.ID), err)
+				err = app.emailOutbox.MarkFailed(email.ID, err.Error())
+				if err != nil {
+					app.logInternalError("app.models.EmailOutbox.MarkFailed failed", err)
+				}
+			default:
+				app.logInternalError(fmt.Sprintf("sending email %d failed", email.ID), err)
+				err = app.emailOutbox.Retry(email.ID, err.Error(), emailMaxAttempts, emailRetryBackoff)
+				if err != nil {
+					app.logInternalError("app.models.EmailOutbox.Retry failed", err)
+				}
+			}
+		}
+
+		if len(emails) < emailClaimLimit {
+			return
+		}
+	}
+}
+
+// listStuckEmails lists the emails which failed for good or have been pending for a while,
+// for admins to look into the mail server or the recipients
+func (app *application) listStuckEmails(w http.ResponseWriter, r *http.Request) {
+	emails, err := app.models.EmailOutbox.FindStuck(emailStuckAfter, emailStuckPageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// emailPreviewData is the sample data email templates are rendered with for previews. It holds
+// the fields of all templates, so that any of them can be previewed.
+var emailPreviewData = map[string]any{
+	"username":           "ada",
+	"activationToken":    "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	"passwordResetToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	"inviterUsername":    "grace",
+	"inviterFullName":    "Grace Hopper",
+	"wallName":           "Compilers",
+	"role":               "editor",
+	"frequency":          "daily",
+	"content":            "unread",
+	"deletionDate":       "January 2, 2006",
+	"unsubscribeURL":     "https://example.com/public/digests/unsubscribe?token=preview",
+	"lockoutDuration":    "15 minutes",
+	"ipAddress":          "192.0.2.1",
+	"unlockURL":          "https://example.com/public/accounts/unlock?token=preview",
+	"items": []map[string]any{
+		{"Title": "Go 1.23 is released", "Link": "https://go.dev/blog/go1.23", "FeedTitle": "The Go Blog"},
+		{"Title": "Range over function types", "Link": "https://go.dev/blog/range-functions", "FeedTitle": "The Go Blog"},
+	},
+	"notifications": []map[string]any{
+		{"Title": "Go 1.23 is released", "Link": "https://go.dev/blog/go1.23", "FeedTitle": "The Go Blog"},
+		{"Title": "Range over function types", "Link": "https://go.dev/blog/range-functions", "FeedTitle": "The Go Blog"},
+	},
+}
+
+// previewEmail renders an email template with sample data, as HTML or with ?format=text as
+// plain text. It is only routed in development, for working on templates.
+func (app *application) previewEmail(w http.ResponseWriter, r *http.Request) {
+	templateFile := httprouter.ParamsFromContext(r.Context()).ByName("template")
+	if !app.mailer.TemplateExists(templateFile) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	rendered, err := app.mailer.Render(templateFile, emailPreviewData)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch app.readString(r.URL.Query(), "format", "html") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(rendered.HTMLBody))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Subject: %s\n\n%s", rendered.Subject, rendered.PlainBody)
+	default:
+		app.badRequestResponse(w, r, errors.New("format must be html or text"))
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/mailer"
+)
+
+func newInvitationEmail(id int64) *data.OutboxEmail {
+	return &data.OutboxEmail{
+		ID:        id,
+		Recipient: "alice@example.com",
+		Template:  "wall_invitation.tmpl",
+		Data: map[string]any{
+			"username":        "alice",
+			"inviterFullName": "Bob",
+			"inviterUsername": "bob",
+			"wallName":        "News",
+			"role":            "viewer",
+		},
+	}
+}
+
+func TestDispatchDueEmails(t *testing.T) {
+	t.Run("sends due emails", func(t *testing.T) {
+		transport := mailer.NewMemoryTransport()
+		app, models := newTestApplication(t)
+		app.mailer = mailer.New(transport, "Semaphore <no-reply@example.com>", "")
+		outbox := &models.outbox
+		outbox.due = []*data.OutboxEmail{newInvitationEmail(1), newInvitationEmail(2)}
+
+		app.dispatchDueEmails()
+
+		messages := transport.Messages()
+		if len(messages) != 2 {
+			t.Fatalf("got %d messages; want 2", len(messages))
+		}
+		if to := messages[0].GetHeader("To"); len(to) != 1 || to[0] != "alice@example.com" {
+			t.Errorf("got recipient %v; want alice@example.com", to)
+		}
+		if subject := messages[0].GetHeader("Subject"); len(subject) != 1 || subject[0] != "Bob invited you to a wall on Semaphore" {
+			t.Errorf("got subject %v", subject)
+		}
+		if len(outbox.sent) != 2 || len(outbox.failed) != 0 || len(outbox.retried) != 0 {
+			t.Errorf("got sent %v, failed %v and retried %v; want both emails sent", outbox.sent, outbox.failed, outbox.retried)
+		}
+	})
+
+	t.Run("claims emails in batches", func(t *testing.T) {
+		var emails []*data.OutboxEmail
+		for i := range emailClaimLimit + 1 {
+			emails = append(emails, newInvitationEmail(int64(i+1)))
+		}
+		transport := mailer.NewMemoryTransport()
+		app, models := newTestApplication(t)
+		app.mailer = mailer.New(transport, "Semaphore <no-reply@example.com>", "")
+		outbox := &models.outbox
+		outbox.due = emails
+
+		app.dispatchDueEmails()
+
+		if len(outbox.sent) != emailClaimLimit+1 {
+			t.Errorf("got %d sent emails; want %d", len(outbox.sent), emailClaimLimit+1)
+		}
+	})
+
+	t.Run("fails rejected recipients for good", func(t *testing.T) {
+		transport := mailer.NewMemoryTransport()
+		transport.Err = &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+		app, models := newTestApplication(t)
+		app.mailer = mailer.New(transport, "Semaphore <no-reply@example.com>", "")
+		outbox := &models.outbox
+		outbox.due = []*data.OutboxEmail{newInvitationEmail(1)}
+
+		app.dispatchDueEmails()
+
+		if _, ok := outbox.failed[1]; !ok {
+			t.Fatalf("got failed %v and retried %v; want the email failed", outbox.failed, outbox.retried)
+		}
+		if len(outbox.retried) != 0 {
+			t.Errorf("permanently failed email was retried")
+		}
+	})
+
+	t.Run("fails broken templates for good", func(t *testing.T) {
+		email := newInvitationEmail(1)
+		email.Template = "missing.tmpl"
+		transport := mailer.NewMemoryTransport()
+		app, models := newTestApplication(t)
+		app.mailer = mailer.New(transport, "Semaphore <no-reply@example.com>", "")
+		outbox := &models.outbox
+		outbox.due = []*data.OutboxEmail{email}
+
+		app.dispatchDueEmails()
+
+		if _, ok := outbox.failed[1]; !ok {
+			t.Fatalf("got failed %v; want the email failed", outbox.failed)
+		}
+		if len(transport.Messages()) != 0 {
+			t.Errorf("email with a broken template was sent")
+		}
+	})
+
+	t.Run("retries temporary failures with backoff", func(t *testing.T) {
+		transport := mailer.NewMemoryTransport()
+		transport.Err = errors.New("connection refused")
+		app, models := newTestApplication(t)
+		app.mailer = mailer.New(transport, "Semaphore <no-reply@example.com>", "")
+		outbox := &models.outbox
+		outbox.due = []*data.OutboxEmail{newInvitationEmail(1)}
+
+		app.dispatchDueEmails()
+
+		retried, ok := outbox.retried[1]
+		if !ok {
+			t.Fatalf("got retried %v and failed %v; want the email retried", outbox.retried, outbox.failed)
+		}
+		if retried.maxAttempts != emailMaxAttempts || retried.backoff != emailRetryBackoff {
+			t.Errorf("got retry with max attempts %d and backoff %s; want %d and %s",
+				retried.maxAttempts, retried.backoff, emailMaxAttempts, emailRetryBackoff)
+		}
+		if !strings.Contains(retried.lastError, "connection refused") {
+			t.Errorf("got last error %q; want the mail server error", retried.lastError)
+		}
+
+		// The email is sent once it is due again and the mail server is back
+		transport.Err = nil
+		outbox.due = append(outbox.due, newInvitationEmail(1))
+
+		app.dispatchDueEmails()
+
+		if len(outbox.sent) != 1 || len(transport.Messages()) != 1 {
+			t.Errorf("got sent %v; want the retried email sent", outbox.sent)
+		}
+	})
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"time"
 )
 
 func (app *application) logInternalError(method string, err error) {
@@ -96,7 +99,28 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) insufficientScopeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "The API token doesn't have the scope necessary to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "Rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+func (app *application) tooManyAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "Too many failed attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) syncTokenExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "The sync token has expired, please sync again from scratch"
+	app.errorResponse(w, r, http.StatusGone, message)
+}
+
+func (app *application) sessionExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "The pagination session has expired, please start a new session"
+	app.errorResponse(w, r, http.StatusGone, message)
+}
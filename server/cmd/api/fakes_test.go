@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+)
+
+// fakeModels keeps the data of the models the application uses through interfaces in memory,
+// and records what the application did with it
+type fakeModels struct {
+	notifications fakeNotificationStore
+	outbox        fakeEmailOutbox
+	identities    fakeIdentityStore
+}
+
+// newTestApplication returns an application which uses fake models and discards its logs
+func newTestApplication(t *testing.T) (*application, *fakeModels) {
+	t.Helper()
+
+	models := &fakeModels{}
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		notificationStores: notificationStores{
+			settings: &models.notifications,
+			jobs:     &models.notifications,
+			devices:  &models.notifications,
+		},
+		emailOutbox: &models.outbox,
+		identityStores: identityStores{
+			identities: &models.identities,
+			users:      &models.identities,
+		},
+	}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	t.Cleanup(app.cancel)
+
+	return app, models
+}
+
+// fakeNotificationStore has the notification settings and the push devices of a single user
+type fakeNotificationStore struct {
+	settings  *data.NotificationSettings
+	sentCount int
+
+	sent     []int64
+	skipped  map[int64]string
+	deferred map[int64]time.Time
+	retried  map[int64]string
+}
+
+func (s *fakeNotificationStore) Get(userID int64) (*data.NotificationSettings, error) {
+	return s.settings, nil
+}
+
+func (s *fakeNotificationStore) CountSentSince(userID int64, channel string, since time.Time) (int, error) {
+	return s.sentCount, nil
+}
+
+func (s *fakeNotificationStore) MarkSent(jobIDs []int64) error {
+	s.sent = append(s.sent, jobIDs...)
+	return nil
+}
+
+func (s *fakeNotificationStore) MarkSkipped(jobIDs []int64, reason string) error {
+	if s.skipped == nil {
+		s.skipped = make(map[int64]string)
+	}
+	for _, id := range jobIDs {
+		s.skipped[id] = reason
+	}
+	return nil
+}
+
+func (s *fakeNotificationStore) Defer(jobIDs []int64, until time.Time) error {
+	if s.deferred == nil {
+		s.deferred = make(map[int64]time.Time)
+	}
+	for _, id := range jobIDs {
+		s.deferred[id] = until
+	}
+	return nil
+}
+
+func (s *fakeNotificationStore) Retry(jobIDs []int64, lastError string, maxAttempts int, backoff time.Duration) error {
+	if s.retried == nil {
+		s.retried = make(map[int64]string)
+	}
+	for _, id := range jobIDs {
+		s.retried[id] = lastError
+	}
+	return nil
+}
+
+func (s *fakeNotificationStore) FindTokensForUser(userID int64) ([]string, error) {
+	return []string{"device-token"}, nil
+}
+
+// fakeEmailOutbox hands out the queued emails once
+type fakeEmailOutbox struct {
+	due []*data.OutboxEmail
+
+	sent    []int64
+	failed  map[int64]string
+	retried map[int64]retriedEmail
+}
+
+type retriedEmail struct {
+	lastError   string
+	maxAttempts int
+	backoff     time.Duration
+}
+
+func (o *fakeEmailOutbox) ClaimDue(limit int, lease time.Duration) ([]*data.OutboxEmail, error) {
+	n := min(limit, len(o.due))
+	emails := o.due[:n]
+	o.due = o.due[n:]
+	return emails, nil
+}
+
+func (o *fakeEmailOutbox) MarkSent(id int64) error {
+	o.sent = append(o.sent, id)
+	return nil
+}
+
+func (o *fakeEmailOutbox) MarkFailed(id int64, lastError string) error {
+	if o.failed == nil {
+		o.failed = make(map[int64]string)
+	}
+	o.failed[id] = lastError
+	return nil
+}
+
+func (o *fakeEmailOutbox) Retry(id int64, lastError string, maxAttempts int, backoff time.Duration) error {
+	if o.retried == nil {
+		o.retried = make(map[int64]retriedEmail)
+	}
+	o.retried[id] = retriedEmail{lastError: lastError, maxAttempts: maxAttempts, backoff: backoff}
+	return nil
+}
+
+// fakeIdentityStore has a single user without linked identities
+type fakeIdentityStore struct {
+	user *data.User
+
+	emailLookups []string
+	inserted     []*data.UserIdentity
+}
+
+func (s *fakeIdentityStore) GetByProviderSubject(provider, subject string) (*data.UserIdentity, error) {
+	return nil, data.ErrRecordNotFound
+}
+
+func (s *fakeIdentityStore) UpdateAfterLogin(identity *data.UserIdentity) error {
+	return nil
+}
+
+func (s *fakeIdentityStore) Insert(identity *data.UserIdentity) error {
+	s.inserted = append(s.inserted, identity)
+	return nil
+}
+
+func (s *fakeIdentityStore) GetByID(id int64) (*data.User, error) {
+	if s.user == nil || id != s.user.ID {
+		return nil, data.ErrRecordNotFound
+	}
+	return s.user, nil
+}
+
+func (s *fakeIdentityStore) GetByEmail(email string) (*data.User, error) {
+	s.emailLookups = append(s.emailLookups, email)
+	if s.user == nil || email != s.user.Email {
+		return nil, data.ErrRecordNotFound
+	}
+	return s.user, nil
+}
@@ -301,6 +301,17 @@ func (app *application) followFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if app.isFeedStale(feed) {
+		app.background(func() {
+			app.RefreshFeed(feed)
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// isFeedStale reports whether a feed was last fetched before the refresher considers it stale
+func (app *application) isFeedStale(feed *data.Feed) bool {
 	refreshBeforeTime := time.Now().Add(-1 * app.config.refresher.refreshStaleFeedsSince)
 	lastRefreshTime := time.Time{}
 
@@ -310,13 +321,7 @@ func (app *application) followFeed(w http.ResponseWriter, r *http.Request) {
 		lastRefreshTime = feed.LastFailureAt.Time
 	}
 
-	if lastRefreshTime.Before(refreshBeforeTime) {
-		app.background(func() {
-			app.RefreshFeed(feed)
-		})
-	}
-
-	w.WriteHeader(http.StatusOK)
+	return lastRefreshTime.Before(refreshBeforeTime)
 }
 
 func (app *application) unfollowFeed(w http.ResponseWriter, r *http.Request) {
@@ -350,9 +355,13 @@ func (app *application) unfollowFeed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only remove the feed from the walls owned by the user. Walls shared with the
+	// user are curated by their owners.
 	var wallIDs []int64
 	for _, wall := range walls {
-		wallIDs = append(wallIDs, wall.ID)
+		if wall.UserID == user.ID {
+			wallIDs = append(wallIDs, wall.ID)
+		}
 	}
 
 	err = app.models.WallFeeds.DeleteFeedForWalls(feedID, wallIDs)
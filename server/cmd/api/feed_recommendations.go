@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+)
+
+// maxFeedRecommendationsPerUser is the number of recommendations precomputed for each user
+const maxFeedRecommendationsPerUser = 100
+
+func (app *application) listFeedRecommendations(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 16, v)
+	input.Sort = app.readString(qs, "sort", "-score")
+	input.SortSafeList = []string{"score", "-score"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	recommendations, metadata, err := app.models.FeedRecommendations.GetForUser(user.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recommendations": recommendations, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// RefreshFeedRecommendations recomputes the feed recommendations of all users who follow at least one feed
+func (app *application) RefreshFeedRecommendations() error {
+	follows, err := app.models.FeedRecommendations.GetFollowGraph()
+	if err != nil {
+		return err
+	}
+
+	feeds, topicParents, err := app.models.FeedRecommendations.GetRecommendableFeeds()
+	if err != nil {
+		return err
+	}
+
+	similarities := data.CalculateFeedSimilarities(follows)
+
+	for userID, followedFeedIDs := range follows {
+		select {
+		case <-app.ctx.Done():
+			return nil
+		default:
+		}
+
+		recommendations := data.ScoreFeedRecommendations(userID, followedFeedIDs, feeds, similarities, topicParents, maxFeedRecommendationsPerUser)
+		err = app.models.FeedRecommendations.ReplaceForUser(userID, recommendations)
+		if err != nil {
+			app.logInternalError("app.models.FeedRecommendations.ReplaceForUser failed", err)
+		}
+	}
+
+	return nil
+}
+
+func (app *application) KeepFeedRecommendationsFresh() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("feed recommendations refresher shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			err := app.RefreshFeedRecommendations()
+			if err != nil {
+				app.logInternalError("app.RefreshFeedRecommendations failed", err)
+			}
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.recommendations.refreshPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
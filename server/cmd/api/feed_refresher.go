@@ -65,6 +65,9 @@ func (app *application) RefreshFeed(feed *data.Feed) {
 	parsedFeed, err := app.parser.ParseURLWithContext(feed.FeedLink, ctx)
 	if err != nil {
 		app.logInternalError("ParseURLWithContext failed for feed: "+feed.FeedLink, err)
+		// Only the first failure after a successful refresh is reported to webhooks, not every
+		// retry of a broken feed
+		wasHealthy := !feed.LastFailureAt.Valid || (feed.LastFetchAt.Valid && feed.LastFetchAt.Time.After(feed.LastFailureAt.Time))
 		feed.LastFailure.String = err.Error()
 		feed.LastFailure.Valid = true
 		feed.LastFailureAt.Time = time.Now()
@@ -72,12 +75,16 @@ func (app *application) RefreshFeed(feed *data.Feed) {
 		err = app.models.Feeds.UpdateFailureStatus(feed)
 		if err != nil {
 			app.logInternalError("app.models.Feeds.Update failed while updating failed feed status", err)
+			return
+		}
+		if wasHealthy {
+			app.enqueueFeedFailedWebhooks(feed)
 		}
 		return
 	}
 
 	items := copyItemsFields(parsedFeed, feed.ID)
-	err = app.models.Items.UpsertMany(items)
+	newItemIDs, err := app.models.Items.UpsertMany(items)
 	if err != nil {
 		app.logInternalError("app.models.Items.UpsertMany failed", err)
 		feed.LastFailure.String = err.Error()
@@ -96,4 +103,10 @@ func (app *application) RefreshFeed(feed *data.Feed) {
 	if err != nil {
 		app.logInternalError("app.models.Feeds.Update() failed", err)
 	}
+
+	if len(newItemIDs) > 0 {
+		app.publishNewItems(feed.ID, len(newItemIDs))
+		app.enqueueNotifications(newItemIDs)
+		app.enqueueItemsCreatedWebhooks(newItemIDs)
+	}
 }
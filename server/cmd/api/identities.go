@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/oidc"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/julienschmidt/httprouter"
+)
+
+var errUnknownProvider = errors.New("unknown identity provider")
+
+// identityStores are the models users are logged in with by ID tokens
+type identityStores struct {
+	identities interface {
+		GetByProviderSubject(provider, subject string) (*data.UserIdentity, error)
+		UpdateAfterLogin(identity *data.UserIdentity) error
+		Insert(identity *data.UserIdentity) error
+	}
+	users interface {
+		GetByID(id int64) (*data.User, error)
+		GetByEmail(email string) (*data.User, error)
+	}
+}
+
+// verifyIDToken verifies an ID token issued by one of the configured OpenID Connect providers
+func (app *application) verifyIDToken(providerName, rawIDToken string) (*oidc.Claims, error) {
+	provider, ok := app.oidcProviders[providerName]
+	if !ok {
+		return nil, errUnknownProvider
+	}
+
+	// Discovering the signing keys of the provider may take a request to it, so the timeout is
+	// longer than for the database
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return provider.Verify(ctx, rawIDToken)
+}
+
+// createOIDCAuthenticationToken logs a user in with an ID token of an OpenID Connect provider.
+// A known identity logs in the user it is linked to. Otherwise the identity is linked to the
+// user with the email address the provider verified, who is created if there is none.
+func (app *application) createOIDCAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	providerName := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	if _, ok := app.oidcProviders[providerName]; !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.NotBlank(input.IDToken), "id_token", "ID token must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := app.verifyIDToken(providerName, input.IDToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	identity, err := app.identityStores.identities.GetByProviderSubject(providerName, claims.Subject)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if identity != nil {
+		user, err := app.identityStores.users.GetByID(identity.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		identity.Email = claims.Email
+		err = app.identityStores.identities.UpdateAfterLogin(identity)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.completeLogin(w, r, user, envelope{"is_new_user": false})
+		return
+	}
+
+	// Identities are only linked by email address if the provider verified it, otherwise anyone
+	// could take over an account by signing up with its email address at the provider
+	if !claims.EmailVerified || !validator.Matches(claims.Email, validator.EmailRX) {
+		app.errorResponse(w, r, http.StatusUnauthorized, "The email address of this account is not verified, link the account from your settings instead")
+		return
+	}
+
+	var isNewUser bool
+	user, err := app.identityStores.users.GetByEmail(claims.Email)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		fullName := claims.Name
+		if fullName == "" {
+			fullName, _, _ = strings.Cut(claims.Email, "@")
+		}
+
+		user, err = app.createOAuthUser(claims.Email, fullName, claims.Picture)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		isNewUser = true
+	}
+
+	identity = &data.UserIdentity{
+		UserID:   user.ID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	err = app.identityStores.identities.Insert(identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateIdentity):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrProviderLinked):
+			app.errorResponse(w, r, http.StatusConflict, "Another account of this provider is already linked to the user with this email address")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !isNewUser && (!user.ProfileImageURL.Valid || user.ProfileImageURL.String == "") && claims.Picture != "" {
+		user.ProfileImageURL = pgtype.Text{
+			String: claims.Picture,
+			Valid:  true,
+		}
+	}
+
+	app.completeLogin(w, r, user, envelope{"is_new_user": isNewUser})
+}
+
+func (app *application) listUserIdentities(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	identities, err := app.models.UserIdentities.FindAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"identities": identities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createUserIdentity links an account of an OpenID Connect provider to the current user, after
+// the user re-authenticated. Unlike a login, the email addresses do not have to match, which lets
+// users link accounts whose email address is different or not verified.
+func (app *application) createUserIdentity(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		reauthentication
+		Provider string `json:"provider"`
+		IDToken  string `json:"id_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	_, ok := app.oidcProviders[input.Provider]
+	v.Check(ok, "provider", "Unknown provider")
+	v.Check(validator.NotBlank(input.IDToken), "id_token", "ID token must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.checkReauthentication(w, r, input.reauthentication)
+	if !ok {
+		return
+	}
+
+	claims, err := app.verifyIDToken(input.Provider, input.IDToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken):
+			v.AddError("id_token", "Invalid ID token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	identity := &data.UserIdentity{
+		UserID:   user.ID,
+		Provider: input.Provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	err = app.models.UserIdentities.Insert(identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateIdentity):
+			v.AddError("id_token", "This account is already linked to a user")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrProviderLinked):
+			v.AddError("provider", "An account of this provider is already linked")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"identity": identity}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserIdentity unlinks an identity from the current user. Users without a password must
+// keep another identity or a passkey to log in with.
+func (app *application) deleteUserIdentity(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "identity_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	if user.Password.IsOAuthPlaceholder() {
+		identities, err := app.models.UserIdentities.FindAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		passkeys, err := app.models.Passkeys.FindAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(identities) == 1 && identities[0].ID == id && len(passkeys) == 0 {
+			app.errorResponse(w, r, http.StatusConflict, "Set a password or add a passkey before unlinking the last account you log in with")
+			return
+		}
+	}
+
+	err = app.models.UserIdentities.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
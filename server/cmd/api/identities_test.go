@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/oidc"
+	"github.com/aravindmathradan/semaphore/internal/oidc/oidctest"
+	"github.com/julienschmidt/httprouter"
+)
+
+// serveTestIssuer serves a local issuer as the "local" provider of the application
+func serveTestIssuer(t *testing.T, app *application) *oidctest.Issuer {
+	t.Helper()
+
+	issuer, err := oidctest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(issuer)
+	t.Cleanup(srv.Close)
+	issuer.URL = srv.URL
+
+	app.oidcProviders = map[string]*oidc.Provider{"local": oidc.NewProvider("local", srv.URL, []string{"client"})}
+	return issuer
+}
+
+// postIDToken posts an ID token to the OIDC login of the local provider
+func postIDToken(t *testing.T, app *application, idToken string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]string{"id_token": idToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/oidc/local", bytes.NewReader(body))
+	params := httprouter.Params{{Key: "provider", Value: "local"}}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+	w := httptest.NewRecorder()
+	app.createOIDCAuthenticationToken(w, r)
+	return w
+}
+
+func TestCreateOIDCAuthenticationToken(t *testing.T) {
+	user := &data.User{ID: 1, Username: "alice", Email: "alice@example.com"}
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		app, models := newTestApplication(t)
+		models.identities.user = user
+		issuer := serveTestIssuer(t, app)
+
+		idToken, err := issuer.IDToken("other-client", "subject", map[string]any{"email": user.Email, "email_verified": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := postIDToken(t, app, idToken)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("got status %d; want %d", w.Code, http.StatusUnauthorized)
+		}
+		if len(models.identities.inserted) != 0 {
+			t.Errorf("got identities %v linked with an invalid token", models.identities.inserted)
+		}
+	})
+
+	// Anyone could sign up at a provider with the email address of an account, so an identity is
+	// only linked by email address if the provider verified it
+	for _, emailVerified := range []any{false, "false", nil} {
+		t.Run(fmt.Sprintf("does not link with email_verified %#v", emailVerified), func(t *testing.T) {
+			app, models := newTestApplication(t)
+			models.identities.user = user
+			issuer := serveTestIssuer(t, app)
+
+			claims := map[string]any{"email": user.Email}
+			if emailVerified != nil {
+				claims["email_verified"] = emailVerified
+			}
+			idToken, err := issuer.IDToken("client", "subject", claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := postIDToken(t, app, idToken)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("got status %d for email_verified %v; want %d", w.Code, emailVerified, http.StatusUnauthorized)
+			}
+			if !strings.Contains(w.Body.String(), "not verified") {
+				t.Errorf("got body %s; want the email address to be unverified", w.Body)
+			}
+			if len(models.identities.emailLookups) != 0 || len(models.identities.inserted) != 0 {
+				t.Errorf("got email lookups %v and identities %v; want no user looked up or linked",
+					models.identities.emailLookups, models.identities.inserted)
+			}
+		})
+	}
+}
@@ -112,3 +112,27 @@ func (app *application) CleanupOldUnsavedItems() {
 		}
 	}
 }
+
+func (app *application) KeepHotScoresFresh() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("hot scores refresher shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			err := app.models.Items.RefreshHotScores()
+			if err != nil {
+				app.logInternalError("app.models.Items.RefreshHotScores failed", err)
+			}
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.hotScores.refreshPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/lockout"
+	"github.com/aravindmathradan/semaphore/public"
+	"github.com/redis/go-redis/v9"
+	"github.com/tomasen/realip"
+)
+
+// lockouts throttle brute-force attacks on credentials. Failed logins are counted per account
+// and per IP address, and requests for account emails per email address. The limiters are nil
+// if lockouts are disabled.
+type lockouts struct {
+	accounts *lockout.Limiter
+	ips      *lockout.Limiter
+	emails   *lockout.Limiter
+}
+
+func (app *application) initLockouts(rdb *redis.Client) {
+	if !app.config.lockout.enabled {
+		return
+	}
+
+	app.lockouts = lockouts{
+		accounts: lockout.New(rdb, "accounts", lockout.Policy{
+			FreeAttempts:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        30 * time.Second,
+			MaxFailures:     app.config.lockout.maxFailures,
+			LockoutDuration: app.config.lockout.duration,
+			Window:          app.config.lockout.duration,
+		}),
+		// Many users may share an IP address, so it tolerates more failures than an account
+		ips: lockout.New(rdb, "ips", lockout.Policy{
+			FreeAttempts:    10,
+			BaseDelay:       time.Second,
+			MaxDelay:        30 * time.Second,
+			MaxFailures:     app.config.lockout.ipMaxFailures,
+			LockoutDuration: app.config.lockout.duration,
+			Window:          app.config.lockout.duration,
+		}),
+		emails: lockout.New(rdb, "emails", lockout.Policy{
+			FreeAttempts: 3,
+			BaseDelay:    time.Minute,
+			MaxDelay:     15 * time.Minute,
+			Window:       time.Hour,
+		}),
+	}
+}
+
+// loginLockoutKey returns the key failed logins are counted with. Failures for unknown usernames
+// and email addresses are counted too, so that lockouts do not reveal which accounts exist.
+func loginLockoutKey(user *data.User, usernameOrEmail string) string {
+	if user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	return "name:" + strings.ToLower(usernameOrEmail)
+}
+
+// logAuthEvent logs an authentication event for auditing
+func (app *application) logAuthEvent(r *http.Request, event string, args ...any) {
+	args = append([]any{"event", event, "ip", realip.FromRequest(r), "user_agent", r.UserAgent()}, args...)
+	app.logger.Info("auth event", args...)
+}
+
+// loginAttempt is a login attempt reserved with the lockouts of its account and IP address. The
+// reservations are nil if lockouts are disabled or the attempt could not be reserved.
+type loginAttempt struct {
+	accountKey string
+	account    *lockout.Reservation
+	ip         *lockout.Reservation
+}
+
+// reserveLoginAttempt counts a login as failed before the credentials are verified, unless the
+// account or the IP address is blocked after failed logins, so that concurrent logins cannot all
+// get past the lockout. It writes the error response and returns false if they are blocked.
+// Logins are allowed if the attempts cannot be counted, so that a Redis outage does not lock
+// everyone out.
+func (app *application) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, accountKey string) (*loginAttempt, bool) {
+	attempt := &loginAttempt{accountKey: accountKey}
+	if app.lockouts.accounts == nil {
+		return attempt, true
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	ip, err := app.lockouts.ips.Reserve(ctx, realip.FromRequest(r))
+	if err != nil {
+		app.logInternalError("app.lockouts.ips.Reserve failed", err)
+		return attempt, true
+	}
+	if !ip.Allowed() {
+		app.logAuthEvent(r, "login_blocked", "account", accountKey, "retry_after", ip.Wait.String())
+		app.tooManyAttemptsResponse(w, r, ip.Wait)
+		return nil, false
+	}
+	attempt.ip = ip
+
+	account, err := app.lockouts.accounts.Reserve(ctx, accountKey)
+	if err != nil {
+		app.logInternalError("app.lockouts.accounts.Reserve failed", err)
+		return attempt, true
+	}
+	if !account.Allowed() {
+		// Attempts on a blocked account are not counted for the IP address
+		err = ip.Release(ctx)
+		if err != nil {
+			app.logInternalError("app.lockouts.ips.Release failed", err)
+		}
+
+		app.logAuthEvent(r, "login_blocked", "account", accountKey, "retry_after", account.Wait.String())
+		app.tooManyAttemptsResponse(w, r, account.Wait)
+		return nil, false
+	}
+	attempt.account = account
+
+	return attempt, true
+}
+
+// recordLoginFailure logs a failed login, which was already counted when it was reserved. The
+// owner of an account which gets locked out is emailed a link to unlock it.
+func (app *application) recordLoginFailure(r *http.Request, attempt *loginAttempt, user *data.User) {
+	app.logAuthEvent(r, "login_failed", "account", attempt.accountKey)
+
+	if attempt.ip != nil && attempt.ip.Result.LockedOut {
+		app.logAuthEvent(r, "ip_locked", "failures", attempt.ip.Result.Failures)
+	}
+
+	if attempt.account == nil || !attempt.account.Result.LockedOut {
+		return
+	}
+
+	app.logAuthEvent(r, "account_locked", "account", attempt.accountKey, "failures", attempt.account.Result.Failures)
+
+	if user == nil {
+		return
+	}
+
+	_, err := app.models.Tokens.NewWithEmail(user.ID, app.config.lockout.duration, data.ScopeUnlock, func(token *data.Token) *data.OutboxEmail {
+		return &data.OutboxEmail{
+			Recipient: user.Email,
+			Template:  "account_lockout.tmpl",
+			Data: map[string]any{
+				"username":        user.Username,
+				"lockoutDuration": fmt.Sprintf("%d minutes", int(app.config.lockout.duration.Minutes())),
+				"ipAddress":       realip.FromRequest(r),
+				"unlockURL":       app.config.baseURL + "/public/accounts/unlock?token=" + token.Plaintext,
+			},
+		}
+	})
+	if err != nil {
+		app.logInternalError("app.models.Tokens.NewWithEmail failed", err)
+	}
+}
+
+// resetLoginFailures forgets the failed logins of an account after a successful login. The
+// failed logins of the IP address are kept, since other users may share it, and only the
+// successful login is taken back.
+func (app *application) resetLoginFailures(r *http.Request, attempt *loginAttempt) {
+	if app.lockouts.accounts == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	if attempt.ip != nil {
+		err := attempt.ip.Release(ctx)
+		if err != nil {
+			app.logInternalError("app.lockouts.ips.Release failed", err)
+		}
+	}
+
+	err := app.lockouts.accounts.Reset(ctx, attempt.accountKey)
+	if err != nil {
+		app.logInternalError("app.lockouts.accounts.Reset failed", err)
+	}
+}
+
+// allowAccountEmail throttles requests for account emails, such as password reset emails, to
+// an email address. Every request counts, whether the address belongs to an account or not. It
+// writes the error response and returns false if the address is blocked.
+func (app *application) allowAccountEmail(w http.ResponseWriter, r *http.Request, email string) bool {
+	if app.lockouts.emails == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	key := strings.ToLower(email)
+
+	reservation, err := app.lockouts.emails.Reserve(ctx, key)
+	if err != nil {
+		app.logInternalError("app.lockouts.emails.Reserve failed", err)
+		return true
+	}
+
+	if !reservation.Allowed() {
+		app.logAuthEvent(r, "account_email_blocked", "email", key, "retry_after", reservation.Wait.String())
+		app.tooManyAttemptsResponse(w, r, reservation.Wait)
+		return false
+	}
+
+	return true
+}
+
+// showAccountUnlock serves the page the unlock link emailed to the owner of a locked out account
+// opens. Link scanners of mail providers open links too, so the page only unlocks the account when
+// its form is submitted.
+func (app *application) showAccountUnlock(w http.ResponseWriter, r *http.Request) {
+	content, err := public.Html.ReadFile("html/pages/account-unlock.html")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(content)
+}
+
+// unlockAccount unlocks an account which was locked out after too many failed logins, with the
+// token of the emailed link. Browsers submitting the unlock page are shown a confirmation page,
+// other clients get an empty response.
+func (app *application) unlockAccount(w http.ResponseWriter, r *http.Request) {
+	token := app.readString(r.URL.Query(), "token", "")
+	if len(token) != 26 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeUnlock, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accountKey := loginLockoutKey(user, "")
+	if app.lockouts.accounts != nil {
+		err = app.lockouts.accounts.Reset(r.Context(), accountKey)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.logAuthEvent(r, "account_unlocked", "account", accountKey)
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	content, err := public.Html.ReadFile("html/pages/account-unlocked.html")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(content)
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
@@ -14,8 +17,14 @@ import (
 
 	"github.com/aravindmathradan/semaphore/internal/cache"
 	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/events"
 	"github.com/aravindmathradan/semaphore/internal/mailer"
+	"github.com/aravindmathradan/semaphore/internal/notifier"
+	"github.com/aravindmathradan/semaphore/internal/oidc"
+	"github.com/aravindmathradan/semaphore/internal/validator"
 	"github.com/aravindmathradan/semaphore/internal/vcs"
+	"github.com/aravindmathradan/semaphore/internal/webauthn"
+	"github.com/aravindmathradan/semaphore/internal/webhooks"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mmcdole/gofeed"
 	"github.com/redis/go-redis/v9"
@@ -23,12 +32,16 @@ import (
 
 var (
 	version = vcs.Version()
+
+	// oidcProviderNameRX matches the names of OpenID Connect providers, which appear in URLs
+	oidcProviderNameRX = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port    int
+	env     string
+	baseURL string
+	db      struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -46,11 +59,22 @@ type config struct {
 		password string
 		sender   string
 	}
+	mail struct {
+		transport    string
+		dir          string
+		templatesDir string
+	}
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
 	}
+	lockout struct {
+		enabled       bool
+		maxFailures   int
+		ipMaxFailures int
+		duration      time.Duration
+	}
 	refresher struct {
 		userAgent              string
 		maxConcurrentRefreshes int
@@ -58,9 +82,52 @@ type config struct {
 		refreshPeriod          time.Duration
 	}
 	cleanup struct {
-		tokensCleanupPeriod        time.Duration
-		itemsCleanupPeriod         time.Duration
-		itemsCleanupBeforeDuration time.Duration
+		tokensCleanupPeriod          time.Duration
+		itemsCleanupPeriod           time.Duration
+		itemsCleanupBeforeDuration   time.Duration
+		changesCleanupPeriod         time.Duration
+		changesCleanupBeforeDuration time.Duration
+	}
+	sync struct {
+		settleDelay time.Duration
+	}
+	sse struct {
+		heartbeatPeriod       time.Duration
+		maxConnectionsPerUser int
+	}
+	notifications struct {
+		fcmCredentialsFile string
+		fake               bool
+		deliveryPeriod     time.Duration
+		emailDigestDelay   time.Duration
+	}
+	emails struct {
+		dispatchPeriod time.Duration
+	}
+	digests struct {
+		period        time.Duration
+		signingSecret string
+	}
+	webhooks struct {
+		deliveryPeriod time.Duration
+		maxFailures    int
+	}
+	accounts struct {
+		deletionGracePeriod time.Duration
+		purgePeriod         time.Duration
+	}
+	walls struct {
+		maxPinned int
+	}
+	hotScores struct {
+		refreshPeriod time.Duration
+	}
+	trending struct {
+		refreshPeriod time.Duration
+		window        time.Duration
+	}
+	recommendations struct {
+		refreshPeriod time.Duration
 	}
 	cors struct {
 		trustedOrigins []string
@@ -69,18 +136,43 @@ type config struct {
 		clientID      string
 		youtubeAPIKey string
 	}
+	webauthn struct {
+		rpID      string
+		rpOrigins []string
+	}
+	oidc struct {
+		providers []oidcProviderConfig
+	}
+}
+
+// oidcProviderConfig is an OpenID Connect provider users can log in with
+type oidcProviderConfig struct {
+	name      string
+	issuer    string
+	clientIDs []string
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	cache  cache.Cache
-	parser *gofeed.Parser
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
-	ctx    context.Context
-	cancel context.CancelFunc
+	config        config
+	logger        *slog.Logger
+	models        data.Models
+	cache         cache.Cache
+	events        *events.Broker
+	notifiers     map[string]notifier.Provider
+	webhookSender *webhooks.Sender
+	lockouts      lockouts
+	webauthn      *webauthn.RelyingParty
+	oidcProviders map[string]*oidc.Provider
+	parser        *gofeed.Parser
+	mailer        mailer.Mailer
+	wg            sync.WaitGroup
+	ctx           context.Context
+	cancel        context.CancelFunc
+
+	// Models which are used through interfaces, so that tests can replace them with fakes
+	notificationStores notificationStores
+	emailOutbox        emailOutboxStore
+	identityStores     identityStores
 }
 
 func main() {
@@ -88,6 +180,7 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.baseURL, "base-url", "http://localhost:4000", "Public base URL of the API server")
 
 	flag.StringVar(&cfg.db.dsn, "dsn", os.Getenv("SEMAPHORE_DB_DSN"), "PostgreSQL connection string")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -103,10 +196,19 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Semaphore <no-reply@smphr.aravindunnikrishnan.in>", "SMTP sender")
 
+	flag.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "Mail transport (smtp|file|log)")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", "", "Maildir the file mail transport writes emails to")
+	flag.StringVar(&cfg.mail.templatesDir, "mail-templates-dir", "", "Directory with email templates overriding the embedded ones")
+
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 4, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 8, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.BoolVar(&cfg.lockout.enabled, "lockout-enabled", true, "Enable delays and lockouts after failed logins")
+	flag.IntVar(&cfg.lockout.maxFailures, "lockout-max-failures", 10, "Failed logins after which an account is locked out")
+	flag.IntVar(&cfg.lockout.ipMaxFailures, "lockout-ip-max-failures", 50, "Failed logins after which an IP address is locked out")
+	flag.DurationVar(&cfg.lockout.duration, "lockout-duration", 15*time.Minute, "Lockout duration after too many failed logins (default: 15m)")
+
 	flag.StringVar(&cfg.refresher.userAgent, "user-agent", os.Getenv("FETCHER_USER_AGENT"), "User agent for feed fetching")
 	flag.IntVar(&cfg.refresher.maxConcurrentRefreshes, "max-concurrent-refreshes", 5, "Maximum concurrent refreshes")
 	flag.DurationVar(&cfg.refresher.refreshStaleFeedsSince, "refresh-since", 5*time.Minute, "Refresh stale feeds since (default: 5m)")
@@ -115,6 +217,38 @@ func main() {
 	flag.DurationVar(&cfg.cleanup.tokensCleanupPeriod, "tokens-cleanup-period", time.Hour*12, "Tokens cleanup period (default: 12h)")
 	flag.DurationVar(&cfg.cleanup.itemsCleanupPeriod, "items-cleanup-period", time.Hour*12, "Items cleanup period (default: 12h)")
 	flag.DurationVar(&cfg.cleanup.itemsCleanupBeforeDuration, "items-cleanup-before-duration", time.Hour*24*30, "Items cleanup before duration (default: 30d)")
+	flag.DurationVar(&cfg.cleanup.changesCleanupPeriod, "changes-cleanup-period", time.Hour*12, "Sync change log cleanup period (default: 12h)")
+	flag.DurationVar(&cfg.cleanup.changesCleanupBeforeDuration, "changes-cleanup-before-duration", time.Hour*24*30, "Sync change log cleanup before duration (default: 30d)")
+
+	flag.DurationVar(&cfg.sync.settleDelay, "sync-settle-delay", 30*time.Second, "Delay before new items are returned by the sync API (default: 30s)")
+
+	flag.DurationVar(&cfg.sse.heartbeatPeriod, "sse-heartbeat-period", 15*time.Second, "Heartbeat period of event streams (default: 15s)")
+	flag.IntVar(&cfg.sse.maxConnectionsPerUser, "sse-max-connections-per-user", 5, "Maximum number of open event streams per user")
+
+	flag.StringVar(&cfg.notifications.fcmCredentialsFile, "fcm-credentials-file", os.Getenv("FCM_CREDENTIALS_FILE"), "Firebase service account key file for push notifications")
+	flag.BoolVar(&cfg.notifications.fake, "notifications-fake", false, "Record notifications in memory instead of delivering them")
+	flag.DurationVar(&cfg.notifications.deliveryPeriod, "notifications-delivery-period", 30*time.Second, "Notifications delivery period (default: 30s)")
+	flag.DurationVar(&cfg.notifications.emailDigestDelay, "notifications-email-digest-delay", time.Hour, "Time new items are collected for a notification email digest (default: 1h)")
+
+	flag.DurationVar(&cfg.emails.dispatchPeriod, "emails-dispatch-period", 5*time.Second, "Email outbox dispatch period (default: 5s)")
+
+	flag.DurationVar(&cfg.digests.period, "digests-period", 15*time.Minute, "Period of checking for due email digests (default: 15m)")
+	flag.StringVar(&cfg.digests.signingSecret, "digests-signing-secret", os.Getenv("DIGESTS_SIGNING_SECRET"), "Secret for signing the unsubscribe links of email digests")
+
+	flag.DurationVar(&cfg.webhooks.deliveryPeriod, "webhooks-delivery-period", 10*time.Second, "Webhooks delivery period (default: 10s)")
+	flag.IntVar(&cfg.webhooks.maxFailures, "webhooks-max-failures", 15, "Failed delivery attempts in a row after which a webhook endpoint is disabled")
+
+	flag.DurationVar(&cfg.accounts.deletionGracePeriod, "account-deletion-grace-period", 30*24*time.Hour, "Time after which a deleted account is purged, unless the user logs in again (default: 30d)")
+	flag.DurationVar(&cfg.accounts.purgePeriod, "account-purge-period", time.Hour, "Deleted accounts purge period (default: 1h)")
+
+	flag.IntVar(&cfg.walls.maxPinned, "walls-max-pinned", 5, "Maximum number of walls a user can pin")
+
+	flag.DurationVar(&cfg.hotScores.refreshPeriod, "hot-scores-refresh-period", 10*time.Minute, "Hot scores refresh period (default: 10m)")
+
+	flag.DurationVar(&cfg.trending.refreshPeriod, "trending-refresh-period", 15*time.Minute, "Trending items refresh period (default: 15m)")
+	flag.DurationVar(&cfg.trending.window, "trending-window", 48*time.Hour, "Window for counting likes and saves of trending items (default: 48h)")
+
+	flag.DurationVar(&cfg.recommendations.refreshPeriod, "recommendations-refresh-period", 6*time.Hour, "Feed recommendations refresh period (default: 6h)")
 
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated within double quotes)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
@@ -124,6 +258,37 @@ func main() {
 	flag.StringVar(&cfg.google.clientID, "google-client-id", "", "Google OAuth web client ID")
 	flag.StringVar(&cfg.google.youtubeAPIKey, "youtube-api-key", os.Getenv("YOUTUBE_API_KEY"), "YouTube Data API key")
 
+	flag.StringVar(&cfg.webauthn.rpID, "webauthn-rp-id", "localhost", "WebAuthn relying party ID, the domain passkeys are scoped to")
+	flag.Func("webauthn-rp-origins", "Origins passkeys are accepted from (space separated within double quotes, default: base URL)", func(val string) error {
+		cfg.webauthn.rpOrigins = strings.Fields(val)
+		return nil
+	})
+
+	flag.Func("oidc-provider", "OpenID Connect provider as \"name issuer client-id...\" (repeatable)", func(val string) error {
+		fields := strings.Fields(val)
+		if len(fields) < 3 {
+			return errors.New("expected a name, an issuer and at least one client ID")
+		}
+		if !validator.Matches(fields[0], oidcProviderNameRX) {
+			return fmt.Errorf("invalid provider name %q", fields[0])
+		}
+		for _, provider := range cfg.oidc.providers {
+			if provider.name == fields[0] {
+				return fmt.Errorf("duplicate provider %q", fields[0])
+			}
+		}
+		issuer, err := url.Parse(fields[1])
+		if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+			return fmt.Errorf("invalid issuer %q", fields[1])
+		}
+		cfg.oidc.providers = append(cfg.oidc.providers, oidcProviderConfig{
+			name:      fields[0],
+			issuer:    fields[1],
+			clientIDs: fields[2:],
+		})
+		return nil
+	})
+
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -138,6 +303,19 @@ func main() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	if len(cfg.webauthn.rpOrigins) == 0 {
+		cfg.webauthn.rpOrigins = []string{cfg.baseURL}
+	}
+
+	// Without a secret anyone could forge the unsubscribe links of digests
+	if cfg.digests.signingSecret == "" {
+		if cfg.env == "production" {
+			logger.Error("digests signing secret must be set in production")
+			os.Exit(1)
+		}
+		logger.Warn("digests signing secret is not set, unsubscribe links of digests can be forged")
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Error(err.Error())
@@ -177,23 +355,48 @@ func main() {
 	feedParser.UserAgent = cfg.refresher.userAgent
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		cache:  cache.NewRedisCache(rdb),
-		parser: feedParser,
-		mailer: mailer.New(
-			cfg.smtp.host,
-			cfg.smtp.port,
-			cfg.smtp.username,
-			cfg.smtp.password,
-			cfg.smtp.sender,
-		),
+		config:        cfg,
+		logger:        logger,
+		models:        data.NewModels(db),
+		cache:         cache.NewRedisCache(rdb),
+		events:        events.NewBroker(rdb),
+		parser:        feedParser,
+		webhookSender: webhooks.NewSender(cfg.refresher.userAgent),
+		webauthn:      webauthn.NewRelyingParty(cfg.webauthn.rpID, "Semaphore", cfg.webauthn.rpOrigins),
+		oidcProviders: make(map[string]*oidc.Provider, len(cfg.oidc.providers)),
+	}
+
+	for _, provider := range cfg.oidc.providers {
+		app.oidcProviders[provider.name] = oidc.NewProvider(provider.name, provider.issuer, provider.clientIDs)
+	}
+	app.emailOutbox = app.models.EmailOutbox
+	app.identityStores = identityStores{
+		identities: app.models.UserIdentities,
+		users:      app.models.Users,
 	}
 
 	// Create a new context which is cancelled on graceful shutdown
 	app.ctx, app.cancel = context.WithCancel(context.Background())
 
+	app.initLockouts(rdb)
+
+	err = app.initMailer()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	err = app.initNotifiers()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Start the event broker in the background
+	app.background(func() {
+		app.events.Run(app.ctx)
+	})
+
 	// Start the feed refresher in the background
 	app.background(func() {
 		app.KeepFeedsFresh()
@@ -209,11 +412,56 @@ func main() {
 		app.CleanupTokens()
 	})
 
+	// Start the deleted users purger in the background
+	app.background(func() {
+		app.PurgeDeletedUsers()
+	})
+
 	// Start the items cleanup in the background
 	app.background(func() {
 		app.CleanupOldUnsavedItems()
 	})
 
+	// Start the sync change log cleanup in the background
+	app.background(func() {
+		app.CleanupUserChanges()
+	})
+
+	// Start the email dispatcher in the background
+	app.background(func() {
+		app.DispatchEmails()
+	})
+
+	// Start the notifications delivery in the background
+	app.background(func() {
+		app.DeliverNotifications()
+	})
+
+	// Start the webhooks delivery in the background
+	app.background(func() {
+		app.DeliverWebhooks()
+	})
+
+	// Start the digests scheduler in the background
+	app.background(func() {
+		app.SendDigests()
+	})
+
+	// Start the hot scores refresher in the background
+	app.background(func() {
+		app.KeepHotScoresFresh()
+	})
+
+	// Start the trending items refresher in the background
+	app.background(func() {
+		app.KeepTrendingItemsFresh()
+	})
+
+	// Start the feed recommendations refresher in the background
+	app.background(func() {
+		app.KeepFeedRecommendationsFresh()
+	})
+
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
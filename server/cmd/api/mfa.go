@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/totp"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+)
+
+const (
+	totpIssuer = "Semaphore"
+	// totpSkew is the number of time steps a code may be off by, to allow for clock drift
+	totpSkew = 1
+)
+
+func (app *application) getMFAStatus(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	enabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	recoveryCodes := 0
+	if enabled {
+		recoveryCodes, err = app.models.MFA.CountRecoveryCodes(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"mfa": envelope{
+		"totp_enabled":             enabled,
+		"recovery_codes_remaining": recoveryCodes,
+	}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enrollTOTP generates the secret of a new authenticator app. MFA is only enabled once a code
+// from the app is verified, so that users cannot lock themselves out with a misconfigured app.
+func (app *application) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.EnrollTOTP(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			app.errorResponse(w, r, http.StatusConflict, "Two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"secret":           totp.EncodeSecret(secret),
+		"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTOTP enables MFA once the user entered a code from the enrolled authenticator app. The
+// recovery codes are returned only once.
+func (app *application) verifyTOTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.NotBlank(input.Code), "code", "Code must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	enrollment, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "No authenticator app is being enrolled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrollment.Enabled() {
+		app.errorResponse(w, r, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, input.Code, time.Now(), totpSkew)
+	if !ok {
+		v.AddError("code", "Invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, hashes, err := data.GenerateRecoveryCodes(data.RecoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.EnableTOTP(user.ID, step, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			app.errorResponse(w, r, http.StatusConflict, "Two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTP removes the authenticator app and the recovery codes of the user, after the user
+// re-authenticated
+func (app *application) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readReauthentication(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.MFA.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the user, after the user
+// re-authenticated. The new codes are returned only once.
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readReauthentication(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !enabled {
+		app.errorResponse(w, r, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	recoveryCodes, hashes, err := data.GenerateRecoveryCodes(data.RecoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.ReplaceRecoveryCodes(user.ID, hashes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationToken completes the login of a user with MFA enabled. It exchanges the
+// mfa-pending token and a code from the authenticator app, or a recovery code, for a session.
+func (app *application) createMFAAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.MFAToken); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "Code or recovery code must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Codes are throttled like passwords, since six digits are quickly guessed
+	accountKey := loginLockoutKey(user, "")
+	attempt, ok := app.reserveLoginAttempt(w, r, accountKey)
+	if !ok {
+		return
+	}
+
+	var verified bool
+	if input.Code != "" {
+		verified, err = app.verifyTOTPCode(user.ID, input.Code)
+	} else {
+		verified, err = app.models.MFA.UseRecoveryCode(user.ID, input.RecoveryCode)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !verified {
+		app.recordLoginFailure(r, attempt, user)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.resetLoginFailures(r, attempt)
+	app.logAuthEvent(r, "login_succeeded", "account", accountKey, "mfa", true)
+
+	// The mfa-pending token is single use, like the codes
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.startSession(w, r, user, nil)
+}
+
+// verifyTOTPCode checks a code from the authenticator app of a user, and consumes its time step
+func (app *application) verifyTOTPCode(userID int64, code string) (bool, error) {
+	authenticator, err := app.models.MFA.GetTOTP(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	if !authenticator.Enabled() {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(authenticator.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.MFA.UseTOTPStep(userID, step)
+}
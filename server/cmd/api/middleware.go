@@ -154,12 +154,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		v := validator.New()
 
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
+		var session *data.Session
+		var err error
+		if strings.HasPrefix(token, data.APITokenPrefix) {
+			if data.ValidateAPITokenPlaintext(v, token); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			session, err = app.models.Sessions.GetForAPIToken(token)
+		} else {
+			if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
 
-		session, err := app.models.Sessions.GetForToken(token)
+			session, err = app.models.Sessions.GetForToken(token)
+		}
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -177,13 +188,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		session.Permissions = permissions
-		session.IsAdmin = permissions.Includes(data.PermissionAllAdmin)
+		session.IsAdmin = session.APIToken == nil && permissions.Includes(data.PermissionAllAdmin)
+
+		if session.APIToken != nil && session.APIToken.LastUsedDue(time.Now()) {
+			err = app.models.APITokens.UpdateLastUsed(session.APIToken.ID)
+			if err != nil {
+				app.logInternalError("app.models.APITokens.UpdateLastUsed failed", err)
+			}
+		}
 
 		r = app.contextSetSession(r, session)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// requireAuthentication requires a user signed in with an authentication token. API tokens are
+// refused, since they may only be used for the routes which accept one of their scopes (see
+// requireScope).
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session := app.contextGetSession(r)
@@ -192,10 +213,36 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 			return
 		}
 
+		if session.APIToken != nil {
+			app.insufficientScopeResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// requireScope requires a signed in user like requireAuthentication, but also accepts API tokens
+// which were granted the scope
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := app.contextGetSession(r)
+			if session.User.IsAnonymous() {
+				app.authenticationRequiredResponse(w, r)
+				return
+			}
+
+			if session.APIToken != nil && !session.APIToken.HasScope(scope) {
+				app.insufficientScopeResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) requireActivation(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session := app.contextGetSession(r)
@@ -211,13 +258,19 @@ func (app *application) requireActivation(next http.Handler) http.Handler {
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		permissions := app.contextGetSession(r).Permissions
+		session := app.contextGetSession(r)
 
-		if !permissions.Includes(code) {
+		if !session.Permissions.Includes(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
 
+		// API tokens may only use the permissions of the user which their scopes cover
+		if session.APIToken != nil && !session.APIToken.GrantsPermission(code) {
+			app.insufficientScopeResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
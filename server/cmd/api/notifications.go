@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/notifier"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	notificationClaimLimit   = 100
+	notificationLease        = 5 * time.Minute
+	notificationMaxAttempts  = 5
+	notificationRetryBackoff = time.Minute
+)
+
+// notificationStores are the models notification jobs are delivered with
+type notificationStores struct {
+	settings interface {
+		Get(userID int64) (*data.NotificationSettings, error)
+	}
+	jobs interface {
+		CountSentSince(userID int64, channel string, since time.Time) (int, error)
+		MarkSent(jobIDs []int64) error
+		MarkSkipped(jobIDs []int64, reason string) error
+		Defer(jobIDs []int64, until time.Time) error
+		Retry(jobIDs []int64, lastError string, maxAttempts int, backoff time.Duration) error
+	}
+	devices interface {
+		FindTokensForUser(userID int64) ([]string, error)
+	}
+}
+
+// initNotifiers sets up the providers which deliver notifications for each channel. With the
+// fake providers, deliveries are only recorded in memory.
+func (app *application) initNotifiers() error {
+	app.notificationStores = notificationStores{
+		settings: app.models.NotificationSettings,
+		jobs:     app.models.NotificationJobs,
+		devices:  app.models.PushDevices,
+	}
+	app.notifiers = make(map[string]notifier.Provider)
+
+	if app.config.notifications.fake {
+		for _, channel := range data.NotificationChannels {
+			app.notifiers[channel] = notifier.NewFakeProvider(channel)
+		}
+		return nil
+	}
+
+	// Push notifications are disabled when no FCM credentials are configured
+	if app.config.notifications.fcmCredentialsFile != "" {
+		credentials, err := os.ReadFile(app.config.notifications.fcmCredentialsFile)
+		if err != nil {
+			return err
+		}
+
+		fcm, err := notifier.NewFCMProvider(app.ctx, credentials)
+		if err != nil {
+			return err
+		}
+		fcm.OnInvalidToken = func(token string) {
+			err := app.models.PushDevices.DeleteByToken(token)
+			if err != nil {
+				app.logInternalError("app.models.PushDevices.DeleteByToken failed", err)
+			}
+		}
+		app.notifiers[data.NotificationChannelPush] = fcm
+	}
+
+	app.notifiers[data.NotificationChannelEmail] = notifier.NewEmailDigestProvider(app.mailer)
+	app.notifiers[data.NotificationChannelWebhook] = notifier.NewWebhookProvider(app.webhookSender)
+
+	return nil
+}
+
+// enqueueNotifications creates the notification jobs for the new items of a feed
+func (app *application) enqueueNotifications(itemIDs []int64) {
+	_, err := app.models.NotificationJobs.EnqueueForItems(itemIDs, app.config.notifications.emailDigestDelay)
+	if err != nil {
+		app.logInternalError("app.models.NotificationJobs.EnqueueForItems failed", err)
+	}
+}
+
+func (app *application) DeliverNotifications() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("notifications delivery shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			app.deliverDueNotifications()
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.notifications.deliveryPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
+
+// deliverDueNotifications delivers the due notification jobs, grouped by user and channel
+func (app *application) deliverDueNotifications() {
+	for {
+		jobs, err := app.models.NotificationJobs.ClaimDue(notificationClaimLimit, notificationLease)
+		if err != nil {
+			app.logInternalError("app.models.NotificationJobs.ClaimDue failed", err)
+			return
+		}
+
+		// Jobs are ordered by user and channel
+		for start := 0; start < len(jobs); {
+			end := start + 1
+			for end < len(jobs) && jobs[end].UserID == jobs[start].UserID && jobs[end].Channel == jobs[start].Channel {
+				end++
+			}
+			app.deliverNotificationJobs(jobs[start:end])
+			start = end
+		}
+
+		if len(jobs) < notificationClaimLimit {
+			return
+		}
+	}
+}
+
+// deliverNotificationJobs delivers the jobs of a single user and channel. Quiet hours defer the
+// jobs until they end, except for webhooks which are not read by people. Push and webhook
+// notifications over the hourly limit of the user are skipped. Emails are not limited since
+// they are already sent as one digest per digest delay.
+func (app *application) deliverNotificationJobs(jobs []*data.NotificationJob) {
+	userID, channel := jobs[0].UserID, jobs[0].Channel
+
+	settings, err := app.notificationStores.settings.Get(userID)
+	if err != nil {
+		app.retryNotificationJobs(jobs, err)
+		return
+	}
+
+	provider, ok := app.notifiers[channel]
+	if !ok {
+		app.skipNotificationJobs(jobs, "channel is not configured on the server")
+		return
+	}
+	if !slices.Contains(settings.Channels, channel) {
+		app.skipNotificationJobs(jobs, "channel was disabled by the user")
+		return
+	}
+
+	now := time.Now()
+	if channel != data.NotificationChannelWebhook {
+		if until, quiet := settings.QuietUntil(now); quiet {
+			err = app.notificationStores.jobs.Defer(notificationJobIDs(jobs), until)
+			if err != nil {
+				app.logInternalError("app.models.NotificationJobs.Defer failed", err)
+			}
+			return
+		}
+	}
+
+	if channel != data.NotificationChannelEmail {
+		sent, err := app.notificationStores.jobs.CountSentSince(userID, channel, now.Add(-time.Hour))
+		if err != nil {
+			app.retryNotificationJobs(jobs, err)
+			return
+		}
+
+		allowed := max(settings.MaxPerHour-sent, 0)
+		if len(jobs) > allowed {
+			app.skipNotificationJobs(jobs[allowed:], "rate limited")
+			jobs = jobs[:allowed]
+		}
+		if len(jobs) == 0 {
+			return
+		}
+	}
+
+	recipient := notifier.Recipient{
+		UserID:     userID,
+		Name:       jobs[0].User.FullName,
+		Username:   jobs[0].User.Username,
+		Email:      jobs[0].User.Email,
+		WebhookURL: settings.WebhookURL.String,
+	}
+	if channel == data.NotificationChannelPush {
+		recipient.DeviceTokens, err = app.notificationStores.devices.FindTokensForUser(userID)
+		if err != nil {
+			app.retryNotificationJobs(jobs, err)
+			return
+		}
+	}
+
+	notifications := make([]notifier.Notification, len(jobs))
+	for i, job := range jobs {
+		feedTitle := job.Item.Feed.Title
+		if job.Item.Feed.DisplayTitle.Valid {
+			feedTitle = job.Item.Feed.DisplayTitle.String
+		}
+		notifications[i] = notifier.Notification{
+			ItemID:    job.Item.ID,
+			Title:     job.Item.Title,
+			Link:      job.Item.Link,
+			FeedID:    job.Item.FeedID,
+			FeedTitle: feedTitle,
+			ImageURL:  job.Item.Feed.ImageURL.String,
+			PubDate:   job.Item.PubDate.Time,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(app.ctx, 30*time.Second)
+	defer cancel()
+
+	err = provider.Deliver(ctx, recipient, notifications)
+	switch {
+	case err == nil:
+		err = app.notificationStores.jobs.MarkSent(notificationJobIDs(jobs))
+		if err != nil {
+			app.logInternalError("app.models.NotificationJobs.MarkSent failed", err)
+		}
+	case errors.Is(err, notifier.ErrNoDestination):
+		app.skipNotificationJobs(jobs, err.Error())
+	default:
+		app.retryNotificationJobs(jobs, fmt.Errorf("%s delivery failed: %w", channel, err))
+	}
+}
+
+func (app *application) skipNotificationJobs(jobs []*data.NotificationJob, reason string) {
+	err := app.notificationStores.jobs.MarkSkipped(notificationJobIDs(jobs), reason)
+	if err != nil {
+		app.logInternalError("app.models.NotificationJobs.MarkSkipped failed", err)
+	}
+}
+
+func (app *application) retryNotificationJobs(jobs []*data.NotificationJob, cause error) {
+	app.logInternalError("notification delivery failed", cause)
+
+	err := app.notificationStores.jobs.Retry(notificationJobIDs(jobs), cause.Error(), notificationMaxAttempts, notificationRetryBackoff)
+	if err != nil {
+		app.logInternalError("app.models.NotificationJobs.Retry failed", err)
+	}
+}
+
+func notificationJobIDs(jobs []*data.NotificationJob) []int64 {
+	ids := make([]int64, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func (app *application) getNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	settings, err := app.models.NotificationSettings.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"settings": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationSettings replaces the notification settings of the current user. Omitted
+// fields are reset to their defaults.
+func (app *application) updateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+	defaults := data.DefaultNotificationSettings(user.ID)
+
+	var input struct {
+		Channels        []string    `json:"channels"`
+		QuietHoursStart pgtype.Text `json:"quiet_hours_start"`
+		QuietHoursEnd   pgtype.Text `json:"quiet_hours_end"`
+		Timezone        *string     `json:"timezone"`
+		MaxPerHour      *int        `json:"max_per_hour"`
+		WebhookURL      pgtype.Text `json:"webhook_url"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	settings := defaults
+	if input.Channels != nil {
+		settings.Channels = input.Channels
+	}
+	settings.QuietHoursStart = input.QuietHoursStart
+	settings.QuietHoursEnd = input.QuietHoursEnd
+	if input.Timezone != nil {
+		settings.Timezone = *input.Timezone
+	}
+	if input.MaxPerHour != nil {
+		settings.MaxPerHour = *input.MaxPerHour
+	}
+	settings.WebhookURL = input.WebhookURL
+
+	v := validator.New()
+	if data.ValidateNotificationSettings(v, settings); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.NotificationSettings.Upsert(settings)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"settings": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listNotificationSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	subscriptions, err := app.models.NotificationSubscriptions.FindAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"subscriptions": subscriptions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createNotificationSubscription(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		FeedID   pgtype.Int8 `json:"feed_id"`
+		Keywords []string    `json:"keywords"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	subscription := &data.NotificationSubscription{
+		UserID:   app.contextGetSession(r).User.ID,
+		FeedID:   input.FeedID,
+		Keywords: input.Keywords,
+	}
+	subscription.Normalize()
+
+	v := validator.New()
+	if data.ValidateNotificationSubscription(v, subscription); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.NotificationSubscriptions.Insert(subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("feed_id", "Feed does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/me/notifications/subscriptions/%d", subscription.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"subscription": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteNotificationSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := app.readIDParam(r, "subscription_id")
+	if err != nil || subscriptionID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.NotificationSubscriptions.Delete(subscriptionID, app.contextGetSession(r).User.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// registerPushDevice registers the FCM token of a device of the current user
+func (app *application) registerPushDevice(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Platform string `json:"platform"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	device := &data.PushDevice{
+		Token:    input.Token,
+		UserID:   app.contextGetSession(r).User.ID,
+		Platform: input.Platform,
+	}
+
+	v := validator.New()
+	if data.ValidatePushDevice(v, device); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.PushDevices.Upsert(device)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"device": device}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePushDevice(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+	if token == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.models.PushDevices.Delete(token, app.contextGetSession(r).User.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
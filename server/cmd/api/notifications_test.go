@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/notifier"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func newNotificationJobs(channel string, n int) []*data.NotificationJob {
+	jobs := make([]*data.NotificationJob, n)
+	for i := range jobs {
+		jobs[i] = &data.NotificationJob{
+			ID:      int64(i + 1),
+			UserID:  1,
+			Channel: channel,
+			Item: &data.Item{
+				ID:     int64(100 + i),
+				Title:  "Item",
+				FeedID: 7,
+				Feed:   &data.Feed{Title: "Feed"},
+			},
+			User: &data.User{Username: "alice", Email: "alice@example.com"},
+		}
+	}
+	return jobs
+}
+
+func TestDeliverNotificationJobs(t *testing.T) {
+	t.Run("delivers and marks sent", func(t *testing.T) {
+		settings := data.DefaultNotificationSettings(1)
+		fake := notifier.NewFakeProvider(data.NotificationChannelPush)
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelPush: fake}
+		stores := &models.notifications
+		stores.settings = settings
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelPush, 2))
+
+		deliveries := fake.Deliveries()
+		if len(deliveries) != 1 || len(deliveries[0].Notifications) != 2 {
+			t.Fatalf("got deliveries %+v; want one delivery of 2 notifications", deliveries)
+		}
+		if got := deliveries[0].Recipient.DeviceTokens; len(got) != 1 {
+			t.Errorf("got device tokens %v; want the tokens of the user", got)
+		}
+		if len(stores.sent) != 2 {
+			t.Errorf("got %d sent jobs; want 2", len(stores.sent))
+		}
+	})
+
+	t.Run("defers during quiet hours", func(t *testing.T) {
+		now := time.Now().UTC()
+		settings := data.DefaultNotificationSettings(1)
+		settings.QuietHoursStart = pgtype.Text{String: now.Add(-time.Hour).Format("15:04"), Valid: true}
+		settings.QuietHoursEnd = pgtype.Text{String: now.Add(time.Hour).Format("15:04"), Valid: true}
+		fake := notifier.NewFakeProvider(data.NotificationChannelPush)
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelPush: fake}
+		stores := &models.notifications
+		stores.settings = settings
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelPush, 1))
+
+		if len(fake.Deliveries()) != 0 {
+			t.Fatal("notifications were delivered during quiet hours")
+		}
+		until, ok := stores.deferred[1]
+		if !ok {
+			t.Fatal("job was not deferred")
+		}
+		if !until.After(now) || until.After(now.Add(time.Hour+time.Minute)) {
+			t.Errorf("got job deferred until %s; want the end of the quiet hours", until)
+		}
+	})
+
+	t.Run("webhooks ignore quiet hours", func(t *testing.T) {
+		now := time.Now().UTC()
+		settings := data.DefaultNotificationSettings(1)
+		settings.Channels = []string{data.NotificationChannelWebhook}
+		settings.WebhookURL = pgtype.Text{String: "https://example.com/hook", Valid: true}
+		settings.QuietHoursStart = pgtype.Text{String: now.Add(-time.Hour).Format("15:04"), Valid: true}
+		settings.QuietHoursEnd = pgtype.Text{String: now.Add(time.Hour).Format("15:04"), Valid: true}
+		fake := notifier.NewFakeProvider(data.NotificationChannelWebhook)
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelWebhook: fake}
+		stores := &models.notifications
+		stores.settings = settings
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelWebhook, 1))
+
+		if len(fake.Deliveries()) != 1 {
+			t.Fatal("webhook notifications were not delivered during quiet hours")
+		}
+	})
+
+	t.Run("skips over the hourly limit", func(t *testing.T) {
+		settings := data.DefaultNotificationSettings(1)
+		settings.MaxPerHour = 3
+		fake := notifier.NewFakeProvider(data.NotificationChannelPush)
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelPush: fake}
+		stores := &models.notifications
+		stores.settings = settings
+		stores.sentCount = 2
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelPush, 3))
+
+		deliveries := fake.Deliveries()
+		if len(deliveries) != 1 || len(deliveries[0].Notifications) != 1 {
+			t.Fatalf("got deliveries %+v; want one delivery of 1 notification", deliveries)
+		}
+		if len(stores.skipped) != 2 || stores.skipped[2] != "rate limited" || stores.skipped[3] != "rate limited" {
+			t.Errorf("got skipped jobs %v; want jobs 2 and 3 rate limited", stores.skipped)
+		}
+	})
+
+	t.Run("skips when the limit is used up", func(t *testing.T) {
+		settings := data.DefaultNotificationSettings(1)
+		settings.MaxPerHour = 3
+		fake := notifier.NewFakeProvider(data.NotificationChannelPush)
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelPush: fake}
+		stores := &models.notifications
+		stores.settings = settings
+		stores.sentCount = 5
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelPush, 2))
+
+		if len(fake.Deliveries()) != 0 {
+			t.Fatal("notifications were delivered over the hourly limit")
+		}
+		if len(stores.skipped) != 2 {
+			t.Errorf("got skipped jobs %v; want both jobs skipped", stores.skipped)
+		}
+	})
+
+	t.Run("skips disabled channels", func(t *testing.T) {
+		settings := data.DefaultNotificationSettings(1)
+		settings.Channels = []string{data.NotificationChannelPush}
+		fake := notifier.NewFakeProvider(data.NotificationChannelEmail)
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelEmail: fake}
+		stores := &models.notifications
+		stores.settings = settings
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelEmail, 1))
+
+		if len(fake.Deliveries()) != 0 {
+			t.Fatal("notifications were delivered through a disabled channel")
+		}
+		if reason := stores.skipped[1]; reason != "channel was disabled by the user" {
+			t.Errorf("got skip reason %q; want the channel to be disabled", reason)
+		}
+	})
+
+	t.Run("retries on provider errors", func(t *testing.T) {
+		settings := data.DefaultNotificationSettings(1)
+		fake := notifier.NewFakeProvider(data.NotificationChannelPush)
+		fake.Err = errors.New("unavailable")
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelPush: fake}
+		stores := &models.notifications
+		stores.settings = settings
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelPush, 2))
+
+		if len(stores.retried) != 2 || len(stores.sent) != 0 {
+			t.Fatalf("got retried %v and sent %v; want both jobs retried", stores.retried, stores.sent)
+		}
+		if reason := stores.retried[1]; reason != "push delivery failed: unavailable" {
+			t.Errorf("got retry error %q", reason)
+		}
+	})
+
+	t.Run("skips recipients without a destination", func(t *testing.T) {
+		settings := data.DefaultNotificationSettings(1)
+		fake := notifier.NewFakeProvider(data.NotificationChannelPush)
+		fake.Err = notifier.ErrNoDestination
+		app, models := newTestApplication(t)
+		app.notifiers = map[string]notifier.Provider{data.NotificationChannelPush: fake}
+		stores := &models.notifications
+		stores.settings = settings
+
+		app.deliverNotificationJobs(newNotificationJobs(data.NotificationChannelPush, 1))
+
+		if len(stores.retried) != 0 || len(stores.skipped) != 1 {
+			t.Errorf("got retried %v and skipped %v; want the job skipped", stores.retried, stores.skipped)
+		}
+	})
+}
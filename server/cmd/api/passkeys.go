@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/aravindmathradan/semaphore/internal/webauthn"
+)
+
+// passkeyUserHandle returns the user handle passkeys of a user are registered with. Passkeys
+// return it on login, which lets the server check that a passkey belongs to the expected user.
+func passkeyUserHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// createPasskeyRegistrationOptions starts the registration of a passkey for the current user,
+// after the user re-authenticated. Adding a passkey grants lasting access to the account, so a
+// stolen authentication token must not be enough to do it.
+func (app *application) createPasskeyRegistrationOptions(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readReauthentication(w, r)
+	if !ok {
+		return
+	}
+
+	passkeys, err := app.models.Passkeys.FindAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	exclude := make([]webauthn.CredentialDescriptor, len(passkeys))
+	for i, passkey := range passkeys {
+		exclude[i] = webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         passkey.CredentialID,
+			Transports: passkey.Transports,
+		}
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Passkeys.InsertChallenge(challenge, user.ID, data.WebAuthnCeremonyRegistration, webauthn.Timeout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	options := app.webauthn.CreationOptions(webauthn.UserEntity{
+		ID:          passkeyUserHandle(user.ID),
+		Name:        user.Email,
+		DisplayName: user.FullName,
+	}, challenge, exclude)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"options": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasskey completes the registration of a passkey with the credential the authenticator
+// created for the registration options
+func (app *application) createPasskey(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name       string                          `json:"name"`
+		Credential webauthn.RegistrationCredential `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasskeyName(v, input.Name)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	challenge, err := webauthn.Challenge(input.Credential.Response.ClientDataJSON)
+	if err != nil {
+		v.AddError("credential", "Invalid passkey")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := app.models.Passkeys.ConsumeChallenge(challenge, data.WebAuthnCeremonyRegistration)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if userID != user.ID {
+		v.AddError("credential", "Passkey registration has expired, please try again")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	credential, err := app.webauthn.VerifyRegistration(challenge, &input.Credential)
+	if err != nil {
+		v.AddError("credential", "Invalid passkey")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	passkey := &data.Passkey{
+		UserID:         user.ID,
+		CredentialID:   credential.ID,
+		PublicKey:      credential.PublicKey,
+		SignCount:      credential.SignCount,
+		AAGUID:         credential.AAGUID,
+		Transports:     credential.Transports,
+		BackupEligible: credential.BackupEligible,
+		Name:           input.Name,
+	}
+
+	err = app.models.Passkeys.Insert(passkey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePasskey):
+			v.AddError("credential", "This passkey is already registered")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"passkey": passkey}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasskeyLoginOptions starts a passkey login. No credentials are listed, so that the
+// authenticator offers all of its passkeys for the site and no username has to be entered.
+func (app *application) createPasskeyLoginOptions(w http.ResponseWriter, r *http.Request) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Passkeys.InsertChallenge(challenge, 0, data.WebAuthnCeremonyLogin, webauthn.Timeout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"options": app.webauthn.RequestOptions(challenge, nil)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasskeyAuthenticationToken logs a user in with the passkey asserted for the login
+// options. It issues the same tokens as a password login. Passkeys require user verification on
+// the authenticator and cannot be phished, so they are not followed by an MFA step.
+func (app *application) createPasskeyAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Credential webauthn.AssertionCredential `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	challenge, err := webauthn.Challenge(input.Credential.Response.ClientDataJSON)
+	if err != nil {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Passkeys.ConsumeChallenge(challenge, data.WebAuthnCeremonyLogin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	passkey, err := app.models.Passkeys.GetByCredentialID(input.Credential.RawID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	userHandle := input.Credential.Response.UserHandle
+	if len(userHandle) > 0 && string(userHandle) != string(passkeyUserHandle(passkey.UserID)) {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	signCount, err := app.webauthn.VerifyAssertion(challenge, passkey.PublicKey, passkey.SignCount, &input.Credential)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			app.logger.Warn("passkey sign count did not increase, it may have been cloned", "passkey_id", passkey.ID, "user_id", passkey.UserID)
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Passkeys.UpdateAfterLogin(passkey.ID, signCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(passkey.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.startSession(w, r, user, nil)
+}
+
+func (app *application) listPasskeys(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	passkeys, err := app.models.Passkeys.FindAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"passkeys": passkeys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePasskey(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "passkey_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	err = app.models.Passkeys.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/syndication"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// feedsTokenTTL is long since feeds tokens are used by feed readers and other tools
+	// which cannot refresh them. Tokens can be revoked or regenerated at any time.
+	feedsTokenTTL = 10 * 365 * 24 * time.Hour
+
+	// personalFeedItemsCount is the number of latest items served in a personal feed
+	personalFeedItemsCount = 50
+
+	personalFeedSaved = "saved"
+	personalFeedLiked = "liked"
+)
+
+// createFeedsToken generates a new token for the personal feeds of the user. Any existing
+// feeds token is revoked, so the feed URLs built with the old token stop working.
+func (app *application) createFeedsToken(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeFeeds, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, feedsTokenTTL, data.ScopeFeeds)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	links := map[string]map[string]string{}
+	for _, list := range []string{personalFeedSaved, personalFeedLiked} {
+		baseURL := app.personalFeedURL(token.Plaintext, list)
+		links[list] = map[string]string{
+			"rss":  baseURL + "/rss",
+			"atom": baseURL + "/atom",
+			"json": baseURL + "/json",
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"feeds_token": token, "links": links}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteFeedsToken(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeFeeds, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) getSavedItemsFeed(w http.ResponseWriter, r *http.Request) {
+	app.servePersonalFeed(w, r, personalFeedSaved)
+}
+
+func (app *application) getLikedItemsFeed(w http.ResponseWriter, r *http.Request) {
+	app.servePersonalFeed(w, r, personalFeedLiked)
+}
+
+// servePersonalFeed serves the latest saved or liked items of the user who owns the feeds token
+func (app *application) servePersonalFeed(w http.ResponseWriter, r *http.Request, list string) {
+	params := httprouter.ParamsFromContext(r.Context())
+	tokenPlaintext := params.ByName("token")
+	format := params.ByName("format")
+
+	if format != feedFormatRSS && format != feedFormatAtom && format != feedFormatJSONFeed {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, tokenPlaintext); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeFeeds, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filters := data.Filters{
+		Page:     1,
+		PageSize: personalFeedItemsCount,
+	}
+
+	var items []*data.Item
+	var title string
+	switch list {
+	case personalFeedSaved:
+		title = fmt.Sprintf("Saved by %s on Semaphore", user.Username)
+		filters.Sort = "-saved_at"
+		filters.SortSafeList = []string{"-saved_at"}
+
+		savedItems, _, err := app.models.SavedItems.GetAllForUser(user.ID, "", filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, savedItem := range savedItems {
+			items = append(items, savedItem.Item)
+		}
+	default:
+		title = fmt.Sprintf("Liked by %s on Semaphore", user.Username)
+		filters.Sort = "-liked_at"
+		filters.SortSafeList = []string{"-liked_at"}
+
+		likedItems, _, err := app.models.LikedItems.GetAllForUser(user.ID, "", filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, likedItem := range likedItems {
+			items = append(items, likedItem.Item)
+		}
+	}
+
+	link := app.personalFeedURL(tokenPlaintext, list)
+	feed := &syndication.Feed{
+		ID:          fmt.Sprintf("urn:semaphore:user:%d:%s", user.ID, list),
+		Title:       title,
+		Description: title,
+		Link:        link,
+		FeedLink:    link,
+		Items:       make([]*syndication.Item, len(items)),
+	}
+	for i, item := range items {
+		feed.Items[i] = newSyndicationItem(item)
+		if item.UpdatedAt.After(feed.Updated) {
+			feed.Updated = item.UpdatedAt
+		}
+	}
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+
+	// Personal feeds must not be stored by shared caches since the URL contains the token
+	app.writeSyndicationFeed(w, r, feed, format, "private, max-age=300")
+}
+
+func (app *application) personalFeedURL(token, list string) string {
+	return fmt.Sprintf("%s/public/feeds/%s/%s", app.config.baseURL, token, list)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/syndication"
+	"github.com/aravindmathradan/semaphore/public"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// publicWallItemsCount is the number of latest items served in the public feeds of a wall
+	publicWallItemsCount = 50
+
+	feedFormatRSS      = "rss"
+	feedFormatAtom     = "atom"
+	feedFormatJSONFeed = "json"
+	feedFormatHTML     = "html"
+)
+
+var publicWallTemplate = template.Must(template.ParseFS(public.Html, "html/templates/public-wall.tmpl"))
+
+func (app *application) publishWall(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	wall := app.findWallForMember(w, r, wallID, data.WallRoleOwner)
+	if wall == nil {
+		return
+	}
+
+	if wall.IsPrimary {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "Cannot publish primary wall")
+		return
+	}
+
+	err = app.models.Walls.Publish(wall)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	baseURL := app.publicWallURL(wall.PublicID.String)
+	links := map[string]string{
+		"html": baseURL,
+		"rss":  baseURL + "/rss",
+		"atom": baseURL + "/atom",
+		"json": baseURL + "/json",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"wall": wall, "links": links}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unpublishWall(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	wall := app.findWallForMember(w, r, wallID, data.WallRoleOwner)
+	if wall == nil {
+		return
+	}
+
+	err = app.models.Walls.Unpublish(wall)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) getPublicWallRSS(w http.ResponseWriter, r *http.Request) {
+	app.servePublicWall(w, r, feedFormatRSS)
+}
+
+func (app *application) getPublicWallAtom(w http.ResponseWriter, r *http.Request) {
+	app.servePublicWall(w, r, feedFormatAtom)
+}
+
+func (app *application) getPublicWallJSONFeed(w http.ResponseWriter, r *http.Request) {
+	app.servePublicWall(w, r, feedFormatJSONFeed)
+}
+
+func (app *application) getPublicWallPreview(w http.ResponseWriter, r *http.Request) {
+	app.servePublicWall(w, r, feedFormatHTML)
+}
+
+// servePublicWall serves the latest items of a public wall in the given format
+func (app *application) servePublicWall(w http.ResponseWriter, r *http.Request, format string) {
+	publicID := httprouter.ParamsFromContext(r.Context()).ByName("public_id")
+
+	wall, err := app.models.Walls.FindByPublicID(publicID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Items are fetched without a user so that the saved and liked state of members stays private
+	items, _, err := app.models.Items.FindAllForWallByNew(wall.ID, 0, data.CursorFilters{
+		PageSize: publicWallItemsCount,
+		SortMode: data.SortModeNew,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	feed := app.buildPublicWallFeed(wall, items)
+
+	app.writeSyndicationFeed(w, r, feed, format, "public, max-age=300")
+}
+
+// writeSyndicationFeed renders a feed in the given format. The responses carry ETag and
+// Last-Modified headers so that feed readers can make conditional requests.
+func (app *application) writeSyndicationFeed(w http.ResponseWriter, r *http.Request, feed *syndication.Feed, format, cacheControl string) {
+	var body []byte
+	var contentType string
+	var err error
+	switch format {
+	case feedFormatRSS:
+		feed.FeedLink += "/rss"
+		body, err = syndication.RSS(feed)
+		contentType = syndication.ContentTypeRSS
+	case feedFormatAtom:
+		feed.FeedLink += "/atom"
+		body, err = syndication.Atom(feed)
+		contentType = syndication.ContentTypeAtom
+	case feedFormatJSONFeed:
+		feed.FeedLink += "/json"
+		body, err = syndication.JSONFeed(feed)
+		contentType = syndication.ContentTypeJSON
+	default:
+		body, err = renderPublicWallPreview(feed)
+		contentType = "text/html; charset=utf-8"
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+
+	// ServeContent responds with 304 Not Modified for matching If-None-Match and If-Modified-Since headers
+	http.ServeContent(w, r, "", feed.Updated, bytes.NewReader(body))
+}
+
+func (app *application) publicWallURL(publicID string) string {
+	return fmt.Sprintf("%s/public/walls/%s", app.config.baseURL, publicID)
+}
+
+// buildPublicWallFeed converts a wall and its items to a format independent feed. The feed
+// is last updated when the wall or any of its items were last updated.
+func (app *application) buildPublicWallFeed(wall *data.Wall, items []*data.Item) *syndication.Feed {
+	link := app.publicWallURL(wall.PublicID.String)
+
+	feed := &syndication.Feed{
+		ID:          "urn:semaphore:wall:" + wall.PublicID.String,
+		Title:       wall.Name,
+		Description: fmt.Sprintf("%s - a wall curated on Semaphore", wall.Name),
+		Link:        link,
+		FeedLink:    link,
+		Items:       make([]*syndication.Item, len(items)),
+	}
+	if wall.UpdatedAt != nil {
+		feed.Updated = *wall.UpdatedAt
+	}
+
+	for i, item := range items {
+		feed.Items[i] = newSyndicationItem(item)
+		if item.UpdatedAt.After(feed.Updated) {
+			feed.Updated = item.UpdatedAt
+		}
+	}
+
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+
+	return feed
+}
+
+func newSyndicationItem(item *data.Item) *syndication.Item {
+	feedItem := &syndication.Item{
+		ID:          "urn:semaphore:item:" + strconv.FormatInt(item.ID, 10),
+		Title:       item.Title,
+		Link:        item.Link,
+		Description: item.Description,
+		Content:     item.Content.String,
+		ImageURL:    item.ImageURL.String,
+		Categories:  item.Categories,
+		Published:   item.PubDate.Time,
+		Updated:     item.UpdatedAt,
+	}
+	for _, author := range item.Authors {
+		if author != nil && author.Name != "" {
+			feedItem.Authors = append(feedItem.Authors, author.Name)
+		}
+	}
+	if item.Feed != nil {
+		feedItem.Source = item.Feed.Title
+		feedItem.SourceURL = item.Feed.FeedLink
+	}
+	return feedItem
+}
+
+func renderPublicWallPreview(feed *syndication.Feed) ([]byte, error) {
+	previewData := map[string]any{
+		"Title":       feed.Title,
+		"Items":       feed.Items,
+		"RSSURL":      feed.Link + "/rss",
+		"AtomURL":     feed.Link + "/atom",
+		"JSONFeedURL": feed.Link + "/json",
+	}
+
+	buf := new(bytes.Buffer)
+	err := publicWallTemplate.Execute(buf, previewData)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
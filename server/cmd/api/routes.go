@@ -21,11 +21,25 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/static/*filepath", staticFileServer)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	if app.config.env == "development" {
+		router.HandlerFunc(http.MethodGet, "/debug/mail/:template", app.previewEmail)
+	}
 	router.HandlerFunc(http.MethodGet, "/user-agreement", app.userAgreement)
 	router.HandlerFunc(http.MethodGet, "/privacy-policy", app.privacyPolicy)
 	router.HandlerFunc(http.MethodGet, "/account-deletion", app.accountDeletion)
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheck)
 
+	router.HandlerFunc(http.MethodGet, "/public/walls/:public_id", app.getPublicWallPreview)
+	router.HandlerFunc(http.MethodGet, "/public/walls/:public_id/rss", app.getPublicWallRSS)
+	router.HandlerFunc(http.MethodGet, "/public/walls/:public_id/atom", app.getPublicWallAtom)
+	router.HandlerFunc(http.MethodGet, "/public/walls/:public_id/json", app.getPublicWallJSONFeed)
+	router.HandlerFunc(http.MethodGet, "/public/feeds/:token/saved/:format", app.getSavedItemsFeed)
+	router.HandlerFunc(http.MethodGet, "/public/feeds/:token/liked/:format", app.getLikedItemsFeed)
+	router.HandlerFunc(http.MethodGet, "/public/digests/unsubscribe", app.unsubscribeFromDigests)
+	router.HandlerFunc(http.MethodPost, "/public/digests/unsubscribe", app.unsubscribeFromDigests)
+	router.HandlerFunc(http.MethodGet, "/public/accounts/unlock", app.showAccountUnlock)
+	router.HandlerFunc(http.MethodPost, "/public/accounts/unlock", app.unlockAccount)
+
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUser)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activate", app.activateUser)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPassword)
@@ -33,12 +47,21 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationToken)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/google", app.createGoogleAuthenticationToken)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationToken)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/webauthn/login/options", app.createPasskeyLoginOptions)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/webauthn/login", app.createPasskeyAuthenticationToken)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc/:provider", app.createOIDCAuthenticationToken)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationToken)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationToken)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetToken)
 
 	authenticated := alice.New(app.requireAuthentication)
 
+	// Routes which API tokens may also use, with the scope they need
+	itemsReadable := alice.New(app.requireScope(data.APITokenScopeItemsRead))
+	followsWritable := alice.New(app.requireScope(data.APITokenScopeFollowsWrite))
+	wallsWritable := alice.New(app.requireScope(data.APITokenScopeWallsWrite))
+
 	router.Handler(http.MethodGet, "/v1/topics", authenticated.ThenFunc(app.listTopicsWithCache))
 
 	router.Handler(http.MethodPut, "/v1/users/username", authenticated.ThenFunc(app.updateUsername))
@@ -46,44 +69,124 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodDelete, "/v1/tokens/authentication", authenticated.ThenFunc(app.deleteAuthenticationToken))
 
 	router.Handler(http.MethodGet, "/v1/me", authenticated.ThenFunc(app.getCurrentUser))
-	router.Handler(http.MethodGet, "/v1/me/feeds", authenticated.ThenFunc(app.listFeedsForUser))
-	router.Handler(http.MethodGet, "/v1/me/feeds/contains", authenticated.ThenFunc(app.checkIfUserFollowsFeeds))
-	router.Handler(http.MethodGet, "/v1/me/items/saved/contains", authenticated.ThenFunc(app.checkIfUserSavedItems))
-	router.Handler(http.MethodGet, "/v1/me/items/liked/contains", authenticated.ThenFunc(app.checkIfUserLikedItems))
-	router.Handler(http.MethodGet, "/v1/me/walls", authenticated.ThenFunc(app.listWalls))
-	router.Handler(http.MethodGet, "/v1/me/items/saved", authenticated.ThenFunc(app.listSavedItemsHandler))
-	router.Handler(http.MethodGet, "/v1/me/items/liked", authenticated.ThenFunc(app.listLikedItemsHandler))
-
-	router.Handler(http.MethodGet, "/v1/feeds", authenticated.ThenFunc(app.listFeeds))
-	router.Handler(http.MethodGet, "/v1/feeds/:feed_id", authenticated.ThenFunc(app.getFeed))
+	router.Handler(http.MethodDelete, "/v1/me", authenticated.ThenFunc(app.deleteCurrentUser))
+	router.Handler(http.MethodGet, "/v1/me/mfa", authenticated.ThenFunc(app.getMFAStatus))
+	router.Handler(http.MethodPost, "/v1/me/mfa/totp", authenticated.ThenFunc(app.enrollTOTP))
+	router.Handler(http.MethodPut, "/v1/me/mfa/totp/verify", authenticated.ThenFunc(app.verifyTOTP))
+	router.Handler(http.MethodDelete, "/v1/me/mfa/totp", authenticated.ThenFunc(app.disableTOTP))
+	router.Handler(http.MethodPost, "/v1/me/mfa/recovery_codes", authenticated.ThenFunc(app.regenerateRecoveryCodes))
+	router.Handler(http.MethodPost, "/v1/tokens/webauthn/registration/options", authenticated.ThenFunc(app.createPasskeyRegistrationOptions))
+	router.Handler(http.MethodPost, "/v1/tokens/webauthn/registration", authenticated.ThenFunc(app.createPasskey))
+	router.Handler(http.MethodGet, "/v1/me/passkeys", authenticated.ThenFunc(app.listPasskeys))
+	router.Handler(http.MethodDelete, "/v1/me/passkeys/:passkey_id", authenticated.ThenFunc(app.deletePasskey))
+	router.Handler(http.MethodGet, "/v1/me/sessions", authenticated.ThenFunc(app.listUserSessions))
+	router.Handler(http.MethodDelete, "/v1/me/sessions/:session_id", authenticated.ThenFunc(app.deleteUserSession))
+	router.Handler(http.MethodGet, "/v1/me/identities", authenticated.ThenFunc(app.listUserIdentities))
+	router.Handler(http.MethodPost, "/v1/me/identities", authenticated.ThenFunc(app.createUserIdentity))
+	router.Handler(http.MethodDelete, "/v1/me/identities/:identity_id", authenticated.ThenFunc(app.deleteUserIdentity))
+	router.Handler(http.MethodGet, "/v1/me/api_tokens", authenticated.ThenFunc(app.listAPITokens))
+	router.Handler(http.MethodPost, "/v1/me/api_tokens", authenticated.ThenFunc(app.createAPIToken))
+	router.Handler(http.MethodDelete, "/v1/me/api_tokens/:token_id", authenticated.ThenFunc(app.deleteAPIToken))
+	router.Handler(http.MethodGet, "/v1/me/feeds", itemsReadable.ThenFunc(app.listFeedsForUser))
+	router.Handler(http.MethodGet, "/v1/me/feeds/contains", itemsReadable.ThenFunc(app.checkIfUserFollowsFeeds))
+	router.Handler(http.MethodGet, "/v1/me/items/saved/contains", itemsReadable.ThenFunc(app.checkIfUserSavedItems))
+	router.Handler(http.MethodGet, "/v1/me/items/liked/contains", itemsReadable.ThenFunc(app.checkIfUserLikedItems))
+	router.Handler(http.MethodGet, "/v1/me/walls", itemsReadable.ThenFunc(app.listWalls))
+	router.Handler(http.MethodGet, "/v1/me/wall_invitations", authenticated.ThenFunc(app.listWallInvitations))
+	router.Handler(http.MethodGet, "/v1/me/items/saved", itemsReadable.ThenFunc(app.listSavedItemsHandler))
+	router.Handler(http.MethodGet, "/v1/me/items/liked", itemsReadable.ThenFunc(app.listLikedItemsHandler))
+	router.Handler(http.MethodGet, "/v1/me/recommendations/feeds", authenticated.ThenFunc(app.listFeedRecommendations))
+	router.Handler(http.MethodGet, "/v1/me/sync", authenticated.ThenFunc(app.syncHandler))
+	router.Handler(http.MethodGet, "/v1/walls/:wall_id/stream", authenticated.ThenFunc(app.streamWallEvents))
+	router.Handler(http.MethodGet, "/v1/me/notifications/settings", authenticated.ThenFunc(app.getNotificationSettings))
+	router.Handler(http.MethodPut, "/v1/me/notifications/settings", authenticated.ThenFunc(app.updateNotificationSettings))
+	router.Handler(http.MethodGet, "/v1/me/notifications/subscriptions", authenticated.ThenFunc(app.listNotificationSubscriptions))
+	router.Handler(http.MethodPost, "/v1/me/notifications/subscriptions", authenticated.ThenFunc(app.createNotificationSubscription))
+	router.Handler(http.MethodDelete, "/v1/me/notifications/subscriptions/:subscription_id", authenticated.ThenFunc(app.deleteNotificationSubscription))
+	router.Handler(http.MethodPut, "/v1/me/push_devices", authenticated.ThenFunc(app.registerPushDevice))
+	router.Handler(http.MethodDelete, "/v1/me/push_devices/:token", authenticated.ThenFunc(app.deletePushDevice))
+
+	router.Handler(http.MethodGet, "/v1/feeds", itemsReadable.ThenFunc(app.listFeeds))
+	router.Handler(http.MethodGet, "/v1/feeds/:feed_id", itemsReadable.ThenFunc(app.getFeed))
 	router.Handler(http.MethodGet, "/v1/feeds/:feed_id/followers", authenticated.ThenFunc(app.listFollowersForFeed))
-	router.Handler(http.MethodPut, "/v1/feeds/:feed_id/followers", authenticated.ThenFunc(app.requirePermission(data.PermissionFeedsFollow, app.followFeed)))
-	router.Handler(http.MethodDelete, "/v1/feeds/:feed_id/followers", authenticated.ThenFunc(app.requirePermission(data.PermissionFeedsFollow, app.unfollowFeed)))
-	router.Handler(http.MethodGet, "/v1/feeds/:feed_id/items", authenticated.ThenFunc(app.listItemsForFeed))
-
-	router.Handler(http.MethodPut, "/v1/walls/:wall_id/feeds/:feed_id", authenticated.ThenFunc(app.addFeedToWall))
-	router.Handler(http.MethodDelete, "/v1/walls/:wall_id/feeds/:feed_id", authenticated.ThenFunc(app.removeFeedFromWall))
-	router.Handler(http.MethodGet, "/v1/walls/:wall_id/feeds", authenticated.ThenFunc(app.listFeedsForWall))
-	router.Handler(http.MethodGet, "/v1/walls/:wall_id/items", authenticated.ThenFunc(app.listItemsForWall))
+	router.Handler(http.MethodPut, "/v1/feeds/:feed_id/followers", followsWritable.ThenFunc(app.requirePermission(data.PermissionFeedsFollow, app.followFeed)))
+	router.Handler(http.MethodDelete, "/v1/feeds/:feed_id/followers", followsWritable.ThenFunc(app.requirePermission(data.PermissionFeedsFollow, app.unfollowFeed)))
+	router.Handler(http.MethodGet, "/v1/feeds/:feed_id/items", itemsReadable.ThenFunc(app.listItemsForFeed))
+
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/feeds/:feed_id", wallsWritable.ThenFunc(app.addFeedToWall))
+	router.Handler(http.MethodDelete, "/v1/walls/:wall_id/feeds/:feed_id", wallsWritable.ThenFunc(app.removeFeedFromWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/feeds/:feed_id/filters", wallsWritable.ThenFunc(app.updateWallFeedFilters))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/feeds/:feed_id/mute", wallsWritable.ThenFunc(app.muteFeedInWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/feeds/:feed_id/unmute", wallsWritable.ThenFunc(app.unmuteFeedInWall))
+	router.Handler(http.MethodGet, "/v1/walls/:wall_id/feeds", itemsReadable.ThenFunc(app.listFeedsForWall))
+	router.Handler(http.MethodGet, "/v1/walls/:wall_id/items", itemsReadable.ThenFunc(app.listItemsForWall))
+	router.Handler(http.MethodGet, "/v1/walls/:wall_id/members", authenticated.ThenFunc(app.listWallMembers))
 
 	router.Handler(http.MethodPut, "/v1/items/:id/save", authenticated.ThenFunc(app.saveItemHandler))
 	router.Handler(http.MethodPut, "/v1/items/:id/unsave", authenticated.ThenFunc(app.unsaveItemHandler))
 	router.Handler(http.MethodPut, "/v1/items/:id/like", authenticated.ThenFunc(app.likeItemHandler))
 	router.Handler(http.MethodPut, "/v1/items/:id/unlike", authenticated.ThenFunc(app.unlikeItemHandler))
+	router.Handler(http.MethodPut, "/v1/items/:id/read", authenticated.ThenFunc(app.readItemHandler))
+	router.Handler(http.MethodPut, "/v1/items/:id/unread", authenticated.ThenFunc(app.unreadItemHandler))
 	router.Handler(http.MethodGet, "/v1/items/:id/like_count", authenticated.ThenFunc(app.getLikeCountHandler))
 
 	activated := authenticated.Append(app.requireActivation)
+	followsWritableActivated := followsWritable.Append(app.requireActivation)
+	wallsWritableActivated := wallsWritable.Append(app.requireActivation)
 
 	router.Handler(http.MethodGet, "/v1/youtube/channel", activated.ThenFunc(app.getYouTubeChannelID))
 
-	router.Handler(http.MethodPost, "/v1/walls", activated.ThenFunc(app.createWall))
-	router.Handler(http.MethodPut, "/v1/walls/:wall_id", activated.ThenFunc(app.updateWall))
-	router.Handler(http.MethodDelete, "/v1/walls/:wall_id", activated.ThenFunc(app.deleteWall))
-	router.Handler(http.MethodPut, "/v1/walls/:wall_id/pin", activated.ThenFunc(app.pinWall))
-	router.Handler(http.MethodPut, "/v1/walls/:wall_id/unpin", activated.ThenFunc(app.unpinWall))
-
-	router.Handler(http.MethodPost, "/v1/feeds", activated.ThenFunc(app.requirePermission(data.PermissionFeedsWrite, app.addAndFollowFeed)))
+	router.Handler(http.MethodPost, "/v1/walls", wallsWritableActivated.ThenFunc(app.createWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id", wallsWritableActivated.ThenFunc(app.updateWall))
+	router.Handler(http.MethodDelete, "/v1/walls/:wall_id", wallsWritableActivated.ThenFunc(app.deleteWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/pin", wallsWritableActivated.ThenFunc(app.pinWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/unpin", wallsWritableActivated.ThenFunc(app.unpinWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/publish", activated.ThenFunc(app.publishWall))
+	router.Handler(http.MethodDelete, "/v1/walls/:wall_id/publish", activated.ThenFunc(app.unpublishWall))
+	router.Handler(http.MethodPut, "/v1/walls/:wall_id/members/:user_id", activated.ThenFunc(app.updateWallMember))
+	router.Handler(http.MethodDelete, "/v1/walls/:wall_id/members/:user_id", activated.ThenFunc(app.removeWallMember))
+	router.Handler(http.MethodPost, "/v1/walls/:wall_id/invitations", activated.ThenFunc(app.createWallInvitation))
+	router.Handler(http.MethodPut, "/v1/me/wall_invitations/:invitation_id/accept", activated.ThenFunc(app.acceptWallInvitation))
+	router.Handler(http.MethodDelete, "/v1/me/wall_invitations/:invitation_id", activated.ThenFunc(app.declineWallInvitation))
+	router.Handler(http.MethodGet, "/v1/me/digest", activated.ThenFunc(app.getDigestSubscription))
+	router.Handler(http.MethodPut, "/v1/me/digest", activated.ThenFunc(app.updateDigestSubscription))
+	router.Handler(http.MethodDelete, "/v1/me/digest", activated.ThenFunc(app.deleteDigestSubscription))
+	router.Handler(http.MethodPost, "/v1/me/wall_folders", wallsWritableActivated.ThenFunc(app.createWallFolder))
+	router.Handler(http.MethodPut, "/v1/me/wall_folders/:folder_id", wallsWritableActivated.ThenFunc(app.updateWallFolder))
+	router.Handler(http.MethodDelete, "/v1/me/wall_folders/:folder_id", wallsWritableActivated.ThenFunc(app.deleteWallFolder))
+	router.Handler(http.MethodPut, "/v1/me/wall_layout", wallsWritableActivated.ThenFunc(app.updateWallLayout))
+
+	router.Handler(http.MethodGet, "/v1/me/webhooks", activated.ThenFunc(app.listUserWebhooks))
+	router.Handler(http.MethodPost, "/v1/me/webhooks", activated.ThenFunc(app.createUserWebhook))
+	router.Handler(http.MethodGet, "/v1/me/webhooks/:webhook_id", activated.ThenFunc(app.getUserWebhook))
+	router.Handler(http.MethodPut, "/v1/me/webhooks/:webhook_id", activated.ThenFunc(app.updateUserWebhook))
+	router.Handler(http.MethodDelete, "/v1/me/webhooks/:webhook_id", activated.ThenFunc(app.deleteUserWebhook))
+	router.Handler(http.MethodGet, "/v1/me/webhooks/:webhook_id/deliveries", activated.ThenFunc(app.listUserWebhookDeliveries))
+
+	router.Handler(http.MethodGet, "/v1/admin/webhooks", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.listSystemWebhooks)))
+	router.Handler(http.MethodPost, "/v1/admin/webhooks", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.createSystemWebhook)))
+	router.Handler(http.MethodGet, "/v1/admin/webhooks/:webhook_id", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.getSystemWebhook)))
+	router.Handler(http.MethodPut, "/v1/admin/webhooks/:webhook_id", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.updateSystemWebhook)))
+	router.Handler(http.MethodDelete, "/v1/admin/webhooks/:webhook_id", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.deleteSystemWebhook)))
+	router.Handler(http.MethodGet, "/v1/admin/webhooks/:webhook_id/deliveries", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.listSystemWebhookDeliveries)))
+
+	router.Handler(http.MethodGet, "/v1/admin/emails/stuck", activated.ThenFunc(app.requirePermission(data.PermissionAllAdmin, app.listStuckEmails)))
+
+	router.Handler(http.MethodPost, "/v1/batch/feed_follows", followsWritable.ThenFunc(app.requirePermission(data.PermissionFeedsFollow, app.batchFeedFollows)))
+	router.Handler(http.MethodPost, "/v1/batch/walls/:wall_id/feeds", wallsWritable.ThenFunc(app.batchWallFeeds))
+	router.Handler(http.MethodPost, "/v1/batch/items", authenticated.ThenFunc(app.batchItems))
+
+	router.Handler(http.MethodPost, "/v1/tokens/feeds", activated.ThenFunc(app.createFeedsToken))
+	router.Handler(http.MethodDelete, "/v1/tokens/feeds", activated.ThenFunc(app.deleteFeedsToken))
+
+	router.Handler(http.MethodPost, "/v1/feeds", followsWritableActivated.ThenFunc(app.requirePermission(data.PermissionFeedsWrite, app.addAndFollowFeed)))
+
+	// The vendored httprouter does not allow a static segment next to the :id wildcard of the
+	// item routes, so trending items are routed before requests reach the router
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/items/trending", itemsReadable.ThenFunc(app.listTrendingItems))
+	mux.Handle("/", router)
 
 	standard := alice.New(app.metrics, app.recoverPanic, app.enableCORS, app.rateLimit, app.authenticate)
-	return standard.Then(router)
+	return standard.Then(mux)
 }
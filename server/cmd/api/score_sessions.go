@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/cache"
+	"github.com/aravindmathradan/semaphore/internal/data"
+)
+
+const (
+	// scoreSessionTTL is the duration for which a score session is kept after it was last paginated
+	scoreSessionTTL = 30 * time.Minute
+
+	// scoreSessionChunkSize is the number of item scores loaded into a score session at a time
+	scoreSessionChunkSize = 300
+)
+
+// scoreLoader loads up to limit item scores ranked after the given item score (or from the top
+// if after is nil). It also reports whether there are no more item scores to load.
+type scoreLoader func(after *data.ItemScore, limit int) ([]*data.ItemScore, bool, error)
+
+func (app *application) getScoreSession(ctx context.Context, sessionID string) (*data.ScoreSession, error) {
+	val, err := app.cache.Get(ctx, cache.GenerateScoreSessionKey(sessionID))
+	if err != nil {
+		return nil, err
+	}
+
+	if val == nil {
+		return nil, data.ErrSessionExpired
+	}
+
+	var session data.ScoreSession
+	err = json.Unmarshal(val, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// saveScoreSession stores the session and extends its expiry by scoreSessionTTL
+func (app *application) saveScoreSession(ctx context.Context, session *data.ScoreSession) error {
+	session.ExpiresAt = time.Now().Add(scoreSessionTTL)
+
+	val, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return app.cache.Set(ctx, cache.GenerateScoreSessionKey(session.ID), val, scoreSessionTTL)
+}
+
+// loadScoreSessionPage makes sure that the session has enough item scores loaded to serve the
+// page after the cursor, loading the next chunk of item scores if required
+func (app *application) loadScoreSessionPage(session *data.ScoreSession, filters data.CursorFilters, load scoreLoader) error {
+	remaining, err := session.Remaining(filters.After)
+	if err != nil {
+		return err
+	}
+
+	if remaining >= filters.PageSize || session.Exhausted {
+		return nil
+	}
+
+	itemScores, exhausted, err := load(session.Last(), max(scoreSessionChunkSize, filters.PageSize))
+	if err != nil {
+		return err
+	}
+
+	session.Extend(itemScores)
+	session.Exhausted = exhausted
+
+	return nil
+}
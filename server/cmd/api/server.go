@@ -22,6 +22,9 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelInfo),
 	}
 
+	// Event streams never become idle, so they are ended when the server starts shutting down
+	srv.RegisterOnShutdown(app.events.Close)
+
 	shutdownError := make(chan error)
 	go func() {
 		// Create a quit channel which carries os.Signal values.
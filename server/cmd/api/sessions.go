@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/tomasen/realip"
+)
+
+const (
+	maxSessionNameLength      = 64
+	maxSessionUserAgentLength = 256
+)
+
+// deviceSession describes the device a request comes from, to create or refresh a session with.
+// Clients may name the device with the X-Device-Name header, otherwise it is named after the
+// browser and operating system in the user agent.
+func deviceSession(r *http.Request, userID int64) *data.UserSession {
+	userAgent := truncate(r.UserAgent(), maxSessionUserAgentLength)
+
+	name := truncate(strings.TrimSpace(r.Header.Get("X-Device-Name")), maxSessionNameLength)
+	if name == "" {
+		name = describeUserAgent(userAgent)
+	}
+
+	return &data.UserSession{
+		UserID:    userID,
+		Name:      name,
+		UserAgent: userAgent,
+		IPAddress: realip.FromRequest(r),
+	}
+}
+
+// describeUserAgent names a device after the browser and the operating system in its user agent,
+// such as "Firefox on Windows"
+func describeUserAgent(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(userAgent, "Dart/"):
+		browser = "Semaphore app"
+	}
+
+	var os string
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+// truncate shortens a string to at most n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+func (app *application) listUserSessions(w http.ResponseWriter, r *http.Request) {
+	session := app.contextGetSession(r)
+
+	sessions, err := app.models.UserSessions.FindAllForUser(session.User.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, s := range sessions {
+		s.Current = s.ID == session.Token.SessionID
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserSession signs the current user out of one of their sessions by revoking its tokens
+func (app *application) deleteUserSession(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "session_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	err = app.models.UserSessions.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
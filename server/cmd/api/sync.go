@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+)
+
+const (
+	syncChangesLimit = 500
+	syncItemsLimit   = 200
+)
+
+// syncHandler returns what changed for the current user since the given sync token. Without a
+// token, a token for the current position is returned and the client is expected to load its
+// state from the regular endpoints first. Changes only carry the entity and its ID, and the client
+// fetches upserted entities from the regular endpoints. New items of the followed feeds are
+// returned in full. The client keeps calling with the returned token while has_more is true.
+func (app *application) syncHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+	tokenParam := app.readString(r.URL.Query(), "token", "")
+
+	if tokenParam == "" {
+		token, err := app.models.UserChanges.NewSyncToken(user.ID, app.config.sync.settleDelay)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"sync_token": token.Encode()}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := data.DecodeSyncToken(tokenParam)
+	if err != nil {
+		v := validator.New()
+		v.AddError("token", "Invalid sync token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, lastSeq, hasMoreChanges, err := app.models.UserChanges.FindSince(user.ID, token.Seq, syncChangesLimit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSyncTokenExpired):
+			app.syncTokenExpiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	items, nextToken, hasMoreItems, err := app.models.Items.FindUpdatedForUser(user.ID, token.ItemsUpdatedAt, token.ItemID, app.config.sync.settleDelay, syncItemsLimit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	nextToken.Seq = lastSeq
+
+	env := envelope{
+		"changes": changes,
+		"items":   items,
+		// Items older than this may have been removed by the items cleanup
+		"items_expire_before": time.Now().Add(-1 * app.config.cleanup.itemsCleanupBeforeDuration),
+		"sync_token":          nextToken.Encode(),
+		"has_more":            hasMoreChanges || hasMoreItems,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) CleanupUserChanges() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("sync change log cleanup shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			err := app.models.UserChanges.Cleanup(startTime.Add(-1 * app.config.cleanup.changesCleanupBeforeDuration))
+			if err != nil {
+				app.logInternalError("app.models.UserChanges.Cleanup failed", err)
+			}
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.cleanup.changesCleanupPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/aravindmathradan/semaphore/internal/data"
 	"github.com/aravindmathradan/semaphore/internal/validator"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tomasen/realip"
 	"google.golang.org/api/idtoken"
 )
 
@@ -22,6 +23,7 @@ const (
 	refreshTokenTTL       = 30 * 24 * time.Hour
 	activationTokenTTL    = 3 * 24 * time.Hour
 	passwordResetTokenTTL = 45 * time.Minute
+	mfaPendingTokenTTL    = 5 * time.Minute
 )
 
 func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http.Request) {
@@ -60,69 +62,42 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 	} else {
 		user, err = app.models.Users.GetByUsername(input.UsernameOrEmail)
 	}
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-
-	match, err := user.Password.Matches(input.Password)
-	if err != nil {
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if !match {
-		app.invalidCredentialsResponse(w, r)
+	accountKey := loginLockoutKey(user, input.UsernameOrEmail)
+	attempt, ok := app.reserveLoginAttempt(w, r, accountKey)
+	if !ok {
 		return
 	}
 
-	refreshToken, err := app.models.Tokens.New(user.ID, refreshTokenTTL, data.ScopeRefresh)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if user == nil {
+		// Compare the password anyway, so that the response time does not reveal which
+		// accounts exist
+		data.CompareDummyPassword(input.Password)
+		app.recordLoginFailure(r, attempt, nil)
+		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	authToken, err := app.models.Tokens.New(user.ID, authTokenTTL, data.ScopeAuthentication)
+	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	user.LastLoginAt.Time = time.Now()
-	user.LastLoginAt.Valid = true
-	err = app.models.Users.Update(user)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	if !match {
+		app.recordLoginFailure(r, attempt, user)
+		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	isAdmin := permissions.Includes(data.PermissionAllAdmin)
+	app.resetLoginFailures(r, attempt)
+	app.logAuthEvent(r, "login_succeeded", "account", accountKey)
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{
-		"authentication_token": authToken,
-		"refresh_token":        refreshToken,
-		"user":                 user,
-		"permissions":          permissions,
-		"is_admin":             isAdmin,
-	}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-	}
+	app.completeLogin(w, r, user, nil)
 }
 
 func (app *application) deleteAuthenticationToken(w http.ResponseWriter, r *http.Request) {
@@ -137,6 +112,15 @@ func (app *application) deleteAuthenticationToken(w http.ResponseWriter, r *http
 	var err error
 	switch deleteSessionScope {
 	case deleteLocalSessionScope:
+		// Revoking the session revokes its refresh token too. Tokens issued before sessions
+		// existed are deleted one by one.
+		if session.Token.SessionID != 0 {
+			err = app.models.UserSessions.Delete(session.Token.SessionID, session.User.ID)
+			if errors.Is(err, data.ErrRecordNotFound) {
+				err = nil
+			}
+			break
+		}
 		if refreshToken != "" && len(refreshToken) == 26 {
 			refreshTokenHash := sha256.Sum256([]byte(refreshToken))
 			err = app.models.Tokens.DeleteByHash(refreshTokenHash[:])
@@ -145,11 +129,18 @@ func (app *application) deleteAuthenticationToken(w http.ResponseWriter, r *http
 			err = app.models.Tokens.DeleteByHash(session.Token.Hash)
 		}
 	case deleteGlobalSessionScope:
-		err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, session.User.ID)
+		err = app.models.UserSessions.DeleteAllForUser(session.User.ID)
+		if err == nil {
+			err = app.models.Tokens.DeleteAllForUser(data.ScopeRefresh, session.User.ID)
+		}
 		if err == nil {
 			err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, session.User.ID)
 		}
 	case deleteOthersSessionScope:
+		if session.Token.SessionID != 0 {
+			err = app.models.UserSessions.DeleteAllForUserExcept(session.User.ID, session.Token.SessionID)
+			break
+		}
 		if refreshToken != "" && len(refreshToken) == 26 {
 			refreshTokenHash := sha256.Sum256([]byte(refreshToken))
 			err = app.models.Tokens.DeleteAllForUserExcept(data.ScopeAuthentication, session.User.ID, refreshTokenHash[:])
@@ -187,46 +178,40 @@ func (app *application) createActivationToken(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "No matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-
-	if user.Activated {
-		v.AddError("email", "Your account has already been activated")
-		app.failedValidationResponse(w, r, v.Errors)
+	if !app.allowAccountEmail(w, r, input.Email) {
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
-	if err != nil {
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"username":        user.Username,
-		}
-
+	// The response is the same whether an account with the email address exists and needs to
+	// be activated or not, so that it cannot be used to find out which email addresses have
+	// an account
+	if user != nil && !user.Activated {
 		// Since email addresses MAY be case sensitive, notice that we are sending this
 		// email using the address stored in our database for the user --- not to the
 		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "activation_token.tmpl", data)
+		_, err = app.models.Tokens.NewWithEmail(user.ID, activationTokenTTL, data.ScopeActivation, func(token *data.Token) *data.OutboxEmail {
+			return &data.OutboxEmail{
+				Recipient: user.Email,
+				Template:  "activation_token.tmpl",
+				Data: map[string]any{
+					"activationToken": token.Plaintext,
+					"username":        user.Username,
+				},
+			}
+		})
 		if err != nil {
-			app.logger.Error(err.Error())
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
+	}
 
-	env := envelope{"message": "An email will be sent to you containing the activation token"}
+	env := envelope{"message": "If your account needs to be activated, an email will be sent to you containing the activation token"}
 
 	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
@@ -251,42 +236,36 @@ func (app *application) createPasswordResetToken(w http.ResponseWriter, r *http.
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "No matching account found")
-			app.failedValidationResponse(w, r, v.Errors)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-
-	if !user.Activated {
-		v.AddError("email", "Your account must be activated to reset password")
-		app.failedValidationResponse(w, r, v.Errors)
+	if !app.allowAccountEmail(w, r, input.Email) {
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, passwordResetTokenTTL, data.ScopePasswordReset)
-	if err != nil {
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.background(func() {
-		data := map[string]any{
-			"passwordResetToken": token.Plaintext,
-		}
-
-		err = app.mailer.Send(user.Email, "password_reset_token.tmpl", data)
+	// The response is the same whether an activated account with the email address exists or
+	// not, so that it cannot be used to find out which email addresses have an account
+	if user != nil && user.Activated {
+		_, err = app.models.Tokens.NewWithEmail(user.ID, passwordResetTokenTTL, data.ScopePasswordReset, func(token *data.Token) *data.OutboxEmail {
+			return &data.OutboxEmail{
+				Recipient: user.Email,
+				Template:  "password_reset_token.tmpl",
+				Data: map[string]any{
+					"passwordResetToken": token.Plaintext,
+				},
+			}
+		})
 		if err != nil {
-			app.logger.Error(err.Error())
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
+		app.logAuthEvent(r, "password_reset_requested", "account", loginLockoutKey(user, ""))
+	}
 
-	env := envelope{"message": "An email will be sent to you containing the password reset token"}
+	env := envelope{"message": "If an account with this email address exists, an email will be sent to it containing the password reset token"}
 
 	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
@@ -311,35 +290,24 @@ func (app *application) refreshAuthenticationToken(w http.ResponseWriter, r *htt
 		return
 	}
 
-	// Get the user associated with the refresh token
-	user, err := app.models.Users.GetForToken(data.ScopeRefresh, input.RefreshToken)
+	// Implement refresh token rotation by exchanging the current refresh token for a new one
+	// of the same session. Reusing an exchanged refresh token revokes the session, since the
+	// token must have been stolen.
+	userSession, refreshToken, authToken, err := app.models.UserSessions.Refresh(input.RefreshToken, deviceSession(r, 0), refreshTokenTTL, authTokenTTL)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.invalidCredentialsResponse(w, r)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.logger.Warn("refresh token reused, session revoked", "ip", realip.FromRequest(r))
+			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Implement refresh token rotation by deleting the current refresh token
-	// and assigning a new one.
-	refreshTokenHash := sha256.Sum256([]byte(input.RefreshToken))
-	err = app.models.Tokens.DeleteByHash(refreshTokenHash[:])
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	refreshToken, err := app.models.Tokens.New(user.ID, refreshTokenTTL, data.ScopeRefresh)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Create a new authentication token
-	authToken, err := app.models.Tokens.New(user.ID, authTokenTTL, data.ScopeAuthentication)
+	user, err := app.models.Users.GetByID(userSession.UserID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -422,75 +390,115 @@ func (app *application) createGoogleAuthenticationToken(w http.ResponseWriter, r
 	if err != nil {
 		// If user doesn't exist, create a new one
 		if errors.Is(err, data.ErrRecordNotFound) {
-			// Generate a random username
-			username, err := app.generateRandomString(16, "abcdefghijklmnopqrstuvwxyz")
+			user, err = app.createOAuthUser(userInfo.Email, userInfo.Name, userInfo.ProfileImageURL)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
+			isNewUser = true
+		} else {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-			// Create the user
-			user = &data.User{
-				Email:     userInfo.Email,
-				FullName:  userInfo.Name,
-				Username:  username,
-				Activated: true, // Google accounts with verified emails are automatically activated
-			}
-			if userInfo.ProfileImageURL != "" {
-				user.ProfileImageURL = pgtype.Text{
-					String: userInfo.ProfileImageURL,
-					Valid:  true,
-				}
-			}
-			user.Password.SetOAuthPasswordPlaceholder()
+	if !isNewUser && (!user.ProfileImageURL.Valid || user.ProfileImageURL.String == "") && userInfo.ProfileImageURL != "" {
+		user.ProfileImageURL = pgtype.Text{
+			String: userInfo.ProfileImageURL,
+			Valid:  true,
+		}
+	}
 
-			err = app.models.Users.Insert(user)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
+	app.completeLogin(w, r, user, envelope{"is_new_user": isNewUser})
+}
 
-			// Add necessary permissions for the new user
-			err = app.models.Permissions.AddForUser(user.ID, data.PermissionFeedsFollow, data.PermissionFeedsWrite)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
+// createOAuthUser creates an activated user for an account of an identity provider which
+// verified the email address. The user has no password and logs in through the provider.
+func (app *application) createOAuthUser(email, fullName, profileImageURL string) (*data.User, error) {
+	// Generate a random username
+	username, err := app.generateRandomString(16, "abcdefghijklmnopqrstuvwxyz")
+	if err != nil {
+		return nil, err
+	}
 
-			// Create primary wall for new user
-			err = app.models.Walls.InsertPrimaryWall(user.ID)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			isNewUser = true
-		} else {
+	user := &data.User{
+		Email:     email,
+		FullName:  fullName,
+		Username:  username,
+		Activated: true,
+	}
+	if profileImageURL != "" {
+		user.ProfileImageURL = pgtype.Text{
+			String: profileImageURL,
+			Valid:  true,
+		}
+	}
+	user.Password.SetOAuthPasswordPlaceholder()
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add necessary permissions for the new user
+	err = app.models.Permissions.AddForUser(user.ID, data.PermissionFeedsFollow, data.PermissionFeedsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create primary wall for new user
+	err = app.models.Walls.InsertPrimaryWall(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// completeLogin finishes the login of a user who proved their identity. Users with MFA enabled
+// get a short-lived mfa-pending token to exchange for a session once they verified their second
+// factor, other users get a session right away.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, env envelope) {
+	mfaEnabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfaEnabled {
+		mfaToken, err := app.models.Tokens.New(user.ID, mfaPendingTokenTTL, data.ScopeMFAPending)
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"mfa_required": true, "mfa_token": mfaToken}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	refreshToken, err := app.models.Tokens.New(user.ID, refreshTokenTTL, data.ScopeRefresh)
+	app.startSession(w, r, user, env)
+}
+
+// startSession starts a session on the device of the request and issues its authentication and
+// refresh tokens. Logging in during the grace period of an account deletion cancels the deletion.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.User, env envelope) {
+	deletionCancelled, err := app.models.Users.CancelDeletion(user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	authToken, err := app.models.Tokens.New(user.ID, authTokenTTL, data.ScopeAuthentication)
+	refreshToken, authToken, err := app.models.UserSessions.New(deviceSession(r, user.ID), refreshTokenTTL, authTokenTTL)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Update last login timestamp
 	user.LastLoginAt.Time = time.Now()
 	user.LastLoginAt.Valid = true
-	if !isNewUser && (!user.ProfileImageURL.Valid || user.ProfileImageURL.String == "") && userInfo.ProfileImageURL != "" {
-		user.ProfileImageURL = pgtype.Text{
-			String: userInfo.ProfileImageURL,
-			Valid:  true,
-		}
-	}
 	err = app.models.Users.Update(user)
 	if err != nil {
 		switch {
@@ -507,16 +515,18 @@ func (app *application) createGoogleAuthenticationToken(w http.ResponseWriter, r
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	isAdmin := permissions.Includes(data.PermissionAllAdmin)
 
-	// Return the tokens and user info
-	err = app.writeJSON(w, http.StatusCreated, envelope{
-		"authentication_token": authToken,
-		"refresh_token":        refreshToken,
-		"user":                 user,
-		"is_new_user":          isNewUser,
-		"is_admin":             isAdmin,
-	}, nil)
+	if env == nil {
+		env = envelope{}
+	}
+	env["authentication_token"] = authToken
+	env["refresh_token"] = refreshToken
+	env["user"] = user
+	env["permissions"] = permissions
+	env["is_admin"] = permissions.Includes(data.PermissionAllAdmin)
+	env["deletion_cancelled"] = deletionCancelled
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -533,6 +543,18 @@ func (app *application) CleanupTokens() {
 			if err != nil {
 				app.logInternalError("app.models.Tokens.CleanupTokens failed", err)
 			}
+			err = app.models.UserSessions.DeleteExpired()
+			if err != nil {
+				app.logInternalError("app.models.UserSessions.DeleteExpired failed", err)
+			}
+			err = app.models.APITokens.DeleteExpired()
+			if err != nil {
+				app.logInternalError("app.models.APITokens.DeleteExpired failed", err)
+			}
+			err = app.models.Passkeys.DeleteExpiredChallenges()
+			if err != nil {
+				app.logInternalError("app.models.Passkeys.DeleteExpiredChallenges failed", err)
+			}
 			timer := time.NewTimer(time.Until(startTime.Add(app.config.cleanup.tokensCleanupPeriod)))
 			select {
 			case <-app.ctx.Done():
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/cache"
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+)
+
+// trendingSnapshotSize is the number of items in a trending snapshot
+const trendingSnapshotSize = 300
+
+func (app *application) listTrendingItems(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TopicID  int64
+		FeedType string
+		data.CursorFilters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.TopicID = int64(app.readInt(qs, "topic_id", -1, v))
+	input.FeedType = app.readString(qs, "feed_type", "")
+	input.After = app.readString(qs, "after", "")
+	input.SessionID = app.readString(qs, "session_id", "")
+	input.PageSize = app.readInt(qs, "page_size", 16, v)
+	input.SortMode = data.SortModeTrending
+	input.SortSafeList = []data.SortMode{data.SortModeTrending}
+
+	if input.FeedType != "" {
+		data.ValidateFeedType(v, input.FeedType)
+	}
+
+	data.ValidateCursorFilters(v, input.CursorFilters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// SessionID is the base64 encoded key of the trending snapshot that is being paginated. Only
+	// the snapshots of the requested scope can be paginated, so that clients cannot read other keys.
+	b, err := base64.URLEncoding.DecodeString(input.SessionID)
+	sessionID := string(b)
+	if err != nil || (sessionID != "" && !cache.IsTrendingItemScoresKey(sessionID, cache.GenerateTrendingScopeKey(input.TopicID, input.FeedType))) {
+		v.AddError("session_id", "invalid session id")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var itemScores []*data.ItemScore
+	var val []byte
+	if sessionID != "" {
+		val, err = app.cache.Get(ctx, sessionID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if val == nil {
+		// If the client is starting a new session or if the snapshot of the session has expired,
+		// paginate the latest snapshot of the scope (calculating it if it doesn't exist)
+		sessionID, itemScores, err = app.getLatestTrendingItemScores(ctx, input.TopicID, input.FeedType)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		input.SessionID = base64.URLEncoding.EncodeToString([]byte(sessionID))
+	} else if err := json.Unmarshal(val, &itemScores); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	items, metadata, err := app.models.Items.FindByScore(itemScores, user.ID, input.CursorFilters)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			v.AddError("after", "invalid cursor")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"items": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getLatestTrendingItemScores returns the key and the item scores of the latest trending snapshot
+// of a scope. If the scope has no snapshot yet, a new snapshot is calculated.
+func (app *application) getLatestTrendingItemScores(ctx context.Context, topicID int64, feedType string) (string, []*data.ItemScore, error) {
+	snapshotKey, err := app.cache.Get(ctx, cache.GenerateTrendingScopeKey(topicID, feedType))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if snapshotKey != nil {
+		val, err := app.cache.Get(ctx, string(snapshotKey))
+		if err != nil {
+			return "", nil, err
+		}
+
+		if val != nil {
+			var itemScores []*data.ItemScore
+			err = json.Unmarshal(val, &itemScores)
+			if err != nil {
+				return "", nil, err
+			}
+			return string(snapshotKey), itemScores, nil
+		}
+	}
+
+	return app.refreshTrendingItemScores(ctx, topicID, feedType)
+}
+
+// refreshTrendingItemScores calculates a new trending snapshot for a scope and makes it the latest
+// snapshot of the scope. Older snapshots are kept until they expire so that clients paginating them
+// are not interrupted.
+func (app *application) refreshTrendingItemScores(ctx context.Context, topicID int64, feedType string) (string, []*data.ItemScore, error) {
+	itemScores, err := app.models.Items.CalculateTrendingItemScores(topicID, feedType, app.config.trending.window, trendingSnapshotSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	val, err := json.Marshal(itemScores)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scopeKey := cache.GenerateTrendingScopeKey(topicID, feedType)
+	snapshotKey := cache.GenerateTrendingItemScoresKey(scopeKey)
+
+	// Snapshots outlive the refresh period so that a session started right before a refresh
+	// can still be paginated for a while
+	err = app.cache.Set(ctx, snapshotKey, val, app.config.trending.refreshPeriod+10*time.Minute)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = app.cache.Set(ctx, scopeKey, []byte(snapshotKey), app.config.trending.refreshPeriod*2)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return snapshotKey, itemScores, nil
+}
+
+// KeepTrendingItemsFresh periodically recalculates the trending snapshots of all items, of
+// each feed type and of each topic. Other scopes are calculated on demand.
+func (app *application) KeepTrendingItemsFresh() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("trending items refresher shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+
+			ctx, cancel := context.WithTimeout(app.ctx, app.config.trending.refreshPeriod)
+
+			_, _, err := app.refreshTrendingItemScores(ctx, -1, "")
+			if err != nil {
+				app.logInternalError("app.refreshTrendingItemScores failed for all items", err)
+			}
+
+			for _, feedType := range data.FeedTypes {
+				_, _, err = app.refreshTrendingItemScores(ctx, -1, feedType)
+				if err != nil {
+					app.logInternalError("app.refreshTrendingItemScores failed for feed type "+feedType, err)
+				}
+			}
+
+			topics, err := app.models.Topics.GetTopics(ctx)
+			if err != nil {
+				app.logInternalError("app.models.Topics.GetTopics failed", err)
+			}
+			for _, topic := range topics {
+				_, _, err = app.refreshTrendingItemScores(ctx, topic.ID, "")
+				if err != nil {
+					app.logInternalError("app.refreshTrendingItemScores failed for topic "+topic.Code, err)
+				}
+			}
+
+			cancel()
+
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.trending.refreshPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
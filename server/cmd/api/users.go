@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/oidc"
 	"github.com/aravindmathradan/semaphore/internal/validator"
 	"github.com/julienschmidt/httprouter"
+	"google.golang.org/api/idtoken"
 )
 
+// userPurgeBatchSize is the number of users whose deletion is due that are purged per query
+const userPurgeBatchSize = 50
+
 func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		FullName string `json:"full_name"`
@@ -71,26 +78,22 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// The welcome email is queued with the activation token, and sent by the email dispatcher
+	_, err = app.models.Tokens.NewWithEmail(user.ID, 3*24*time.Hour, data.ScopeActivation, func(token *data.Token) *data.OutboxEmail {
+		return &data.OutboxEmail{
+			Recipient: user.Email,
+			Template:  "user_welcome.tmpl",
+			Data: map[string]any{
+				"activationToken": token.Plaintext,
+				"username":        user.Username,
+			},
+		}
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"username":        user.Username,
-		}
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			// Importantly, if there is an error sending the email then we use the
-			// app.logger.Error() helper to manage it, instead of the
-			// app.serverErrorResponse() helper.
-			app.logger.Error(err.Error())
-		}
-	})
-
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -302,3 +305,235 @@ func (app *application) updateUsername(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// reauthentication are the credentials a user re-authenticates with. Inputs of handlers which
+// require a re-authentication embed it.
+type reauthentication struct {
+	Password      string `json:"password"`
+	GoogleIDToken string `json:"google_id_token"`
+	OIDCProvider  string `json:"oidc_provider"`
+	OIDCIDToken   string `json:"oidc_id_token"`
+	Code          string `json:"code"`
+	RecoveryCode  string `json:"recovery_code"`
+}
+
+// reauthenticate checks that the user of a session has just proven their identity again, using
+// their password, or for accounts without a password a Google ID token or an ID token of a
+// linked OpenID Connect identity. It is required before destructive changes, so that a stolen
+// authentication token is not enough to make them.
+func (app *application) reauthenticate(user *data.User, input reauthentication) (bool, error) {
+	if input.Password != "" {
+		if user.Password.IsOAuthPlaceholder() {
+			return false, nil
+		}
+		return user.Password.Matches(input.Password)
+	}
+
+	if input.GoogleIDToken != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		tokenPayload, err := idtoken.Validate(ctx, input.GoogleIDToken, app.config.google.clientID)
+		if err != nil {
+			return false, nil
+		}
+
+		email, _ := tokenPayload.Claims["email"].(string)
+		emailVerified, _ := tokenPayload.Claims["email_verified"].(bool)
+		return emailVerified && strings.EqualFold(email, user.Email), nil
+	}
+
+	if input.OIDCIDToken != "" {
+		claims, err := app.verifyIDToken(input.OIDCProvider, input.OIDCIDToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, errUnknownProvider):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		identity, err := app.models.UserIdentities.GetByProviderSubject(input.OIDCProvider, claims.Subject)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		return identity.UserID == user.ID, nil
+	}
+
+	return false, nil
+}
+
+// readReauthentication reads the credentials of a re-authentication from the request body and
+// checks them. It writes the error response and returns false if the user could not be
+// re-authenticated.
+func (app *application) readReauthentication(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	var input reauthentication
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	return app.checkReauthentication(w, r, input)
+}
+
+// checkReauthentication checks the credentials of a re-authentication against the user of the
+// session. Users with MFA enabled also have to provide a code from their authenticator app or a
+// recovery code. It writes the error response and returns false if the user could not be
+// re-authenticated.
+func (app *application) checkReauthentication(w http.ResponseWriter, r *http.Request, input reauthentication) (*data.User, bool) {
+	v := validator.New()
+	v.Check(input.Password != "" || input.GoogleIDToken != "" || input.OIDCIDToken != "", "password", "Password, Google ID token or OIDC ID token must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	user := app.contextGetSession(r).User
+
+	// Re-authentications are throttled like logins, so that a stolen authentication token
+	// cannot be used to guess the password or the codes
+	accountKey := loginLockoutKey(user, "")
+	attempt, ok := app.reserveLoginAttempt(w, r, accountKey)
+	if !ok {
+		return nil, false
+	}
+
+	ok, err := app.reauthenticate(user, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		app.recordLoginFailure(r, attempt, user)
+		app.invalidCredentialsResponse(w, r)
+		return nil, false
+	}
+
+	mfaEnabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if mfaEnabled {
+		switch {
+		case input.Code != "":
+			ok, err = app.verifyTOTPCode(user.ID, input.Code)
+		case input.RecoveryCode != "":
+			ok, err = app.models.MFA.UseRecoveryCode(user.ID, input.RecoveryCode)
+		default:
+			v.AddError("code", "Code or recovery code must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+			return nil, false
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+		if !ok {
+			app.recordLoginFailure(r, attempt, user)
+			app.invalidCredentialsResponse(w, r)
+			return nil, false
+		}
+	}
+
+	app.resetLoginFailures(r, attempt)
+
+	return user, true
+}
+
+// deleteCurrentUser schedules the deletion of the current user after the grace period. The user
+// is signed out everywhere, and logging in again before the deletion is due cancels it.
+func (app *application) deleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readReauthentication(w, r)
+	if !ok {
+		return
+	}
+
+	deleteAt := time.Now().Add(app.config.accounts.deletionGracePeriod)
+	email := &data.OutboxEmail{
+		Recipient: user.Email,
+		Template:  "account_deletion.tmpl",
+		Data: map[string]any{
+			"username":     user.Username,
+			"deletionDate": deleteAt.UTC().Format("January 2, 2006 15:04 MST"),
+		},
+	}
+
+	err := app.models.Users.ScheduleDeletion(user, deleteAt, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"deletion_scheduled_at": user.DeletionScheduledAt}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PurgeDeletedUsers periodically deletes the users whose scheduled deletion is due
+func (app *application) PurgeDeletedUsers() {
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("user purger shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			app.purgeDueUsers()
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.accounts.purgePeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
+
+func (app *application) purgeDueUsers() {
+	for {
+		userIDs, err := app.models.Users.FindDueForDeletion(userPurgeBatchSize)
+		if err != nil {
+			app.logInternalError("app.models.Users.FindDueForDeletion failed", err)
+			return
+		}
+
+		purged := 0
+		for _, userID := range userIDs {
+			err = app.models.Users.Purge(userID)
+			switch {
+			case err == nil:
+				purged++
+				app.logger.Info("purged deleted user", "user_id", userID)
+			case errors.Is(err, data.ErrRecordNotFound):
+				// The deletion was cancelled in the meantime
+			default:
+				app.logInternalError("app.models.Users.Purge failed", err)
+			}
+		}
+
+		// Stop when a batch could not be purged, so that failing users are not retried in a loop
+		if len(userIDs) < userPurgeBatchSize || purged == 0 {
+			return
+		}
+	}
+}
@@ -15,23 +15,7 @@ func (app *application) addFeedToWall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := app.contextGetSession(r).User
-
-	walls, err := app.models.Walls.FindAllForUser(user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	containsWall := false
-	for _, w := range walls {
-		if w.ID == wallID {
-			containsWall = true
-		}
-	}
-
-	if !containsWall {
-		app.notPermittedResponse(w, r)
+	if app.findWallForMember(w, r, wallID, data.WallRoleEditor) == nil {
 		return
 	}
 
@@ -79,23 +63,7 @@ func (app *application) removeFeedFromWall(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user := app.contextGetSession(r).User
-
-	walls, err := app.models.Walls.FindAllForUser(user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	containsWall := false
-	for _, w := range walls {
-		if w.ID == wallID {
-			containsWall = true
-		}
-	}
-
-	if !containsWall {
-		app.notPermittedResponse(w, r)
+	if app.findWallForMember(w, r, wallID, data.WallRoleEditor) == nil {
 		return
 	}
 
@@ -145,32 +113,116 @@ func (app *application) listFeedsForWall(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	wall, err := app.models.Walls.FindByID(wallID)
+	if app.findWallForMember(w, r, wallID, data.WallRoleViewer) == nil {
+		return
+	}
+
+	feeds, metadata, err := app.models.WallFeeds.FindFeedsForWall(wallID, input.Title, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"feeds": feeds, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWallFeedFilters replaces the filters of a feed in a wall. Empty filters include
+// all the items of the feed.
+func (app *application) updateWallFeedFilters(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	feedID, err := app.readIDParam(r, "feed_id")
+	if err != nil || feedID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input data.WallFeedFilters
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.Normalize()
+
+	v := validator.New()
+	if data.ValidateWallFeedFilters(v, input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if app.findWallForMember(w, r, wallID, data.WallRoleEditor) == nil {
+		return
+	}
+
+	wallFeed := &data.WallFeed{
+		WallID:  wallID,
+		FeedID:  feedID,
+		Filters: input,
+	}
+	err = app.models.WallFeeds.UpdateFilters(wallFeed)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
-			return
 		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
-	}
-
-	user := app.contextGetSession(r).User
-	if wall.UserID != user.ID {
-		app.notPermittedResponse(w, r)
 		return
 	}
 
-	feeds, metadata, err := app.models.WallFeeds.FindFeedsForWall(wallID, input.Title, input.Filters)
+	err = app.writeJSON(w, http.StatusOK, envelope{"wall_feed": wallFeed}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) muteFeedInWall(w http.ResponseWriter, r *http.Request) {
+	app.setFeedMutedInWall(w, r, true)
+}
+
+func (app *application) unmuteFeedInWall(w http.ResponseWriter, r *http.Request) {
+	app.setFeedMutedInWall(w, r, false)
+}
+
+// setFeedMutedInWall mutes or unmutes a feed in a wall. Muting a feed in the primary wall
+// hides its items there while the feed stays followed.
+func (app *application) setFeedMutedInWall(w http.ResponseWriter, r *http.Request, muted bool) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"feeds": feeds, "metadata": metadata}, nil)
+	feedID, err := app.readIDParam(r, "feed_id")
+	if err != nil || feedID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if app.findWallForMember(w, r, wallID, data.WallRoleEditor) == nil {
+		return
+	}
+
+	err = app.models.WallFeeds.SetMuted(wallID, feedID, muted)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func (app *application) createWallFolder(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string `json:"name"`
+		ParentID *int64 `json:"parent_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	folder := &data.WallFolder{
+		UserID: app.contextGetSession(r).User.ID,
+		Name:   input.Name,
+	}
+	if input.ParentID != nil {
+		folder.ParentID = pgtype.Int8{Int64: *input.ParentID, Valid: true}
+	}
+
+	v := validator.New()
+	if data.ValidateWallFolder(v, folder); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WallFolders.Insert(folder)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("parent_id", "Parent folder does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/me/wall_folders/%d", folder.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"folder": folder}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWallFolder(w http.ResponseWriter, r *http.Request) {
+	folderID, err := app.readIDParam(r, "folder_id")
+	if err != nil || folderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	folder := &data.WallFolder{
+		ID:     folderID,
+		UserID: app.contextGetSession(r).User.ID,
+		Name:   input.Name,
+	}
+
+	v := validator.New()
+	if data.ValidateWallFolder(v, folder); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WallFolders.Update(folder)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"folder": folder}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWallFolder deletes a folder. The walls and folders inside it are moved to the top level.
+func (app *application) deleteWallFolder(w http.ResponseWriter, r *http.Request) {
+	folderID, err := app.readIDParam(r, "folder_id")
+	if err != nil || folderID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.WallFolders.Delete(folderID, app.contextGetSession(r).User.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateWallLayout moves walls and folders of the current user after a drag and drop. Only the
+// walls and folders that moved need to be sent. A null folder_id or parent_id moves the wall or
+// folder to the top level.
+func (app *application) updateWallLayout(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Folders []*data.WallFolderPosition `json:"folders"`
+		Walls   []*data.WallPosition       `json:"walls"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Folders) > 0 || len(input.Walls) > 0, "walls", "Walls or folders must be provided")
+	v.Check(len(input.Folders)+len(input.Walls) <= 500, "walls", "Must not contain more than 500 walls and folders")
+
+	folderIDs := make([]int64, len(input.Folders))
+	for i, folder := range input.Folders {
+		if folder == nil {
+			v.AddError("folders", "Must not contain null entries")
+			break
+		}
+		folderIDs[i] = folder.ID
+		v.Check(folder.Position >= 0, "folders", "Positions must not be negative")
+		v.Check(!folder.ParentID.Valid || folder.ParentID.Int64 != folder.ID, "folders", "A folder cannot be moved into itself")
+	}
+	v.Check(validator.Unique(folderIDs), "folders", "Must not contain duplicate folders")
+
+	wallIDs := make([]int64, len(input.Walls))
+	for i, wall := range input.Walls {
+		if wall == nil {
+			v.AddError("walls", "Must not contain null entries")
+			break
+		}
+		wallIDs[i] = wall.WallID
+		v.Check(wall.Position >= 0, "walls", "Positions must not be negative")
+	}
+	v.Check(validator.Unique(wallIDs), "walls", "Must not contain duplicate walls")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WallFolders.UpdateLayout(app.contextGetSession(r).User.ID, input.Folders, input.Walls)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrWallFolderCycle):
+			v.AddError("folders", "A folder cannot be moved into itself or its subfolders")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
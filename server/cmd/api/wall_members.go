@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+)
+
+// wallInvitationTTL is the duration for which an invitation to a wall can be accepted
+const wallInvitationTTL = 7 * 24 * time.Hour
+
+// findWallForMember returns the wall if the current user is a member of the wall with at least
+// the required role. Otherwise, it sends the appropriate error response and returns nil.
+func (app *application) findWallForMember(w http.ResponseWriter, r *http.Request, wallID int64, requiredRole string) *data.Wall {
+	user := app.contextGetSession(r).User
+
+	wall, err := app.models.Walls.FindByIDForUser(wallID, user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+			return nil
+		}
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	if !data.HasWallRole(wall.Role, requiredRole) {
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+
+	return wall
+}
+
+func (app *application) listWallMembers(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if app.findWallForMember(w, r, wallID, data.WallRoleViewer) == nil {
+		return
+	}
+
+	members, err := app.models.WallMembers.FindAllForWall(wallID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWallMember(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readIDParam(r, "user_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateWallMemberRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if app.findWallForMember(w, r, wallID, data.WallRoleOwner) == nil {
+		return
+	}
+
+	err = app.models.WallMembers.UpdateRole(wallID, userID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrChangingWallOwner):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "Cannot change the role of the wall owner")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeWallMember removes a member from a wall. Owners can remove any member
+// and other members can only remove themselves (leave the wall).
+func (app *application) removeWallMember(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readIDParam(r, "user_id")
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	requiredRole := data.WallRoleOwner
+	if userID == app.contextGetSession(r).User.ID {
+		requiredRole = data.WallRoleViewer
+	}
+
+	if app.findWallForMember(w, r, wallID, requiredRole) == nil {
+		return
+	}
+
+	err = app.models.WallMembers.Delete(wallID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrChangingWallOwner):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "Cannot remove the wall owner")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) createWallInvitation(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Role     string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Username != "" || input.Email != "", "username", "Username or email must be provided")
+	if input.Email != "" {
+		data.ValidateEmail(v, input.Email)
+	}
+	data.ValidateWallMemberRole(v, input.Role)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	wall := app.findWallForMember(w, r, wallID, data.WallRoleOwner)
+	if wall == nil {
+		return
+	}
+
+	if wall.IsPrimary {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "Cannot share primary wall")
+		return
+	}
+
+	var invitee *data.User
+	if input.Username != "" {
+		invitee, err = app.models.Users.GetByUsername(input.Username)
+	} else {
+		invitee, err = app.models.Users.GetByEmail(input.Email)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if input.Username != "" {
+				v.AddError("username", "No user found with this username")
+			} else {
+				v.AddError("email", "No user found with this email address")
+			}
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	inviter := app.contextGetSession(r).User
+
+	invitation := &data.WallInvitation{
+		WallID:    wallID,
+		UserID:    invitee.ID,
+		Role:      input.Role,
+		InvitedBy: inviter.ID,
+		Expiry:    time.Now().Add(wallInvitationTTL),
+	}
+
+	email := &data.OutboxEmail{
+		Recipient: invitee.Email,
+		Template:  "wall_invitation.tmpl",
+		Data: map[string]any{
+			"username":        invitee.Username,
+			"inviterFullName": inviter.FullName,
+			"inviterUsername": inviter.Username,
+			"wallName":        wall.Name,
+			"role":            invitation.Role,
+		},
+	}
+
+	err = app.models.WallInvitations.Upsert(invitation, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateWallMember):
+			app.errorResponse(w, r, http.StatusConflict, "The user is already a member of the wall")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/me/wall_invitations/%d", invitation.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWallInvitations(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetSession(r).User
+
+	invitations, err := app.models.WallInvitations.FindAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) acceptWallInvitation(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := app.readIDParam(r, "invitation_id")
+	if err != nil || invitationID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	member, err := app.models.WallInvitations.Accept(invitationID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateWallMember):
+			app.errorResponse(w, r, http.StatusConflict, "You are already a member of the wall")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) declineWallInvitation(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := app.readIDParam(r, "invitation_id")
+	if err != nil || invitationID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetSession(r).User
+
+	err = app.models.WallInvitations.Delete(invitationID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
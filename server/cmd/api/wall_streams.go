@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/events"
+)
+
+const (
+	eventTypeNewItems = "new_items"
+	// eventTypeReset tells the client that events were missed and it should reload the wall
+	eventTypeReset = "reset"
+
+	// Writes to a stream which take longer than this fail and end the stream
+	streamWriteTimeout = 10 * time.Second
+	// Time clients wait before reconnecting to a stream
+	streamRetryDelay = 5 * time.Second
+)
+
+func wallEventsTopic(wallID int64) string {
+	return fmt.Sprintf("walls:%d", wallID)
+}
+
+// publishNewItems notifies the streams of the walls a feed is in that new items are available
+func (app *application) publishNewItems(feedID int64, count int) {
+	wallIDs, err := app.models.WallFeeds.FindWallIDsForFeed(feedID)
+	if err != nil {
+		app.logInternalError("app.models.WallFeeds.FindWallIDsForFeed failed", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, wallID := range wallIDs {
+		payload := map[string]any{
+			"wall_id": wallID,
+			"feed_id": feedID,
+			"count":   count,
+		}
+		_, err := app.events.Publish(ctx, wallEventsTopic(wallID), eventTypeNewItems, payload)
+		if err != nil {
+			app.logInternalError("app.events.Publish failed", err)
+			return
+		}
+	}
+}
+
+// streamWallEvents streams the events of a wall as Server-Sent Events. A comment is sent
+// periodically to keep the connection alive, and clients that reconnect with the Last-Event-ID
+// header receive the events they missed. If some of the missed events are no longer available,
+// a reset event is sent instead and the client should reload the wall.
+func (app *application) streamWallEvents(w http.ResponseWriter, r *http.Request) {
+	wallID, err := app.readIDParam(r, "wall_id")
+	if err != nil || wallID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID != "" && !events.ValidID(lastEventID) {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid Last-Event-ID header")
+		return
+	}
+
+	if app.findWallForMember(w, r, wallID, data.WallRoleViewer) == nil {
+		return
+	}
+
+	user := app.contextGetSession(r).User
+	owner := strconv.FormatInt(user.ID, 10)
+	staleAfter := 3 * app.config.sse.heartbeatPeriod
+
+	randomBytes := make([]byte, 16)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	connectionID := hex.EncodeToString(randomBytes)
+
+	acquired, err := app.events.AcquireConnection(r.Context(), owner, connectionID, app.config.sse.maxConnectionsPerUser, staleAfter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !acquired {
+		app.errorResponse(w, r, http.StatusTooManyRequests, "Too many open streams, please close some of them and try again")
+		return
+	}
+	defer func() {
+		// The request context is already done when the client goes away
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		err := app.events.ReleaseConnection(ctx, owner, connectionID)
+		if err != nil {
+			app.logInternalError("app.events.ReleaseConnection failed", err)
+		}
+	}()
+
+	// Subscribe before reading the missed events so that no event falls in between
+	sub := app.events.Subscribe(wallEventsTopic(wallID))
+	defer app.events.Unsubscribe(sub)
+
+	var missed []events.Event
+	complete := true
+	if lastEventID != "" {
+		missed, complete, err = app.events.Since(r.Context(), wallEventsTopic(wallID), lastEventID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The server write timeout applies to the whole response, so it is replaced by a deadline
+	// for each write
+	rc := http.NewResponseController(w)
+	write := func(message string) error {
+		err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(w, message)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	err = write(fmt.Sprintf("retry: %d\n\n", streamRetryDelay.Milliseconds()))
+	if err != nil {
+		return
+	}
+
+	if !complete {
+		lastEventID = ""
+		err = write(fmt.Sprintf("event: %s\ndata: {}\n\n", eventTypeReset))
+		if err != nil {
+			return
+		}
+	}
+	for _, event := range missed {
+		lastEventID = event.ID
+		err = write(formatEvent(event))
+		if err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(app.config.sse.heartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// The subscriber fell behind or the server is shutting down. The client
+				// reconnects and resumes from the last event it received.
+				return
+			}
+			// Skip the events which were already sent from the stream of missed events
+			if lastEventID != "" && events.CompareIDs(event.ID, lastEventID) <= 0 {
+				continue
+			}
+			lastEventID = event.ID
+			err = write(formatEvent(event))
+		case <-heartbeat.C:
+			// Stop streaming if the user is no longer a member of the wall
+			_, err = app.models.WallMembers.GetRole(wallID, user.ID)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					app.logError(r, err)
+				}
+				return
+			}
+			err = app.events.RefreshConnection(r.Context(), owner, connectionID, staleAfter)
+			if err != nil {
+				app.logError(r, err)
+			}
+			err = write(": heartbeat\n\n")
+		}
+		if err != nil {
+			// The client went away
+			return
+		}
+	}
+}
+
+func formatEvent(event events.Event) string {
+	return fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
@@ -2,23 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/aravindmathradan/semaphore/internal/cache"
 	"github.com/aravindmathradan/semaphore/internal/data"
 	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 func (app *application) createWall(w http.ResponseWriter, r *http.Request) {
 	user := app.contextGetSession(r).User
 
 	var input struct {
-		WallName string `json:"name"`
+		WallName string  `json:"name"`
+		Emoji    *string `json:"emoji"`
+		Color    *string `json:"color"`
+		Icon     *string `json:"icon"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -34,6 +35,7 @@ func (app *application) createWall(w http.ResponseWriter, r *http.Request) {
 		UserID:    user.ID,
 		IsPrimary: false,
 	}
+	setWallAppearance(wall, input.Emoji, input.Color, input.Icon)
 
 	data.ValidateWall(v, wall)
 	if !v.Valid() {
@@ -60,13 +62,13 @@ func (app *application) createWall(w http.ResponseWriter, r *http.Request) {
 func (app *application) listWalls(w http.ResponseWriter, r *http.Request) {
 	user := app.contextGetSession(r).User
 
-	walls, err := app.models.Walls.FindAllForUser(user.ID)
+	tree, err := app.models.Walls.FindTreeForUser(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"walls": walls}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"walls": tree.Walls, "folders": tree.Folders}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -88,7 +90,7 @@ func (app *application) listItemsForWall(w http.ResponseWriter, r *http.Request)
 	filters.SessionID = app.readString(qs, "session_id", "")
 	filters.PageSize = app.readInt(qs, "page_size", 16, v)
 	filters.SortMode = data.SortMode(app.readString(qs, "sort_mode", string(data.SortModeNew)))
-	filters.SortSafeList = []data.SortMode{data.SortModeNew, data.SortModeHot}
+	filters.SortSafeList = []data.SortMode{data.SortModeNew, data.SortModeHot, data.SortModeForYou}
 
 	data.ValidateCursorFilters(v, filters)
 	if !v.Valid() {
@@ -96,21 +98,11 @@ func (app *application) listItemsForWall(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	wall, err := app.models.Walls.FindByID(wallID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
+	if app.findWallForMember(w, r, wallID, data.WallRoleViewer) == nil {
 		return
 	}
 
 	user := app.contextGetSession(r).User
-	if wall.UserID != user.ID {
-		app.notPermittedResponse(w, r)
-		return
-	}
 
 	var items []*data.Item
 	var metadata data.CursorMetadata
@@ -126,86 +118,77 @@ func (app *application) listItemsForWall(w http.ResponseWriter, r *http.Request)
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-	} else if filters.SortMode == data.SortModeHot {
-		// For hot sort, item scores are calculated once and cached throughout the paination session
+	} else if filters.SortMode == data.SortModeHot || filters.SortMode == data.SortModeForYou {
+		// For hot and for you sorts, item scores are loaded into a score session which keeps the
+		// order of the items stable throughout the pagination session
 
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		defer cancel()
 
-		// SessionID is encoded as base64 string when sent to the client
-		// Client sends back the same base64 string.
-		// Decode it to get the actual session ID
-		b, err := base64.URLEncoding.DecodeString(filters.SessionID)
-		if err != nil {
-			v.AddError("session_id", "invalid session id")
-			app.failedValidationResponse(w, r, v.Errors)
-			return
-		}
-		sessionID := string(b)
-
-		var itemScores []*data.ItemScore
-		if sessionID == "" {
-			// Create a new session ID for the current pagination session
-			// SessionID belongs to a wall and sort mode
-			// UserID is not included because a wall can have only one owner
-			sessionID = cache.GenerateItemScoresKey(wallID, string(filters.SortMode))
-
-			// Encode the session ID to send to the client. This will be part of metadata
-			filters.SessionID = base64.URLEncoding.EncodeToString([]byte(sessionID))
-
-			// Calculate item scores for the current pagination session for a snapshot size of 300 (no. of items)
-			itemScores, err = app.models.Items.CalculateHotItemScoresForWall(wallID, user.ID, 300)
+		var session *data.ScoreSession
+		if filters.SessionID == "" {
+			session, err = data.NewScoreSession(wallID, user.ID, filters.SortMode, scoreSessionTTL)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
-
-			// Cache the pagination session with item scores for 10 minutes
-			val, err := json.Marshal(itemScores)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			err = app.cache.Set(ctx, sessionID, val, 10*time.Minute)
 		} else {
-			// If client sends SessionID, get item scores from cache for the corresponding session
-			val, err := app.cache.Get(ctx, sessionID)
+			session, err = app.getScoreSession(ctx, filters.SessionID)
 			if err != nil {
-				app.serverErrorResponse(w, r, err)
+				switch {
+				case errors.Is(err, data.ErrSessionExpired):
+					app.sessionExpiredResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
 				return
 			}
 
-			if val == nil {
-				// If session is not found in cache, create a new session
-				sessionID = cache.GenerateItemScoresKey(wallID, string(filters.SortMode))
-
-				// Encode the session ID to send to the client. This will be part of metadata
-				filters.SessionID = base64.URLEncoding.EncodeToString([]byte(sessionID))
-
-				// Calculate item scores for the current pagination session for 100 top items
-				itemScores, err = app.models.Items.CalculateHotItemScoresForWall(wallID, user.ID, 100)
-				if err != nil {
-					app.serverErrorResponse(w, r, err)
-					return
-				}
+			// Sessions can only be paginated by the user who started them, with the same wall and sort mode
+			if session.WallID != wallID || session.UserID != user.ID || session.SortMode != filters.SortMode {
+				v.AddError("session_id", "invalid session id")
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+		}
 
-				// Cache the pagination session with item scores for 10 minutes
-				val, err := json.Marshal(itemScores)
-				if err != nil {
-					app.serverErrorResponse(w, r, err)
-					return
-				}
-				err = app.cache.Set(ctx, sessionID, val, 10*time.Minute)
+		var load scoreLoader
+		if filters.SortMode == data.SortModeHot {
+			// Hot scores are materialized, so the next chunk can be queried directly after the last loaded item
+			load = func(after *data.ItemScore, limit int) ([]*data.ItemScore, bool, error) {
+				itemScores, err := app.models.Items.FindHotItemScoresForWall(wallID, after, limit)
+				return itemScores, len(itemScores) < limit, err
+			}
+		} else {
+			// For you scores are ranked in memory, so a larger snapshot is calculated and the
+			// item scores which are already loaded are skipped when extending the session
+			load = func(after *data.ItemScore, limit int) ([]*data.ItemScore, bool, error) {
+				snapshotSize := len(session.Scores) + limit
+				itemScores, err := app.models.Affinities.CalculateForYouItemScoresForWall(wallID, user.ID, snapshotSize)
+				return itemScores, len(itemScores) < snapshotSize, err
+			}
+		}
 
-				// Else, decode the item scores from cache
-			} else if err := json.Unmarshal(val, &itemScores); err != nil {
-				app.serverErrorResponse(w, r, err)
+		err = app.loadScoreSessionPage(session, filters, load)
+		if err != nil {
+			if errors.Is(err, data.ErrInvalidCursor) {
+				v.AddError("after", "invalid cursor")
+				app.failedValidationResponse(w, r, v.Errors)
 				return
 			}
+			app.serverErrorResponse(w, r, err)
+			return
 		}
 
+		err = app.saveScoreSession(ctx, session)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		filters.SessionID = session.ID
+
 		// Find items by score. Pagination is handled inside the FindByScore function using the cursor
-		items, metadata, err = app.models.Items.FindByScore(itemScores, user.ID, filters)
+		items, metadata, err = app.models.Items.FindByScore(session.Scores, user.ID, filters)
 		if err != nil {
 			if errors.Is(err, data.ErrInvalidCursor) {
 				v.AddError("after", "invalid cursor")
@@ -215,6 +198,9 @@ func (app *application) listItemsForWall(w http.ResponseWriter, r *http.Request)
 			app.serverErrorResponse(w, r, err)
 			return
 		}
+		if metadata.SessionID != "" {
+			metadata.SessionExpiresAt = &session.ExpiresAt
+		}
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"items": items, "metadata": metadata}, nil)
@@ -231,7 +217,10 @@ func (app *application) updateWall(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var input struct {
-		Name string `json:"name"`
+		Name  *string `json:"name"`
+		Emoji *string `json:"emoji"`
+		Color *string `json:"color"`
+		Icon  *string `json:"icon"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -240,28 +229,19 @@ func (app *application) updateWall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wall, err := app.models.Walls.FindByID(wallID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
+	wall := app.findWallForMember(w, r, wallID, data.WallRoleOwner)
+	if wall == nil {
 		return
 	}
 
-	if wall.IsPrimary {
-		app.errorResponse(w, r, http.StatusUnprocessableEntity, "Cannot update primary wall")
-		return
-	}
-
-	user := app.contextGetSession(r).User
-	if wall.UserID != user.ID {
-		app.notPermittedResponse(w, r)
-		return
+	if input.Name != nil {
+		if wall.IsPrimary {
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "Cannot rename primary wall")
+			return
+		}
+		wall.Name = *input.Name
 	}
-
-	wall.Name = input.Name
+	setWallAppearance(wall, input.Emoji, input.Color, input.Icon)
 
 	v := validator.New()
 
@@ -273,7 +253,13 @@ func (app *application) updateWall(w http.ResponseWriter, r *http.Request) {
 
 	err = app.models.Walls.Update(wall)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateWall):
+			v.AddError("name", "You already have a wall with the same name")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -290,13 +276,8 @@ func (app *application) deleteWall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wall, err := app.models.Walls.FindByID(wallID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
+	wall := app.findWallForMember(w, r, wallID, data.WallRoleOwner)
+	if wall == nil {
 		return
 	}
 
@@ -305,12 +286,6 @@ func (app *application) deleteWall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := app.contextGetSession(r).User
-	if wall.UserID != user.ID {
-		app.notPermittedResponse(w, r)
-		return
-	}
-
 	err = app.models.Walls.Delete(wallID)
 	if err != nil {
 		switch {
@@ -327,6 +302,24 @@ func (app *application) deleteWall(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// setWallAppearance sets the appearance fields of a wall which are present in a request.
+// Empty values clear the field.
+func setWallAppearance(wall *data.Wall, emoji, color, icon *string) {
+	for _, field := range []struct {
+		value  *string
+		target *pgtype.Text
+	}{
+		{emoji, &wall.Emoji},
+		{color, &wall.Color},
+		{icon, &wall.Icon},
+	} {
+		if field.value != nil {
+			*field.target = pgtype.Text{String: *field.value, Valid: *field.value != ""}
+		}
+	}
+}
+
+// pinWall pins a wall for the current user. Pins are personal to each member of a wall.
 func (app *application) pinWall(w http.ResponseWriter, r *http.Request) {
 	wallID, err := app.readIDParam(r, "wall_id")
 	if err != nil || wallID < 1 {
@@ -334,27 +327,15 @@ func (app *application) pinWall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wall, err := app.models.Walls.FindByID(wallID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	user := app.contextGetSession(r).User
-	if wall.UserID != user.ID {
-		app.notPermittedResponse(w, r)
-		return
-	}
 
-	err = app.models.Walls.Pin(wallID)
+	err = app.models.Walls.Pin(wallID, user.ID, app.config.walls.maxPinned)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrPinnedWallsLimit):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Cannot pin more than %d walls", app.config.walls.maxPinned))
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -371,23 +352,9 @@ func (app *application) unpinWall(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wall, err := app.models.Walls.FindByID(wallID)
-	if err != nil {
-		if errors.Is(err, data.ErrRecordNotFound) {
-			app.notFoundResponse(w, r)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	user := app.contextGetSession(r).User
-	if wall.UserID != user.ID {
-		app.notPermittedResponse(w, r)
-		return
-	}
 
-	err = app.models.Walls.Unpin(wallID)
+	err = app.models.Walls.Unpin(wallID, user.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
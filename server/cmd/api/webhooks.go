@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/data"
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/aravindmathradan/semaphore/internal/webhooks"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	webhookClaimLimit         = 50
+	webhookLease              = 2 * time.Minute
+	webhookMaxAttempts        = 6
+	webhookRetryBackoff       = 30 * time.Second
+	webhookConcurrency        = 5
+	webhookMaxEndpoints       = 10
+	webhookDeliveriesPageSize = 100
+	// webhookDeliveriesRetention is how long finished deliveries are kept in the delivery log
+	webhookDeliveriesRetention = 30 * 24 * time.Hour
+)
+
+// systemWebhookOwner is the owner id of the system-wide endpoints managed by admins
+const systemWebhookOwner = 0
+
+// enqueueItemsCreatedWebhooks creates the item.created deliveries for the new items of a feed
+func (app *application) enqueueItemsCreatedWebhooks(itemIDs []int64) {
+	_, err := app.models.WebhookDeliveries.EnqueueItemsCreated(itemIDs)
+	if err != nil {
+		app.logInternalError("app.models.WebhookDeliveries.EnqueueItemsCreated failed", err)
+	}
+}
+
+// enqueueFeedFailedWebhooks creates the feed.failed deliveries for a feed which failed to refresh
+func (app *application) enqueueFeedFailedWebhooks(feed *data.Feed) {
+	_, err := app.models.WebhookDeliveries.EnqueueFeedFailed(feed)
+	if err != nil {
+		app.logInternalError("app.models.WebhookDeliveries.EnqueueFeedFailed failed", err)
+	}
+}
+
+func (app *application) DeliverWebhooks() {
+	var lastCleanup time.Time
+
+	for {
+		select {
+		case <-app.ctx.Done():
+			app.logger.Info("webhooks delivery shutting down gracefully")
+			return
+		default:
+			startTime := time.Now()
+			if startTime.Sub(lastCleanup) > time.Hour {
+				err := app.models.WebhookDeliveries.Cleanup(startTime.Add(-webhookDeliveriesRetention))
+				if err != nil {
+					app.logInternalError("app.models.WebhookDeliveries.Cleanup failed", err)
+				}
+				lastCleanup = startTime
+			}
+			app.deliverDueWebhooks()
+			timer := time.NewTimer(time.Until(startTime.Add(app.config.webhooks.deliveryPeriod)))
+			select {
+			case <-app.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// Continue with the next iteration
+			}
+		}
+	}
+}
+
+// deliverDueWebhooks delivers the due webhook deliveries, a few at a time so that slow
+// endpoints do not hold up the others
+func (app *application) deliverDueWebhooks() {
+	for {
+		deliveries, err := app.models.WebhookDeliveries.ClaimDue(webhookClaimLimit, webhookLease)
+		if err != nil {
+			app.logInternalError("app.models.WebhookDeliveries.ClaimDue failed", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, webhookConcurrency)
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				app.deliverWebhook(delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookClaimLimit {
+			return
+		}
+	}
+}
+
+// deliverWebhook sends a delivery to its endpoint and records the outcome. Responses other than
+// 2xx are failures, and are retried along with the deliveries which got no response.
+func (app *application) deliverWebhook(delivery *data.WebhookDelivery) {
+	body, err := json.Marshal(map[string]any{
+		"id":         delivery.ID,
+		"event":      delivery.Event,
+		"created_at": delivery.CreatedAt,
+		"data":       delivery.Payload,
+	})
+	if err != nil {
+		app.logInternalError("json.Marshal failed for webhook delivery", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(app.ctx, 15*time.Second)
+	defer cancel()
+
+	msg := webhooks.Message{DeliveryID: delivery.ID, Event: delivery.Event, Body: body}
+	// System-wide endpoints are managed by admins and may be on the internal network
+	allowInternal := !delivery.Endpoint.UserID.Valid
+	result, err := app.webhookSender.Send(ctx, delivery.Endpoint.URL, delivery.Endpoint.Secret, msg, allowInternal)
+
+	var attempt data.WebhookAttempt
+	if err != nil {
+		attempt.Error = pgtype.Text{String: err.Error(), Valid: true}
+	} else {
+		attempt.ResponseStatus = pgtype.Int4{Int32: int32(result.StatusCode), Valid: true}
+		attempt.ResponseBody = pgtype.Text{String: result.Body, Valid: true}
+		attempt.Duration = result.Duration
+	}
+
+	if err == nil && result.OK() {
+		err = app.models.WebhookDeliveries.MarkSucceeded(delivery, attempt)
+		if err != nil {
+			app.logInternalError("app.models.WebhookDeliveries.MarkSucceeded failed", err)
+		}
+		return
+	}
+
+	disabled, err := app.models.WebhookDeliveries.Retry(delivery, attempt, webhookMaxAttempts, webhookRetryBackoff, app.config.webhooks.maxFailures)
+	if err != nil {
+		app.logInternalError("app.models.WebhookDeliveries.Retry failed", err)
+		return
+	}
+	if disabled {
+		app.logger.Info("webhook endpoint disabled after repeated failures", "endpoint_id", delivery.EndpointID)
+	}
+}
+
+func (app *application) listUserWebhooks(w http.ResponseWriter, r *http.Request) {
+	app.listWebhooks(w, r, app.contextGetSession(r).User.ID)
+}
+
+func (app *application) listSystemWebhooks(w http.ResponseWriter, r *http.Request) {
+	app.listWebhooks(w, r, systemWebhookOwner)
+}
+
+func (app *application) createUserWebhook(w http.ResponseWriter, r *http.Request) {
+	app.createWebhook(w, r, app.contextGetSession(r).User.ID)
+}
+
+func (app *application) createSystemWebhook(w http.ResponseWriter, r *http.Request) {
+	app.createWebhook(w, r, systemWebhookOwner)
+}
+
+func (app *application) getUserWebhook(w http.ResponseWriter, r *http.Request) {
+	app.getWebhook(w, r, app.contextGetSession(r).User.ID)
+}
+
+func (app *application) getSystemWebhook(w http.ResponseWriter, r *http.Request) {
+	app.getWebhook(w, r, systemWebhookOwner)
+}
+
+func (app *application) updateUserWebhook(w http.ResponseWriter, r *http.Request) {
+	app.updateWebhook(w, r, app.contextGetSession(r).User.ID)
+}
+
+func (app *application) updateSystemWebhook(w http.ResponseWriter, r *http.Request) {
+	app.updateWebhook(w, r, systemWebhookOwner)
+}
+
+func (app *application) deleteUserWebhook(w http.ResponseWriter, r *http.Request) {
+	app.deleteWebhook(w, r, app.contextGetSession(r).User.ID)
+}
+
+func (app *application) deleteSystemWebhook(w http.ResponseWriter, r *http.Request) {
+	app.deleteWebhook(w, r, systemWebhookOwner)
+}
+
+func (app *application) listUserWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	app.listWebhookDeliveries(w, r, app.contextGetSession(r).User.ID)
+}
+
+func (app *application) listSystemWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	app.listWebhookDeliveries(w, r, systemWebhookOwner)
+}
+
+func (app *application) listWebhooks(w http.ResponseWriter, r *http.Request, ownerID int64) {
+	endpoints, err := app.models.WebhookEndpoints.FindAllForOwner(ownerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": endpoints}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createWebhook registers an endpoint. The signing secret is only returned in the response, so
+// that it is not exposed again later.
+func (app *application) createWebhook(w http.ResponseWriter, r *http.Request, ownerID int64) {
+	var input struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		WallIDs     []int64  `json:"wall_ids"`
+		Description string   `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	endpoint := &data.WebhookEndpoint{
+		URL:         input.URL,
+		Events:      input.Events,
+		WallIDs:     input.WallIDs,
+		Description: input.Description,
+	}
+	if ownerID != systemWebhookOwner {
+		endpoint.UserID = pgtype.Int8{Int64: ownerID, Valid: true}
+	}
+	if endpoint.WallIDs == nil {
+		endpoint.WallIDs = []int64{}
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookEndpoint(v, endpoint); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	count, err := app.models.WebhookEndpoints.CountForOwner(ownerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if count >= webhookMaxEndpoints {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "Webhook limit reached")
+		return
+	}
+
+	endpoint.Secret, err = webhooks.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.WebhookEndpoints.Insert(endpoint)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("wall_ids", "Must only contain walls you are a member of")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": endpoint, "secret": endpoint.Secret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getWebhook(w http.ResponseWriter, r *http.Request, ownerID int64) {
+	endpoint := app.findWebhookForOwner(w, r, ownerID)
+	if endpoint == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"webhook": endpoint}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWebhook changes an endpoint. Setting enabled re-enables an endpoint which was disabled
+// after repeated failures.
+func (app *application) updateWebhook(w http.ResponseWriter, r *http.Request, ownerID int64) {
+	endpoint := app.findWebhookForOwner(w, r, ownerID)
+	if endpoint == nil {
+		return
+	}
+
+	var input struct {
+		URL         *string  `json:"url"`
+		Events      []string `json:"events"`
+		WallIDs     []int64  `json:"wall_ids"`
+		Description *string  `json:"description"`
+		Enabled     *bool    `json:"enabled"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		endpoint.URL = *input.URL
+	}
+	if input.Events != nil {
+		endpoint.Events = input.Events
+	}
+	if input.WallIDs != nil {
+		endpoint.WallIDs = input.WallIDs
+	}
+	if input.Description != nil {
+		endpoint.Description = *input.Description
+	}
+	if input.Enabled != nil {
+		endpoint.Enabled = *input.Enabled
+	}
+
+	v := validator.New()
+	if data.ValidateWebhookEndpoint(v, endpoint); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WebhookEndpoints.Update(endpoint)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("wall_ids", "Must only contain walls you are a member of")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": endpoint}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhook(w http.ResponseWriter, r *http.Request, ownerID int64) {
+	id, err := app.readIDParam(r, "webhook_id")
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.WebhookEndpoints.Delete(id, ownerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries returns the delivery log of an endpoint, newest first
+func (app *application) listWebhookDeliveries(w http.ResponseWriter, r *http.Request, ownerID int64) {
+	endpoint := app.findWebhookForOwner(w, r, ownerID)
+	if endpoint == nil {
+		return
+	}
+
+	deliveries, err := app.models.WebhookDeliveries.FindAllForEndpoint(endpoint.ID, webhookDeliveriesPageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// findWebhookForOwner reads the webhook id from the URL and returns the endpoint if it belongs
+// to the owner. Otherwise it writes the error response and returns nil.
+func (app *application) findWebhookForOwner(w http.ResponseWriter, r *http.Request, ownerID int64) *data.WebhookEndpoint {
+	id, err := app.readIDParam(r, "webhook_id")
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	endpoint, err := app.models.WebhookEndpoints.Get(id, ownerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return endpoint
+}
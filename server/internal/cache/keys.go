@@ -2,18 +2,44 @@ package cache
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
 const (
-	KeyItemScoresPrefix = "item_scores:"
-	KeyTopicsPrefix     = "topics:"
+	KeyScoreSessionsPrefix = "score_sessions:"
+	KeyTopicsPrefix        = "topics:"
+	KeyTrendingPrefix      = "trending:"
+
+	// trendingSnapshotLayout is the time layout which makes the keys of trending snapshots unique
+	trendingSnapshotLayout = "20060102150405"
 )
 
-func GenerateItemScoresKey(wallID int64, sortMode string) string {
-	return fmt.Sprintf("%s%d:%s:%s", KeyItemScoresPrefix, wallID, sortMode, time.Now().Format("20060102150405"))
+func GenerateScoreSessionKey(sessionID string) string {
+	return fmt.Sprintf("%s%s", KeyScoreSessionsPrefix, sessionID)
 }
 
 func GenerateTopicsKey() string {
 	return fmt.Sprintf("%s", KeyTopicsPrefix)
 }
+
+// GenerateTrendingScopeKey returns the key which points to the latest trending snapshot of a scope
+func GenerateTrendingScopeKey(topicID int64, feedType string) string {
+	return fmt.Sprintf("%stopic:%d:type:%s", KeyTrendingPrefix, topicID, feedType)
+}
+
+// GenerateTrendingItemScoresKey returns the key of a new trending snapshot of a scope
+func GenerateTrendingItemScoresKey(scopeKey string) string {
+	return fmt.Sprintf("%s:%s", scopeKey, time.Now().Format(trendingSnapshotLayout))
+}
+
+// IsTrendingItemScoresKey reports whether key is the key of a trending snapshot of the scope
+func IsTrendingItemScoresKey(key, scopeKey string) bool {
+	suffix, ok := strings.CutPrefix(key, scopeKey+":")
+	if !ok || len(suffix) != len(trendingSnapshotLayout) {
+		return false
+	}
+
+	_, err := time.Parse(trendingSnapshotLayout, suffix)
+	return err == nil
+}
@@ -0,0 +1,429 @@
+package data
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Signal is a kind of user interaction with an item that is used to learn affinities
+type Signal string
+
+const (
+	SignalLike Signal = "like"
+	SignalSave Signal = "save"
+	SignalRead Signal = "read"
+)
+
+// Affinity factors are the dimensions along which a user's preferences are learnt
+const (
+	AffinityFactorFeed     = "feed"
+	AffinityFactorTopic    = "topic"
+	AffinityFactorAuthor   = "author"
+	AffinityFactorCategory = "category"
+)
+
+// Interaction is a single like, save or read of an item by a user along with
+// the attributes of the item that affinities are learnt for
+type Interaction struct {
+	Signal     Signal
+	CreatedAt  time.Time
+	FeedID     int64
+	FeedTitle  string
+	TopicID    pgtype.Int8
+	TopicName  pgtype.Text
+	Authors    pgtype.FlatArray[*Person]
+	Categories pgtype.FlatArray[string]
+}
+
+// RankingCandidate is an item that can be ranked for a user along with the attributes
+// that are matched against the user's affinities
+type RankingCandidate struct {
+	ItemID     int64
+	BaseScore  float64
+	FeedID     int64
+	FeedTitle  string
+	TopicID    pgtype.Int8
+	TopicName  pgtype.Text
+	Authors    pgtype.FlatArray[*Person]
+	Categories pgtype.FlatArray[string]
+}
+
+// RankingReason explains how much a single affinity contributed to the score of an item
+type RankingReason struct {
+	Factor       string  `json:"factor"`
+	Label        string  `json:"label"`
+	Contribution float64 `json:"contribution"`
+}
+
+// affinity is the learnt preference of a user for a single feed, topic, author or category
+type affinity struct {
+	label string
+	value float64
+}
+
+// AffinityProfile holds the normalized affinities (between 0 and 1) of a user for each factor
+type AffinityProfile struct {
+	Feeds      map[int64]*affinity
+	Topics     map[int64]*affinity
+	Authors    map[string]*affinity
+	Categories map[string]*affinity
+}
+
+type AffinityModel struct {
+	DB *pgxpool.Pool
+}
+
+var (
+	// signalWeights is the multiplier applied to each kind of interaction.
+	// Saving an item is a stronger signal than liking it, and reading it is the weakest signal.
+	signalWeights = map[Signal]float64{
+		SignalLike: 1.0,
+		SignalSave: 3.0,
+		SignalRead: 0.25,
+	}
+
+	// factorWeights is the maximum boost that a fully matching factor adds to the base score.
+	// A feed the user interacts with the most boosts items by 100%, a topic by 50% and so on.
+	factorWeights = map[string]float64{
+		AffinityFactorFeed:     1.0,
+		AffinityFactorTopic:    0.5,
+		AffinityFactorAuthor:   0.75,
+		AffinityFactorCategory: 0.5,
+	}
+)
+
+const (
+	// affinityHalfLife is the time after which an interaction counts for half as much
+	affinityHalfLife = 30 * 24 * time.Hour
+
+	// minReasonContribution is the minimum contribution of a factor for it to be
+	// listed as a reason for ranking an item
+	minReasonContribution = 0.05
+
+	// maxReasons is the maximum number of reasons returned for an item
+	maxReasons = 3
+)
+
+func normalizeAffinityKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// BuildAffinityProfile learns the affinities of a user from their interactions.
+// Each interaction adds its signal weight, decayed exponentially with the age of the
+// interaction, to the feed, topic, authors and categories of the item. The affinities
+// of each factor are then normalized by the largest affinity of that factor.
+func BuildAffinityProfile(interactions []*Interaction, now time.Time) *AffinityProfile {
+	profile := &AffinityProfile{
+		Feeds:      make(map[int64]*affinity),
+		Topics:     make(map[int64]*affinity),
+		Authors:    make(map[string]*affinity),
+		Categories: make(map[string]*affinity),
+	}
+
+	for _, interaction := range interactions {
+		age := max(now.Sub(interaction.CreatedAt), 0)
+		weight := signalWeights[interaction.Signal] * math.Pow(0.5, float64(age)/float64(affinityHalfLife))
+		if weight == 0 {
+			continue
+		}
+
+		addInt64Affinity(profile.Feeds, interaction.FeedID, interaction.FeedTitle, weight)
+		if interaction.TopicID.Valid {
+			addInt64Affinity(profile.Topics, interaction.TopicID.Int64, interaction.TopicName.String, weight)
+		}
+		for _, author := range interaction.Authors {
+			if author == nil || normalizeAffinityKey(author.Name) == "" {
+				continue
+			}
+			addStringAffinity(profile.Authors, normalizeAffinityKey(author.Name), author.Name, weight)
+		}
+		for _, category := range interaction.Categories {
+			if normalizeAffinityKey(category) == "" {
+				continue
+			}
+			addStringAffinity(profile.Categories, normalizeAffinityKey(category), category, weight)
+		}
+	}
+
+	normalizeAffinities(profile.Feeds)
+	normalizeAffinities(profile.Topics)
+	normalizeAffinities(profile.Authors)
+	normalizeAffinities(profile.Categories)
+
+	return profile
+}
+
+func addInt64Affinity(affinities map[int64]*affinity, key int64, label string, weight float64) {
+	if a, ok := affinities[key]; ok {
+		a.value += weight
+		return
+	}
+	affinities[key] = &affinity{label: label, value: weight}
+}
+
+func addStringAffinity(affinities map[string]*affinity, key string, label string, weight float64) {
+	if a, ok := affinities[key]; ok {
+		a.value += weight
+		return
+	}
+	affinities[key] = &affinity{label: label, value: weight}
+}
+
+func normalizeAffinities[K comparable](affinities map[K]*affinity) {
+	maxValue := 0.0
+	for _, a := range affinities {
+		maxValue = max(maxValue, a.value)
+	}
+	if maxValue == 0 {
+		return
+	}
+	for _, a := range affinities {
+		a.value /= maxValue
+	}
+}
+
+// IsEmpty reports whether the profile has no learnt affinities
+func (p *AffinityProfile) IsEmpty() bool {
+	return len(p.Feeds) == 0 && len(p.Topics) == 0 && len(p.Authors) == 0 && len(p.Categories) == 0
+}
+
+// Score returns the personalized score of a candidate along with the reasons behind it.
+//
+// Formula:
+// score = baseScore * (1 + sum(factorWeight * affinity))
+//
+// The affinity of the author and category factors is the largest affinity among all
+// the authors and categories of the item. The reasons are the factors sorted by their
+// contribution, i.e. factorWeight * affinity.
+func (p *AffinityProfile) Score(candidate *RankingCandidate) (float64, []RankingReason) {
+	var reasons []RankingReason
+
+	if a, ok := p.Feeds[candidate.FeedID]; ok {
+		reasons = append(reasons, RankingReason{
+			Factor:       AffinityFactorFeed,
+			Label:        candidate.FeedTitle,
+			Contribution: factorWeights[AffinityFactorFeed] * a.value,
+		})
+	}
+
+	if candidate.TopicID.Valid {
+		if a, ok := p.Topics[candidate.TopicID.Int64]; ok {
+			reasons = append(reasons, RankingReason{
+				Factor:       AffinityFactorTopic,
+				Label:        a.label,
+				Contribution: factorWeights[AffinityFactorTopic] * a.value,
+			})
+		}
+	}
+
+	var bestAuthor *affinity
+	for _, author := range candidate.Authors {
+		if author == nil {
+			continue
+		}
+		if a, ok := p.Authors[normalizeAffinityKey(author.Name)]; ok && (bestAuthor == nil || a.value > bestAuthor.value) {
+			bestAuthor = a
+		}
+	}
+	if bestAuthor != nil {
+		reasons = append(reasons, RankingReason{
+			Factor:       AffinityFactorAuthor,
+			Label:        bestAuthor.label,
+			Contribution: factorWeights[AffinityFactorAuthor] * bestAuthor.value,
+		})
+	}
+
+	var bestCategory *affinity
+	for _, category := range candidate.Categories {
+		if a, ok := p.Categories[normalizeAffinityKey(category)]; ok && (bestCategory == nil || a.value > bestCategory.value) {
+			bestCategory = a
+		}
+	}
+	if bestCategory != nil {
+		reasons = append(reasons, RankingReason{
+			Factor:       AffinityFactorCategory,
+			Label:        bestCategory.label,
+			Contribution: factorWeights[AffinityFactorCategory] * bestCategory.value,
+		})
+	}
+
+	boost := 0.0
+	for _, reason := range reasons {
+		boost += reason.Contribution
+	}
+
+	sort.SliceStable(reasons, func(i, j int) bool {
+		return reasons[i].Contribution > reasons[j].Contribution
+	})
+
+	// Only keep the reasons which contributed meaningfully to the score
+	explained := reasons[:0]
+	for _, reason := range reasons {
+		if reason.Contribution >= minReasonContribution && len(explained) < maxReasons {
+			reason.Contribution = math.Round(reason.Contribution*100) / 100
+			explained = append(explained, reason)
+		}
+	}
+
+	return candidate.BaseScore * (1 + boost), explained
+}
+
+// RankCandidates scores all candidates using the profile and returns the item scores
+// sorted by score in descending order
+func RankCandidates(profile *AffinityProfile, candidates []*RankingCandidate) []*ItemScore {
+	itemScores := make([]*ItemScore, len(candidates))
+	for i, candidate := range candidates {
+		score, reasons := profile.Score(candidate)
+		itemScores[i] = &ItemScore{
+			ItemID:  candidate.ItemID,
+			Score:   score,
+			Reasons: reasons,
+		}
+	}
+
+	sort.Slice(itemScores, func(i, j int) bool {
+		if itemScores[i].Score == itemScores[j].Score {
+			return itemScores[i].ItemID > itemScores[j].ItemID
+		}
+		return itemScores[i].Score > itemScores[j].Score
+	})
+
+	return itemScores
+}
+
+// GetInteractionsForUser returns the most recent likes, saves and reads of a user that are
+// used to learn the user's affinities
+func (m AffinityModel) GetInteractionsForUser(userID int64, since time.Time, limit int) ([]*Interaction, error) {
+	query := `
+		WITH interactions AS (
+			SELECT 'like' as signal, item_id, created_at
+			FROM liked_items
+			WHERE user_id = $1 AND created_at > $2
+			UNION ALL
+			SELECT 'save' as signal, item_id, created_at
+			FROM saved_items
+			WHERE user_id = $1 AND created_at > $2
+			UNION ALL
+			SELECT 'read' as signal, item_id, created_at
+			FROM read_items
+			WHERE user_id = $1 AND created_at > $2
+		)
+		SELECT interactions.signal, interactions.created_at, feeds.id, feeds.title,
+			topics.id, topics.name, items.authors, items.categories
+		FROM interactions
+		INNER JOIN items ON items.id = interactions.item_id
+		INNER JOIN feeds ON feeds.id = items.feed_id
+		LEFT JOIN topics ON topics.id = feeds.topic_id
+		ORDER BY interactions.created_at DESC
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	interactions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Interaction, error) {
+		var interaction Interaction
+		err := row.Scan(
+			&interaction.Signal,
+			&interaction.CreatedAt,
+			&interaction.FeedID,
+			&interaction.FeedTitle,
+			&interaction.TopicID,
+			&interaction.TopicName,
+			&interaction.Authors,
+			&interaction.Categories,
+		)
+		return &interaction, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return interactions, nil
+}
+
+// GetRankingCandidatesForWall returns the most recent items of a wall along with their
+// global hot score, which is used as the base score for personalized ranking
+func (m AffinityModel) GetRankingCandidatesForWall(wallID int64, limit int) ([]*RankingCandidate, error) {
+	query := `
+		WITH recent_items AS (
+			SELECT items.id, items.feed_id, items.authors, items.categories, items.hot_score
+			FROM items
+			INNER JOIN wall_feeds ON wall_feeds.feed_id = items.feed_id
+			WHERE wall_feeds.wall_id = $1
+			AND ` + wallFeedItemsCondition + `
+			ORDER BY COALESCE(items.pub_date, items.updated_at) DESC, items.id DESC
+			LIMIT $2
+		)
+		SELECT items.id, items.hot_score, feeds.id, feeds.title, topics.id, topics.name,
+			items.authors, items.categories
+		FROM recent_items items
+		INNER JOIN feeds ON feeds.id = items.feed_id
+		LEFT JOIN topics ON topics.id = feeds.topic_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, wallID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*RankingCandidate, error) {
+		var candidate RankingCandidate
+		err := row.Scan(
+			&candidate.ItemID,
+			&candidate.BaseScore,
+			&candidate.FeedID,
+			&candidate.FeedTitle,
+			&candidate.TopicID,
+			&candidate.TopicName,
+			&candidate.Authors,
+			&candidate.Categories,
+		)
+		return &candidate, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// CalculateForYouItemScoresForWall ranks the recent items of a wall for a user using the
+// affinities learnt from the user's likes, saves and reads
+func (m AffinityModel) CalculateForYouItemScoresForWall(wallID, userID int64, snapshotSize int) ([]*ItemScore, error) {
+	now := time.Now()
+
+	// Interactions older than 6 half lives contribute less than 2% and are ignored
+	interactions, err := m.GetInteractionsForUser(userID, now.Add(-6*affinityHalfLife), 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rank a larger pool of candidates than the snapshot size so that older items
+	// the user is likely to be interested in can still make it into the snapshot
+	candidates, err := m.GetRankingCandidatesForWall(wallID, snapshotSize*3)
+	if err != nil {
+		return nil, err
+	}
+
+	itemScores := RankCandidates(BuildAffinityProfile(interactions, now), candidates)
+	if len(itemScores) > snapshotSize {
+		itemScores = itemScores[:snapshotSize]
+	}
+
+	return itemScores, nil
+}
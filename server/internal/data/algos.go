@@ -1,6 +1,9 @@
 package data
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 func buildHotItemsScoreCalculationQuery(likeCountColumn, saveCountColumn, pubDateColumn, alternateDateColumn string) string {
 	// Base score prevents the numerator from being 0 if there are no likes or saves
@@ -44,3 +47,42 @@ func buildHotItemsScoreCalculationQuery(likeCountColumn, saveCountColumn, pubDat
 		pubDateColumn, alternateDateColumn, alternateDateColumn, smoothFactor, gravity,
 	)
 }
+
+func buildTrendingItemsScoreCalculationQuery(recentLikeCountColumn, recentSaveCountColumn, pubDateColumn, alternateDateColumn string, window time.Duration) string {
+	// Like weight is the multiplier for the like count within the window
+	likeWeight := 1.0
+
+	// Save weight is the multiplier for the save count within the window
+	saveWeight := 3.0
+
+	// Window hours is the duration over which likes and saves are counted.
+	// Dividing by it gives the engagement velocity as weighted likes and saves per hour
+	windowHours := window.Hours()
+
+	// SmoothFactor is the time smoothing factor
+	// It prevents division by 0 for new items and over-boosting brand new items
+	smoothFactor := 2.0
+
+	// Gravity exponent is the time based decay factor
+	// It is lower than the gravity of hot scores because velocity is already limited to the window,
+	// so it only needs to favour newer items among items gaining likes and saves at the same rate
+	gravity := 0.8
+
+	// Formula:
+	// velocity = (recentLikeCount * likeWeight + recentSaveCount * saveWeight) / windowHours
+	// score = velocity / (timeSincePublished + smoothFactor) ^ gravity
+
+	return fmt.Sprintf(`
+		(
+			(
+				COALESCE(%s, 0) * %f +
+				COALESCE(%s, 0) * %f
+			)::float / %f /
+			POWER(EXTRACT(EPOCH FROM (now() - LEAST(COALESCE(%s, %s), %s)))/3600 + %f, %f)
+		)`,
+		recentLikeCountColumn, likeWeight,
+		recentSaveCountColumn, saveWeight,
+		windowHours,
+		pubDateColumn, alternateDateColumn, alternateDateColumn, smoothFactor, gravity,
+	)
+}
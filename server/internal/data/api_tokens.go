@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APITokenPrefix starts the plaintext of every API token, so that they can be told apart from
+// authentication tokens and found by secret scanners
+const APITokenPrefix = "smp_"
+
+// apiTokenLength is the length of the plaintext of an API token, with its prefix
+const apiTokenLength = len(APITokenPrefix) + 32
+
+// apiTokenLastUsedInterval is how often the last use of an API token is recorded at most
+const apiTokenLastUsedInterval = time.Minute
+
+const (
+	APITokenScopeItemsRead    = "items:read"    // read feeds, walls and their items
+	APITokenScopeFollowsWrite = "follows:write" // add, follow and unfollow feeds
+	APITokenScopeWallsWrite   = "walls:write"   // create, update and delete walls and their feeds
+)
+
+var APITokenScopes = []string{APITokenScopeItemsRead, APITokenScopeFollowsWrite, APITokenScopeWallsWrite}
+
+// apiTokenScopePermissions lists the permissions of the user an API token may use with each of
+// its scopes. The admin permission is never granted to API tokens.
+var apiTokenScopePermissions = map[string][]string{
+	APITokenScopeItemsRead:    {PermissionFeedsRead},
+	APITokenScopeFollowsWrite: {PermissionFeedsFollow, PermissionFeedsWrite},
+}
+
+// APIToken is a long-lived personal access token, which lets scripts use the parts of the API
+// its scopes allow on behalf of a user
+type APIToken struct {
+	ID         int64              `json:"id"`
+	UserID     int64              `json:"-"`
+	Name       string             `json:"name"`
+	Plaintext  string             `json:"token,omitempty"`
+	Hash       []byte             `json:"-"`
+	Scopes     []string           `json:"scopes"`
+	Expiry     pgtype.Timestamptz `json:"expiry"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+// LastUsedDue reports whether the last use of the token is older than apiTokenLastUsedInterval,
+// so that the use at now should be recorded with UpdateLastUsed
+func (t *APIToken) LastUsedDue(now time.Time) bool {
+	return !t.LastUsedAt.Valid || now.Sub(t.LastUsedAt.Time) >= apiTokenLastUsedInterval
+}
+
+// HasScope reports whether the token was granted the scope
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// GrantsPermission reports whether one of the scopes of the token lets it use the permission
+func (t *APIToken) GrantsPermission(code string) bool {
+	for _, scope := range t.Scopes {
+		if slices.Contains(apiTokenScopePermissions[scope], code) {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateAPIToken(v *validator.Validator, token *APIToken) {
+	v.Check(validator.NotBlank(token.Name), "name", "Must be provided")
+	v.Check(validator.MaxChars(token.Name, 64), "name", "Must not be more than 64 characters long")
+
+	v.Check(len(token.Scopes) > 0, "scopes", "Must contain at least one scope")
+	v.Check(validator.Unique(token.Scopes), "scopes", "Must not contain duplicate scopes")
+	for _, scope := range token.Scopes {
+		v.Check(validator.PermittedValue(scope, APITokenScopes...), "scopes", "Scopes must be one of items:read, follows:write or walls:write")
+	}
+
+	if token.Expiry.Valid {
+		v.Check(token.Expiry.Time.After(time.Now()), "expiry", "Must be in the future")
+	}
+}
+
+// ValidateAPITokenPlaintext reports whether a bearer token looks like an API token
+func ValidateAPITokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(strings.HasPrefix(tokenPlaintext, APITokenPrefix), "token", "Token must start with "+APITokenPrefix)
+	v.Check(len(tokenPlaintext) == apiTokenLength, "token", "Token must be 36 bytes long")
+}
+
+type APITokenModel struct {
+	DB *pgxpool.Pool
+}
+
+// New generates the plaintext of an API token and stores the token. The plaintext is only
+// available on the returned token, since only its hash is stored.
+func (m APITokenModel) New(token *APIToken) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	token.Plaintext = APITokenPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	query := `
+		INSERT INTO api_tokens (user_id, name, hash, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, token.UserID, token.Name, token.Hash, token.Scopes, token.Expiry).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+}
+
+func (m APITokenModel) FindAllForUser(userID int64) ([]*APIToken, error) {
+	query := `
+		SELECT id, user_id, name, scopes, expiry, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*APIToken, error) {
+		var token APIToken
+		err := row.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&token.Scopes,
+			&token.Expiry,
+			&token.LastUsedAt,
+			&token.CreatedAt,
+		)
+		return &token, err
+	})
+}
+
+// UpdateLastUsed records that the token was used. Callers check LastUsedDue first, so that
+// scripts making many requests do not write the row on every one of them. The same interval is
+// checked again in the query for concurrent requests which loaded the token before the update.
+func (m APITokenModel) UpdateLastUsed(id int64) error {
+	query := `
+		UPDATE api_tokens
+		SET last_used_at = NOW()
+		WHERE id = $1
+		AND (last_used_at IS NULL OR last_used_at <= NOW() - $2::double precision * INTERVAL '1 second')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id, apiTokenLastUsedInterval.Seconds())
+	return err
+}
+
+func (m APITokenModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM api_tokens
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m APITokenModel) DeleteExpired() error {
+	query := `
+		DELETE FROM api_tokens
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query)
+	return err
+}
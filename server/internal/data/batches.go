@@ -0,0 +1,293 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	BatchActionFollow   = "follow"
+	BatchActionUnfollow = "unfollow"
+	BatchActionAdd      = "add"
+	BatchActionRemove   = "remove"
+	BatchActionSave     = "save"
+	BatchActionUnsave   = "unsave"
+	BatchActionLike     = "like"
+	BatchActionUnlike   = "unlike"
+	BatchActionRead     = "read"
+	BatchActionUnread   = "unread"
+
+	BatchStatusOK       = "ok"
+	BatchStatusNotFound = "not_found"
+
+	// MaxBatchSize is the maximum number of actions in a single batch request
+	MaxBatchSize = 100
+)
+
+// BatchAction is a single action of a batch request on the entity with the given ID.
+// Status and Error are set once the batch is applied.
+type BatchAction struct {
+	Action string `json:"action"`
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchModel struct {
+	DB *pgxpool.Pool
+}
+
+func ValidateBatchActions(v *validator.Validator, actions []*BatchAction, permittedActions ...string) {
+	v.Check(len(actions) > 0, "actions", "Must contain at least one action")
+	v.Check(len(actions) <= MaxBatchSize, "actions", "Must not contain more than 100 actions")
+
+	for _, action := range actions {
+		if action == nil {
+			v.AddError("actions", "Must not contain null actions")
+			return
+		}
+		v.Check(validator.PermittedValue(action.Action, permittedActions...), "actions", "Contains an invalid action")
+		v.Check(action.ID > 0, "actions", "Contains an invalid id")
+	}
+}
+
+// ApplyFeedFollowActions follows and unfollows feeds for a user in a single transaction. Followed
+// feeds are added to the primary wall of the user, and unfollowed feeds are removed from the walls
+// owned by the user.
+func (m BatchModel) ApplyFeedFollowActions(userID int64, actions []*BatchAction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	existingFeeds, err := findExistingIDs(ctx, tx, "feeds", actions)
+	if err != nil {
+		return err
+	}
+
+	// The walls whose feeds changed are recorded once at the end of the batch
+	changedWalls := make(map[int64]bool)
+
+	var primaryWallID int64
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM walls
+		WHERE user_id = $1 AND is_primary = true`, userID).Scan(&primaryWallID)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if !existingFeeds[action.ID] {
+			action.setNotFound("Feed not found")
+			continue
+		}
+
+		switch action.Action {
+		case BatchActionFollow:
+			var result pgconn.CommandTag
+			result, err = tx.Exec(ctx, `
+				INSERT INTO feed_follows (user_id, feed_id)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING`, userID, action.ID)
+			if err != nil {
+				return err
+			}
+			if result.RowsAffected() > 0 {
+				err = recordUserChange(ctx, tx, userID, ChangeEntityFeedFollow, action.ID, ChangeOpUpsert)
+				if err != nil {
+					return err
+				}
+			}
+			result, err = tx.Exec(ctx, `
+				INSERT INTO wall_feeds (wall_id, feed_id)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING`, primaryWallID, action.ID)
+			if err == nil && result.RowsAffected() > 0 {
+				changedWalls[primaryWallID] = true
+			}
+		case BatchActionUnfollow:
+			var result pgconn.CommandTag
+			result, err = tx.Exec(ctx, `
+				DELETE FROM feed_follows
+				WHERE user_id = $1 AND feed_id = $2`, userID, action.ID)
+			if err != nil {
+				return err
+			}
+			if result.RowsAffected() == 0 {
+				action.setNotFound("You are not following this feed")
+				continue
+			}
+			err = recordUserChange(ctx, tx, userID, ChangeEntityFeedFollow, action.ID, ChangeOpDelete)
+			if err != nil {
+				return err
+			}
+			var wallIDs []int64
+			wallIDs, err = deleteFeedFromOwnedWalls(ctx, tx, userID, action.ID)
+			for _, wallID := range wallIDs {
+				changedWalls[wallID] = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+		action.Status = BatchStatusOK
+	}
+
+	err = recordWallChanges(ctx, tx, changedWalls)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ApplyWallFeedActions adds feeds to and removes feeds from a wall in a single transaction
+func (m BatchModel) ApplyWallFeedActions(wallID int64, actions []*BatchAction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	existingFeeds, err := findExistingIDs(ctx, tx, "feeds", actions)
+	if err != nil {
+		return err
+	}
+
+	var wallChanged bool
+	for _, action := range actions {
+		if !existingFeeds[action.ID] {
+			action.setNotFound("Feed not found")
+			continue
+		}
+
+		var result pgconn.CommandTag
+		switch action.Action {
+		case BatchActionAdd:
+			result, err = tx.Exec(ctx, `
+				INSERT INTO wall_feeds (wall_id, feed_id)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING`, wallID, action.ID)
+		case BatchActionRemove:
+			result, err = tx.Exec(ctx, `
+				DELETE FROM wall_feeds
+				WHERE wall_id = $1 AND feed_id = $2`, wallID, action.ID)
+			if err == nil && result.RowsAffected() == 0 {
+				action.setNotFound("The feed is not in the wall")
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		wallChanged = wallChanged || result.RowsAffected() > 0
+		action.Status = BatchStatusOK
+	}
+
+	if wallChanged {
+		err = recordWallChange(ctx, tx, wallID, ChangeOpUpsert)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ApplyItemActions saves, likes and marks items as read (or undoes them) for a user in a
+// single transaction. The like and save counts of the items are updated along the way.
+func (m BatchModel) ApplyItemActions(userID int64, actions []*BatchAction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	existingItems, err := findExistingIDs(ctx, tx, "items", actions)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if !existingItems[action.ID] {
+			action.setNotFound("Item not found")
+			continue
+		}
+
+		switch action.Action {
+		case BatchActionSave:
+			err = insertSavedItem(ctx, tx, userID, action.ID)
+		case BatchActionUnsave:
+			err = deleteSavedItem(ctx, tx, userID, action.ID)
+			if errors.Is(err, ErrRecordNotFound) {
+				action.setNotFound("The item is not saved")
+				continue
+			}
+		case BatchActionLike:
+			err = insertLikedItem(ctx, tx, userID, action.ID)
+		case BatchActionUnlike:
+			err = deleteLikedItem(ctx, tx, userID, action.ID)
+			if errors.Is(err, ErrRecordNotFound) {
+				action.setNotFound("The item is not liked")
+				continue
+			}
+		case BatchActionRead:
+			err = insertReadItem(ctx, tx, userID, action.ID)
+		case BatchActionUnread:
+			err = deleteReadItem(ctx, tx, userID, action.ID)
+		}
+		if err != nil {
+			return err
+		}
+		action.Status = BatchStatusOK
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (a *BatchAction) setNotFound(message string) {
+	a.Status = BatchStatusNotFound
+	a.Error = message
+}
+
+// findExistingIDs returns the IDs of the actions which exist in the given table. The rows are
+// locked so that they are not deleted before the transaction completes.
+func findExistingIDs(ctx context.Context, tx pgx.Tx, table string, actions []*BatchAction) (map[int64]bool, error) {
+	ids := make([]int64, len(actions))
+	for i, action := range actions {
+		ids[i] = action.ID
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id FROM `+pgx.Identifier{table}.Sanitize()+` WHERE id = ANY($1) FOR SHARE`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[int64]bool)
+	var id int64
+	_, err = pgx.ForEachRow(rows, []any{&id}, func() error {
+		existing[id] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DigestSendStatusSending = "sending"
+	DigestSendStatusSent    = "sent"
+	DigestSendStatusEmpty   = "empty"
+	DigestSendStatusFailed  = "failed"
+)
+
+// DigestSendModel keeps the log of the digests sent to each user. There is at most one entry
+// per user and period, which is claimed before the digest is sent, so a digest is never sent
+// twice even when several replicas run the scheduler at the same time.
+type DigestSendModel struct {
+	DB *pgxpool.Pool
+}
+
+// Claim records that the digest of a user for a period is being sent. It returns false if the
+// digest was already claimed, unless the earlier attempt failed and fewer than maxAttempts
+// attempts were made.
+func (m DigestSendModel) Claim(userID int64, periodStart, periodEnd time.Time, maxAttempts int) (int64, bool, error) {
+	query := `
+		INSERT INTO digest_sends (user_id, period_start, period_end)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, period_end) DO UPDATE
+		SET status = 'sending', attempts = digest_sends.attempts + 1, last_error = NULL
+		WHERE digest_sends.status = 'failed' AND digest_sends.attempts < $4
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRow(ctx, query, userID, periodStart, periodEnd, maxAttempts).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return id, true, nil
+}
+
+func (m DigestSendModel) MarkSent(id int64, itemCount int) error {
+	query := `
+		UPDATE digest_sends
+		SET status = 'sent', item_count = $2, sent_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id, itemCount)
+	return err
+}
+
+// MarkEmpty records that no digest was sent for the period since there were no items to send
+func (m DigestSendModel) MarkEmpty(id int64) error {
+	query := `
+		UPDATE digest_sends
+		SET status = 'empty'
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id)
+	return err
+}
+
+func (m DigestSendModel) MarkFailed(id int64, lastError string) error {
+	query := `
+		UPDATE digest_sends
+		SET status = 'failed', last_error = $2
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id, lastError)
+	return err
+}
+
+// Cleanup deletes the log entries created before the given time
+func (m DigestSendModel) Cleanup(before time.Time) error {
+	query := `
+		DELETE FROM digest_sends
+		WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, before)
+	return err
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DigestFrequencyDaily  = "daily"
+	DigestFrequencyWeekly = "weekly"
+
+	// DigestContentUnread selects the newest items the user has not read yet
+	DigestContentUnread = "unread"
+	// DigestContentHot selects the hottest items, whether the user has read them or not
+	DigestContentHot = "hot"
+
+	// digestUnsubscribePurpose separates the signatures of unsubscribe tokens from other
+	// signatures made with the same secret
+	digestUnsubscribePurpose = "digest-unsubscribe"
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// DigestSubscription is the opt-in of a user to a scheduled email digest of the items in their
+// walls. An empty list of walls selects all the walls the user is a member of. Digests are sent
+// at the send hour in the timezone of the user, on the send weekday for weekly digests.
+type DigestSubscription struct {
+	UserID      int64     `json:"-"`
+	Frequency   string    `json:"frequency"`
+	Content     string    `json:"content"`
+	WallIDs     []int64   `json:"wall_ids"`
+	Timezone    string    `json:"timezone"`
+	SendHour    int       `json:"send_hour"`
+	SendWeekday int       `json:"send_weekday"`
+	MaxItems    int       `json:"max_items"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// User and LastPeriodEnd are only set for the subscriptions returned by FindAfter
+	User          *User              `json:"-"`
+	LastPeriodEnd pgtype.Timestamptz `json:"-"`
+}
+
+type DigestSubscriptionModel struct {
+	DB *pgxpool.Pool
+}
+
+func ValidateDigestSubscription(v *validator.Validator, subscription *DigestSubscription) {
+	v.Check(validator.PermittedValue(subscription.Frequency, DigestFrequencyDaily, DigestFrequencyWeekly), "frequency", "Must be daily or weekly")
+	v.Check(validator.PermittedValue(subscription.Content, DigestContentUnread, DigestContentHot), "content", "Must be unread or hot")
+
+	v.Check(len(subscription.WallIDs) <= 20, "wall_ids", "Must not contain more than 20 walls")
+	v.Check(validator.Unique(subscription.WallIDs), "wall_ids", "Must not contain duplicate walls")
+	for _, wallID := range subscription.WallIDs {
+		v.Check(wallID > 0, "wall_ids", "Must contain valid wall ids")
+	}
+
+	_, err := time.LoadLocation(subscription.Timezone)
+	v.Check(validator.NotBlank(subscription.Timezone) && err == nil, "timezone", "Must be a valid IANA timezone")
+
+	v.Check(subscription.SendHour >= 0 && subscription.SendHour <= 23, "send_hour", "Must be between 0 and 23")
+	v.Check(subscription.SendWeekday >= 0 && subscription.SendWeekday <= 6, "send_weekday", "Must be between 0 (Sunday) and 6 (Saturday)")
+
+	v.Check(subscription.MaxItems >= 1, "max_items", "Must be greater than zero")
+	v.Check(subscription.MaxItems <= 50, "max_items", "Must not be more than 50")
+}
+
+// LatestPeriod returns the period covered by the latest digest which was due at or before now.
+// The period ends at the scheduled send time and is one day or one week long in the timezone
+// of the user.
+func (s *DigestSubscription) LatestPeriod(now time.Time) (time.Time, time.Time) {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := now.In(loc)
+	end := time.Date(local.Year(), local.Month(), local.Day(), s.SendHour, 0, 0, 0, loc)
+
+	if s.Frequency == DigestFrequencyWeekly {
+		end = end.AddDate(0, 0, -((int(end.Weekday()) - s.SendWeekday + 7) % 7))
+		if end.After(local) {
+			end = end.AddDate(0, 0, -7)
+		}
+		return end.AddDate(0, 0, -7), end
+	}
+
+	if end.After(local) {
+		end = end.AddDate(0, 0, -1)
+	}
+	return end.AddDate(0, 0, -1), end
+}
+
+// NewDigestUnsubscribeToken returns a token which unsubscribes a user from digests without
+// signing in. The token is the user id signed with the secret, so it needs no storage and
+// stays valid for as long as the secret is not rotated.
+func NewDigestUnsubscribeToken(userID int64, secret []byte) string {
+	id := strconv.FormatInt(userID, 10)
+	return id + "." + signDigestUnsubscribe(id, secret)
+}
+
+// ParseDigestUnsubscribeToken verifies the signature of an unsubscribe token and returns the
+// id of the user it was issued for
+func ParseDigestUnsubscribeToken(token string, secret []byte) (int64, error) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidUnsubscribeToken
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(signDigestUnsubscribe(id, secret))) {
+		return 0, ErrInvalidUnsubscribeToken
+	}
+
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || userID < 1 {
+		return 0, ErrInvalidUnsubscribeToken
+	}
+
+	return userID, nil
+}
+
+func signDigestUnsubscribe(id string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(digestUnsubscribePurpose + ":" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m DigestSubscriptionModel) Get(userID int64) (*DigestSubscription, error) {
+	query := `
+		SELECT frequency, content, wall_ids, timezone, send_hour, send_weekday, max_items, created_at, updated_at
+		FROM digest_subscriptions
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	subscription := &DigestSubscription{UserID: userID}
+	err := m.DB.QueryRow(ctx, query, userID).Scan(
+		&subscription.Frequency,
+		&subscription.Content,
+		&subscription.WallIDs,
+		&subscription.Timezone,
+		&subscription.SendHour,
+		&subscription.SendWeekday,
+		&subscription.MaxItems,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return subscription, nil
+}
+
+// Upsert subscribes a user to digests or replaces the preferences of an existing subscription.
+// ErrRecordNotFound is returned if the user is not a member of one of the walls.
+func (m DigestSubscriptionModel) Upsert(subscription *DigestSubscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = checkWallMemberships(ctx, tx, subscription.UserID, subscription.WallIDs)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO digest_subscriptions (user_id, frequency, content, wall_ids, timezone, send_hour, send_weekday, max_items)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id) DO UPDATE
+		SET frequency = EXCLUDED.frequency,
+			content = EXCLUDED.content,
+			wall_ids = EXCLUDED.wall_ids,
+			timezone = EXCLUDED.timezone,
+			send_hour = EXCLUDED.send_hour,
+			send_weekday = EXCLUDED.send_weekday,
+			max_items = EXCLUDED.max_items,
+			updated_at = NOW()
+		RETURNING created_at, updated_at`
+
+	args := []any{
+		subscription.UserID,
+		subscription.Frequency,
+		subscription.Content,
+		subscription.WallIDs,
+		subscription.Timezone,
+		subscription.SendHour,
+		subscription.SendWeekday,
+		subscription.MaxItems,
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&subscription.CreatedAt, &subscription.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Delete unsubscribes a user from digests. Unsubscribing twice is not an error, since
+// unsubscribe links may be followed more than once.
+func (m DigestSubscriptionModel) Delete(userID int64) error {
+	query := `
+		DELETE FROM digest_subscriptions
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
+
+// FindAfter returns up to limit subscriptions of activated users whose account is not being
+// deleted, with a user id greater than afterUserID, along with the end of the period of the last
+// digest sent to each user. Failed sends are not counted, so that they can be retried.
+func (m DigestSubscriptionModel) FindAfter(afterUserID int64, limit int) ([]*DigestSubscription, error) {
+	query := `
+		SELECT ds.user_id, ds.frequency, ds.content, ds.wall_ids, ds.timezone, ds.send_hour, ds.send_weekday,
+			ds.max_items, ds.created_at, ds.updated_at, u.email, u.full_name, u.username, last_send.period_end
+		FROM digest_subscriptions ds
+		INNER JOIN users u ON u.id = ds.user_id
+		LEFT JOIN LATERAL (
+			SELECT MAX(period_end) AS period_end
+			FROM digest_sends
+			WHERE digest_sends.user_id = ds.user_id AND digest_sends.status <> 'failed'
+		) last_send ON TRUE
+		WHERE u.activated AND u.deletion_scheduled_at IS NULL AND ds.user_id > $1
+		ORDER BY ds.user_id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, afterUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*DigestSubscription, error) {
+		subscription := &DigestSubscription{User: &User{}}
+		err := row.Scan(
+			&subscription.UserID,
+			&subscription.Frequency,
+			&subscription.Content,
+			&subscription.WallIDs,
+			&subscription.Timezone,
+			&subscription.SendHour,
+			&subscription.SendWeekday,
+			&subscription.MaxItems,
+			&subscription.CreatedAt,
+			&subscription.UpdatedAt,
+			&subscription.User.Email,
+			&subscription.User.FullName,
+			&subscription.User.Username,
+			&subscription.LastPeriodEnd,
+		)
+		subscription.User.ID = subscription.UserID
+		return subscription, err
+	})
+}
@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed"
+)
+
+// OutboxEmail is an email which is sent by the dispatcher after the transaction which created
+// it commits. The template data often contains tokens, so it is never exposed and is cleared
+// once the email is sent or has failed for good.
+type OutboxEmail struct {
+	ID        int64              `json:"id"`
+	Recipient string             `json:"recipient"`
+	Template  string             `json:"template"`
+	Data      map[string]any     `json:"-"`
+	Status    string             `json:"status"`
+	Attempts  int                `json:"attempts"`
+	RunAt     time.Time          `json:"next_attempt_at"`
+	LastError pgtype.Text        `json:"last_error"`
+	SentAt    pgtype.Timestamptz `json:"sent_at"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+type EmailOutboxModel struct {
+	DB *pgxpool.Pool
+}
+
+// insertOutboxEmail queues an email within the transaction which creates the records it is
+// about, so that the email is sent if and only if the transaction commits
+func insertOutboxEmail(ctx context.Context, tx pgx.Tx, email *OutboxEmail) error {
+	query := `
+		INSERT INTO email_outbox (recipient, template, data)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, run_at, created_at`
+
+	return tx.QueryRow(ctx, query, email.Recipient, email.Template, email.Data).Scan(
+		&email.ID,
+		&email.Status,
+		&email.RunAt,
+		&email.CreatedAt,
+	)
+}
+
+// ClaimDue claims up to limit pending emails which are due. Claimed emails are leased by moving
+// them into the future, so an email whose dispatcher went away is picked up again once the
+// lease runs out. Each claim counts as an attempt.
+func (m EmailOutboxModel) ClaimDue(limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	query := `
+		UPDATE email_outbox o
+		SET run_at = NOW() + $2::double precision * INTERVAL '1 second', attempts = o.attempts + 1
+		FROM (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND run_at <= NOW()
+			ORDER BY run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE o.id = due.id
+		RETURNING o.id, o.recipient, o.template, o.data, o.status, o.attempts, o.run_at, o.created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*OutboxEmail, error) {
+		var email OutboxEmail
+		err := row.Scan(
+			&email.ID,
+			&email.Recipient,
+			&email.Template,
+			&email.Data,
+			&email.Status,
+			&email.Attempts,
+			&email.RunAt,
+			&email.CreatedAt,
+		)
+		return &email, err
+	})
+}
+
+func (m EmailOutboxModel) MarkSent(id int64) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sent', data = '{}', last_error = NULL, sent_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id)
+	return err
+}
+
+// MarkFailed records that an email cannot be sent, e.g. because the recipient was rejected
+func (m EmailOutboxModel) MarkFailed(id int64, lastError string) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'failed', data = '{}', last_error = $2
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id, lastError)
+	return err
+}
+
+// Retry schedules the next attempt of an email with exponential backoff, or fails the email
+// once it was attempted maxAttempts times
+func (m EmailOutboxModel) Retry(id int64, lastError string, maxAttempts int, backoff time.Duration) error {
+	query := `
+		UPDATE email_outbox
+		SET status = CASE WHEN attempts >= $3 THEN 'failed' ELSE 'pending' END,
+			data = CASE WHEN attempts >= $3 THEN '{}' ELSE data END,
+			run_at = NOW() + $4::double precision * POWER(2, attempts - 1) * INTERVAL '1 second',
+			last_error = $2
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id, lastError, maxAttempts, backoff.Seconds())
+	return err
+}
+
+// FindStuck returns up to limit emails which failed for good, or are still pending after the
+// given duration, oldest first
+func (m EmailOutboxModel) FindStuck(pendingFor time.Duration, limit int) ([]*OutboxEmail, error) {
+	query := `
+		SELECT id, recipient, template, status, attempts, run_at, last_error, sent_at, created_at
+		FROM email_outbox
+		WHERE status = 'failed'
+		OR (status = 'pending' AND created_at < NOW() - $1::double precision * INTERVAL '1 second')
+		ORDER BY created_at, id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, pendingFor.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*OutboxEmail, error) {
+		var email OutboxEmail
+		err := row.Scan(
+			&email.ID,
+			&email.Recipient,
+			&email.Template,
+			&email.Status,
+			&email.Attempts,
+			&email.RunAt,
+			&email.LastError,
+			&email.SentAt,
+			&email.CreatedAt,
+		)
+		return &email, err
+	})
+}
+
+// Cleanup deletes the sent emails which were created before the given time. Failed emails are
+// kept until an admin has looked into them.
+func (m EmailOutboxModel) Cleanup(before time.Time) error {
+	query := `
+		DELETE FROM email_outbox
+		WHERE created_at < $1 AND status = 'sent'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, before)
+	return err
+}
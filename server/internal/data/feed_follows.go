@@ -204,7 +204,13 @@ func (m FeedFollowModel) Insert(feedFollow *FeedFollow) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRow(ctx, query, feedFollow.UserID, feedFollow.FeedID).Scan(
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, feedFollow.UserID, feedFollow.FeedID).Scan(
 		&feedFollow.CreatedAt,
 		&feedFollow.UpdatedAt,
 	)
@@ -218,7 +224,12 @@ func (m FeedFollowModel) Insert(feedFollow *FeedFollow) error {
 		return err
 	}
 
-	return nil
+	err = recordUserChange(ctx, tx, feedFollow.UserID, ChangeEntityFeedFollow, feedFollow.FeedID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (m FeedFollowModel) Delete(feedFollow FeedFollow) error {
@@ -229,7 +240,13 @@ func (m FeedFollowModel) Delete(feedFollow FeedFollow) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.Exec(ctx, query, feedFollow.UserID, feedFollow.FeedID)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, feedFollow.UserID, feedFollow.FeedID)
 	if err != nil {
 		return err
 	}
@@ -238,5 +255,10 @@ func (m FeedFollowModel) Delete(feedFollow FeedFollow) error {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = recordUserChange(ctx, tx, feedFollow.UserID, ChangeEntityFeedFollow, feedFollow.FeedID, ChangeOpDelete)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
@@ -0,0 +1,394 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FeedRecommendation struct {
+	UserID        int64     `json:"-"`
+	FeedID        int64     `json:"feed_id"`
+	Score         float64   `json:"score"`
+	CoFollowScore float64   `json:"co_follow_score"`
+	TopicScore    float64   `json:"topic_score"`
+	CreatedAt     time.Time `json:"created_at"`
+	Feed          *Feed     `json:"feed,omitempty"`
+}
+
+// RecommendableFeed holds the attributes of a feed that are needed to score it as a recommendation
+type RecommendableFeed struct {
+	ID             int64
+	TopicID        pgtype.Int8
+	IsVerified     bool
+	AddedBy        pgtype.Int8
+	FollowersCount int
+}
+
+// FeedSimilarities maps a feed to the feeds that are co-followed with it and the similarity between them
+type FeedSimilarities map[int64]map[int64]float64
+
+type FeedRecommendationModel struct {
+	DB *pgxpool.Pool
+}
+
+const (
+	// coFollowWeight is the weight of collaborative filtering in the blended score.
+	// The rest of the score comes from topic similarity.
+	coFollowWeight = 0.7
+
+	// relatedTopicWeight is how much a followed feed from a related topic (parent, child
+	// or sibling topic) counts towards topic similarity compared to one from the same topic
+	relatedTopicWeight = 0.5
+)
+
+// CalculateFeedSimilarities calculates the cosine similarity between every pair of feeds that
+// are followed together by at least one user.
+//
+// Formula:
+// similarity(X, Y) = coFollowers(X, Y) / sqrt(followers(X) * followers(Y))
+func CalculateFeedSimilarities(follows map[int64][]int64) FeedSimilarities {
+	followers := make(map[int64]int)
+	coFollowers := make(map[int64]map[int64]int)
+
+	for _, feedIDs := range follows {
+		for i, x := range feedIDs {
+			followers[x]++
+			for _, y := range feedIDs[i+1:] {
+				if x == y {
+					continue
+				}
+				if coFollowers[x] == nil {
+					coFollowers[x] = make(map[int64]int)
+				}
+				if coFollowers[y] == nil {
+					coFollowers[y] = make(map[int64]int)
+				}
+				coFollowers[x][y]++
+				coFollowers[y][x]++
+			}
+		}
+	}
+
+	similarities := make(FeedSimilarities, len(coFollowers))
+	for x, ys := range coFollowers {
+		similarities[x] = make(map[int64]float64, len(ys))
+		for y, count := range ys {
+			similarities[x][y] = float64(count) / math.Sqrt(float64(followers[x]*followers[y]))
+		}
+	}
+
+	return similarities
+}
+
+// relatedTopics returns the topic along with its parent topics. Two topics are related if
+// these sets intersect, i.e. if one is the parent of the other or if they share a parent.
+func relatedTopics(topicID int64, parents map[int64][]int64) []int64 {
+	return append([]int64{topicID}, parents[topicID]...)
+}
+
+// ScoreFeedRecommendations scores the feeds that a user does not follow, using the feeds that
+// they follow, and returns the top recommendations sorted by score in descending order.
+//
+// Formula:
+// coFollowScore(Y) = sum(similarity(X, Y) for X in followed feeds) / max over all candidates
+// topicScore(Y)    = (sameTopic(Y) + relatedTopicWeight * relatedTopic(Y)) / number of followed feeds
+// score(Y)         = coFollowWeight * coFollowScore(Y) + (1 - coFollowWeight) * topicScore(Y)
+//
+// sameTopic and relatedTopic are the number of followed feeds in the same topic as Y and in a
+// topic related to Y's topic (see relatedTopics). Feeds which are not verified are only
+// recommended to the user who added them. Ties are broken by the number of followers.
+func ScoreFeedRecommendations(
+	userID int64,
+	followedFeedIDs []int64,
+	feeds map[int64]*RecommendableFeed,
+	similarities FeedSimilarities,
+	topicParents map[int64][]int64,
+	limit int,
+) []*FeedRecommendation {
+	if len(followedFeedIDs) == 0 {
+		return []*FeedRecommendation{}
+	}
+
+	followed := make(map[int64]bool, len(followedFeedIDs))
+	for _, feedID := range followedFeedIDs {
+		followed[feedID] = true
+	}
+
+	isCandidate := func(feedID int64) bool {
+		feed, ok := feeds[feedID]
+		if !ok || followed[feedID] {
+			return false
+		}
+		return feed.IsVerified || (feed.AddedBy.Valid && feed.AddedBy.Int64 == userID)
+	}
+
+	coFollowScores := make(map[int64]float64)
+	topicCounts := make(map[int64]int)
+	for _, x := range followedFeedIDs {
+		for y, similarity := range similarities[x] {
+			if isCandidate(y) {
+				coFollowScores[y] += similarity
+			}
+		}
+		if feed, ok := feeds[x]; ok && feed.TopicID.Valid {
+			topicCounts[feed.TopicID.Int64]++
+		}
+	}
+
+	maxCoFollowScore := 0.0
+	for _, score := range coFollowScores {
+		maxCoFollowScore = max(maxCoFollowScore, score)
+	}
+
+	recommendations := []*FeedRecommendation{}
+	for feedID, feed := range feeds {
+		if !isCandidate(feedID) {
+			continue
+		}
+
+		coFollowScore := 0.0
+		if maxCoFollowScore > 0 {
+			coFollowScore = coFollowScores[feedID] / maxCoFollowScore
+		}
+
+		topicScore := 0.0
+		if feed.TopicID.Valid {
+			sameTopic := float64(topicCounts[feed.TopicID.Int64])
+			relatedTopic := 0.0
+			candidateTopics := relatedTopics(feed.TopicID.Int64, topicParents)
+			for topicID, count := range topicCounts {
+				if topicID == feed.TopicID.Int64 {
+					continue
+				}
+				for _, t := range relatedTopics(topicID, topicParents) {
+					if slices.Contains(candidateTopics, t) {
+						relatedTopic += float64(count)
+						break
+					}
+				}
+			}
+			topicScore = min((sameTopic+relatedTopicWeight*relatedTopic)/float64(len(followedFeedIDs)), 1)
+		}
+
+		if coFollowScore == 0 && topicScore == 0 {
+			continue
+		}
+
+		recommendations = append(recommendations, &FeedRecommendation{
+			UserID:        userID,
+			FeedID:        feedID,
+			Score:         coFollowWeight*coFollowScore + (1-coFollowWeight)*topicScore,
+			CoFollowScore: coFollowScore,
+			TopicScore:    topicScore,
+		})
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool {
+		a, b := recommendations[i], recommendations[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if feeds[a.FeedID].FollowersCount != feeds[b.FeedID].FollowersCount {
+			return feeds[a.FeedID].FollowersCount > feeds[b.FeedID].FollowersCount
+		}
+		return a.FeedID < b.FeedID
+	})
+
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+
+	return recommendations
+}
+
+// GetFollowGraph returns the feeds followed by each user
+func (m FeedRecommendationModel) GetFollowGraph() (map[int64][]int64, error) {
+	query := `
+		SELECT user_id, feed_id
+		FROM feed_follows`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID, feedID int64
+	follows := make(map[int64][]int64)
+	_, err = pgx.ForEachRow(rows, []any{&userID, &feedID}, func() error {
+		follows[userID] = append(follows[userID], feedID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return follows, nil
+}
+
+// GetRecommendableFeeds returns all feeds along with the parent topics of each topic
+func (m FeedRecommendationModel) GetRecommendableFeeds() (map[int64]*RecommendableFeed, map[int64][]int64, error) {
+	feedsQuery := `
+		SELECT id, topic_id, is_verified, added_by, followers_count
+		FROM feeds`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, feedsQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feeds := make(map[int64]*RecommendableFeed)
+	_, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (*RecommendableFeed, error) {
+		var feed RecommendableFeed
+		err := row.Scan(
+			&feed.ID,
+			&feed.TopicID,
+			&feed.IsVerified,
+			&feed.AddedBy,
+			&feed.FollowersCount,
+		)
+		feeds[feed.ID] = &feed
+		return &feed, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subtopicsQuery := `
+		SELECT parent_id, child_id
+		FROM subtopics`
+
+	rows, err = m.DB.Query(ctx, subtopicsQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parentID, childID int64
+	topicParents := make(map[int64][]int64)
+	_, err = pgx.ForEachRow(rows, []any{&parentID, &childID}, func() error {
+		topicParents[childID] = append(topicParents[childID], parentID)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return feeds, topicParents, nil
+}
+
+// ReplaceForUser replaces the precomputed recommendations of a user
+func (m FeedRecommendationModel) ReplaceForUser(userID int64, recommendations []*FeedRecommendation) error {
+	feedIDs := make([]int64, len(recommendations))
+	scores := make([]float64, len(recommendations))
+	coFollowScores := make([]float64, len(recommendations))
+	topicScores := make([]float64, len(recommendations))
+	for i, recommendation := range recommendations {
+		feedIDs[i] = recommendation.FeedID
+		scores[i] = recommendation.Score
+		coFollowScores[i] = recommendation.CoFollowScore
+		topicScores[i] = recommendation.TopicScore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM feed_recommendations WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO feed_recommendations (user_id, feed_id, score, co_follow_score, topic_score)
+		SELECT $1, t.feed_id, t.score, t.co_follow_score, t.topic_score
+		FROM UNNEST($2::bigint[], $3::float8[], $4::float8[], $5::float8[])
+			AS t(feed_id, score, co_follow_score, topic_score)
+		ON CONFLICT DO NOTHING`
+
+	_, err = tx.Exec(ctx, query, userID, feedIDs, scores, coFollowScores, topicScores)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetForUser returns the precomputed recommendations of a user. Feeds which the user has
+// followed since the recommendations were computed are excluded.
+func (m FeedRecommendationModel) GetForUser(userID int64, filters Filters) ([]*FeedRecommendation, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), fr.feed_id, fr.score, fr.co_follow_score, fr.topic_score, fr.created_at,
+			feeds.id, feeds.display_title, feeds.title, feeds.description, feeds.link, feeds.feed_link, feeds.image_url,
+			feeds.pub_date, feeds.pub_updated, feeds.feed_type, feeds.owner_type, feeds.topic_id, feeds.followers_count
+		FROM feed_recommendations fr
+		INNER JOIN feeds ON feeds.id = fr.feed_id
+		WHERE fr.user_id = $1
+		AND (feeds.is_verified = TRUE OR feeds.added_by = $1)
+		AND NOT EXISTS (
+			SELECT 1 FROM feed_follows
+			WHERE feed_follows.user_id = $1 AND feed_follows.feed_id = fr.feed_id
+		)
+		ORDER BY fr.score %s, feeds.followers_count DESC, feeds.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, getEmptyMetadata(filters.Page, filters.PageSize), err
+	}
+
+	totalRecords := 0
+	recommendations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*FeedRecommendation, error) {
+		var recommendation FeedRecommendation
+		var feed Feed
+		err := row.Scan(
+			&totalRecords,
+			&recommendation.FeedID,
+			&recommendation.Score,
+			&recommendation.CoFollowScore,
+			&recommendation.TopicScore,
+			&recommendation.CreatedAt,
+			&feed.ID,
+			&feed.DisplayTitle,
+			&feed.Title,
+			&feed.Description,
+			&feed.Link,
+			&feed.FeedLink,
+			&feed.ImageURL,
+			&feed.PubDate,
+			&feed.PubUpdated,
+			&feed.FeedType,
+			&feed.OwnerType,
+			&feed.TopicID,
+			&feed.FollowersCount,
+		)
+		recommendation.UserID = userID
+		recommendation.Feed = &feed
+		return &recommendation, err
+	})
+	if err != nil {
+		return nil, getEmptyMetadata(filters.Page, filters.PageSize), err
+	}
+
+	return recommendations, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -48,12 +48,14 @@ type Feed struct {
 	IsVerified     bool               `json:"is_verified,omitempty"`
 }
 
+var FeedTypes = []string{"website", "medium", "substack", "reddit", "youtube", "podcast"}
+
 func ValidateFeedLink(v *validator.Validator, feedLink string) {
 	v.Check(validator.NotBlank(feedLink), "feed_link", "Feed link must not be empty")
 }
 
 func ValidateFeedType(v *validator.Validator, feedType string) {
-	v.Check(validator.PermittedValue(feedType, "website", "medium", "substack", "reddit", "youtube", "podcast"), "feed_type", "Feed type must be one of website, medium, substack, reddit, youtube, or podcast")
+	v.Check(validator.PermittedValue(feedType, FeedTypes...), "feed_type", "Feed type must be one of website, medium, substack, reddit, youtube, or podcast")
 }
 
 // convertToTsQuery converts a search term to PostgreSQL tsquery format with prefix matching
@@ -436,7 +438,8 @@ func (m FeedModel) DeleteByID(id int64) error {
 
 func (m FeedModel) GetUncheckedFeedsSince(since time.Time) ([]*Feed, error) {
 	query := `
-		SELECT id, feed_link, display_title, feed_type, owner_type, topic_id, version, is_verified
+		SELECT id, feed_link, display_title, feed_type, owner_type, topic_id, version, is_verified,
+			last_fetch_at, last_failure_at
 		FROM feeds
 		WHERE GREATEST(last_fetch_at, last_failure_at, '-Infinity'::timestamptz) < $1`
 
@@ -459,6 +462,8 @@ func (m FeedModel) GetUncheckedFeedsSince(since time.Time) ([]*Feed, error) {
 			&feed.TopicID,
 			&feed.Version,
 			&feed.IsVerified,
+			&feed.LastFetchAt,
+			&feed.LastFailureAt,
 		)
 		return &feed, err
 	})
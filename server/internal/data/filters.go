@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/aravindmathradan/semaphore/internal/validator"
 )
@@ -106,8 +107,10 @@ func (f Filters) offset() int {
 type SortMode string
 
 const (
-	SortModeNew SortMode = "new"
-	SortModeHot SortMode = "hot"
+	SortModeNew      SortMode = "new"
+	SortModeHot      SortMode = "hot"
+	SortModeForYou   SortMode = "for_you"
+	SortModeTrending SortMode = "trending"
 )
 
 type CursorFilters struct {
@@ -119,10 +122,11 @@ type CursorFilters struct {
 }
 
 type CursorMetadata struct {
-	SessionID  string `json:"session_id,omitempty"`
-	PageSize   int    `json:"page_size"`
-	NextCursor string `json:"next_cursor"`
-	HasMore    bool   `json:"has_more"`
+	SessionID        string     `json:"session_id,omitempty"`
+	SessionExpiresAt *time.Time `json:"session_expires_at,omitempty"`
+	PageSize         int        `json:"page_size"`
+	NextCursor       string     `json:"next_cursor"`
+	HasMore          bool       `json:"has_more"`
 }
 
 func ValidateCursorFilters(v *validator.Validator, f CursorFilters) {
@@ -164,7 +168,7 @@ func encodeCursor(c any) string {
 func decodeCursor(s string, c any) error {
 	b, err := base64.URLEncoding.DecodeString(s)
 	if err != nil {
-		return err
+		return ErrInvalidCursor
 	}
 	dec := json.NewDecoder(bytes.NewReader(b))
 	dec.DisallowUnknownFields()
@@ -37,10 +37,12 @@ type Item struct {
 	CreatedAt   time.Time                    `json:"created_at,omitempty"`
 	UpdatedAt   time.Time                    `json:"updated_at,omitempty"`
 
-	IsSaved bool    `json:"is_saved,omitempty"`
-	IsLiked bool    `json:"is_liked,omitempty"`
-	Score   float64 `json:"score,omitempty"`
-	Feed    *Feed   `json:"feed,omitempty"`
+	IsSaved bool            `json:"is_saved,omitempty"`
+	IsLiked bool            `json:"is_liked,omitempty"`
+	IsRead  bool            `json:"is_read,omitempty"`
+	Score   float64         `json:"score,omitempty"`
+	Reasons []RankingReason `json:"reasons,omitempty"`
+	Feed    *Feed           `json:"feed,omitempty"`
 }
 
 // Person is an individual specified in a feed
@@ -57,8 +59,9 @@ type Enclosure struct {
 }
 
 type ItemScore struct {
-	ItemID int64
-	Score  float64
+	ItemID  int64
+	Score   float64
+	Reasons []RankingReason `json:",omitempty"`
 }
 
 // Cursor for sorting items by "new"
@@ -149,6 +152,8 @@ func buildUpsertItemsQuery(items []*Item) (query string, args []any) {
 
 	buf.WriteString(`
 		),
+		-- Data-modifying CTEs run even if they are not referenced. The insert below checks items
+		-- directly, since unchanged items are skipped by the update.
 		updated_items AS (
 			UPDATE items AS i
 			SET
@@ -163,31 +168,46 @@ func buildUpsertItemsQuery(items []*Item) (query string, args []any) {
 				image_url = a.image_url,
 				categories = a.categories,
 				enclosures = a.enclosures,
-				version = i.version + 1
+				version = i.version + 1,
+				updated_at = NOW()
 			FROM all_items as a
 			WHERE i.feed_id = a.feed_id
 			AND (i.link = a.link OR i.guid = a.guid)
-			RETURNING i.feed_id, i.guid, i.link
+			-- Items are only updated if they changed, so that sync does not resend every item of a
+			-- feed whenever it is refreshed
+			AND (i.title, i.description, i.content, i.link, i.pub_date, i.pub_updated, i.guid,
+				i.authors, i.image_url, i.categories, i.enclosures)
+			IS DISTINCT FROM (a.title, a.description, a.content, a.link, a.pub_date, a.pub_updated, a.guid,
+				a.authors, a.image_url, a.categories, a.enclosures)
 		)
 		INSERT INTO items (feed_id, title, description, content, link, pub_date, pub_updated,
-			guid, authors, image_url, categories, enclosures)
-		SELECT *
+			guid, authors, image_url, categories, enclosures, hot_score)
+		SELECT ai.*, `)
+
+	// New items have no likes or saves yet, but their hot score is calculated right away so that
+	// they are not ranked below older items until the hot scores are refreshed
+	buf.WriteString(buildHotItemsScoreCalculationQuery("0", "0", "ai.pub_date", "NOW()"))
+
+	buf.WriteString(`
 		FROM all_items ai
 		WHERE NOT EXISTS (
 			SELECT 1
-			FROM updated_items ui
-			WHERE ui.feed_id = ai.feed_id
-			AND (ui.link = ai.link OR ui.guid = ai.guid)
+			FROM items i
+			WHERE i.feed_id = ai.feed_id
+			AND (i.link = ai.link OR i.guid = ai.guid)
 		)
 		ON CONFLICT DO NOTHING
+		RETURNING id
 	`)
 
 	return buf.String(), args
 }
 
-func (m ItemModel) UpsertMany(items []*Item) error {
+// UpsertMany inserts new items and updates the existing items of a feed. The IDs of the new
+// items are returned.
+func (m ItemModel) UpsertMany(items []*Item) ([]int64, error) {
 	if len(items) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	query, args := buildUpsertItemsQuery(items)
@@ -195,12 +215,17 @@ func (m ItemModel) UpsertMany(items []*Item) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.Exec(ctx, query, args...)
+	rows, err := m.DB.Query(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to upsert items:\n%w\nquery:\n%s\nargs:\n%v", err, query, args)
+		return nil, fmt.Errorf("failed to upsert items:\n%w\nquery:\n%s\nargs:\n%v", err, query, args)
 	}
 
-	return nil
+	newItemIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert items:\n%w\nquery:\n%s\nargs:\n%v", err, query, args)
+	}
+
+	return newItemIDs, nil
 }
 
 func (m ItemModel) Insert(item *Item) error {
@@ -251,10 +276,12 @@ func (m ItemModel) FindAllForFeedsByNew(feedIDs []int64, userID int64, title str
 		SELECT items.id, items.title, items.description, items.content, items.link, items.pub_date,
 			items.pub_updated, items.authors, items.guid, items.image_url, items.categories, items.enclosures, items.feed_id,
 			items.version, items.created_at, items.updated_at, (si.item_id IS NOT NULL) as is_saved,
-			(li.item_id IS NOT NULL) as is_liked
+			(li.item_id IS NOT NULL) as is_liked,
+			(ri.item_id IS NOT NULL) as is_read
 		FROM items
 		LEFT JOIN saved_items si ON si.item_id = items.id AND si.user_id = $3
 		LEFT JOIN liked_items li ON li.item_id = items.id AND li.user_id = $3
+		LEFT JOIN read_items ri ON ri.item_id = items.id AND ri.user_id = $3
 		WHERE items.feed_id = ANY($1)
 		AND (
 			to_tsvector('simple', items.title) @@ plainto_tsquery('simple', $2)
@@ -311,6 +338,7 @@ func (m ItemModel) FindAllForFeedsByNew(feedIDs []int64, userID int64, title str
 			&item.UpdatedAt,
 			&item.IsSaved,
 			&item.IsLiked,
+			&item.IsRead,
 		)
 		lastID = item.ID
 		lastPubDate = item.PubDate
@@ -340,13 +368,16 @@ func (m ItemModel) FindAllForWallByNew(wallID, userID int64, cursorFilters Curso
 			items.pub_updated, items.authors, items.guid, items.image_url, items.categories, items.enclosures, items.feed_id,
 			items.version, items.created_at, items.updated_at, feeds.id, feeds.display_title, feeds.title, feeds.description, feeds.link, feeds.feed_link,
 			feeds.pub_date as feed_pub_date, feeds.pub_updated as feed_pub_updated, feeds.feed_type, feeds.owner_type, feeds.feed_format, feeds.language,
-			feeds.image_url as feed_image_url, (si.item_id IS NOT NULL) as is_saved, (li.item_id IS NOT NULL) as is_liked
+			feeds.image_url as feed_image_url, (si.item_id IS NOT NULL) as is_saved, (li.item_id IS NOT NULL) as is_liked,
+			(ri.item_id IS NOT NULL) as is_read
 		FROM items
 		INNER JOIN feeds ON feeds.id = items.feed_id
 		INNER JOIN wall_feeds ON wall_feeds.feed_id = feeds.id
 		LEFT JOIN saved_items si ON si.item_id = items.id AND si.user_id = $2
 		LEFT JOIN liked_items li ON li.item_id = items.id AND li.user_id = $2
+		LEFT JOIN read_items ri ON ri.item_id = items.id AND ri.user_id = $2
 		WHERE wall_feeds.wall_id = $1
+		AND ` + wallFeedItemsCondition + `
 	`
 	args := []any{wallID, userID}
 
@@ -412,6 +443,7 @@ func (m ItemModel) FindAllForWallByNew(wallID, userID int64, cursorFilters Curso
 			&feed.ImageURL,
 			&item.IsSaved,
 			&item.IsLiked,
+			&item.IsRead,
 		)
 		item.Feed = &feed
 		lastID = item.ID
@@ -444,25 +476,10 @@ func (m ItemModel) FindByScore(itemScores []*ItemScore, userID int64, cursorFilt
 		return []*Item{}, getEmptyCursorMetadata(cursorFilters.PageSize), nil
 	}
 
-	start := 0
-	var cursor sortByScoreCursor
-	if cursorFilters.After != "" {
-		// If cursor is provided, decode it to get the previous item's score and ID
-		err := decodeCursor(cursorFilters.After, &cursor)
-		if err != nil {
-			return nil, getEmptyCursorMetadata(cursorFilters.PageSize), err
-		}
-
-		// Find the start index of the items to fetch next
-		for _, itemScore := range itemScores {
-			start++
-			if itemScore.Score > cursor.Score ||
-				(itemScore.Score == cursor.Score && itemScore.ItemID > cursor.ID) {
-				continue
-			} else {
-				break
-			}
-		}
+	// Find the start index of the items to fetch next using the cursor
+	start, err := findScoreIndexAfter(itemScores, cursorFilters.After)
+	if err != nil {
+		return nil, getEmptyCursorMetadata(cursorFilters.PageSize), err
 	}
 
 	// end is the end index of the items to fetch next
@@ -489,11 +506,17 @@ func (m ItemModel) FindByScore(itemScores []*ItemScore, userID int64, cursorFilt
 	}
 
 	// Create a new items variable for ordering the items according to itemScores
-	// Also add the score to the items
-	items := make([]*Item, len(slice))
-	for i, itemScore := range slice {
-		items[i] = itemMap[itemScore.ItemID]
-		items[i].Score = itemScore.Score
+	// Also add the score and the reasons behind it to the items
+	// Items which were deleted after the scores were calculated are skipped
+	items := make([]*Item, 0, len(slice))
+	for _, itemScore := range slice {
+		item, ok := itemMap[itemScore.ItemID]
+		if !ok {
+			continue
+		}
+		item.Score = itemScore.Score
+		item.Reasons = itemScore.Reasons
+		items = append(items, item)
 	}
 
 	if len(slice) == 0 {
@@ -509,35 +532,194 @@ func (m ItemModel) FindByScore(itemScores []*ItemScore, userID int64, cursorFilt
 	return items, calculateCursorMetadata(
 		nextCursor,
 		cursorFilters.PageSize,
-		len(items) == cursorFilters.PageSize,
+		len(slice) == cursorFilters.PageSize,
 		cursorFilters.SessionID,
 	), nil
 }
 
-func (m ItemModel) CalculateHotItemScoresForWall(wallID, userID int64, snapshotSize int) ([]*ItemScore, error) {
-	scoreCalculation := buildHotItemsScoreCalculationQuery("lc.like_count", "sc.save_count", "items.pub_date", "items.created_at")
+// FindHotItemScoresForWall returns the scores of the hottest items of a wall ranked after the given
+// item score (or from the top if after is nil) using the materialized hot scores
+func (m ItemModel) FindHotItemScoresForWall(wallID int64, after *ItemScore, limit int) ([]*ItemScore, error) {
+	query := `
+		SELECT items.id, items.hot_score
+		FROM items
+		INNER JOIN wall_feeds ON wall_feeds.feed_id = items.feed_id
+		WHERE wall_feeds.wall_id = $1
+		AND ` + wallFeedItemsCondition + `
+	`
+	args := []any{wallID}
+
+	if after != nil {
+		query += `
+			AND (items.hot_score, items.id) < ($2, $3)
+		`
+		args = append(args, after.Score, after.ItemID)
+	}
+	query += fmt.Sprintf(`
+		ORDER BY items.hot_score DESC, items.id DESC
+		LIMIT $%d
+	`, len(args)+1)
+	args = append(args, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	itemScores, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*ItemScore, error) {
+		var itemScore ItemScore
+		err := row.Scan(&itemScore.ItemID, &itemScore.Score)
+		return &itemScore, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return itemScores, nil
+}
+
+// FindForDigest returns up to limit items which were added between since and until to the walls
+// of a user, or to the given walls when wallIDs is not empty. With DigestContentUnread the
+// newest unread items are returned, otherwise the hottest items.
+func (m ItemModel) FindForDigest(userID int64, wallIDs []int64, content string, since, until time.Time, limit int) ([]*Item, error) {
+	query := `
+		SELECT items.id, items.title, items.link, items.pub_date, items.image_url, items.feed_id,
+			feeds.display_title, feeds.title, feeds.image_url
+		FROM items
+		INNER JOIN feeds ON feeds.id = items.feed_id
+		WHERE items.created_at >= $3 AND items.created_at < $4
+		AND EXISTS (
+			SELECT 1
+			FROM wall_feeds
+			INNER JOIN wall_members ON wall_members.wall_id = wall_feeds.wall_id
+			WHERE wall_feeds.feed_id = items.feed_id
+			AND wall_members.user_id = $1
+			AND (CARDINALITY($2::bigint[]) = 0 OR wall_feeds.wall_id = ANY($2))
+			AND ` + wallFeedItemsCondition + `
+		)
+	`
+	if content == DigestContentUnread {
+		query += `
+			AND NOT EXISTS (SELECT 1 FROM read_items WHERE read_items.item_id = items.id AND read_items.user_id = $1)
+			ORDER BY COALESCE(items.pub_date, items.created_at) DESC, items.id DESC
+		`
+	} else {
+		query += `
+			ORDER BY items.hot_score DESC, items.id DESC
+		`
+	}
+	query += `
+		LIMIT $5
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID, wallIDs, since, until, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Item, error) {
+		item := &Item{Feed: &Feed{}}
+		err := row.Scan(
+			&item.ID,
+			&item.Title,
+			&item.Link,
+			&item.PubDate,
+			&item.ImageURL,
+			&item.FeedID,
+			&item.Feed.DisplayTitle,
+			&item.Feed.Title,
+			&item.Feed.ImageURL,
+		)
+		item.Feed.ID = item.FeedID
+		return item, err
+	})
+}
+
+// updateItemEngagementCounts adjusts the like and save counters of an item by the given deltas
+// and recalculates its hot score, so that likes and saves are reflected in hot walls immediately
+func updateItemEngagementCounts(ctx context.Context, tx pgx.Tx, itemID int64, likeDelta, saveDelta int) error {
+	likeCount := fmt.Sprintf("(like_count + %d)", likeDelta)
+	saveCount := fmt.Sprintf("(save_count + %d)", saveDelta)
 	query := fmt.Sprintf(`
-		WITH ranked_items AS (
+		UPDATE items
+		SET like_count = %s,
+			save_count = %s,
+			hot_score = %s
+		WHERE id = $1`,
+		likeCount, saveCount,
+		buildHotItemsScoreCalculationQuery(likeCount, saveCount, "pub_date", "created_at"),
+	)
+
+	_, err := tx.Exec(ctx, query, itemID)
+	return err
+}
+
+// RefreshHotScores recalculates the hot score of all items from their like and save counters.
+// Hot scores decay with time, so they have to be refreshed periodically.
+func (m ItemModel) RefreshHotScores() error {
+	query := fmt.Sprintf(`
+		UPDATE items
+		SET hot_score = %s`,
+		buildHotItemsScoreCalculationQuery("like_count", "save_count", "pub_date", "created_at"),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query)
+	return err
+}
+
+// CalculateTrendingItemScores ranks items from all verified feeds by the velocity of their likes
+// and saves within the window. The items can be scoped by a topic (including its subtopics) using
+// topicID (-1 for all topics) and by feed type ("" for all feed types).
+func (m ItemModel) CalculateTrendingItemScores(topicID int64, feedType string, window time.Duration, snapshotSize int) ([]*ItemScore, error) {
+	scoreCalculation := buildTrendingItemsScoreCalculationQuery("lc.like_count", "sc.save_count", "items.pub_date", "items.created_at", window)
+	query := fmt.Sprintf(`
+		WITH recent_likes AS (
+			SELECT item_id, COUNT(*) as like_count FROM liked_items WHERE created_at > $1 GROUP BY item_id
+		),
+		recent_saves AS (
+			SELECT item_id, COUNT(*) as save_count FROM saved_items WHERE created_at > $1 GROUP BY item_id
+		),
+		ranked_items AS (
 			SELECT items.id as item_id, %s as score
 			FROM items
 			INNER JOIN feeds ON feeds.id = items.feed_id
-			INNER JOIN wall_feeds ON wall_feeds.feed_id = feeds.id
-			LEFT JOIN (
-				SELECT item_id, COUNT(*) as save_count FROM saved_items GROUP BY item_id
-			) sc ON sc.item_id = items.id
-			LEFT JOIN (
-				SELECT item_id, COUNT(*) as like_count FROM liked_items GROUP BY item_id
-			) lc ON lc.item_id = items.id
-			WHERE wall_feeds.wall_id = $1
+			LEFT JOIN recent_likes lc ON lc.item_id = items.id
+			LEFT JOIN recent_saves sc ON sc.item_id = items.id
+			WHERE (lc.item_id IS NOT NULL OR sc.item_id IS NOT NULL)
+			AND feeds.is_verified = TRUE
+			AND (
+				feeds.topic_id = $2
+				OR EXISTS (
+					SELECT 1 FROM subtopics
+					WHERE subtopics.child_id = feeds.topic_id
+					AND subtopics.parent_id = $2
+				)
+				OR $2 = -1
+			)
+			AND (
+				CASE
+					WHEN $3::text = '' THEN TRUE
+					ELSE feeds.feed_type = $3::feed_type_enum
+				END
+			)
 		)
 		SELECT *
 		FROM ranked_items
 		ORDER BY score DESC, item_id DESC
-		LIMIT $2
+		LIMIT $4
 	`, scoreCalculation)
-	args := []any{wallID, snapshotSize}
+	args := []any{time.Now().Add(-window), topicID, feedType, snapshotSize}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.Query(ctx, query, args...)
@@ -563,11 +745,13 @@ func (m ItemModel) GetByItemIDs(ids []int64, userID int64) ([]*Item, error) {
 			items.pub_updated, items.authors, items.guid, items.image_url, items.categories, items.enclosures, items.feed_id,
 			items.version, items.created_at, items.updated_at, feeds.id, feeds.display_title, feeds.title, feeds.description, feeds.link, feeds.feed_link,
 			feeds.pub_date as feed_pub_date, feeds.pub_updated as feed_pub_updated, feeds.feed_type, feeds.owner_type, feeds.feed_format, feeds.language,
-			feeds.image_url as feed_image_url, (si.item_id IS NOT NULL) as is_saved, (li.item_id IS NOT NULL) as is_liked
+			feeds.image_url as feed_image_url, (si.item_id IS NOT NULL) as is_saved, (li.item_id IS NOT NULL) as is_liked,
+			(ri.item_id IS NOT NULL) as is_read
 		FROM items
 		INNER JOIN feeds ON feeds.id = items.feed_id
 		LEFT JOIN saved_items si ON si.item_id = items.id AND si.user_id = $2
 		LEFT JOIN liked_items li ON li.item_id = items.id AND li.user_id = $2
+		LEFT JOIN read_items ri ON ri.item_id = items.id AND ri.user_id = $2
 		WHERE items.id = ANY($1)
 	`
 	args := []any{ids, userID}
@@ -615,6 +799,7 @@ func (m ItemModel) GetByItemIDs(ids []int64, userID int64) ([]*Item, error) {
 			&feed.ImageURL,
 			&item.IsSaved,
 			&item.IsLiked,
+			&item.IsRead,
 		)
 		item.Feed = &feed
 		return &item, err
@@ -680,3 +865,98 @@ func (m ItemModel) CleanupItems(before time.Time) error {
 	_, err := m.DB.Exec(ctx, query, before)
 	return err
 }
+
+// FindUpdatedForUser returns the items of the feeds a user follows which were updated after the
+// position (updatedAt, itemID) and before settleDelay ago, oldest first. Items written by
+// transactions that are still in progress are not visible yet, so the most recent items are left
+// for the next sync. The returned token points after the last item returned, or at the upper
+// bound if no more items remain. Whether more items remain is also returned.
+func (m ItemModel) FindUpdatedForUser(userID int64, updatedAt time.Time, itemID int64, settleDelay time.Duration, limit int) ([]*Item, SyncToken, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var token SyncToken
+	err := m.DB.QueryRow(ctx, `SELECT NOW() - $1::double precision * INTERVAL '1 second'`, settleDelay.Seconds()).Scan(&token.ItemsUpdatedAt)
+	if err != nil {
+		return nil, token, false, err
+	}
+
+	query := `
+		SELECT items.id, items.title, items.description, items.content, items.link, items.pub_date,
+			items.pub_updated, items.authors, items.guid, items.image_url, items.categories, items.enclosures, items.feed_id,
+			items.version, items.created_at, items.updated_at, feeds.id, feeds.display_title, feeds.title, feeds.description, feeds.link, feeds.feed_link,
+			feeds.pub_date as feed_pub_date, feeds.pub_updated as feed_pub_updated, feeds.feed_type, feeds.owner_type, feeds.feed_format, feeds.language,
+			feeds.image_url as feed_image_url, (si.item_id IS NOT NULL) as is_saved, (li.item_id IS NOT NULL) as is_liked,
+			(ri.item_id IS NOT NULL) as is_read
+		FROM items
+		INNER JOIN feeds ON feeds.id = items.feed_id
+		INNER JOIN feed_follows ff ON ff.feed_id = items.feed_id AND ff.user_id = $1
+		LEFT JOIN saved_items si ON si.item_id = items.id AND si.user_id = $1
+		LEFT JOIN liked_items li ON li.item_id = items.id AND li.user_id = $1
+		LEFT JOIN read_items ri ON ri.item_id = items.id AND ri.user_id = $1
+		WHERE (items.updated_at, items.id) > ($2, $3)
+		AND items.updated_at < $4
+		ORDER BY items.updated_at ASC, items.id ASC
+		LIMIT $5`
+
+	args := []any{userID, updatedAt, itemID, token.ItemsUpdatedAt, limit + 1}
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, token, false, err
+	}
+
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Item, error) {
+		var item Item
+		var feed Feed
+		err := row.Scan(
+			&item.ID,
+			&item.Title,
+			&item.Description,
+			&item.Content,
+			&item.Link,
+			&item.PubDate,
+			&item.PubUpdated,
+			&item.Authors,
+			&item.GUID,
+			&item.ImageURL,
+			&item.Categories,
+			&item.Enclosures,
+			&item.FeedID,
+			&item.Version,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&feed.ID,
+			&feed.DisplayTitle,
+			&feed.Title,
+			&feed.Description,
+			&feed.Link,
+			&feed.FeedLink,
+			&feed.PubDate,
+			&feed.PubUpdated,
+			&feed.FeedType,
+			&feed.OwnerType,
+			&feed.FeedFormat,
+			&feed.Language,
+			&feed.ImageURL,
+			&item.IsSaved,
+			&item.IsLiked,
+			&item.IsRead,
+		)
+		item.Feed = &feed
+		return &item, err
+	})
+	if err != nil {
+		return nil, token, false, err
+	}
+
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+		last := items[len(items)-1]
+		token.ItemsUpdatedAt = last.UpdatedAt
+		token.ItemID = last.ID
+	}
+
+	return items, token, hasMore, nil
+}
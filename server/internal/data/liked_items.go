@@ -29,50 +29,95 @@ var (
 )
 
 func (m LikedItemModel) Insert(userID, itemID int64) error {
-	query := `
-		INSERT INTO liked_items (user_id, item_id)
-		VALUES ($1, $2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = insertLikedItem(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (m LikedItemModel) Delete(userID, itemID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.Exec(ctx, query, userID, itemID)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = deleteLikedItem(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// insertLikedItem likes an item for a user within a transaction and updates the like count
+// of the item. Liking an item which is already liked does nothing.
+func insertLikedItem(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
+	query := `
+		INSERT INTO liked_items (user_id, item_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	result, err := tx.Exec(ctx, query, userID, itemID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgErr.Code == strconv.Itoa(23503) && strings.Contains(pgErr.ConstraintName, "liked_items_item_id_fkey") {
 				return ErrFKeyItemNotFound
 			}
-			if pgErr.Code == strconv.Itoa(23505) && strings.Contains(pgErr.ConstraintName, "liked_items_pkey") {
-				// Do nothing, it's already liked
-				return nil
-			}
 		}
 		return err
 	}
 
-	return nil
+	// The item was already liked
+	if result.RowsAffected() == 0 {
+		return nil
+	}
+
+	err = updateItemEngagementCounts(ctx, tx, itemID, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	return recordUserChange(ctx, tx, userID, ChangeEntityLikedItem, itemID, ChangeOpUpsert)
 }
 
-func (m LikedItemModel) Delete(userID, itemID int64) error {
+// deleteLikedItem unlikes an item for a user within a transaction and updates the like count
+// of the item. ErrRecordNotFound is returned if the item was not liked.
+func deleteLikedItem(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
 	query := `
 		DELETE FROM liked_items
 		WHERE user_id = $1 AND item_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	result, err := m.DB.Exec(ctx, query, userID, itemID)
+	result, err := tx.Exec(ctx, query, userID, itemID)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
+	if result.RowsAffected() == 0 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = updateItemEngagementCounts(ctx, tx, itemID, -1, 0)
+	if err != nil {
+		return err
+	}
+
+	return recordUserChange(ctx, tx, userID, ChangeEntityLikedItem, itemID, ChangeOpDelete)
 }
 
 func (m LikedItemModel) GetAllForUser(userID int64, title string, filters Filters) ([]*LikedItem, Metadata, error) {
@@ -159,9 +204,9 @@ func (m LikedItemModel) GetAllForUser(userID int64, title string, filters Filter
 
 func (m LikedItemModel) GetLikeCount(itemID int64) (int, error) {
 	query := `
-		SELECT COUNT(*) 
-		FROM liked_items
-		WHERE item_id = $1`
+		SELECT like_count
+		FROM items
+		WHERE id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -169,6 +214,9 @@ func (m LikedItemModel) GetLikeCount(itemID int64) (int, error) {
 	var count int
 	err := m.DB.QueryRow(ctx, query, itemID).Scan(&count)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
 		return 0, err
 	}
 
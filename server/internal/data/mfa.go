@@ -0,0 +1,293 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated when MFA is enabled
+const RecoveryCodeCount = 10
+
+var ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+
+// TOTP is the authenticator app of a user. It is enrolled while EnabledAt is not set, and only
+// enforced on login once the user verified a code from it.
+type TOTP struct {
+	UserID       int64
+	Secret       []byte
+	EnabledAt    pgtype.Timestamptz
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+func (t *TOTP) Enabled() bool {
+	return t.EnabledAt.Valid
+}
+
+// GenerateRecoveryCodes generates one-time recovery codes, along with the hashes they are stored
+// as. The codes look like "k3x9v-q2m7d".
+func GenerateRecoveryCodes(n int) ([]string, [][]byte, error) {
+	codes := make([]string, n)
+	hashes := make([][]byte, n)
+
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring the case and the separators users might
+// type differently
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+type MFAModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m MFAModel) GetTOTP(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_used_step, created_at
+		FROM user_totp
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var totp TOTP
+	err := m.DB.QueryRow(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.EnabledAt,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// IsEnabled reports whether a user has to provide a second factor on login
+func (m MFAModel) IsEnabled(userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_totp
+			WHERE user_id = $1 AND enabled_at IS NOT NULL
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	err := m.DB.QueryRow(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// EnrollTOTP stores the secret of a new authenticator app, replacing an enrollment which was
+// never verified. It returns ErrMFAAlreadyEnabled if the user already has MFA enabled.
+func (m MFAModel) EnrollTOTP(userID int64, secret []byte) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW(), updated_at = NOW()
+		WHERE user_totp.enabled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// EnableTOTP enables the enrolled authenticator app of a user once a code from it was verified,
+// and replaces the recovery codes of the user
+func (m MFAModel) EnableTOTP(userID int64, step int64, recoveryCodeHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE user_totp
+		SET enabled_at = NOW(), last_used_step = $2, updated_at = NOW()
+		WHERE user_id = $1 AND enabled_at IS NULL`
+
+	result, err := tx.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep records that the code of a time step was used to log in. It reports false if a
+// code of that or a later step was used already, so that an intercepted code cannot be replayed.
+func (m MFAModel) UseTOTPStep(userID int64, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2, updated_at = NOW()
+		WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode marks a recovery code of a user as used. It reports false if the code does not
+// exist or was used already.
+func (m MFAModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user
+func (m MFAModel) CountRecoveryCodes(userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM mfa_recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of a user who has MFA enabled
+func (m MFAModel) ReplaceRecoveryCodes(userID int64, hashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = replaceRecoveryCodes(ctx, tx, userID, hashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, hashes [][]byte) error {
+	query := `
+		DELETE FROM mfa_recovery_codes
+		WHERE user_id = $1`
+
+	_, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO mfa_recovery_codes (user_id, hash)
+		SELECT $1, UNNEST($2::bytea[])`
+
+	_, err = tx.Exec(ctx, query, userID, hashes)
+	return err
+}
+
+// Disable removes the authenticator app and the recovery codes of a user
+func (m MFAModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		DELETE FROM user_totp
+		WHERE user_id = $1`
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM mfa_recovery_codes
+		WHERE user_id = $1`
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -13,18 +13,40 @@ var (
 )
 
 type Models struct {
-	Users       UserModel
-	Tokens      TokenModel
-	Sessions    SessionModel
-	Permissions PermissionModel
-	Feeds       FeedModel
-	FeedFollows FeedFollowModel
-	Items       ItemModel
-	Walls       WallModel
-	WallFeeds   WallFeedModel
-	SavedItems  SavedItemModel
-	LikedItems  LikedItemModel
-	Topics      TopicModel
+	Users                     UserModel
+	Tokens                    TokenModel
+	Sessions                  SessionModel
+	Permissions               PermissionModel
+	Feeds                     FeedModel
+	FeedFollows               FeedFollowModel
+	Items                     ItemModel
+	Walls                     WallModel
+	WallFeeds                 WallFeedModel
+	WallMembers               WallMemberModel
+	WallInvitations           WallInvitationModel
+	WallFolders               WallFolderModel
+	SavedItems                SavedItemModel
+	LikedItems                LikedItemModel
+	ReadItems                 ReadItemModel
+	Topics                    TopicModel
+	Affinities                AffinityModel
+	FeedRecommendations       FeedRecommendationModel
+	Batches                   BatchModel
+	UserChanges               UserChangeModel
+	NotificationSettings      NotificationSettingsModel
+	NotificationSubscriptions NotificationSubscriptionModel
+	NotificationJobs          NotificationJobModel
+	PushDevices               PushDeviceModel
+	DigestSubscriptions       DigestSubscriptionModel
+	DigestSends               DigestSendModel
+	WebhookEndpoints          WebhookEndpointModel
+	WebhookDeliveries         WebhookDeliveryModel
+	EmailOutbox               EmailOutboxModel
+	MFA                       MFAModel
+	Passkeys                  PasskeyModel
+	UserIdentities            UserIdentityModel
+	UserSessions              UserSessionModel
+	APITokens                 APITokenModel
 }
 
 func NewModels(db *pgxpool.Pool) Models {
@@ -38,8 +60,30 @@ func NewModels(db *pgxpool.Pool) Models {
 		ItemModel{DB: db},
 		WallModel{DB: db},
 		WallFeedModel{DB: db},
+		WallMemberModel{DB: db},
+		WallInvitationModel{DB: db},
+		WallFolderModel{DB: db},
 		SavedItemModel{DB: db},
 		LikedItemModel{DB: db},
+		ReadItemModel{DB: db},
 		TopicModel{DB: db},
+		AffinityModel{DB: db},
+		FeedRecommendationModel{DB: db},
+		BatchModel{DB: db},
+		UserChangeModel{DB: db},
+		NotificationSettingsModel{DB: db},
+		NotificationSubscriptionModel{DB: db},
+		NotificationJobModel{DB: db},
+		PushDeviceModel{DB: db},
+		DigestSubscriptionModel{DB: db},
+		DigestSendModel{DB: db},
+		WebhookEndpointModel{DB: db},
+		WebhookDeliveryModel{DB: db},
+		EmailOutboxModel{DB: db},
+		MFAModel{DB: db},
+		PasskeyModel{DB: db},
+		UserIdentityModel{DB: db},
+		UserSessionModel{DB: db},
+		APITokenModel{DB: db},
 	}
 }
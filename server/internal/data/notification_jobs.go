@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	NotificationJobStatusPending = "pending"
+	NotificationJobStatusSent    = "sent"
+	NotificationJobStatusSkipped = "skipped"
+	NotificationJobStatusFailed  = "failed"
+)
+
+// NotificationJob is the delivery of a new item to a user through one channel
+type NotificationJob struct {
+	ID       int64
+	UserID   int64
+	Channel  string
+	Attempts int
+	Item     *Item
+	User     *User
+}
+
+type NotificationJobModel struct {
+	DB *pgxpool.Pool
+}
+
+// EnqueueForItems creates the notification jobs for new items, for every subscription the
+// items match and every channel of the subscribed user. Email jobs of a user are scheduled
+// together, emailDigestDelay after the first one, so that they are sent as a single digest.
+// The number of jobs created is returned.
+func (m NotificationJobModel) EnqueueForItems(itemIDs []int64, emailDigestDelay time.Duration) (int64, error) {
+	query := `
+		WITH matches AS (
+			SELECT DISTINCT ns.user_id, i.id AS item_id
+			FROM items i
+			INNER JOIN notification_subscriptions ns ON (
+				ns.feed_id = i.feed_id
+				OR (ns.feed_id IS NULL AND EXISTS (
+					SELECT 1 FROM feed_follows ff
+					WHERE ff.user_id = ns.user_id AND ff.feed_id = i.feed_id
+				))
+			)
+			INNER JOIN users u ON u.id = ns.user_id
+			WHERE i.id = ANY($1)
+			AND u.deletion_scheduled_at IS NULL
+			AND (
+				CARDINALITY(ns.keywords) = 0
+				OR EXISTS (
+					SELECT 1 FROM UNNEST(ns.keywords) AS k(keyword)
+					WHERE STRPOS(LOWER(i.title || ' ' || i.description), k.keyword) > 0
+				)
+			)
+		)
+		INSERT INTO notification_jobs (user_id, item_id, channel, run_at)
+		SELECT m.user_id, m.item_id, c.channel,
+			CASE WHEN c.channel = 'email' THEN COALESCE(
+				(SELECT MIN(j.run_at) FROM notification_jobs j
+				WHERE j.user_id = m.user_id AND j.channel = 'email' AND j.status = 'pending'),
+				NOW() + $2::double precision * INTERVAL '1 second'
+			) ELSE NOW() END
+		FROM matches m
+		LEFT JOIN notification_settings s ON s.user_id = m.user_id
+		CROSS JOIN LATERAL UNNEST(COALESCE(s.channels, '{push}'::text[])) AS c(channel)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, itemIDs, emailDigestDelay.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// ClaimDue claims up to limit pending jobs which are due, ordered by user and channel. Claimed
+// jobs are leased by moving them into the future, so a job whose worker went away is picked up
+// again once the lease runs out. Each claim counts as an attempt.
+func (m NotificationJobModel) ClaimDue(limit int, lease time.Duration) ([]*NotificationJob, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE notification_jobs j
+			SET run_at = NOW() + $2::double precision * INTERVAL '1 second', attempts = j.attempts + 1
+			FROM (
+				SELECT id FROM notification_jobs
+				WHERE status = 'pending' AND run_at <= NOW()
+				ORDER BY run_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			) due
+			WHERE j.id = due.id
+			RETURNING j.id, j.user_id, j.item_id, j.channel, j.attempts
+		)
+		SELECT c.id, c.user_id, c.channel, c.attempts, i.id, i.title, i.description, i.link, i.pub_date,
+			f.id, f.display_title, f.title, f.link, f.image_url, u.email, u.full_name, u.username
+		FROM claimed c
+		INNER JOIN items i ON i.id = c.item_id
+		INNER JOIN feeds f ON f.id = i.feed_id
+		INNER JOIN users u ON u.id = c.user_id
+		ORDER BY c.user_id, c.channel, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*NotificationJob, error) {
+		job := &NotificationJob{Item: &Item{Feed: &Feed{}}, User: &User{}}
+		err := row.Scan(
+			&job.ID,
+			&job.UserID,
+			&job.Channel,
+			&job.Attempts,
+			&job.Item.ID,
+			&job.Item.Title,
+			&job.Item.Description,
+			&job.Item.Link,
+			&job.Item.PubDate,
+			&job.Item.Feed.ID,
+			&job.Item.Feed.DisplayTitle,
+			&job.Item.Feed.Title,
+			&job.Item.Feed.Link,
+			&job.Item.Feed.ImageURL,
+			&job.User.Email,
+			&job.User.FullName,
+			&job.User.Username,
+		)
+		job.Item.FeedID = job.Item.Feed.ID
+		job.User.ID = job.UserID
+		return job, err
+	})
+}
+
+// CountSentSince returns the number of notifications sent to a user through a channel since
+// the given time
+func (m NotificationJobModel) CountSentSince(userID int64, channel string, since time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM notification_jobs
+		WHERE user_id = $1 AND channel = $2 AND status = 'sent' AND sent_at >= $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRow(ctx, query, userID, channel, since).Scan(&count)
+	return count, err
+}
+
+func (m NotificationJobModel) MarkSent(jobIDs []int64) error {
+	query := `
+		UPDATE notification_jobs
+		SET status = 'sent', sent_at = NOW(), last_error = NULL
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, jobIDs)
+	return err
+}
+
+// MarkSkipped marks jobs which will not be delivered, e.g. because of rate limits
+func (m NotificationJobModel) MarkSkipped(jobIDs []int64, reason string) error {
+	query := `
+		UPDATE notification_jobs
+		SET status = 'skipped', last_error = $2
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, jobIDs, reason)
+	return err
+}
+
+// Defer moves jobs to a later time without counting their claim as an attempt
+func (m NotificationJobModel) Defer(jobIDs []int64, until time.Time) error {
+	query := `
+		UPDATE notification_jobs
+		SET run_at = $2, attempts = GREATEST(attempts - 1, 0)
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, jobIDs, until)
+	return err
+}
+
+// Retry schedules failed jobs again with exponential backoff. Jobs which reached maxAttempts
+// are marked as failed.
+func (m NotificationJobModel) Retry(jobIDs []int64, lastError string, maxAttempts int, backoff time.Duration) error {
+	query := `
+		UPDATE notification_jobs
+		SET status = CASE WHEN attempts >= $3 THEN 'failed' ELSE 'pending' END,
+			run_at = NOW() + $4::double precision * POWER(2, attempts - 1) * INTERVAL '1 second',
+			last_error = $2
+		WHERE id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, jobIDs, lastError, maxAttempts, backoff.Seconds())
+	return err
+}
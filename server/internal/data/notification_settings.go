@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	NotificationChannelPush    = "push"
+	NotificationChannelEmail   = "email"
+	NotificationChannelWebhook = "webhook"
+)
+
+var NotificationChannels = []string{NotificationChannelPush, NotificationChannelEmail, NotificationChannelWebhook}
+
+var timeOfDayRX = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// NotificationSettings are the preferences of a user for the delivery of notifications. Quiet
+// hours are times of the day ("22:00") in the timezone of the user, and may wrap around midnight.
+type NotificationSettings struct {
+	UserID          int64       `json:"-"`
+	Channels        []string    `json:"channels"`
+	QuietHoursStart pgtype.Text `json:"quiet_hours_start"`
+	QuietHoursEnd   pgtype.Text `json:"quiet_hours_end"`
+	Timezone        string      `json:"timezone"`
+	MaxPerHour      int         `json:"max_per_hour"`
+	WebhookURL      pgtype.Text `json:"webhook_url"`
+	UpdatedAt       *time.Time  `json:"updated_at,omitempty"`
+}
+
+type NotificationSettingsModel struct {
+	DB *pgxpool.Pool
+}
+
+// DefaultNotificationSettings returns the settings of a user who has not changed them
+func DefaultNotificationSettings(userID int64) *NotificationSettings {
+	return &NotificationSettings{
+		UserID:     userID,
+		Channels:   []string{NotificationChannelPush},
+		Timezone:   "UTC",
+		MaxPerHour: 10,
+	}
+}
+
+func ValidateNotificationSettings(v *validator.Validator, settings *NotificationSettings) {
+	v.Check(validator.Unique(settings.Channels), "channels", "Must not contain duplicate channels")
+	for _, channel := range settings.Channels {
+		v.Check(validator.PermittedValue(channel, NotificationChannels...), "channels", "Channels must be one of push, email or webhook")
+	}
+
+	v.Check(settings.QuietHoursStart.Valid == settings.QuietHoursEnd.Valid, "quiet_hours", "Both the start and the end of quiet hours must be provided")
+	if settings.QuietHoursStart.Valid {
+		v.Check(validator.Matches(settings.QuietHoursStart.String, timeOfDayRX), "quiet_hours_start", "Must be a time of the day in the format HH:MM")
+	}
+	if settings.QuietHoursEnd.Valid {
+		v.Check(validator.Matches(settings.QuietHoursEnd.String, timeOfDayRX), "quiet_hours_end", "Must be a time of the day in the format HH:MM")
+	}
+
+	_, err := time.LoadLocation(settings.Timezone)
+	v.Check(validator.NotBlank(settings.Timezone) && err == nil, "timezone", "Must be a valid IANA timezone")
+
+	v.Check(settings.MaxPerHour >= 1, "max_per_hour", "Must be greater than zero")
+	v.Check(settings.MaxPerHour <= 100, "max_per_hour", "Must not be more than 100")
+
+	if settings.WebhookURL.Valid {
+		webhookURL, err := url.Parse(settings.WebhookURL.String)
+		v.Check(err == nil && webhookURL.Scheme == "https" && webhookURL.Host != "", "webhook_url", "Must be a valid https URL")
+		v.Check(validator.MaxChars(settings.WebhookURL.String, 2048), "webhook_url", "Must not be more than 2048 characters long")
+	}
+	if validator.PermittedValue(NotificationChannelWebhook, settings.Channels...) {
+		v.Check(settings.WebhookURL.Valid, "webhook_url", "Must be provided to receive notifications by webhook")
+	}
+}
+
+// QuietUntil reports whether now falls in the quiet hours of the user and returns the time
+// the quiet hours end
+func (s *NotificationSettings) QuietUntil(now time.Time) (time.Time, bool) {
+	if !s.QuietHoursStart.Valid || !s.QuietHoursEnd.Valid {
+		return time.Time{}, false
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, err := time.Parse("15:04", s.QuietHoursStart.String)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse("15:04", s.QuietHoursEnd.String)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	var quiet bool
+	switch {
+	case startMinute < endMinute:
+		quiet = minute >= startMinute && minute < endMinute
+	case startMinute > endMinute:
+		// Quiet hours wrap around midnight
+		quiet = minute >= startMinute || minute < endMinute
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	until := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+	if !until.After(local) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, true
+}
+
+// Get returns the notification settings of a user. The default settings are returned if the
+// user has not changed them.
+func (m NotificationSettingsModel) Get(userID int64) (*NotificationSettings, error) {
+	query := `
+		SELECT channels, quiet_hours_start, quiet_hours_end, timezone, max_per_hour, webhook_url, updated_at
+		FROM notification_settings
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	settings := &NotificationSettings{UserID: userID}
+	err := m.DB.QueryRow(ctx, query, userID).Scan(
+		&settings.Channels,
+		&settings.QuietHoursStart,
+		&settings.QuietHoursEnd,
+		&settings.Timezone,
+		&settings.MaxPerHour,
+		&settings.WebhookURL,
+		&settings.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return DefaultNotificationSettings(userID), nil
+		default:
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+func (m NotificationSettingsModel) Upsert(settings *NotificationSettings) error {
+	query := `
+		INSERT INTO notification_settings (user_id, channels, quiet_hours_start, quiet_hours_end, timezone, max_per_hour, webhook_url)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE
+		SET channels = EXCLUDED.channels,
+			quiet_hours_start = EXCLUDED.quiet_hours_start,
+			quiet_hours_end = EXCLUDED.quiet_hours_end,
+			timezone = EXCLUDED.timezone,
+			max_per_hour = EXCLUDED.max_per_hour,
+			webhook_url = EXCLUDED.webhook_url,
+			updated_at = NOW()
+		RETURNING updated_at`
+
+	args := []any{
+		settings.UserID,
+		settings.Channels,
+		settings.QuietHoursStart,
+		settings.QuietHoursEnd,
+		settings.Timezone,
+		settings.MaxPerHour,
+		settings.WebhookURL,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&settings.UpdatedAt)
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NotificationSubscription opts a user in to notifications for the new items of a feed, for the
+// new items matching keywords in the feeds the user follows, or for the new items of a feed
+// matching keywords
+type NotificationSubscription struct {
+	ID        int64       `json:"id"`
+	UserID    int64       `json:"-"`
+	FeedID    pgtype.Int8 `json:"feed_id"`
+	Keywords  []string    `json:"keywords"`
+	CreatedAt *time.Time  `json:"created_at,omitempty"`
+}
+
+type NotificationSubscriptionModel struct {
+	DB *pgxpool.Pool
+}
+
+// Normalize lowercases and trims the keywords so that they match case insensitively
+func (s *NotificationSubscription) Normalize() {
+	keywords := make([]string, 0, len(s.Keywords))
+	for _, keyword := range s.Keywords {
+		keywords = append(keywords, strings.ToLower(strings.TrimSpace(keyword)))
+	}
+	s.Keywords = keywords
+}
+
+func ValidateNotificationSubscription(v *validator.Validator, subscription *NotificationSubscription) {
+	v.Check(subscription.FeedID.Valid || len(subscription.Keywords) > 0, "feed_id", "A feed or keywords must be provided")
+	if subscription.FeedID.Valid {
+		v.Check(subscription.FeedID.Int64 > 0, "feed_id", "Must be a valid feed id")
+	}
+
+	v.Check(len(subscription.Keywords) <= 20, "keywords", "Must not contain more than 20 values")
+	v.Check(validator.Unique(subscription.Keywords), "keywords", "Must not contain duplicate values")
+	for _, keyword := range subscription.Keywords {
+		v.Check(validator.NotBlank(keyword), "keywords", "Must not contain blank values")
+		v.Check(validator.MaxChars(keyword, 100), "keywords", "Values must not be more than 100 characters long")
+	}
+}
+
+// Insert creates a subscription. ErrRecordNotFound is returned if the feed does not exist.
+func (m NotificationSubscriptionModel) Insert(subscription *NotificationSubscription) error {
+	query := `
+		INSERT INTO notification_subscriptions (user_id, feed_id, keywords)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, subscription.UserID, subscription.FeedID, subscription.Keywords).Scan(
+		&subscription.ID,
+		&subscription.CreatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == strconv.Itoa(23503) && strings.Contains(pgErr.ConstraintName, "notification_subscriptions_feed_id_fkey") {
+				return ErrRecordNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m NotificationSubscriptionModel) FindAllForUser(userID int64) ([]*NotificationSubscription, error) {
+	query := `
+		SELECT id, feed_id, keywords, created_at
+		FROM notification_subscriptions
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*NotificationSubscription, error) {
+		subscription := &NotificationSubscription{UserID: userID}
+		err := row.Scan(
+			&subscription.ID,
+			&subscription.FeedID,
+			&subscription.Keywords,
+			&subscription.CreatedAt,
+		)
+		return subscription, err
+	})
+}
+
+func (m NotificationSubscriptionModel) Delete(subscriptionID, userID int64) error {
+	query := `
+		DELETE FROM notification_subscriptions
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, subscriptionID, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
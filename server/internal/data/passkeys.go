@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+var ErrDuplicatePasskey = errors.New("passkey already registered")
+
+// Passkey is a WebAuthn credential a user can log in with instead of a password
+type Passkey struct {
+	ID             int64              `json:"id"`
+	UserID         int64              `json:"-"`
+	CredentialID   []byte             `json:"-"`
+	PublicKey      []byte             `json:"-"`
+	SignCount      uint32             `json:"-"`
+	AAGUID         []byte             `json:"-"`
+	Transports     []string           `json:"transports"`
+	BackupEligible bool               `json:"backup_eligible"`
+	Name           string             `json:"name"`
+	LastUsedAt     pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt      time.Time          `json:"created_at"`
+}
+
+func ValidatePasskeyName(v *validator.Validator, name string) {
+	v.Check(validator.NotBlank(name), "name", "Name must be provided")
+	v.Check(validator.MaxChars(name, 64), "name", "Name must not be more than 64 characters long")
+}
+
+type PasskeyModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m PasskeyModel) Insert(passkey *Passkey) error {
+	query := `
+		INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, aaguid, transports, backup_eligible, name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	if passkey.Transports == nil {
+		passkey.Transports = []string{}
+	}
+
+	args := []any{
+		passkey.UserID,
+		passkey.CredentialID,
+		passkey.PublicKey,
+		int64(passkey.SignCount),
+		passkey.AAGUID,
+		passkey.Transports,
+		passkey.BackupEligible,
+		passkey.Name,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&passkey.ID, &passkey.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == strconv.Itoa(23505) && strings.Contains(pgErr.ConstraintName, "passkeys_credential_id_key") {
+				return ErrDuplicatePasskey
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m PasskeyModel) GetByCredentialID(credentialID []byte) (*Passkey, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, sign_count, aaguid, transports, backup_eligible, name,
+			last_used_at, created_at
+		FROM passkeys
+		WHERE credential_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey, err := pgx.CollectOneRow(rows, scanPasskey)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return passkey, nil
+}
+
+func (m PasskeyModel) FindAllForUser(userID int64) ([]*Passkey, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, sign_count, aaguid, transports, backup_eligible, name,
+			last_used_at, created_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanPasskey)
+}
+
+func scanPasskey(row pgx.CollectableRow) (*Passkey, error) {
+	var passkey Passkey
+	var signCount int64
+	err := row.Scan(
+		&passkey.ID,
+		&passkey.UserID,
+		&passkey.CredentialID,
+		&passkey.PublicKey,
+		&signCount,
+		&passkey.AAGUID,
+		&passkey.Transports,
+		&passkey.BackupEligible,
+		&passkey.Name,
+		&passkey.LastUsedAt,
+		&passkey.CreatedAt,
+	)
+	passkey.SignCount = uint32(signCount)
+	return &passkey, err
+}
+
+// UpdateAfterLogin records the new sign count of a passkey which was used to log in
+func (m PasskeyModel) UpdateAfterLogin(id int64, signCount uint32) error {
+	query := `
+		UPDATE passkeys
+		SET sign_count = $2, last_used_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, id, int64(signCount))
+	return err
+}
+
+func (m PasskeyModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM passkeys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// InsertChallenge stores the challenge of a WebAuthn ceremony until it is consumed or expires.
+// Registration challenges belong to the user registering a passkey, login challenges to no one
+// (userID 0) since the user is only known from the passkey.
+func (m PasskeyModel) InsertChallenge(challenge []byte, userID int64, ceremony string, ttl time.Duration) error {
+	query := `
+		INSERT INTO webauthn_challenges (hash, user_id, ceremony, expiry)
+		VALUES ($1, NULLIF($2, 0), $3, $4)`
+
+	hash := sha256.Sum256(challenge)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, hash[:], userID, ceremony, time.Now().Add(ttl))
+	return err
+}
+
+// ConsumeChallenge deletes the challenge of a ceremony and returns the user it belongs to, so
+// that every challenge is used at most once. It returns ErrRecordNotFound if the challenge does
+// not exist, was used already or has expired.
+func (m PasskeyModel) ConsumeChallenge(challenge []byte, ceremony string) (int64, error) {
+	query := `
+		DELETE FROM webauthn_challenges
+		WHERE hash = $1 AND ceremony = $2 AND expiry > NOW()
+		RETURNING COALESCE(user_id, 0)`
+
+	hash := sha256.Sum256(challenge)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := m.DB.QueryRow(ctx, query, hash[:], ceremony).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (m PasskeyModel) DeleteExpiredChallenges() error {
+	query := `
+		DELETE FROM webauthn_challenges
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query)
+	return err
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PushDevice is a device of a user registered for push notifications with its FCM token
+type PushDevice struct {
+	Token     string     `json:"token"`
+	UserID    int64      `json:"-"`
+	Platform  string     `json:"platform"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type PushDeviceModel struct {
+	DB *pgxpool.Pool
+}
+
+func ValidatePushDevice(v *validator.Validator, device *PushDevice) {
+	v.Check(validator.NotBlank(device.Token), "token", "Token must be provided")
+	v.Check(validator.MaxBytes(device.Token, 4096), "token", "Token must not be more than 4096 bytes long")
+	v.Check(validator.PermittedValue(device.Platform, "android", "ios", "web"), "platform", "Platform must be one of android, ios or web")
+}
+
+// Upsert registers a device for a user. A token registered by another user is moved to the
+// user, since tokens belong to an app installation and not to an account.
+func (m PushDeviceModel) Upsert(device *PushDevice) error {
+	query := `
+		INSERT INTO push_devices (token, user_id, platform)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE
+		SET user_id = EXCLUDED.user_id, platform = EXCLUDED.platform, updated_at = NOW()
+		RETURNING created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, device.Token, device.UserID, device.Platform).Scan(&device.CreatedAt, &device.UpdatedAt)
+}
+
+func (m PushDeviceModel) FindTokensForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT token FROM push_devices
+		WHERE user_id = $1
+		ORDER BY updated_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (m PushDeviceModel) Delete(token string, userID int64) error {
+	query := `
+		DELETE FROM push_devices
+		WHERE token = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, token, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteByToken removes a token which FCM reported as no longer valid
+func (m PushDeviceModel) DeleteByToken(token string) error {
+	query := `
+		DELETE FROM push_devices
+		WHERE token = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, token)
+	return err
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ReadItemModel struct {
+	DB *pgxpool.Pool
+}
+
+// Insert marks an item as read by a user. Marking an item which is already read does nothing.
+func (m ReadItemModel) Insert(userID, itemID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = insertReadItem(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Delete marks an item as unread by a user
+func (m ReadItemModel) Delete(userID, itemID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = deleteReadItem(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func insertReadItem(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
+	query := `
+		INSERT INTO read_items (user_id, item_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	result, err := tx.Exec(ctx, query, userID, itemID)
+	if err != nil || result.RowsAffected() == 0 {
+		return err
+	}
+
+	return recordUserChange(ctx, tx, userID, ChangeEntityReadItem, itemID, ChangeOpUpsert)
+}
+
+func deleteReadItem(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
+	query := `
+		DELETE FROM read_items
+		WHERE user_id = $1 AND item_id = $2`
+
+	result, err := tx.Exec(ctx, query, userID, itemID)
+	if err != nil || result.RowsAffected() == 0 {
+		return err
+	}
+
+	return recordUserChange(ctx, tx, userID, ChangeEntityReadItem, itemID, ChangeOpDelete)
+}
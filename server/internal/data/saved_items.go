@@ -21,41 +21,94 @@ type SavedItemModel struct {
 }
 
 func (m SavedItemModel) Insert(userID, itemID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = insertSavedItem(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (m SavedItemModel) Delete(userID, itemID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = deleteSavedItem(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// insertSavedItem saves an item for a user within a transaction and updates the save count
+// of the item. Saving an item which is already saved does nothing.
+func insertSavedItem(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
 	query := `
 		INSERT INTO saved_items (user_id, item_id)
 		VALUES ($1, $2)
 		ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	result, err := tx.Exec(ctx, query, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	// The item was already saved
+	if result.RowsAffected() == 0 {
+		return nil
+	}
 
-	_, err := m.DB.Exec(ctx, query, userID, itemID)
+	err = updateItemEngagementCounts(ctx, tx, itemID, 0, 1)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = enqueueWebhookItemSaved(ctx, tx, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	return recordUserChange(ctx, tx, userID, ChangeEntitySavedItem, itemID, ChangeOpUpsert)
 }
 
-func (m SavedItemModel) Delete(userID, itemID int64) error {
+// deleteSavedItem unsaves an item for a user within a transaction and updates the save count
+// of the item. ErrRecordNotFound is returned if the item was not saved.
+func deleteSavedItem(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
 	query := `
 		DELETE FROM saved_items
 		WHERE user_id = $1 AND item_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	result, err := m.DB.Exec(ctx, query, userID, itemID)
+	result, err := tx.Exec(ctx, query, userID, itemID)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
+	if result.RowsAffected() == 0 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = updateItemEngagementCounts(ctx, tx, itemID, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	return recordUserChange(ctx, tx, userID, ChangeEntitySavedItem, itemID, ChangeOpDelete)
 }
 
 func (m SavedItemModel) GetAllForUser(userID int64, title string, filters Filters) ([]*SavedItem, Metadata, error) {
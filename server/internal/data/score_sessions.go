@@ -0,0 +1,106 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// ScoreSession is a pagination session over a snapshot of item scores. The snapshot is
+// loaded in chunks, so a session can be paginated beyond the first chunk while keeping the
+// order of the items that were already served stable.
+type ScoreSession struct {
+	ID        string
+	WallID    int64
+	UserID    int64
+	SortMode  SortMode
+	Scores    []*ItemScore
+	Exhausted bool
+	ExpiresAt time.Time
+}
+
+// NewScoreSession creates a session with a random ID which is used by clients
+// to continue paginating the session
+func NewScoreSession(wallID, userID int64, sortMode SortMode, ttl time.Duration) (*ScoreSession, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScoreSession{
+		ID:        base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		WallID:    wallID,
+		UserID:    userID,
+		SortMode:  sortMode,
+		Scores:    []*ItemScore{},
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// Remaining returns the number of loaded scores that come after the cursor
+func (s *ScoreSession) Remaining(after string) (int, error) {
+	start, err := findScoreIndexAfter(s.Scores, after)
+	if err != nil {
+		return 0, err
+	}
+	return len(s.Scores) - start, nil
+}
+
+// Last returns the lowest ranked score loaded into the session, or nil if the session is empty
+func (s *ScoreSession) Last() *ItemScore {
+	if len(s.Scores) == 0 {
+		return nil
+	}
+	return s.Scores[len(s.Scores)-1]
+}
+
+// Extend appends the scores which rank after the last loaded score and are not in the session yet.
+// Scores which would rank before the last loaded score are dropped to keep the served order stable.
+func (s *ScoreSession) Extend(itemScores []*ItemScore) {
+	loaded := make(map[int64]bool, len(s.Scores))
+	for _, itemScore := range s.Scores {
+		loaded[itemScore.ItemID] = true
+	}
+
+	for _, itemScore := range itemScores {
+		if loaded[itemScore.ItemID] {
+			continue
+		}
+		if last := s.Last(); last != nil && !ranksAfter(itemScore, last.Score, last.ItemID) {
+			continue
+		}
+		s.Scores = append(s.Scores, itemScore)
+		loaded[itemScore.ItemID] = true
+	}
+}
+
+// ranksAfter reports whether an item score comes after the given score and item ID
+// when sorted by score and item ID in descending order
+func ranksAfter(itemScore *ItemScore, score float64, itemID int64) bool {
+	return itemScore.Score < score || (itemScore.Score == score && itemScore.ItemID < itemID)
+}
+
+// findScoreIndexAfter returns the index of the first item score after the cursor using
+// binary search. itemScores must be sorted by score and item ID in descending order.
+func findScoreIndexAfter(itemScores []*ItemScore, after string) (int, error) {
+	if after == "" {
+		return 0, nil
+	}
+
+	var cursor sortByScoreCursor
+	err := decodeCursor(after, &cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return sort.Search(len(itemScores), func(i int) bool {
+		return ranksAfter(itemScores[i], cursor.Score, cursor.ID)
+	}), nil
+}
@@ -11,9 +11,11 @@ import (
 )
 
 type Session struct {
-	IsAdmin     bool
-	User        *User
-	Token       *Token
+	IsAdmin bool
+	User    *User
+	Token   *Token
+	// APIToken is set instead of Token if the request was authenticated with an API token
+	APIToken    *APIToken
 	Permissions Permissions
 }
 
@@ -27,7 +29,8 @@ func (m SessionModel) GetForToken(tokenPlaintext string) (*Session, error) {
 	query := `
 		SELECT users.id, users.created_at, users.updated_at, users.full_name, users.username,
 		users.email, users.profile_image_url, users.password_hash, users.activated, users.last_login_at,
-		users.version, tokens.hash, tokens.scope, tokens.expiry, tokens.created_at
+		users.version, users.deletion_scheduled_at, tokens.hash, tokens.scope, tokens.expiry, tokens.created_at,
+		COALESCE(tokens.session_id, 0)
 		FROM users
 		INNER JOIN tokens ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
@@ -53,10 +56,12 @@ func (m SessionModel) GetForToken(tokenPlaintext string) (*Session, error) {
 		&user.Activated,
 		&user.LastLoginAt,
 		&user.Version,
+		&user.DeletionScheduledAt,
 		&token.Hash,
 		&token.Scope,
 		&token.Expiry,
 		&token.CreatedAt,
+		&token.SessionID,
 	)
 	session.User = &user
 	session.Token = &token
@@ -71,3 +76,56 @@ func (m SessionModel) GetForToken(tokenPlaintext string) (*Session, error) {
 
 	return &session, nil
 }
+
+// GetForAPIToken returns the session of the user an unexpired API token belongs to
+func (m SessionModel) GetForAPIToken(tokenPlaintext string) (*Session, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.updated_at, users.full_name, users.username,
+		users.email, users.profile_image_url, users.password_hash, users.activated, users.last_login_at,
+		users.version, users.deletion_scheduled_at, api_tokens.id, api_tokens.name, api_tokens.hash,
+		api_tokens.scopes, api_tokens.expiry, api_tokens.last_used_at, api_tokens.created_at
+		FROM users
+		INNER JOIN api_tokens ON users.id = api_tokens.user_id
+		WHERE api_tokens.hash = $1
+		AND (api_tokens.expiry IS NULL OR api_tokens.expiry > $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	var token APIToken
+	err := m.DB.QueryRow(ctx, query, tokenHash[:], time.Now()).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.FullName,
+		&user.Username,
+		&user.Email,
+		&user.ProfileImageURL,
+		&user.Password.hash,
+		&user.Activated,
+		&user.LastLoginAt,
+		&user.Version,
+		&user.DeletionScheduledAt,
+		&token.ID,
+		&token.Name,
+		&token.Hash,
+		&token.Scopes,
+		&token.Expiry,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	token.UserID = user.ID
+
+	return &Session{User: &user, APIToken: &token}, nil
+}
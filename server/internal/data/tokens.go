@@ -16,12 +16,20 @@ const (
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
 	ScopeRefresh        = "refresh"
+	ScopeFeeds          = "feeds"
+	// ScopeMFAPending tokens are issued on login to users with MFA enabled, and are exchanged for
+	// the authentication and refresh tokens once the second factor is verified
+	ScopeMFAPending = "mfa-pending"
+	// ScopeUnlock tokens are sent to users whose account was locked out after too many failed
+	// logins, to unlock it
+	ScopeUnlock = "unlock"
 )
 
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
+	SessionID int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
 	CreatedAt time.Time `json:"-"`
@@ -85,6 +93,42 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewWithEmail creates a token and queues the email which delivers it in the same transaction,
+// so that the email is not lost if sending fails or the server restarts. The email is built
+// from the new token since it usually contains the plaintext.
+func (m TokenModel) NewWithEmail(userID int64, ttl time.Duration, scope string, email func(token *Token) *OutboxEmail) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+
+	err = tx.QueryRow(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope).Scan(&token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertOutboxEmail(ctx, tx, email(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit(ctx)
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
         INSERT INTO tokens (hash, user_id, expiry, scope) 
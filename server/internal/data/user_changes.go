@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Entities whose changes are recorded in the change log of a user
+const (
+	ChangeEntitySavedItem  = "saved_item"
+	ChangeEntityLikedItem  = "liked_item"
+	ChangeEntityReadItem   = "read_item"
+	ChangeEntityFeedFollow = "feed_follow"
+	ChangeEntityWall       = "wall"
+	ChangeEntityWallFolder = "wall_folder"
+
+	ChangeOpUpsert = "upsert"
+	ChangeOpDelete = "delete"
+)
+
+var (
+	ErrSyncTokenExpired = errors.New("sync token has expired")
+)
+
+// UserChange is an entry in the change log of a user. Upserts tell the client to fetch or update
+// the entity and deletes are tombstones for entities which are gone.
+type UserChange struct {
+	Seq       int64     `json:"seq"`
+	Entity    string    `json:"entity"`
+	EntityID  int64     `json:"id"`
+	Op        string    `json:"op"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// SyncToken marks the position of a client in the change log of its user and in the
+// items of the feeds the user follows
+type SyncToken struct {
+	Seq            int64
+	ItemsUpdatedAt time.Time
+	ItemID         int64
+}
+
+func (t SyncToken) Encode() string {
+	return encodeCursor(t)
+}
+
+func DecodeSyncToken(s string) (SyncToken, error) {
+	var token SyncToken
+	err := decodeCursor(s, &token)
+	return token, err
+}
+
+type UserChangeModel struct {
+	DB *pgxpool.Pool
+}
+
+// recordUserChanges appends a change to the change logs of the given users. It must be called in the
+// transaction which makes the change. The sequence row of each user stays locked until the transaction
+// ends, so the changes of a user become visible in the order of their sequence numbers.
+func recordUserChanges(ctx context.Context, tx pgx.Tx, userIDs []int64, entity string, entityID int64, op string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		WITH seqs AS (
+			INSERT INTO user_change_sequences (user_id, seq)
+			SELECT user_id, 1
+			FROM UNNEST($1::bigint[]) AS user_id
+			ORDER BY user_id
+			ON CONFLICT (user_id) DO UPDATE
+			SET seq = user_change_sequences.seq + 1
+			RETURNING user_id, seq
+		)
+		INSERT INTO user_changes (user_id, seq, entity, entity_id, op)
+		SELECT user_id, seq, $2, $3, $4
+		FROM seqs`
+
+	_, err := tx.Exec(ctx, query, userIDs, entity, entityID, op)
+	return err
+}
+
+func recordUserChange(ctx context.Context, tx pgx.Tx, userID int64, entity string, entityID int64, op string) error {
+	return recordUserChanges(ctx, tx, []int64{userID}, entity, entityID, op)
+}
+
+// recordWallChange records a change of a wall for all the members of the wall
+func recordWallChange(ctx context.Context, tx pgx.Tx, wallID int64, op string) error {
+	userIDs, err := findWallMemberIDs(ctx, tx, wallID)
+	if err != nil {
+		return err
+	}
+
+	return recordUserChanges(ctx, tx, userIDs, ChangeEntityWall, wallID, op)
+}
+
+// recordWallChanges records an update of each of the given walls for their members. Walls are
+// recorded in the order of their IDs so that concurrent transactions lock the sequences of
+// shared members in the same order.
+func recordWallChanges(ctx context.Context, tx pgx.Tx, wallIDs map[int64]bool) error {
+	for _, wallID := range slices.Sorted(maps.Keys(wallIDs)) {
+		err := recordWallChange(ctx, tx, wallID, ChangeOpUpsert)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findWallMemberIDs(ctx context.Context, tx pgx.Tx, wallID int64) ([]int64, error) {
+	rows, err := tx.Query(ctx, `SELECT user_id FROM wall_members WHERE wall_id = $1`, wallID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// NewSyncToken returns a token for the current position of a user. Items are synced from
+// settleDelay ago so that items written by transactions still in progress are not skipped.
+func (m UserChangeModel) NewSyncToken(userID int64, settleDelay time.Duration) (SyncToken, error) {
+	query := `
+		SELECT COALESCE((SELECT seq FROM user_change_sequences WHERE user_id = $1), 0),
+			NOW() - $2::double precision * INTERVAL '1 second'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token SyncToken
+	err := m.DB.QueryRow(ctx, query, userID, settleDelay.Seconds()).Scan(&token.Seq, &token.ItemsUpdatedAt)
+	if err != nil {
+		return SyncToken{}, err
+	}
+
+	return token, nil
+}
+
+// FindSince returns the changes of a user after the given sequence number. At most limit entries of the
+// change log are read, and the changes of the same entity are collapsed into the latest one. The sequence
+// number of the last entry read and whether more entries remain are also returned. ErrSyncTokenExpired
+// is returned if entries after seq were already removed from the change log.
+func (m UserChangeModel) FindSince(userID, seq int64, limit int) ([]*UserChange, int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var prunedSeq int64
+	err := m.DB.QueryRow(ctx, `
+		SELECT pruned_seq FROM user_change_sequences
+		WHERE user_id = $1`, userID).Scan(&prunedSeq)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, seq, false, err
+	}
+	if seq < prunedSeq {
+		return nil, seq, false, ErrSyncTokenExpired
+	}
+
+	query := `
+		WITH log AS (
+			SELECT seq, entity, entity_id, op, created_at
+			FROM user_changes
+			WHERE user_id = $1 AND seq > $2
+			ORDER BY seq ASC
+			LIMIT $3
+		),
+		latest AS (
+			SELECT DISTINCT ON (entity, entity_id) seq, entity, entity_id, op, created_at
+			FROM log
+			ORDER BY entity, entity_id, seq DESC
+		)
+		SELECT seq, entity, entity_id, op, created_at,
+			(SELECT MAX(seq) FROM log), (SELECT COUNT(*) FROM log)
+		FROM latest
+		ORDER BY seq ASC`
+
+	rows, err := m.DB.Query(ctx, query, userID, seq, limit)
+	if err != nil {
+		return nil, seq, false, err
+	}
+
+	lastSeq := seq
+	var count int
+	changes, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*UserChange, error) {
+		var change UserChange
+		err := row.Scan(
+			&change.Seq,
+			&change.Entity,
+			&change.EntityID,
+			&change.Op,
+			&change.ChangedAt,
+			&lastSeq,
+			&count,
+		)
+		return &change, err
+	})
+	if err != nil {
+		return nil, seq, false, err
+	}
+
+	return changes, lastSeq, count == limit, nil
+}
+
+// Cleanup removes the changes recorded before the given time. The last removed sequence number of
+// each user is kept so that tokens pointing before it can be detected as expired.
+func (m UserChangeModel) Cleanup(before time.Time) error {
+	query := `
+		WITH deleted AS (
+			DELETE FROM user_changes
+			WHERE created_at < $1
+			RETURNING user_id, seq
+		)
+		UPDATE user_change_sequences s
+		SET pruned_seq = GREATEST(s.pruned_seq, d.max_seq)
+		FROM (
+			SELECT user_id, MAX(seq) AS max_seq
+			FROM deleted
+			GROUP BY user_id
+		) d
+		WHERE s.user_id = d.user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, before)
+	return err
+}
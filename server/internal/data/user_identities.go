@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("identity already linked to a user")
+	ErrProviderLinked    = errors.New("user already has an identity of the provider")
+)
+
+// UserIdentity links an account of an OpenID Connect provider, identified by its subject, to a
+// user who can then log in with it
+type UserIdentity struct {
+	ID          int64              `json:"id"`
+	UserID      int64              `json:"-"`
+	Provider    string             `json:"provider"`
+	Subject     string             `json:"-"`
+	Email       string             `json:"email"`
+	LastLoginAt pgtype.Timestamptz `json:"last_login_at"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+type UserIdentityModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m UserIdentityModel) Insert(identity *UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == strconv.Itoa(23505) {
+			switch {
+			case strings.Contains(pgErr.ConstraintName, "user_identities_provider_subject_key"):
+				return ErrDuplicateIdentity
+			case strings.Contains(pgErr.ConstraintName, "user_identities_user_id_provider_key"):
+				return ErrProviderLinked
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m UserIdentityModel) GetByProviderSubject(provider, subject string) (*UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, last_login_at, created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := pgx.CollectOneRow(rows, scanUserIdentity)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+func (m UserIdentityModel) FindAllForUser(userID int64) ([]*UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, last_login_at, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanUserIdentity)
+}
+
+func scanUserIdentity(row pgx.CollectableRow) (*UserIdentity, error) {
+	var identity UserIdentity
+	err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.LastLoginAt,
+		&identity.CreatedAt,
+	)
+	return &identity, err
+}
+
+// UpdateAfterLogin records a login with an identity, along with the email address the provider
+// currently has for the account
+func (m UserIdentityModel) UpdateAfterLogin(identity *UserIdentity) error {
+	query := `
+		UPDATE user_identities
+		SET email = $2, last_login_at = NOW()
+		WHERE id = $1
+		RETURNING last_login_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, identity.ID, identity.Email).Scan(&identity.LastLoginAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserIdentityModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM user_identities
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
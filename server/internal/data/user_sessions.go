@@ -0,0 +1,305 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token which was already exchanged is used
+// again. Only one of the holders of the token can be the legitimate client, so the whole
+// session is revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// UserSession is a login of a user on a device. Its refresh token is rotated on every refresh,
+// and the refresh and authentication tokens issued for it are revoked along with it.
+type UserSession struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	Name       string    `json:"name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Expiry     time.Time `json:"expiry"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current"`
+}
+
+type UserSessionModel struct {
+	DB *pgxpool.Pool
+}
+
+// New creates a session along with its refresh and authentication tokens
+func (m UserSessionModel) New(session *UserSession, refreshTTL, authTTL time.Duration) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO user_sessions (user_id, name, user_agent, ip_address, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, last_used_at, created_at`
+
+	session.Expiry = time.Now().Add(refreshTTL)
+	args := []any{session.UserID, session.Name, session.UserAgent, session.IPAddress, session.Expiry}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&session.ID, &session.LastUsedAt, &session.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, authToken, err := insertSessionTokens(ctx, tx, session, refreshTTL, authTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return refreshToken, authToken, tx.Commit(ctx)
+}
+
+// Refresh exchanges a refresh token for new refresh and authentication tokens of the same
+// session, and records the device the session was last used from. The exchanged refresh token
+// is kept as used until it expires: using it again revokes the session and returns
+// ErrRefreshTokenReused. Refresh tokens issued before sessions existed start a new session.
+func (m UserSessionModel) Refresh(refreshTokenPlaintext string, device *UserSession, refreshTTL, authTTL time.Duration) (*UserSession, *Token, *Token, error) {
+	hash := sha256.Sum256([]byte(refreshTokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the token so that concurrent refreshes with the same token are detected as reuse
+	query := `
+		SELECT user_id, session_id, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+		FOR UPDATE`
+
+	var userID int64
+	var sessionID pgtype.Int8
+	var usedAt pgtype.Timestamptz
+	err = tx.QueryRow(ctx, query, hash[:], ScopeRefresh).Scan(&userID, &sessionID, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, nil, err
+		}
+	}
+
+	if usedAt.Valid {
+		query = `
+			DELETE FROM user_sessions
+			WHERE id = $1`
+
+		_, err = tx.Exec(ctx, query, sessionID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return nil, nil, nil, ErrRefreshTokenReused
+	}
+
+	session := &UserSession{
+		UserID:    userID,
+		Name:      device.Name,
+		UserAgent: device.UserAgent,
+		IPAddress: device.IPAddress,
+		Expiry:    time.Now().Add(refreshTTL),
+	}
+
+	if sessionID.Valid {
+		query = `
+			UPDATE user_sessions
+			SET user_agent = $2, ip_address = $3, expiry = $4, last_used_at = NOW()
+			WHERE id = $1
+			RETURNING id, name, last_used_at, created_at`
+
+		args := []any{sessionID.Int64, session.UserAgent, session.IPAddress, session.Expiry}
+		err = tx.QueryRow(ctx, query, args...).Scan(&session.ID, &session.Name, &session.LastUsedAt, &session.CreatedAt)
+	} else {
+		query = `
+			INSERT INTO user_sessions (user_id, name, user_agent, ip_address, expiry)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, last_used_at, created_at`
+
+		args := []any{session.UserID, session.Name, session.UserAgent, session.IPAddress, session.Expiry}
+		err = tx.QueryRow(ctx, query, args...).Scan(&session.ID, &session.LastUsedAt, &session.CreatedAt)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	query = `
+		UPDATE tokens
+		SET used_at = NOW(), session_id = $2
+		WHERE hash = $1`
+
+	_, err = tx.Exec(ctx, query, hash[:], session.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	refreshToken, authToken, err := insertSessionTokens(ctx, tx, session, refreshTTL, authTTL)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return session, refreshToken, authToken, tx.Commit(ctx)
+}
+
+func insertSessionTokens(ctx context.Context, tx pgx.Tx, session *UserSession, refreshTTL, authTTL time.Duration) (*Token, *Token, error) {
+	refreshToken, err := generateToken(session.UserID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authToken, err := generateToken(session.UserID, authTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, session_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+
+	for _, token := range []*Token{refreshToken, authToken} {
+		token.SessionID = session.ID
+		err = tx.QueryRow(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.SessionID).Scan(&token.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return refreshToken, authToken, nil
+}
+
+func (m UserSessionModel) FindAllForUser(userID int64) ([]*UserSession, error) {
+	query := `
+		SELECT id, user_id, name, user_agent, ip_address, expiry, last_used_at, created_at
+		FROM user_sessions
+		WHERE user_id = $1 AND expiry > NOW()
+		ORDER BY last_used_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*UserSession, error) {
+		var session UserSession
+		err := row.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.Name,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.Expiry,
+			&session.LastUsedAt,
+			&session.CreatedAt,
+		)
+		return &session, err
+	})
+}
+
+// Delete revokes a session of a user along with its tokens
+func (m UserSessionModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM user_sessions
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUserExcept revokes all sessions of a user but one, along with the refresh and
+// authentication tokens which were issued before sessions existed and belong to no session
+func (m UserSessionModel) DeleteAllForUserExcept(userID, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		DELETE FROM user_sessions
+		WHERE user_id = $1 AND id != $2`
+
+	_, err = tx.Exec(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope IN ($2, $3) AND session_id IS NULL`
+
+	_, err = tx.Exec(ctx, query, userID, ScopeRefresh, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteAllForUser revokes all sessions of a user along with their tokens
+func (m UserSessionModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM user_sessions
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, userID)
+	return err
+}
+
+func (m UserSessionModel) DeleteExpired() error {
+	query := `
+		DELETE FROM user_sessions
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +34,8 @@ type User struct {
 	Activated       bool               `json:"activated,omitempty"`
 	Version         int                `json:"-"`
 	LastLoginAt     pgtype.Timestamptz `json:"last_login_at,omitempty"`
+	// DeletionScheduledAt is when the account is purged, if the user asked for it to be deleted
+	DeletionScheduledAt pgtype.Timestamptz `json:"deletion_scheduled_at,omitempty"`
 }
 
 type password struct {
@@ -60,6 +63,22 @@ func (p *password) SetOAuthPasswordPlaceholder() {
 	p.plaintext = nil
 }
 
+// IsOAuthPlaceholder reports whether the user signed up with an OAuth provider and never set a
+// password
+func (p *password) IsOAuthPlaceholder() bool {
+	return string(p.hash) == "$2a$10$************************"
+}
+
+// dummyPasswordHash is a bcrypt hash with the cost passwords are hashed with, which is compared
+// against when a user does not exist
+var dummyPasswordHash = []byte("$2a$12$Fp5RbGTBYOoPgn41EIiYye1fvucUcdKWSN7fujrQ7ZGnLevY1jnse")
+
+// CompareDummyPassword compares a password against a fixed hash, so that logging in as a user who
+// does not exist takes as long as logging in with a wrong password
+func CompareDummyPassword(plaintextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
@@ -179,7 +198,8 @@ func (m UserModel) Insert(user *User) error {
 
 func (m UserModel) GetByID(id int64) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, full_name, username, email, profile_image_url, password_hash, activated, last_login_at, version
+		SELECT id, created_at, updated_at, full_name, username, email, profile_image_url, password_hash, activated, last_login_at, version,
+		deletion_scheduled_at
 		FROM users
 		WHERE id = $1`
 
@@ -200,6 +220,7 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 		&user.Activated,
 		&user.LastLoginAt,
 		&user.Version,
+		&user.DeletionScheduledAt,
 	)
 	if err != nil {
 		switch {
@@ -215,7 +236,8 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-        SELECT id, created_at, updated_at, full_name, username, email, profile_image_url, password_hash, activated, last_login_at, version
+        SELECT id, created_at, updated_at, full_name, username, email, profile_image_url, password_hash, activated, last_login_at, version,
+		deletion_scheduled_at
         FROM users
         WHERE email = $1`
 
@@ -236,6 +258,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Activated,
 		&user.LastLoginAt,
 		&user.Version,
+		&user.DeletionScheduledAt,
 	)
 
 	if err != nil {
@@ -252,7 +275,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 func (m UserModel) GetByUsername(username string) (*User, error) {
 	query := `
-        SELECT id, created_at, updated_at, full_name, username, email, profile_image_url, password_hash, activated, last_login_at, version
+        SELECT id, created_at, updated_at, full_name, username, email, profile_image_url, password_hash, activated, last_login_at, version,
+		deletion_scheduled_at
         FROM users
         WHERE username = $1`
 
@@ -273,6 +297,7 @@ func (m UserModel) GetByUsername(username string) (*User, error) {
 		&user.Activated,
 		&user.LastLoginAt,
 		&user.Version,
+		&user.DeletionScheduledAt,
 	)
 
 	if err != nil {
@@ -292,7 +317,8 @@ func (m UserModel) GetForToken(scope, tokenPlaintext string) (*User, error) {
 
 	query := `
 		SELECT users.id, users.created_at, users.updated_at, users.full_name, users.username,
-		users.email, users.profile_image_url, users.password_hash, users.activated, users.last_login_at, users.version
+		users.email, users.profile_image_url, users.password_hash, users.activated, users.last_login_at, users.version,
+		users.deletion_scheduled_at
 		FROM users
 		INNER JOIN tokens ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
@@ -316,6 +342,7 @@ func (m UserModel) GetForToken(scope, tokenPlaintext string) (*User, error) {
 		&user.Activated,
 		&user.LastLoginAt,
 		&user.Version,
+		&user.DeletionScheduledAt,
 	)
 	if err != nil {
 		switch {
@@ -389,3 +416,221 @@ func (m UserModel) Update(user *User) error {
 
 	return nil
 }
+
+// ScheduleDeletion schedules the deletion of a user at the given time. All sessions and tokens
+// of the user are revoked and the confirmation email is queued in the same transaction, so that
+// the user is signed out everywhere as soon as the deletion is scheduled.
+func (m UserModel) ScheduleDeletion(user *User, deleteAt time.Time, email *OutboxEmail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE users
+		SET deletion_scheduled_at = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $3
+		RETURNING deletion_scheduled_at, version`
+
+	err = tx.QueryRow(ctx, query, user.ID, deleteAt, user.Version).Scan(&user.DeletionScheduledAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
+		DELETE FROM user_sessions
+		WHERE user_id = $1`
+
+	_, err = tx.Exec(ctx, query, user.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE user_id = $1`
+
+	_, err = tx.Exec(ctx, query, user.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM api_tokens
+		WHERE user_id = $1`
+
+	_, err = tx.Exec(ctx, query, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutboxEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CancelDeletion cancels the scheduled deletion of a user, if any. It reports whether a deletion
+// was cancelled.
+func (m UserModel) CancelDeletion(user *User) (bool, error) {
+	if !user.DeletionScheduledAt.Valid {
+		return false, nil
+	}
+
+	query := `
+		UPDATE users
+		SET deletion_scheduled_at = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, user.ID).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			// The user was purged or the deletion was cancelled concurrently
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	user.DeletionScheduledAt = pgtype.Timestamptz{}
+	return true, nil
+}
+
+// FindDueForDeletion returns the IDs of up to limit users whose scheduled deletion is due
+func (m UserModel) FindDueForDeletion(limit int) ([]int64, error) {
+	query := `
+		SELECT id
+		FROM users
+		WHERE deletion_scheduled_at <= NOW()
+		ORDER BY deletion_scheduled_at
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// Purge deletes a user whose scheduled deletion is due, along with all of their data. Feeds the
+// user added are shared with their other followers, so they are handed over to the follower who
+// followed first instead of being deleted. Unverified feeds no one else uses are deleted. The
+// like and save counters of the items the user liked or saved are decremented, since the cascade
+// from users does not touch them. Purge returns ErrRecordNotFound if the deletion was cancelled.
+func (m UserModel) Purge(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the user so that logging in cannot cancel the deletion halfway through
+	query := `
+		SELECT id
+		FROM users
+		WHERE id = $1 AND deletion_scheduled_at <= NOW()
+		FOR UPDATE`
+
+	err = tx.QueryRow(ctx, query, userID).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE feeds
+		SET added_by = successors.user_id, version = feeds.version + 1, updated_at = NOW()
+		FROM (
+			SELECT DISTINCT ON (feed_follows.feed_id) feed_follows.feed_id, feed_follows.user_id
+			FROM feed_follows
+			INNER JOIN feeds ON feeds.id = feed_follows.feed_id
+			WHERE feeds.added_by = $1 AND feed_follows.user_id <> $1
+			ORDER BY feed_follows.feed_id, feed_follows.created_at, feed_follows.user_id
+		) successors
+		WHERE feeds.id = successors.feed_id`
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	// Verified feeds and feeds on the walls of other users are kept without an owner, by the
+	// ON DELETE SET NULL of feeds.added_by
+	query = `
+		DELETE FROM feeds
+		WHERE added_by = $1 AND is_verified = FALSE
+		AND NOT EXISTS (
+			SELECT 1 FROM wall_feeds
+			INNER JOIN walls ON walls.id = wall_feeds.wall_id
+			WHERE wall_feeds.feed_id = feeds.id AND walls.user_id <> $1
+		)`
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	likeCount := "(items.like_count - engagements.likes)"
+	saveCount := "(items.save_count - engagements.saves)"
+	query = fmt.Sprintf(`
+		UPDATE items
+		SET like_count = %s,
+			save_count = %s,
+			hot_score = %s
+		FROM (
+			SELECT item_id, SUM(likes) AS likes, SUM(saves) AS saves
+			FROM (
+				SELECT item_id, 1 AS likes, 0 AS saves FROM liked_items WHERE user_id = $1
+				UNION ALL
+				SELECT item_id, 0 AS likes, 1 AS saves FROM saved_items WHERE user_id = $1
+			) user_engagements
+			GROUP BY item_id
+		) engagements
+		WHERE items.id = engagements.item_id`,
+		likeCount, saveCount,
+		buildHotItemsScoreCalculationQuery(likeCount, saveCount, "items.pub_date", "items.created_at"),
+	)
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM users
+		WHERE id = $1`
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aravindmathradan/semaphore/internal/validator"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -17,17 +18,94 @@ var (
 	ErrDuplicateWallFeed = errors.New("feed is already added to the wall")
 )
 
+// wallFeedItemsCondition restricts the items of a wall to the feeds which are not muted and
+// to the items which match the filters of their feed in the wall. A filter with no values
+// matches all items. It expects the items and wall_feeds tables to be joined.
+const wallFeedItemsCondition = `
+	NOT wall_feeds.is_muted
+	AND (
+		CARDINALITY(wall_feeds.categories) = 0
+		OR EXISTS (
+			SELECT 1 FROM UNNEST(items.categories) AS category
+			WHERE LOWER(category) = ANY(wall_feeds.categories)
+		)
+	)
+	AND (
+		CARDINALITY(wall_feeds.keywords) = 0
+		OR EXISTS (
+			SELECT 1 FROM UNNEST(wall_feeds.keywords) AS keyword
+			WHERE STRPOS(LOWER(items.title), keyword) > 0 OR STRPOS(LOWER(items.description), keyword) > 0
+		)
+	)
+	AND (
+		CARDINALITY(wall_feeds.authors) = 0
+		OR EXISTS (
+			SELECT 1 FROM JSONB_ARRAY_ELEMENTS(
+				CASE WHEN JSONB_TYPEOF(items.authors) = 'array' THEN items.authors ELSE '[]' END
+			) AS author
+			WHERE LOWER(author->>'name') = ANY(wall_feeds.authors)
+		)
+	)`
+
 type WallFeed struct {
-	WallID    int64      `json:"wall_id"`
-	FeedID    int64      `json:"feed_id"`
-	CreatedAt *time.Time `json:"created_at,omitempty"`
-	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	WallID    int64           `json:"wall_id"`
+	FeedID    int64           `json:"feed_id"`
+	IsMuted   bool            `json:"is_muted"`
+	Filters   WallFeedFilters `json:"filters"`
+	CreatedAt *time.Time      `json:"created_at,omitempty"`
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"`
+}
+
+// WallFeedFilters limit the items of a feed which are shown in a wall. An item is shown only
+// if it matches every filter which has values, and it matches a filter if it matches any of
+// its values. Values are matched case insensitively.
+type WallFeedFilters struct {
+	Categories []string `json:"categories"`
+	Keywords   []string `json:"keywords"`
+	Authors    []string `json:"authors"`
+}
+
+// WallFeedDTO is a feed of a wall along with the way it is included in the wall
+type WallFeedDTO struct {
+	Feed
+	IsMuted bool            `json:"is_muted"`
+	Filters WallFeedFilters `json:"filters"`
 }
 
 type WallFeedModel struct {
 	DB *pgxpool.Pool
 }
 
+// Normalize trims and lowercases the values of the filters so that they can be matched
+// against lowercased item fields in queries
+func (f *WallFeedFilters) Normalize() {
+	for _, values := range []*[]string{&f.Categories, &f.Keywords, &f.Authors} {
+		normalized := make([]string, 0, len(*values))
+		for _, value := range *values {
+			normalized = append(normalized, strings.ToLower(strings.TrimSpace(value)))
+		}
+		*values = normalized
+	}
+}
+
+func ValidateWallFeedFilters(v *validator.Validator, filters WallFeedFilters) {
+	for _, filter := range []struct {
+		key    string
+		values []string
+	}{
+		{"categories", filters.Categories},
+		{"keywords", filters.Keywords},
+		{"authors", filters.Authors},
+	} {
+		v.Check(len(filter.values) <= 20, filter.key, "Must not contain more than 20 values")
+		v.Check(validator.Unique(filter.values), filter.key, "Must not contain duplicate values")
+		for _, value := range filter.values {
+			v.Check(validator.NotBlank(value), filter.key, "Must not contain blank values")
+			v.Check(validator.MaxChars(value, 100), filter.key, "Values must not be more than 100 characters long")
+		}
+	}
+}
+
 func (m WallFeedModel) Insert(wallFeed *WallFeed) error {
 	query := `
 		INSERT INTO wall_feeds (wall_id, feed_id)
@@ -37,7 +115,13 @@ func (m WallFeedModel) Insert(wallFeed *WallFeed) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRow(ctx, query, wallFeed.WallID, wallFeed.FeedID).Scan(
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, wallFeed.WallID, wallFeed.FeedID).Scan(
 		&wallFeed.CreatedAt,
 		&wallFeed.UpdatedAt,
 	)
@@ -50,10 +134,16 @@ func (m WallFeedModel) Insert(wallFeed *WallFeed) error {
 		}
 		return err
 	}
-	return nil
+
+	err = recordWallChange(ctx, tx, wallFeed.WallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func (m WallFeedModel) FindFeedsForWall(wallID int64, title string, filters Filters) ([]*Feed, Metadata, error) {
+func (m WallFeedModel) FindFeedsForWall(wallID int64, title string, filters Filters) ([]*WallFeedDTO, Metadata, error) {
 	columnMapping := sortColumnMapping{
 		"id":          "feeds.id",
 		"title":       "feeds.title",
@@ -62,7 +152,8 @@ func (m WallFeedModel) FindFeedsForWall(wallID int64, title string, filters Filt
 	}
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), feeds.id, feeds.display_title, feeds.title, feeds.description, feeds.link, feeds.feed_link,
-			feeds.image_url, feeds.pub_date, feeds.pub_updated, feeds.feed_type, feeds.owner_type, feeds.feed_format, feeds.feed_version, feeds.language
+			feeds.image_url, feeds.pub_date, feeds.pub_updated, feeds.feed_type, feeds.owner_type, feeds.feed_format, feeds.feed_version, feeds.language,
+			wall_feeds.is_muted, wall_feeds.categories, wall_feeds.keywords, wall_feeds.authors
 		FROM feeds
 		INNER JOIN wall_feeds ON wall_feeds.feed_id = feeds.id
 		WHERE wall_feeds.wall_id = $1 
@@ -84,8 +175,8 @@ func (m WallFeedModel) FindFeedsForWall(wallID int64, title string, filters Filt
 	}
 
 	totalRecords := 0
-	feeds, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Feed, error) {
-		var feed Feed
+	feeds, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*WallFeedDTO, error) {
+		var feed WallFeedDTO
 		err := row.Scan(
 			&totalRecords,
 			&feed.ID,
@@ -102,6 +193,10 @@ func (m WallFeedModel) FindFeedsForWall(wallID int64, title string, filters Filt
 			&feed.FeedFormat,
 			&feed.FeedVersion,
 			&feed.Language,
+			&feed.IsMuted,
+			&feed.Filters.Categories,
+			&feed.Filters.Keywords,
+			&feed.Filters.Authors,
 		)
 		return &feed, err
 	})
@@ -112,25 +207,124 @@ func (m WallFeedModel) FindFeedsForWall(wallID int64, title string, filters Filt
 	return feeds, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
+// UpdateFilters replaces the filters of a feed in a wall
+func (m WallFeedModel) UpdateFilters(wallFeed *WallFeed) error {
+	query := `
+		UPDATE wall_feeds
+		SET categories = $3, keywords = $4, authors = $5, updated_at = NOW()
+		WHERE wall_id = $1 AND feed_id = $2
+		RETURNING is_muted, created_at, updated_at`
+
+	args := []any{
+		wallFeed.WallID,
+		wallFeed.FeedID,
+		wallFeed.Filters.Categories,
+		wallFeed.Filters.Keywords,
+		wallFeed.Filters.Authors,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&wallFeed.IsMuted, &wallFeed.CreatedAt, &wallFeed.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = recordWallChange(ctx, tx, wallFeed.WallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// SetMuted mutes or unmutes a feed in a wall. Items of muted feeds are not shown in the wall,
+// which lets a feed be hidden from the primary wall without unfollowing it.
+func (m WallFeedModel) SetMuted(wallID, feedID int64, muted bool) error {
+	query := `
+		UPDATE wall_feeds
+		SET is_muted = $3, updated_at = NOW()
+		WHERE wall_id = $1 AND feed_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, wallID, feedID, muted)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordWallChange(ctx, tx, wallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
 func (m WallFeedModel) DeleteFeedForWalls(feedID int64, wallIDs []int64) error {
 	query := `
 		DELETE FROM wall_feeds
 		WHERE feed_id = $1
-		AND wall_id = ANY($2)`
+		AND wall_id = ANY($2)
+		RETURNING wall_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.Exec(ctx, query, feedID, wallIDs)
+	tx, err := m.DB.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	if result.RowsAffected() == 0 {
+	rows, err := tx.Query(ctx, query, feedID, wallIDs)
+	if err != nil {
+		return err
+	}
+
+	changedWalls := make(map[int64]bool)
+	var wallID int64
+	_, err = pgx.ForEachRow(rows, []any{&wallID}, func() error {
+		changedWalls[wallID] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changedWalls) == 0 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = recordWallChanges(ctx, tx, changedWalls)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (m WallFeedModel) Delete(wallFeed *WallFeed) error {
@@ -141,7 +335,13 @@ func (m WallFeedModel) Delete(wallFeed *WallFeed) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.Exec(ctx, query, wallFeed.WallID, wallFeed.FeedID)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, wallFeed.WallID, wallFeed.FeedID)
 	if err != nil {
 		return err
 	}
@@ -150,5 +350,45 @@ func (m WallFeedModel) Delete(wallFeed *WallFeed) error {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = recordWallChange(ctx, tx, wallFeed.WallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// deleteFeedFromOwnedWalls removes a feed from the walls owned by a user within a transaction and
+// returns the IDs of the walls it was removed from. Walls shared with the user are curated by
+// their owners.
+func deleteFeedFromOwnedWalls(ctx context.Context, tx pgx.Tx, userID, feedID int64) ([]int64, error) {
+	query := `
+		DELETE FROM wall_feeds
+		WHERE feed_id = $2
+		AND wall_id IN (SELECT id FROM walls WHERE user_id = $1)
+		RETURNING wall_id`
+
+	rows, err := tx.Query(ctx, query, userID, feedID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// FindWallIDsForFeed returns the IDs of the walls a feed is added to and not muted in
+func (m WallFeedModel) FindWallIDsForFeed(feedID int64) ([]int64, error) {
+	query := `
+		SELECT wall_id FROM wall_feeds
+		WHERE feed_id = $1 AND is_muted = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, feedID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
 }
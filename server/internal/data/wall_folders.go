@@ -0,0 +1,321 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrWallFolderCycle = errors.New("wall folder cannot be moved into itself")
+)
+
+// WallFolder groups the walls of a user. Folders can be nested in other folders and
+// are private to the user who created them.
+type WallFolder struct {
+	ID        int64              `json:"id"`
+	UserID    int64              `json:"user_id"`
+	ParentID  pgtype.Int8        `json:"parent_id"`
+	Name      string             `json:"name"`
+	Position  int                `json:"position"`
+	CreatedAt *time.Time         `json:"created_at,omitempty"`
+	UpdatedAt *time.Time         `json:"updated_at,omitempty"`
+	Walls     []*WallWithFeedDTO `json:"walls,omitempty"`
+	Folders   []*WallFolder      `json:"folders,omitempty"`
+}
+
+// WallFolderPosition is the position of a folder within its parent folder
+type WallFolderPosition struct {
+	ID       int64       `json:"id"`
+	ParentID pgtype.Int8 `json:"parent_id"`
+	Position int         `json:"position"`
+}
+
+// WallPosition is the position of a wall within a folder of a member
+type WallPosition struct {
+	WallID   int64       `json:"wall_id"`
+	FolderID pgtype.Int8 `json:"folder_id"`
+	Position int         `json:"position"`
+}
+
+type WallFolderModel struct {
+	DB *pgxpool.Pool
+}
+
+func ValidateWallFolder(v *validator.Validator, folder *WallFolder) {
+	v.Check(validator.NotBlank(folder.Name), "name", "Name must be provided")
+	v.Check(validator.MaxChars(folder.Name, 36), "name", "Name must not be more than 36 characters long")
+}
+
+// Insert creates a folder after the existing folders of its parent. ErrRecordNotFound is
+// returned if the parent folder does not belong to the user.
+func (m WallFolderModel) Insert(folder *WallFolder) error {
+	query := `
+		INSERT INTO wall_folders (user_id, parent_id, name, position)
+		SELECT $1, $2, $3, (
+			SELECT COALESCE(MAX(position) + 1, 0)
+			FROM wall_folders
+			WHERE user_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+		)
+		WHERE $2::bigint IS NULL OR EXISTS (
+			SELECT 1 FROM wall_folders WHERE id = $2 AND user_id = $1
+		)
+		RETURNING id, position, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, folder.UserID, folder.ParentID, folder.Name).Scan(
+		&folder.ID,
+		&folder.Position,
+		&folder.CreatedAt,
+		&folder.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = recordUserChange(ctx, tx, folder.UserID, ChangeEntityWallFolder, folder.ID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Update renames a folder. Folders are moved with UpdateLayout.
+func (m WallFolderModel) Update(folder *WallFolder) error {
+	query := `
+		UPDATE wall_folders
+		SET name = $3, updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING parent_id, position, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, folder.ID, folder.UserID, folder.Name).Scan(
+		&folder.ParentID,
+		&folder.Position,
+		&folder.CreatedAt,
+		&folder.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = recordUserChange(ctx, tx, folder.UserID, ChangeEntityWallFolder, folder.ID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Delete removes a folder of a user. The walls and folders inside it are moved to the top level.
+func (m WallFolderModel) Delete(folderID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The contents of the folder are moved by the foreign keys, so they are looked up first
+	// to record their changes
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM wall_folders
+		WHERE parent_id = $1 AND user_id = $2
+		ORDER BY id`, folderID, userID)
+	if err != nil {
+		return err
+	}
+	childFolderIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT wall_id FROM wall_members
+		WHERE folder_id = $1 AND user_id = $2
+		ORDER BY wall_id`, folderID, userID)
+	if err != nil {
+		return err
+	}
+	childWallIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx, `
+		DELETE FROM wall_folders
+		WHERE id = $1 AND user_id = $2`, folderID, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordUserChange(ctx, tx, userID, ChangeEntityWallFolder, folderID, ChangeOpDelete)
+	if err != nil {
+		return err
+	}
+	err = recordLayoutChanges(ctx, tx, userID, childFolderIDs, childWallIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UpdateLayout moves folders and walls of a user to new parents and positions in a single
+// transaction. ErrRecordNotFound is returned if any of the folders or walls do not belong to
+// the user, and ErrWallFolderCycle if a folder would end up inside itself.
+func (m WallFolderModel) UpdateLayout(userID int64, folders []*WallFolderPosition, walls []*WallPosition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(folders) > 0 {
+		ids := make([]int64, len(folders))
+		parentIDs := make([]pgtype.Int8, len(folders))
+		positions := make([]int, len(folders))
+		for i, folder := range folders {
+			ids[i] = folder.ID
+			parentIDs[i] = folder.ParentID
+			positions[i] = folder.Position
+		}
+
+		result, err := tx.Exec(ctx, `
+			UPDATE wall_folders wf
+			SET parent_id = l.parent_id, position = l.position, updated_at = NOW()
+			FROM UNNEST($2::bigint[], $3::bigint[], $4::integer[]) AS l(id, parent_id, position)
+			WHERE wf.id = l.id AND wf.user_id = $1
+			AND (l.parent_id IS NULL OR EXISTS (
+				SELECT 1 FROM wall_folders p WHERE p.id = l.parent_id AND p.user_id = $1
+			))`, userID, ids, parentIDs, positions)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() != int64(len(folders)) {
+			return ErrRecordNotFound
+		}
+
+		// Walk up from every folder of the user and look for a folder that is its own ancestor
+		var hasCycle bool
+		err = tx.QueryRow(ctx, `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id, ARRAY[id] AS path, false AS is_cycle
+				FROM wall_folders
+				WHERE user_id = $1
+				UNION ALL
+				SELECT a.id, wf.parent_id, a.path || wf.id, wf.id = ANY(a.path)
+				FROM ancestors a
+				INNER JOIN wall_folders wf ON wf.id = a.parent_id
+				WHERE NOT a.is_cycle
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors WHERE is_cycle)`, userID).Scan(&hasCycle)
+		if err != nil {
+			return err
+		}
+		if hasCycle {
+			return ErrWallFolderCycle
+		}
+	}
+
+	if len(walls) > 0 {
+		wallIDs := make([]int64, len(walls))
+		folderIDs := make([]pgtype.Int8, len(walls))
+		positions := make([]int, len(walls))
+		for i, wall := range walls {
+			wallIDs[i] = wall.WallID
+			folderIDs[i] = wall.FolderID
+			positions[i] = wall.Position
+		}
+
+		result, err := tx.Exec(ctx, `
+			UPDATE wall_members wm
+			SET folder_id = l.folder_id, position = l.position
+			FROM UNNEST($2::bigint[], $3::bigint[], $4::integer[]) AS l(wall_id, folder_id, position)
+			WHERE wm.wall_id = l.wall_id AND wm.user_id = $1
+			AND (l.folder_id IS NULL OR EXISTS (
+				SELECT 1 FROM wall_folders wf WHERE wf.id = l.folder_id AND wf.user_id = $1
+			))`, userID, wallIDs, folderIDs, positions)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() != int64(len(walls)) {
+			return ErrRecordNotFound
+		}
+	}
+
+	folderIDs := make([]int64, len(folders))
+	for i, folder := range folders {
+		folderIDs[i] = folder.ID
+	}
+	wallIDs := make([]int64, len(walls))
+	for i, wall := range walls {
+		wallIDs[i] = wall.WallID
+	}
+
+	err = recordLayoutChanges(ctx, tx, userID, folderIDs, wallIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// recordLayoutChanges records an update of the given folders and walls of a user whose place
+// in the layout of the user changed
+func recordLayoutChanges(ctx context.Context, tx pgx.Tx, userID int64, folderIDs, wallIDs []int64) error {
+	for _, folderID := range folderIDs {
+		err := recordUserChange(ctx, tx, userID, ChangeEntityWallFolder, folderID, ChangeOpUpsert)
+		if err != nil {
+			return err
+		}
+	}
+	for _, wallID := range wallIDs {
+		err := recordUserChange(ctx, tx, userID, ChangeEntityWall, wallID, ChangeOpUpsert)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrDuplicateWallMember = errors.New("user is already a member of the wall")
+)
+
+type WallInvitation struct {
+	ID        int64      `json:"id"`
+	WallID    int64      `json:"wall_id"`
+	UserID    int64      `json:"user_id"`
+	Role      string     `json:"role"`
+	InvitedBy int64      `json:"invited_by"`
+	Expiry    time.Time  `json:"expiry"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Wall      *Wall      `json:"wall,omitempty"`
+	Inviter   *User      `json:"inviter,omitempty"`
+}
+
+type WallInvitationModel struct {
+	DB *pgxpool.Pool
+}
+
+// Upsert creates an invitation for a user to join a wall. If the user already has a pending
+// invitation to the wall, the role and expiry of the invitation are updated. The invitation
+// email is queued in the same transaction.
+func (m WallInvitationModel) Upsert(invitation *WallInvitation, email *OutboxEmail) error {
+	query := `
+		INSERT INTO wall_invitations (wall_id, user_id, role, invited_by, expiry)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (
+			SELECT 1 FROM wall_members WHERE wall_id = $1 AND user_id = $2
+		)
+		ON CONFLICT (wall_id, user_id) DO UPDATE
+		SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by,
+			expiry = EXCLUDED.expiry, created_at = NOW()
+		RETURNING id, created_at`
+
+	args := []any{invitation.WallID, invitation.UserID, invitation.Role, invitation.InvitedBy, invitation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrDuplicateWallMember
+		default:
+			return err
+		}
+	}
+
+	err = insertOutboxEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// FindAllForUser returns the pending invitations of a user
+func (m WallInvitationModel) FindAllForUser(userID int64) ([]*WallInvitation, error) {
+	query := `
+		SELECT wi.id, wi.wall_id, wi.user_id, wi.role, wi.invited_by, wi.expiry, wi.created_at,
+			w.id, w.name, u.id, u.full_name, u.username
+		FROM wall_invitations wi
+		INNER JOIN walls w ON w.id = wi.wall_id
+		INNER JOIN users u ON u.id = wi.invited_by
+		WHERE wi.user_id = $1 AND wi.expiry > NOW()
+		ORDER BY wi.created_at DESC, wi.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*WallInvitation, error) {
+		var invitation WallInvitation
+		var wall Wall
+		var inviter User
+		err := row.Scan(
+			&invitation.ID,
+			&invitation.WallID,
+			&invitation.UserID,
+			&invitation.Role,
+			&invitation.InvitedBy,
+			&invitation.Expiry,
+			&invitation.CreatedAt,
+			&wall.ID,
+			&wall.Name,
+			&inviter.ID,
+			&inviter.FullName,
+			&inviter.Username,
+		)
+		invitation.Wall = &wall
+		invitation.Inviter = &inviter
+		return &invitation, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Accept adds the invited user as a member of the wall with the role of the invitation
+// and removes the invitation
+func (m WallInvitationModel) Accept(invitationID, userID int64) (*WallMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	member := &WallMember{UserID: userID}
+	err = tx.QueryRow(ctx, `
+		DELETE FROM wall_invitations
+		WHERE id = $1 AND user_id = $2 AND expiry > NOW()
+		RETURNING wall_id, role`, invitationID, userID).Scan(&member.WallID, &member.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO wall_members (wall_id, user_id, role, position)
+		VALUES ($1, $2, $3, `+nextWallPositionQuery+`)
+		RETURNING created_at`, member.WallID, member.UserID, member.Role).Scan(&member.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == strconv.Itoa(23505) && strings.Contains(pgErr.ConstraintName, "wall_members_pkey") {
+				return nil, ErrDuplicateWallMember
+			}
+		}
+		return nil, err
+	}
+
+	err = recordUserChange(ctx, tx, member.UserID, ChangeEntityWall, member.WallID, ChangeOpUpsert)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// Delete removes a pending invitation of a user
+func (m WallInvitationModel) Delete(invitationID, userID int64) error {
+	query := `
+		DELETE FROM wall_invitations
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, invitationID, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	WallRoleOwner  = "owner"
+	WallRoleEditor = "editor"
+	WallRoleViewer = "viewer"
+)
+
+var (
+	ErrChangingWallOwner = errors.New("cannot change or remove the owner of a wall")
+)
+
+// wallRoleRanks orders the wall roles by their privileges
+var wallRoleRanks = map[string]int{
+	WallRoleViewer: 1,
+	WallRoleEditor: 2,
+	WallRoleOwner:  3,
+}
+
+// HasWallRole reports whether a role has at least the privileges of the required role
+func HasWallRole(role, required string) bool {
+	return wallRoleRanks[role] > 0 && wallRoleRanks[role] >= wallRoleRanks[required]
+}
+
+func ValidateWallMemberRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, WallRoleEditor, WallRoleViewer), "role", "Role must be one of editor or viewer")
+}
+
+// nextWallPositionQuery selects the position after the last top level wall of the user $2
+const nextWallPositionQuery = `(
+	SELECT COALESCE(MAX(position) + 1, 0)
+	FROM wall_members
+	WHERE user_id = $2 AND folder_id IS NULL
+)`
+
+type WallMember struct {
+	WallID    int64      `json:"wall_id"`
+	UserID    int64      `json:"user_id"`
+	Role      string     `json:"role"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	User      *User      `json:"user,omitempty"`
+}
+
+type WallMemberModel struct {
+	DB *pgxpool.Pool
+}
+
+// GetRole returns the role of a user in a wall. ErrRecordNotFound is returned
+// if the user is not a member of the wall.
+func (m WallMemberModel) GetRole(wallID, userID int64) (string, error) {
+	query := `
+		SELECT role
+		FROM wall_members
+		WHERE wall_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var role string
+	err := m.DB.QueryRow(ctx, query, wallID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+func (m WallMemberModel) FindAllForWall(wallID int64) ([]*WallMember, error) {
+	query := `
+		SELECT wm.wall_id, wm.user_id, wm.role, wm.created_at,
+			u.id, u.full_name, u.username, u.profile_image_url
+		FROM wall_members wm
+		INNER JOIN users u ON u.id = wm.user_id
+		WHERE wm.wall_id = $1
+		ORDER BY wm.role = 'owner' DESC, wm.created_at ASC, wm.user_id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, wallID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*WallMember, error) {
+		var member WallMember
+		var user User
+		err := row.Scan(
+			&member.WallID,
+			&member.UserID,
+			&member.Role,
+			&member.CreatedAt,
+			&user.ID,
+			&user.FullName,
+			&user.Username,
+			&user.ProfileImageURL,
+		)
+		member.User = &user
+		return &member, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// UpdateRole changes the role of a member. The role of the owner cannot be changed.
+func (m WallMemberModel) UpdateRole(wallID, userID int64, role string) error {
+	query := `
+		UPDATE wall_members
+		SET role = $3
+		WHERE wall_id = $1 AND user_id = $2 AND role != 'owner'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, wallID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return m.ownerOrNotFound(ctx, wallID, userID)
+	}
+
+	err = recordUserChange(ctx, tx, userID, ChangeEntityWall, wallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Delete removes a member from a wall. The owner cannot be removed.
+func (m WallMemberModel) Delete(wallID, userID int64) error {
+	query := `
+		DELETE FROM wall_members
+		WHERE wall_id = $1 AND user_id = $2 AND role != 'owner'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, wallID, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return m.ownerOrNotFound(ctx, wallID, userID)
+	}
+
+	// The wall is gone for the removed member
+	err = recordUserChange(ctx, tx, userID, ChangeEntityWall, wallID, ChangeOpDelete)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ownerOrNotFound returns ErrChangingWallOwner if the user is the owner of the wall
+// and ErrRecordNotFound otherwise
+func (m WallMemberModel) ownerOrNotFound(ctx context.Context, wallID, userID int64) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM wall_members
+			WHERE wall_id = $1 AND user_id = $2 AND role = 'owner'
+		)`
+
+	var isOwner bool
+	err := m.DB.QueryRow(ctx, query, wallID, userID).Scan(&isOwner)
+	if err != nil {
+		return err
+	}
+
+	if isOwner {
+		return ErrChangingWallOwner
+	}
+	return ErrRecordNotFound
+}
+
+// checkWallMemberships returns ErrRecordNotFound if the user is not a member of all the walls.
+// The wall ids are expected to be unique.
+func checkWallMemberships(ctx context.Context, tx pgx.Tx, userID int64, wallIDs []int64) error {
+	if len(wallIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT COUNT(*) FROM wall_members
+		WHERE user_id = $1 AND wall_id = ANY($2)`
+
+	var count int
+	err := tx.QueryRow(ctx, query, userID, wallIDs).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count != len(wallIDs) {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base32"
 	"errors"
 	"strconv"
 	"strings"
@@ -10,49 +12,87 @@ import (
 	"github.com/aravindmathradan/semaphore/internal/validator"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var (
 	ErrDuplicateWall       = errors.New("user already owns a wall with same name")
 	ErrDeletingPrimaryWall = errors.New("cannot delete primary wall")
-	ErrDuplicatePinnedWall = errors.New("user already has another pinned wall")
+	ErrPinnedWallsLimit    = errors.New("user has reached the limit of pinned walls")
 )
 
 type Wall struct {
-	ID        int64      `json:"id"`
-	Name      string     `json:"name"`
-	IsPrimary bool       `json:"is_primary"`
-	IsPinned  bool       `json:"is_pinned"`
-	UserID    int64      `json:"user_id"`
-	CreatedAt *time.Time `json:"created_at,omitempty"`
-	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	Emoji     pgtype.Text `json:"emoji"`
+	Color     pgtype.Text `json:"color"`
+	Icon      pgtype.Text `json:"icon"`
+	IsPrimary bool        `json:"is_primary"`
+	IsPinned  bool        `json:"is_pinned"`
+	IsPublic  bool        `json:"is_public"`
+	PublicID  pgtype.Text `json:"public_id,omitempty"`
+	UserID    int64       `json:"user_id"`
+	Role      string      `json:"role,omitempty"`
+	CreatedAt *time.Time  `json:"created_at,omitempty"`
+	UpdatedAt *time.Time  `json:"updated_at,omitempty"`
 }
 
 type WallModel struct {
 	DB *pgxpool.Pool
 }
 
+// WallWithFeedDTO is a wall as seen by one of its members. The folder and position
+// of a wall are chosen by each member for themselves.
 type WallWithFeedDTO struct {
 	Wall
-	Feeds []Feed `json:"feeds,omitempty"`
+	FolderID pgtype.Int8 `json:"folder_id"`
+	Position int         `json:"position"`
+	Feeds    []Feed      `json:"feeds,omitempty"`
+}
+
+// WallTree holds the walls of a user arranged in folders. Walls and folders at the
+// top level are not in any folder.
+type WallTree struct {
+	Walls   []*WallWithFeedDTO `json:"walls"`
+	Folders []*WallFolder      `json:"folders"`
 }
 
 func ValidateWall(v *validator.Validator, wall *Wall) {
 	v.Check(validator.NotBlank(wall.Name), "name", "Name must be provided")
 	v.Check(validator.MaxChars(wall.Name, 36), "name", "Name must not be more than 36 characters long")
+
+	if wall.Emoji.Valid {
+		v.Check(validator.NotBlank(wall.Emoji.String), "emoji", "Emoji must not be blank")
+		v.Check(validator.MaxBytes(wall.Emoji.String, 32), "emoji", "Emoji must be a single emoji")
+	}
+	if wall.Color.Valid {
+		v.Check(validator.Matches(wall.Color.String, validator.HexColorRX), "color", "Color must be a hex color code like #1a2b3c")
+	}
+	if wall.Icon.Valid {
+		v.Check(validator.Matches(wall.Icon.String, validator.SlugRX), "icon", "Icon must only contain lowercase letters, digits and hyphens")
+		v.Check(validator.MaxChars(wall.Icon.String, 32), "icon", "Icon must not be more than 32 characters long")
+	}
 }
 
 func (m WallModel) Insert(wall *Wall) error {
 	query := `
-		INSERT INTO walls (name, is_primary, is_pinned, user_id)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO walls (name, emoji, color, icon, is_primary, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, updated_at`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRow(ctx, query, wall.Name, wall.IsPrimary, wall.IsPinned, wall.UserID).Scan(
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	args := []any{wall.Name, wall.Emoji, wall.Color, wall.Icon, wall.IsPrimary, wall.UserID}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(
 		&wall.ID,
 		&wall.CreatedAt,
 		&wall.UpdatedAt,
@@ -66,7 +106,22 @@ func (m WallModel) Insert(wall *Wall) error {
 		}
 		return err
 	}
-	return nil
+
+	// The user who creates a wall is its owner
+	_, err = tx.Exec(ctx, `
+		INSERT INTO wall_members (wall_id, user_id, role, position)
+		VALUES ($1, $2, $3, `+nextWallPositionQuery+`)`, wall.ID, wall.UserID, WallRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	err = recordUserChange(ctx, tx, wall.UserID, ChangeEntityWall, wall.ID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	wall.Role = WallRoleOwner
+	return tx.Commit(ctx)
 }
 
 func (m WallModel) InsertPrimaryWall(userID int64) error {
@@ -81,7 +136,7 @@ func (m WallModel) InsertPrimaryWall(userID int64) error {
 
 func (m WallModel) FindByID(wallID int64) (*Wall, error) {
 	query := `
-		SELECT id, name, is_primary, is_pinned, user_id, created_at, updated_at
+		SELECT id, name, emoji, color, icon, is_primary, user_id, created_at, updated_at
 		FROM walls
 		WHERE id = $1`
 
@@ -92,9 +147,51 @@ func (m WallModel) FindByID(wallID int64) (*Wall, error) {
 	err := m.DB.QueryRow(ctx, query, wallID).Scan(
 		&wall.ID,
 		&wall.Name,
+		&wall.Emoji,
+		&wall.Color,
+		&wall.Icon,
+		&wall.IsPrimary,
+		&wall.UserID,
+		&wall.CreatedAt,
+		&wall.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return wall, nil
+}
+
+// FindByIDForUser returns a wall along with the role of the user in the wall.
+// Role is empty if the user is not a member of the wall.
+func (m WallModel) FindByIDForUser(wallID, userID int64) (*Wall, error) {
+	query := `
+		SELECT w.id, w.name, w.emoji, w.color, w.icon, w.is_primary, COALESCE(wm.is_pinned, false), w.is_public,
+			CASE WHEN w.is_public THEN w.public_id END, w.user_id, COALESCE(wm.role, ''), w.created_at, w.updated_at
+		FROM walls w
+		LEFT JOIN wall_members wm ON wm.wall_id = w.id AND wm.user_id = $2
+		WHERE w.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	wall := &Wall{}
+	err := m.DB.QueryRow(ctx, query, wallID, userID).Scan(
+		&wall.ID,
+		&wall.Name,
+		&wall.Emoji,
+		&wall.Color,
+		&wall.Icon,
 		&wall.IsPrimary,
 		&wall.IsPinned,
+		&wall.IsPublic,
+		&wall.PublicID,
 		&wall.UserID,
+		&wall.Role,
 		&wall.CreatedAt,
 		&wall.UpdatedAt,
 	)
@@ -109,9 +206,12 @@ func (m WallModel) FindByID(wallID int64) (*Wall, error) {
 	return wall, nil
 }
 
+// FindAllForUser returns the walls which the user is a member of, along with the role of the user
 func (m WallModel) FindAllForUser(userID int64) ([]*WallWithFeedDTO, error) {
 	query := `
-		SELECT w.id, w.name, w.is_primary, w.is_pinned, w.user_id, w.created_at, w.updated_at,
+		SELECT w.id, w.name, w.emoji, w.color, w.icon, w.is_primary, wm.is_pinned, w.is_public,
+			CASE WHEN w.is_public THEN w.public_id END, w.user_id, wm.role, wm.folder_id, wm.position,
+			w.created_at, w.updated_at,
 		COALESCE(
 			JSONB_AGG(JSONB_BUILD_OBJECT(
 				'id', f.id,
@@ -129,11 +229,11 @@ func (m WallModel) FindAllForUser(userID int64) ([]*WallWithFeedDTO, error) {
 			FILTER (WHERE f.id IS NOT NULL), '[]'
 		) as w_feeds	
 		FROM walls w
+		INNER JOIN wall_members wm ON wm.wall_id = w.id AND wm.user_id = $1
 		LEFT JOIN wall_feeds wf ON w.id = wf.wall_id
 		LEFT JOIN feeds f ON wf.feed_id = f.id
-		WHERE w.user_id = $1
-		GROUP BY w.id
-		ORDER BY w.is_pinned DESC, w.is_primary DESC, w.name ASC`
+		GROUP BY w.id, wm.wall_id, wm.user_id
+		ORDER BY wm.is_pinned DESC, w.is_primary DESC, wm.position ASC, w.name ASC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -148,9 +248,17 @@ func (m WallModel) FindAllForUser(userID int64) ([]*WallWithFeedDTO, error) {
 		err := rows.Scan(
 			&wall.ID,
 			&wall.Name,
+			&wall.Emoji,
+			&wall.Color,
+			&wall.Icon,
 			&wall.IsPrimary,
 			&wall.IsPinned,
+			&wall.IsPublic,
+			&wall.PublicID,
 			&wall.UserID,
+			&wall.Role,
+			&wall.FolderID,
+			&wall.Position,
 			&wall.CreatedAt,
 			&wall.UpdatedAt,
 			&wall.Feeds,
@@ -163,9 +271,132 @@ func (m WallModel) FindAllForUser(userID int64) ([]*WallWithFeedDTO, error) {
 	return walls, nil
 }
 
+// FindTreeForUser returns the walls which the user is a member of arranged in the folders of
+// the user. Walls and folders are fetched together in a single query and the tree is built
+// from the rows, which are already in the order of the walls and folders within their parents.
+func (m WallModel) FindTreeForUser(userID int64) (*WallTree, error) {
+	query := `
+		SELECT 'wall' AS kind, w.id, w.name, w.emoji, w.color, w.icon, w.is_primary, wm.is_pinned, w.is_public,
+			CASE WHEN w.is_public THEN w.public_id END, w.user_id, wm.role, wm.folder_id, wm.position,
+			w.created_at, w.updated_at,
+		COALESCE(
+			JSONB_AGG(JSONB_BUILD_OBJECT(
+				'id', f.id,
+				'display_title', f.display_title,
+				'title', f.title,
+				'description', f.description,
+				'link', f.link,
+				'feed_link', f.feed_link,
+				'pub_date', f.pub_date,
+				'pub_updated', f.pub_updated,
+				'feed_format', f.feed_format,
+				'feed_version', f.feed_version,
+				'language', f.language
+			))
+			FILTER (WHERE f.id IS NOT NULL), '[]'
+		) AS feeds
+		FROM walls w
+		INNER JOIN wall_members wm ON wm.wall_id = w.id AND wm.user_id = $1
+		LEFT JOIN wall_feeds wf ON w.id = wf.wall_id
+		LEFT JOIN feeds f ON wf.feed_id = f.id
+		GROUP BY w.id, wm.wall_id, wm.user_id
+		UNION ALL
+		SELECT 'folder', wfo.id, wfo.name, NULL, NULL, NULL, false, false, false,
+			NULL, wfo.user_id, '', wfo.parent_id, wfo.position,
+			wfo.created_at, wfo.updated_at, '[]'::jsonb
+		FROM wall_folders wfo
+		WHERE wfo.user_id = $1
+		ORDER BY is_pinned DESC, is_primary DESC, position ASC, name ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &WallTree{
+		Walls:   []*WallWithFeedDTO{},
+		Folders: []*WallFolder{},
+	}
+	var walls []*WallWithFeedDTO
+	var folders []*WallFolder
+	foldersByID := make(map[int64]*WallFolder)
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var wall WallWithFeedDTO
+		err := rows.Scan(
+			&kind,
+			&wall.ID,
+			&wall.Name,
+			&wall.Emoji,
+			&wall.Color,
+			&wall.Icon,
+			&wall.IsPrimary,
+			&wall.IsPinned,
+			&wall.IsPublic,
+			&wall.PublicID,
+			&wall.UserID,
+			&wall.Role,
+			&wall.FolderID,
+			&wall.Position,
+			&wall.CreatedAt,
+			&wall.UpdatedAt,
+			&wall.Feeds,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if kind == "folder" {
+			folder := &WallFolder{
+				ID:        wall.ID,
+				UserID:    wall.UserID,
+				ParentID:  wall.FolderID,
+				Name:      wall.Name,
+				Position:  wall.Position,
+				CreatedAt: wall.CreatedAt,
+				UpdatedAt: wall.UpdatedAt,
+				Walls:     []*WallWithFeedDTO{},
+				Folders:   []*WallFolder{},
+			}
+			folders = append(folders, folder)
+			foldersByID[folder.ID] = folder
+			continue
+		}
+		walls = append(walls, &wall)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Parents are looked up only after all the rows are read since a parent can be
+	// ordered after its children
+	for _, folder := range folders {
+		if parent, ok := foldersByID[folder.ParentID.Int64]; ok && folder.ParentID.Valid {
+			parent.Folders = append(parent.Folders, folder)
+		} else {
+			tree.Folders = append(tree.Folders, folder)
+		}
+	}
+	for _, wall := range walls {
+		if folder, ok := foldersByID[wall.FolderID.Int64]; ok && wall.FolderID.Valid {
+			folder.Walls = append(folder.Walls, wall)
+		} else {
+			tree.Walls = append(tree.Walls, wall)
+		}
+	}
+
+	return tree, nil
+}
+
 func (m WallModel) FindPrimaryWallForUser(userID int64) (*Wall, error) {
 	query := `
-		SELECT id, name, is_primary, is_pinned, user_id, created_at, updated_at
+		SELECT id, name, emoji, color, icon, is_primary, user_id, created_at, updated_at
 		FROM walls
 		WHERE user_id = $1 AND is_primary = true
 		LIMIT 1`
@@ -177,8 +408,10 @@ func (m WallModel) FindPrimaryWallForUser(userID int64) (*Wall, error) {
 	err := m.DB.QueryRow(ctx, query, userID).Scan(
 		&wall.ID,
 		&wall.Name,
+		&wall.Emoji,
+		&wall.Color,
+		&wall.Icon,
 		&wall.IsPrimary,
-		&wall.IsPinned,
 		&wall.UserID,
 		&wall.CreatedAt,
 		&wall.UpdatedAt,
@@ -189,14 +422,19 @@ func (m WallModel) FindPrimaryWallForUser(userID int64) (*Wall, error) {
 	return wall, nil
 }
 
+// Update changes the name and appearance of a wall. The name of the primary wall
+// cannot be changed.
 func (m WallModel) Update(wall *Wall) error {
 	query := `
         UPDATE walls 
-        SET name = $1, updated_at = $2
-        WHERE id = $3 AND is_primary = false`
+        SET name = CASE WHEN is_primary THEN name ELSE $1 END, emoji = $2, color = $3, icon = $4, updated_at = $5
+        WHERE id = $6`
 
 	args := []any{
 		wall.Name,
+		wall.Emoji,
+		wall.Color,
+		wall.Icon,
 		time.Now(),
 		wall.ID,
 	}
@@ -204,7 +442,13 @@ func (m WallModel) Update(wall *Wall) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.Exec(ctx, query, args...)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, query, args...)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -215,7 +459,12 @@ func (m WallModel) Update(wall *Wall) error {
 		return err
 	}
 
-	return nil
+	err = recordWallChange(ctx, tx, wall.ID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (m WallModel) Delete(wallID int64) error {
@@ -227,55 +476,161 @@ func (m WallModel) Delete(wallID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.Exec(ctx, query, wallID)
+	tx, err := m.DB.Begin(ctx)
 	if err != nil {
-		switch {
-		case errors.Is(err, pgx.ErrNoRows):
-			var isPrimary bool
-			searchQuery := `
-				SELECT is_primary
-				FROM walls
-				WHERE id = $1`
-			err = m.DB.QueryRow(ctx, searchQuery, wallID).Scan(
-				&isPrimary,
-			)
-			if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The members are removed along with the wall, so they are looked up first
+	userIDs, err := findWallMemberIDs(ctx, tx, wallID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx, query, wallID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		var isPrimary bool
+		searchQuery := `
+			SELECT is_primary
+			FROM walls
+			WHERE id = $1`
+		err = tx.QueryRow(ctx, searchQuery, wallID).Scan(
+			&isPrimary,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, pgx.ErrNoRows):
+				return ErrRecordNotFound
+			default:
 				return err
 			}
-			if isPrimary {
-				return ErrDeletingPrimaryWall
-			}
-			return ErrRecordNotFound
-		default:
+		}
+		if isPrimary {
+			return ErrDeletingPrimaryWall
+		}
+		return ErrRecordNotFound
+	}
+
+	err = recordUserChanges(ctx, tx, userIDs, ChangeEntityWall, wallID, ChangeOpDelete)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Pin pins a wall for one of its members. A member can pin at most maxPinned walls.
+func (m WallModel) Pin(wallID, userID int64, maxPinned int) error {
+	query := `
+		UPDATE wall_members
+		SET is_pinned = true
+		WHERE wall_id = $1 AND user_id = $2
+		AND (
+			is_pinned = true
+			OR (SELECT COUNT(*) FROM wall_members WHERE user_id = $2 AND is_pinned = true) < $3
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, wallID, userID, maxPinned)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		var isMember bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM wall_members WHERE wall_id = $1 AND user_id = $2
+			)`, wallID, userID).Scan(&isMember)
+		if err != nil {
 			return err
 		}
+		if !isMember {
+			return ErrRecordNotFound
+		}
+		return ErrPinnedWallsLimit
 	}
-	return nil
+
+	err = recordUserChange(ctx, tx, userID, ChangeEntityWall, wallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func (m WallModel) Pin(wallID int64) error {
-	// First unpin any currently pinned walls for this user
+func (m WallModel) Unpin(wallID, userID int64) error {
 	query := `
-		WITH current_wall AS (
-			SELECT user_id
-			FROM walls
-			WHERE id = $1
-		),
-		update_pinned AS (
-			UPDATE walls
-			SET is_pinned = false, updated_at = NOW()
-			WHERE user_id = (SELECT user_id FROM current_wall)
-			AND is_pinned = true
-		)
-		UPDATE walls
-		SET is_pinned = true, updated_at = NOW()
-		WHERE id = $1
+		UPDATE wall_members
+		SET is_pinned = false
+		WHERE wall_id = $1 AND user_id = $2
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.Exec(ctx, query, wallID)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, wallID, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = recordUserChange(ctx, tx, userID, ChangeEntityWall, wallID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Publish makes a wall public. A public ID is generated the first time a wall is published and
+// is kept when the wall is unpublished, so the public URL of a wall stays the same.
+func (m WallModel) Publish(wall *Wall) error {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	publicID := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	query := `
+		UPDATE walls
+		SET is_public = true, public_id = COALESCE(public_id, $2), updated_at = NOW()
+		WHERE id = $1 AND is_primary = false
+		RETURNING is_public, public_id, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, wall.ID, publicID).Scan(&wall.IsPublic, &wall.PublicID, &wall.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -285,20 +640,31 @@ func (m WallModel) Pin(wallID int64) error {
 		}
 	}
 
-	return nil
+	err = recordWallChange(ctx, tx, wall.ID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func (m WallModel) Unpin(wallID int64) error {
+func (m WallModel) Unpublish(wall *Wall) error {
 	query := `
 		UPDATE walls
-		SET is_pinned = false, updated_at = NOW()
+		SET is_public = false, updated_at = NOW()
 		WHERE id = $1
-	`
+		RETURNING updated_at`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.Exec(ctx, query, wallID)
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, wall.ID).Scan(&wall.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
@@ -308,5 +674,52 @@ func (m WallModel) Unpin(wallID int64) error {
 		}
 	}
 
+	err = recordWallChange(ctx, tx, wall.ID, ChangeOpUpsert)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	wall.IsPublic = false
+	wall.PublicID = pgtype.Text{}
 	return nil
 }
+
+// FindByPublicID returns a public wall by its public ID
+func (m WallModel) FindByPublicID(publicID string) (*Wall, error) {
+	query := `
+		SELECT id, name, emoji, color, icon, is_primary, is_public, public_id, user_id, created_at, updated_at
+		FROM walls
+		WHERE public_id = $1 AND is_public = true`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	wall := &Wall{}
+	err := m.DB.QueryRow(ctx, query, publicID).Scan(
+		&wall.ID,
+		&wall.Name,
+		&wall.Emoji,
+		&wall.Color,
+		&wall.Icon,
+		&wall.IsPrimary,
+		&wall.IsPublic,
+		&wall.PublicID,
+		&wall.UserID,
+		&wall.CreatedAt,
+		&wall.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return wall, nil
+}
@@ -0,0 +1,352 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusSucceeded = "succeeded"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+// webhookItemPayload builds the JSON object of an item in webhook payloads. It expects the
+// items table as i and the feeds table as f.
+const webhookItemPayload = `JSONB_BUILD_OBJECT(
+	'id', i.id,
+	'title', i.title,
+	'link', i.link,
+	'pub_date', i.pub_date,
+	'image_url', i.image_url,
+	'feed', JSONB_BUILD_OBJECT('id', f.id, 'title', COALESCE(f.display_title, f.title), 'link', f.link)
+)`
+
+// WebhookDelivery is an event to be delivered, or delivered, to a webhook endpoint. The
+// response fields are those of the latest attempt.
+type WebhookDelivery struct {
+	ID             int64              `json:"id"`
+	EndpointID     int64              `json:"endpoint_id"`
+	Event          string             `json:"event"`
+	Payload        json.RawMessage    `json:"payload"`
+	Status         string             `json:"status"`
+	Attempts       int                `json:"attempts"`
+	RunAt          time.Time          `json:"next_attempt_at"`
+	ResponseStatus pgtype.Int4        `json:"response_status"`
+	ResponseBody   pgtype.Text        `json:"response_body"`
+	LastError      pgtype.Text        `json:"last_error"`
+	DurationMS     pgtype.Int4        `json:"duration_ms"`
+	DeliveredAt    pgtype.Timestamptz `json:"delivered_at"`
+	CreatedAt      time.Time          `json:"created_at"`
+
+	// Endpoint is only set for the deliveries returned by ClaimDue
+	Endpoint *WebhookEndpoint `json:"-"`
+}
+
+// WebhookAttempt is the outcome of an attempt to deliver a webhook. The response fields are not
+// set when no response was received.
+type WebhookAttempt struct {
+	ResponseStatus pgtype.Int4
+	ResponseBody   pgtype.Text
+	Error          pgtype.Text
+	Duration       time.Duration
+}
+
+type WebhookDeliveryModel struct {
+	DB *pgxpool.Pool
+}
+
+// EnqueueItemsCreated creates the item.created deliveries of new items. Endpoints of users
+// receive the items which show up in their walls, and system-wide endpoints receive all items.
+func (m WebhookDeliveryModel) EnqueueItemsCreated(itemIDs []int64) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+		SELECT matches.endpoint_id, 'item.created', JSONB_BUILD_OBJECT('item', ` + webhookItemPayload + `, 'wall_ids', matches.wall_ids)
+		FROM (
+			SELECT e.id AS endpoint_id, items.id AS item_id, ARRAY_AGG(DISTINCT wall_feeds.wall_id) AS wall_ids
+			FROM items
+			INNER JOIN wall_feeds ON wall_feeds.feed_id = items.feed_id
+			INNER JOIN wall_members ON wall_members.wall_id = wall_feeds.wall_id
+			INNER JOIN webhook_endpoints e ON e.user_id = wall_members.user_id
+			WHERE items.id = ANY($1)
+			AND e.enabled AND 'item.created' = ANY(e.events)
+			AND (CARDINALITY(e.wall_ids) = 0 OR wall_feeds.wall_id = ANY(e.wall_ids))
+			AND ` + wallFeedItemsCondition + `
+			GROUP BY e.id, items.id
+			UNION ALL
+			SELECT e.id, items.id, '{}'::bigint[]
+			FROM items
+			CROSS JOIN webhook_endpoints e
+			WHERE items.id = ANY($1)
+			AND e.user_id IS NULL AND e.enabled AND 'item.created' = ANY(e.events)
+		) matches
+		INNER JOIN items i ON i.id = matches.item_id
+		INNER JOIN feeds f ON f.id = i.feed_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, itemIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// EnqueueFeedFailed creates the feed.failed deliveries of a feed which could not be refreshed.
+// Endpoints of users receive the failures of the feeds they follow.
+func (m WebhookDeliveryModel) EnqueueFeedFailed(feed *Feed) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+		SELECT e.id, 'feed.failed', JSONB_BUILD_OBJECT(
+			'feed', JSONB_BUILD_OBJECT('id', f.id, 'title', COALESCE(f.display_title, f.title), 'link', f.link, 'feed_link', f.feed_link),
+			'error', $2::text,
+			'failed_at', $3::timestamptz
+		)
+		FROM webhook_endpoints e
+		CROSS JOIN feeds f
+		WHERE f.id = $1
+		AND e.enabled AND 'feed.failed' = ANY(e.events)
+		AND (
+			e.user_id IS NULL
+			OR e.user_id IN (SELECT user_id FROM feed_follows WHERE feed_id = $1)
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, feed.ID, feed.LastFailure.String, feed.LastFailureAt.Time)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// enqueueWebhookItemSaved creates the item.saved deliveries of an item saved by a user within
+// the transaction which saves it
+func enqueueWebhookItemSaved(ctx context.Context, tx pgx.Tx, userID, itemID int64) error {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event, payload)
+		SELECT e.id, 'item.saved', JSONB_BUILD_OBJECT(
+			'item', ` + webhookItemPayload + `,
+			'user', JSONB_BUILD_OBJECT('id', u.id, 'username', u.username)
+		)
+		FROM webhook_endpoints e
+		CROSS JOIN items i
+		INNER JOIN feeds f ON f.id = i.feed_id
+		INNER JOIN users u ON u.id = $1
+		WHERE i.id = $2
+		AND e.enabled AND 'item.saved' = ANY(e.events)
+		AND (e.user_id IS NULL OR e.user_id = $1)`
+
+	_, err := tx.Exec(ctx, query, userID, itemID)
+	return err
+}
+
+// ClaimDue claims up to limit pending deliveries of enabled endpoints which are due. Claimed
+// deliveries are leased by moving them into the future, so a delivery whose worker went away is
+// picked up again once the lease runs out. Each claim counts as an attempt.
+func (m WebhookDeliveryModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries d
+			SET run_at = NOW() + $2::double precision * INTERVAL '1 second', attempts = d.attempts + 1
+			FROM (
+				SELECT webhook_deliveries.id FROM webhook_deliveries
+				INNER JOIN webhook_endpoints ON webhook_endpoints.id = webhook_deliveries.endpoint_id
+				WHERE webhook_deliveries.status = 'pending' AND webhook_deliveries.run_at <= NOW()
+				AND webhook_endpoints.enabled
+				ORDER BY webhook_deliveries.run_at
+				LIMIT $1
+				FOR UPDATE OF webhook_deliveries SKIP LOCKED
+			) due
+			WHERE d.id = due.id
+			RETURNING d.id, d.endpoint_id, d.event, d.payload, d.attempts, d.created_at
+		)
+		SELECT c.id, c.endpoint_id, c.event, c.payload, c.attempts, c.created_at, e.user_id, e.url, e.secret
+		FROM claimed c
+		INNER JOIN webhook_endpoints e ON e.id = c.endpoint_id
+		ORDER BY c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*WebhookDelivery, error) {
+		delivery := &WebhookDelivery{Status: WebhookDeliveryStatusPending, Endpoint: &WebhookEndpoint{}}
+		err := row.Scan(
+			&delivery.ID,
+			&delivery.EndpointID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Attempts,
+			&delivery.CreatedAt,
+			&delivery.Endpoint.UserID,
+			&delivery.Endpoint.URL,
+			&delivery.Endpoint.Secret,
+		)
+		delivery.Endpoint.ID = delivery.EndpointID
+		return delivery, err
+	})
+}
+
+// MarkSucceeded records a successful attempt and resets the failures of the endpoint
+func (m WebhookDeliveryModel) MarkSucceeded(delivery *WebhookDelivery, attempt WebhookAttempt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'succeeded', response_status = $2, response_body = $3, last_error = NULL,
+			duration_ms = $4, delivered_at = NOW()
+		WHERE id = $1`,
+		delivery.ID, attempt.ResponseStatus, attempt.ResponseBody, attempt.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE webhook_endpoints
+		SET consecutive_failures = 0
+		WHERE id = $1 AND consecutive_failures > 0`,
+		delivery.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Retry records a failed attempt. The delivery is retried with exponential backoff, or failed
+// once it was attempted maxAttempts times. The endpoint is disabled once maxFailures attempts
+// failed in a row, and Retry reports whether this attempt disabled it.
+func (m WebhookDeliveryModel) Retry(delivery *WebhookDelivery, attempt WebhookAttempt, maxAttempts int, backoff time.Duration, maxFailures int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN attempts >= $2 THEN 'failed' ELSE 'pending' END,
+			run_at = NOW() + $3::double precision * POWER(2, attempts - 1) * INTERVAL '1 second',
+			response_status = $4, response_body = $5, last_error = $6, duration_ms = $7
+		WHERE id = $1`,
+		delivery.ID, maxAttempts, backoff.Seconds(),
+		attempt.ResponseStatus, attempt.ResponseBody, attempt.Error, attempt.Duration.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+
+	var enabled, disabled bool
+	err = tx.QueryRow(ctx, `
+		UPDATE webhook_endpoints
+		SET consecutive_failures = consecutive_failures + 1,
+			enabled = enabled AND consecutive_failures + 1 < $2,
+			disabled_reason = CASE
+				WHEN enabled AND consecutive_failures + 1 >= $2 THEN $3
+				ELSE disabled_reason
+			END
+		WHERE id = $1
+		RETURNING enabled, consecutive_failures = $2`,
+		delivery.EndpointID, maxFailures, fmt.Sprintf("Disabled after %d failed delivery attempts in a row", maxFailures),
+	).Scan(&enabled, &disabled)
+	if err != nil {
+		return false, err
+	}
+
+	if !enabled {
+		err = failPendingWebhookDeliveries(ctx, tx, delivery.EndpointID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return disabled, tx.Commit(ctx)
+}
+
+// FindAllForEndpoint returns the latest deliveries of an endpoint, newest first
+func (m WebhookDeliveryModel) FindAllForEndpoint(endpointID int64, limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT id, endpoint_id, event, payload, status, attempts, run_at, response_status, response_body,
+			last_error, duration_ms, delivered_at, created_at
+		FROM webhook_deliveries
+		WHERE endpoint_id = $1
+		ORDER BY id DESC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, endpointID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*WebhookDelivery, error) {
+		var delivery WebhookDelivery
+		err := row.Scan(
+			&delivery.ID,
+			&delivery.EndpointID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.RunAt,
+			&delivery.ResponseStatus,
+			&delivery.ResponseBody,
+			&delivery.LastError,
+			&delivery.DurationMS,
+			&delivery.DeliveredAt,
+			&delivery.CreatedAt,
+		)
+		return &delivery, err
+	})
+}
+
+// Cleanup deletes the deliveries which were created before the given time and are no longer
+// pending
+func (m WebhookDeliveryModel) Cleanup(before time.Time) error {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE created_at < $1 AND status <> 'pending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.Exec(ctx, query, before)
+	return err
+}
+
+// failPendingWebhookDeliveries fails the pending deliveries of a disabled endpoint
+func failPendingWebhookDeliveries(ctx context.Context, tx pgx.Tx, endpointID int64) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'failed', last_error = 'Endpoint was disabled'
+		WHERE endpoint_id = $1 AND status = 'pending'`
+
+	_, err := tx.Exec(ctx, query, endpointID)
+	return err
+}
@@ -0,0 +1,282 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	WebhookEventItemCreated = "item.created"
+	WebhookEventItemSaved   = "item.saved"
+	WebhookEventFeedFailed  = "feed.failed"
+)
+
+var WebhookEvents = []string{WebhookEventItemCreated, WebhookEventItemSaved, WebhookEventFeedFailed}
+
+// WebhookEndpoint is an HTTPS endpoint which receives the events it subscribes to. Endpoints of
+// users receive the events of their walls, saved items and followed feeds. System-wide endpoints
+// have no user and receive the events of all users. The wall ids restrict item.created events
+// to the given walls.
+//
+// Models take an owner id to scope endpoints, which is the id of the user or 0 for system-wide
+// endpoints.
+type WebhookEndpoint struct {
+	ID                  int64       `json:"id"`
+	UserID              pgtype.Int8 `json:"-"`
+	URL                 string      `json:"url"`
+	Secret              string      `json:"-"`
+	Events              []string    `json:"events"`
+	WallIDs             []int64     `json:"wall_ids"`
+	Description         string      `json:"description"`
+	Enabled             bool        `json:"enabled"`
+	DisabledReason      pgtype.Text `json:"disabled_reason"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	CreatedAt           time.Time   `json:"created_at"`
+	UpdatedAt           time.Time   `json:"updated_at"`
+	Version             int32       `json:"version"`
+}
+
+type WebhookEndpointModel struct {
+	DB *pgxpool.Pool
+}
+
+func ValidateWebhookEndpoint(v *validator.Validator, endpoint *WebhookEndpoint) {
+	endpointURL, err := url.Parse(endpoint.URL)
+	v.Check(err == nil && endpointURL.Scheme == "https" && endpointURL.Host != "", "url", "Must be a valid https URL")
+	v.Check(validator.MaxChars(endpoint.URL, 2048), "url", "Must not be more than 2048 characters long")
+
+	v.Check(len(endpoint.Events) > 0, "events", "Must contain at least one event")
+	v.Check(validator.Unique(endpoint.Events), "events", "Must not contain duplicate events")
+	for _, event := range endpoint.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "Events must be one of item.created, item.saved or feed.failed")
+	}
+
+	if endpoint.UserID.Valid {
+		v.Check(len(endpoint.WallIDs) <= 20, "wall_ids", "Must not contain more than 20 walls")
+		v.Check(validator.Unique(endpoint.WallIDs), "wall_ids", "Must not contain duplicate walls")
+		for _, wallID := range endpoint.WallIDs {
+			v.Check(wallID > 0, "wall_ids", "Must contain valid wall ids")
+		}
+	} else {
+		v.Check(len(endpoint.WallIDs) == 0, "wall_ids", "Must be empty for system-wide webhooks")
+	}
+
+	v.Check(validator.MaxChars(endpoint.Description, 200), "description", "Must not be more than 200 characters long")
+}
+
+// Insert creates an endpoint. ErrRecordNotFound is returned if the user is not a member of one
+// of the walls.
+func (m WebhookEndpointModel) Insert(endpoint *WebhookEndpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if endpoint.UserID.Valid {
+		err = checkWallMemberships(ctx, tx, endpoint.UserID.Int64, endpoint.WallIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO webhook_endpoints (user_id, url, secret, events, wall_ids, description)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, enabled, created_at, updated_at, version`
+
+	args := []any{
+		endpoint.UserID,
+		endpoint.URL,
+		endpoint.Secret,
+		endpoint.Events,
+		endpoint.WallIDs,
+		endpoint.Description,
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(
+		&endpoint.ID,
+		&endpoint.Enabled,
+		&endpoint.CreatedAt,
+		&endpoint.UpdatedAt,
+		&endpoint.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (m WebhookEndpointModel) Get(id, ownerID int64) (*WebhookEndpoint, error) {
+	query := `
+		SELECT id, user_id, url, secret, events, wall_ids, description, enabled, disabled_reason,
+			consecutive_failures, created_at, updated_at, version
+		FROM webhook_endpoints
+		WHERE id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, 0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := pgx.CollectOneRow(rows, scanWebhookEndpoint)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return endpoint, nil
+}
+
+func (m WebhookEndpointModel) FindAllForOwner(ownerID int64) ([]*WebhookEndpoint, error) {
+	query := `
+		SELECT id, user_id, url, secret, events, wall_ids, description, enabled, disabled_reason,
+			consecutive_failures, created_at, updated_at, version
+		FROM webhook_endpoints
+		WHERE user_id IS NOT DISTINCT FROM NULLIF($1, 0)
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, scanWebhookEndpoint)
+}
+
+func (m WebhookEndpointModel) CountForOwner(ownerID int64) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM webhook_endpoints
+		WHERE user_id IS NOT DISTINCT FROM NULLIF($1, 0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRow(ctx, query, ownerID).Scan(&count)
+	return count, err
+}
+
+// Update saves the changes to an endpoint. Enabling an endpoint resets its failures, and
+// disabling it fails its pending deliveries, so that they are not sent in a burst when the
+// endpoint is enabled again.
+func (m WebhookEndpointModel) Update(endpoint *WebhookEndpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if endpoint.UserID.Valid {
+		err = checkWallMemberships(ctx, tx, endpoint.UserID.Int64, endpoint.WallIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if endpoint.Enabled {
+		endpoint.DisabledReason = pgtype.Text{}
+	}
+
+	query := `
+		UPDATE webhook_endpoints
+		SET url = $1, events = $2, wall_ids = $3, description = $4, enabled = $5, disabled_reason = $6,
+			consecutive_failures = CASE WHEN $5 AND NOT enabled THEN 0 ELSE consecutive_failures END,
+			updated_at = NOW(), version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING consecutive_failures, updated_at, version`
+
+	args := []any{
+		endpoint.URL,
+		endpoint.Events,
+		endpoint.WallIDs,
+		endpoint.Description,
+		endpoint.Enabled,
+		endpoint.DisabledReason,
+		endpoint.ID,
+		endpoint.Version,
+	}
+
+	err = tx.QueryRow(ctx, query, args...).Scan(&endpoint.ConsecutiveFailures, &endpoint.UpdatedAt, &endpoint.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if !endpoint.Enabled {
+		err = failPendingWebhookDeliveries(ctx, tx, endpoint.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (m WebhookEndpointModel) Delete(id, ownerID int64) error {
+	query := `
+		DELETE FROM webhook_endpoints
+		WHERE id = $1 AND user_id IS NOT DISTINCT FROM NULLIF($2, 0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id, ownerID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func scanWebhookEndpoint(row pgx.CollectableRow) (*WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	err := row.Scan(
+		&endpoint.ID,
+		&endpoint.UserID,
+		&endpoint.URL,
+		&endpoint.Secret,
+		&endpoint.Events,
+		&endpoint.WallIDs,
+		&endpoint.Description,
+		&endpoint.Enabled,
+		&endpoint.DisabledReason,
+		&endpoint.ConsecutiveFailures,
+		&endpoint.CreatedAt,
+		&endpoint.UpdatedAt,
+		&endpoint.Version,
+	)
+	return &endpoint, err
+}
@@ -0,0 +1,288 @@
+package events
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyEventsPrefix      = "events:"
+	keyStreamPrefix      = "events_stream:"
+	keyConnectionsPrefix = "events_connections:"
+
+	// Number of recent events kept for each topic to resume streams
+	streamMaxLen = 100
+	// Streams of topics without new events are removed after this time
+	streamTTL = 24 * time.Hour
+
+	// Number of events buffered for a subscriber before it is dropped
+	subscriptionBufferSize = 16
+)
+
+// Event is a message published on a topic. IDs are increasing within a topic and are
+// used by subscribers to resume from the last event they received.
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// Subscription receives the events of a topic on C. C is closed when the subscriber falls
+// behind or the broker is closed, and the subscriber is expected to resume from the last
+// event it received.
+type Subscription struct {
+	C     <-chan Event
+	c     chan Event
+	topic string
+}
+
+// Broker publishes events through Redis so that subscribers connected to any replica receive
+// them. Each replica holds a single Redis subscription and fans the events out to its local
+// subscribers.
+type Broker struct {
+	rdb *redis.Client
+
+	mu            sync.Mutex
+	subscriptions map[string]map[*Subscription]bool
+	closed        bool
+}
+
+func NewBroker(rdb *redis.Client) *Broker {
+	return &Broker{
+		rdb:           rdb,
+		subscriptions: make(map[string]map[*Subscription]bool),
+	}
+}
+
+// The event is added to the stream of the topic and published in the same script so that
+// events are published in the order of their IDs
+var publishScript = redis.NewScript(`
+	local id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', 'type', ARGV[2], 'data', ARGV[3])
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+	redis.call('PUBLISH', ARGV[5], cjson.encode({id = id, type = ARGV[2], data = ARGV[3]}))
+	return id
+`)
+
+// Publish publishes an event on a topic and returns its ID
+func (b *Broker) Publish(ctx context.Context, topic, eventType string, data any) (string, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return publishScript.Run(ctx, b.rdb,
+		[]string{keyStreamPrefix + topic},
+		streamMaxLen, eventType, string(js), int(streamTTL.Seconds()), keyEventsPrefix+topic,
+	).Text()
+}
+
+// Since returns the events of a topic after the given event ID. complete is false if older
+// events were already removed and some events after lastID may be missing.
+func (b *Broker) Since(ctx context.Context, topic, lastID string) (events []Event, complete bool, err error) {
+	key := keyStreamPrefix + topic
+
+	oldest, err := b.rdb.XRangeN(ctx, key, "-", "+", 1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(oldest) == 0 {
+		return nil, true, nil
+	}
+
+	messages, err := b.rdb.XRange(ctx, key, "("+lastID, "+").Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	events = make([]Event, 0, len(messages))
+	for _, message := range messages {
+		eventType, _ := message.Values["type"].(string)
+		data, _ := message.Values["data"].(string)
+		events = append(events, Event{ID: message.ID, Type: eventType, Data: data})
+	}
+
+	return events, CompareIDs(lastID, oldest[0].ID) >= 0, nil
+}
+
+// Run receives the events published on all topics and delivers them to the local subscribers
+// until the context is cancelled
+func (b *Broker) Run(ctx context.Context) {
+	pubsub := b.rdb.PSubscribe(ctx, keyEventsPrefix+"*")
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var event Event
+			err := json.Unmarshal([]byte(message.Payload), &event)
+			if err != nil {
+				// Skip messages which were not published by Publish
+				continue
+			}
+
+			b.deliver(strings.TrimPrefix(message.Channel, keyEventsPrefix), event)
+		}
+	}
+}
+
+func (b *Broker) deliver(topic string, event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscriptions[topic] {
+		select {
+		case sub.c <- event:
+		default:
+			// Drop subscribers that fall behind instead of blocking the others. They resume
+			// from their last event once they reconnect.
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribe subscribes to the events published on a topic from now on
+func (b *Broker) Subscribe(topic string) *Subscription {
+	c := make(chan Event, subscriptionBufferSize)
+	sub := &Subscription{C: c, c: c, topic: topic}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(c)
+		return sub
+	}
+
+	if b.subscriptions[topic] == nil {
+		b.subscriptions[topic] = make(map[*Subscription]bool)
+	}
+	b.subscriptions[topic][sub] = true
+
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// remove removes a subscription and closes its channel. b.mu must be held.
+func (b *Broker) remove(sub *Subscription) {
+	subs, ok := b.subscriptions[sub.topic]
+	if !ok || !subs[sub] {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subscriptions, sub.topic)
+	}
+	close(sub.c)
+}
+
+// Close closes all the subscriptions so that long-lived streams end on shutdown
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subscriptions {
+		for sub := range subs {
+			b.remove(sub)
+		}
+	}
+}
+
+// Connections are tracked in a sorted set scored by the time they were last seen, so the
+// connections of a replica that went away without releasing them expire on their own
+var acquireConnectionScript = redis.NewScript(`
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+	if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+		return 0
+	end
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+	return 1
+`)
+
+// AcquireConnection registers a connection of an owner (e.g. a user) if the owner has less
+// than limit connections. Connections which were not refreshed within staleAfter are not
+// counted. It reports whether the connection was registered.
+func (b *Broker) AcquireConnection(ctx context.Context, owner, connectionID string, limit int, staleAfter time.Duration) (bool, error) {
+	now := time.Now()
+
+	acquired, err := acquireConnectionScript.Run(ctx, b.rdb,
+		[]string{keyConnectionsPrefix + owner},
+		now.Add(-staleAfter).UnixMilli(), limit, now.UnixMilli(), connectionID, staleAfter.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return acquired == 1, nil
+}
+
+// RefreshConnection marks a connection as still open
+func (b *Broker) RefreshConnection(ctx context.Context, owner, connectionID string, staleAfter time.Duration) error {
+	key := keyConnectionsPrefix + owner
+
+	_, err := b.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddXX(ctx, key, redis.Z{Score: float64(time.Now().UnixMilli()), Member: connectionID})
+		pipe.PExpire(ctx, key, staleAfter)
+		return nil
+	})
+	return err
+}
+
+func (b *Broker) ReleaseConnection(ctx context.Context, owner, connectionID string) error {
+	return b.rdb.ZRem(ctx, keyConnectionsPrefix+owner, connectionID).Err()
+}
+
+// CompareIDs compares two event IDs of the same topic. It returns -1 if a is older than b,
+// 1 if a is newer than b and 0 if they are the same. Invalid IDs are treated as the oldest.
+func CompareIDs(a, b string) int {
+	aMillis, aSeq := parseID(a)
+	bMillis, bSeq := parseID(b)
+
+	if aMillis != bMillis {
+		return cmp.Compare(aMillis, bMillis)
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
+
+// ValidID reports whether id is a valid event ID
+func ValidID(id string) bool {
+	millis, seq, found := strings.Cut(id, "-")
+	if !found {
+		return false
+	}
+	_, err := strconv.ParseUint(millis, 10, 64)
+	if err != nil {
+		return false
+	}
+	_, err = strconv.ParseUint(seq, 10, 64)
+	return err == nil
+}
+
+func parseID(id string) (uint64, uint64) {
+	millis, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseUint(millis, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
@@ -0,0 +1,194 @@
+// Package lockout slows down and locks out brute-force attacks on credentials. Failed attempts
+// are counted per key (such as an account or an IP address) in Redis, so that all API servers
+// share the counts. After a few free attempts every failure blocks further attempts for a
+// doubling delay, and too many failures lock the key out for a longer time.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "lockout:"
+
+// Policy configures how failures of a key are throttled
+type Policy struct {
+	// FreeAttempts is the number of failures which are not followed by a delay
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure beyond the free attempts. It doubles with
+	// every further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxFailures is the number of failures which lock the key out for LockoutDuration. Zero
+	// never locks the key out.
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// Window is the time after the last failure after which the failures are forgotten
+	Window time.Duration
+}
+
+// delay returns the time attempts are blocked for after the given number of failures
+func (p Policy) delay(failures int) time.Duration {
+	if p.MaxFailures > 0 && failures >= p.MaxFailures {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// delays returns the delays in milliseconds after 1, 2, ... failures, up to the number of
+// failures after which the delay does not change anymore
+func (p Policy) delays() []int64 {
+	n := p.FreeAttempts + 1
+	if p.MaxFailures > 0 {
+		n = max(n, p.MaxFailures)
+	} else {
+		for p.BaseDelay > 0 && p.delay(n) < p.MaxDelay {
+			n++
+		}
+	}
+
+	delays := make([]int64, n)
+	for i := range delays {
+		delays[i] = p.delay(i + 1).Milliseconds()
+	}
+	return delays
+}
+
+// Result is the outcome of an attempt which was counted as a failure
+type Result struct {
+	// Failures is the number of failures of the key within the window
+	Failures int
+	// Delay is the time further attempts are blocked for
+	Delay time.Duration
+	// LockedOut is true if this failure locked the key out. It is only true for the failure
+	// which reached the maximum, so that the lockout is reported once.
+	LockedOut bool
+}
+
+// Reservation is an attempt which was counted as a failure of its key before it was made, so
+// that concurrent attempts cannot all pass a check made before any of them failed. An attempt
+// which succeeds resets the key or releases its reservation.
+type Reservation struct {
+	// Wait is the time attempts for the key are still blocked for. If it is not zero, the
+	// attempt is not allowed and was not counted.
+	Wait time.Duration
+	// Result is the outcome of counting the attempt as a failure, if it is allowed
+	Result *Result
+
+	limiter *Limiter
+	key     string
+}
+
+// Allowed reports whether the attempt may be made
+func (r *Reservation) Allowed() bool {
+	return r.Wait == 0
+}
+
+// Limiter counts the failed attempts of the keys of one kind, such as accounts
+type Limiter struct {
+	rdb    *redis.Client
+	name   string
+	policy Policy
+}
+
+func New(rdb *redis.Client, name string, policy Policy) *Limiter {
+	return &Limiter{rdb: rdb, name: name, policy: policy}
+}
+
+func (l *Limiter) failuresKey(key string) string {
+	return fmt.Sprintf("%s%s:%s:failures", keyPrefix, l.name, key)
+}
+
+func (l *Limiter) blockedKey(key string) string {
+	return fmt.Sprintf("%s%s:%s:blocked", keyPrefix, l.name, key)
+}
+
+// The block is checked, and the failure counted and blocked for, in the same script so that no
+// attempt can be made between the check and the block. The blocked key holds the number of
+// failures it was set for, so that releasing a reservation only lifts its own block.
+var reserveScript = redis.NewScript(`
+	local wait = redis.call('PTTL', KEYS[2])
+	if wait > 0 then
+		return {0, wait}
+	end
+	local failures = redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	local delay = tonumber(ARGV[math.min(failures, #ARGV - 1) + 1])
+	if delay > 0 then
+		redis.call('SET', KEYS[2], failures, 'PX', delay)
+	end
+	return {failures, 0}
+`)
+
+var releaseScript = redis.NewScript(`
+	if redis.call('GET', KEYS[2]) == ARGV[1] then
+		redis.call('DEL', KEYS[2])
+	end
+	if tonumber(redis.call('GET', KEYS[1]) or '0') > 0 then
+		redis.call('DECR', KEYS[1])
+	end
+	return 1
+`)
+
+// Reserve counts an attempt for the key as a failure and blocks further attempts as the policy
+// says, unless attempts for the key are still blocked
+func (l *Limiter) Reserve(ctx context.Context, key string) (*Reservation, error) {
+	window := max(l.policy.Window, l.policy.LockoutDuration)
+
+	args := []any{window.Milliseconds()}
+	for _, delay := range l.policy.delays() {
+		args = append(args, delay)
+	}
+
+	values, err := reserveScript.Run(ctx, l.rdb,
+		[]string{l.failuresKey(key), l.blockedKey(key)},
+		args...,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &Reservation{limiter: l, key: key}
+	if values[1] > 0 {
+		reservation.Wait = time.Duration(values[1]) * time.Millisecond
+		return reservation, nil
+	}
+
+	failures := int(values[0])
+	reservation.Result = &Result{
+		Failures:  failures,
+		Delay:     l.policy.delay(failures),
+		LockedOut: l.policy.MaxFailures > 0 && failures == l.policy.MaxFailures,
+	}
+	return reservation, nil
+}
+
+// Release takes back the failure an allowed attempt was counted as, after it succeeded. Unlike
+// Reset, the earlier failures of the key are kept.
+func (r *Reservation) Release(ctx context.Context) error {
+	if r.Result == nil {
+		return nil
+	}
+
+	return releaseScript.Run(ctx, r.limiter.rdb,
+		[]string{r.limiter.failuresKey(r.key), r.limiter.blockedKey(r.key)},
+		r.Result.Failures,
+	).Err()
+}
+
+// Reset forgets the failures of the key and unblocks it, after a successful attempt or when the
+// owner of an account unlocks it
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.rdb.Del(ctx, l.failuresKey(key), l.blockedKey(key)).Err()
+}
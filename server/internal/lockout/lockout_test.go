@@ -0,0 +1,52 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPolicyDelays checks that the delays passed to the reserve script give the delay of the
+// policy for any number of failures, with the last one used for all further failures
+func TestPolicyDelays(t *testing.T) {
+	policies := map[string]Policy{
+		"lockout": {
+			FreeAttempts:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        30 * time.Second,
+			MaxFailures:     10,
+			LockoutDuration: 15 * time.Minute,
+		},
+		"lockout within the free attempts": {
+			FreeAttempts:    5,
+			BaseDelay:       time.Second,
+			MaxDelay:        30 * time.Second,
+			MaxFailures:     3,
+			LockoutDuration: 15 * time.Minute,
+		},
+		"no lockout": {
+			FreeAttempts: 3,
+			BaseDelay:    time.Minute,
+			MaxDelay:     15 * time.Minute,
+		},
+		"no delay": {
+			FreeAttempts: 3,
+		},
+	}
+
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			delays := policy.delays()
+			if len(delays) == 0 {
+				t.Fatal("got no delays")
+			}
+
+			for failures := 1; failures <= 50; failures++ {
+				got := delays[min(failures, len(delays))-1]
+				want := policy.delay(failures).Milliseconds()
+				if got != want {
+					t.Errorf("got delay %dms after %d failures; want %dms", got, failures, want)
+				}
+			}
+		})
+	}
+}
@@ -3,7 +3,13 @@ package mailer
 import (
 	"bytes"
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-mail/mail/v2"
@@ -12,60 +18,159 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+// ErrTemplate is returned when an email cannot be rendered from its template
+var ErrTemplate = errors.New("mailer: invalid template")
+
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport   Transport
+	sender      string
+	templateDir string
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-
+// New returns a mailer which sends emails through the transport. Templates found in
+// templateDir override the embedded templates of the same name, and are read on every send so
+// that they can be edited without a restart. An empty templateDir uses the embedded templates
+// only.
+func New(transport Transport, sender, templateDir string) Mailer {
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		transport:   transport,
+		sender:      sender,
+		templateDir: templateDir,
 	}
 }
 
+// Rendered is an email rendered from a template
+type Rendered struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
 func (m Mailer) Send(recipient, templateFile string, data any) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	return m.SendWithHeaders(recipient, templateFile, data, nil)
+}
+
+// SendWithHeaders sends an email like Send, with additional headers such as List-Unsubscribe
+func (m Mailer) SendWithHeaders(recipient, templateFile string, data any, headers map[string]string) error {
+	msg, err := m.compose(recipient, templateFile, data, headers)
 	if err != nil {
 		return err
 	}
 
+	// retry sending email three times in case of error
+	for i := 1; i <= 3; i++ {
+		err = m.transport.Send(msg)
+		if nil == err {
+			return nil
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
+
+// Deliver sends an email once, without retrying. It is used by the email outbox dispatcher,
+// which retries failed emails with backoff.
+func (m Mailer) Deliver(recipient, templateFile string, data any) error {
+	msg, err := m.compose(recipient, templateFile, data, nil)
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Send(msg)
+}
+
+// Render renders the subject and the bodies of a template with the given data
+func (m Mailer) Render(templateFile string, data any) (*Rendered, error) {
+	tmpl, err := m.parseTemplate(templateFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTemplate, err)
+	}
+
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %w", ErrTemplate, err)
 	}
 
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %w", ErrTemplate, err)
 	}
 
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %w", ErrTemplate, err)
+	}
+
+	return &Rendered{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+func (m Mailer) compose(recipient, templateFile string, data any, headers map[string]string) (*mail.Message, error) {
+	rendered, err := m.Render(templateFile, data)
+	if err != nil {
+		return nil, err
 	}
 
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	msg.SetHeader("Subject", rendered.Subject)
+	for name, value := range headers {
+		msg.SetHeader(name, value)
+	}
+	msg.SetBody("text/plain", rendered.PlainBody)
+	msg.AddAlternative("text/html", rendered.HTMLBody)
 
-	// retry sending email three times in case of error
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if nil == err {
-			return nil
+	return msg, nil
+}
+
+// parseTemplate parses a template from the template directory if it exists there, and from
+// the embedded templates otherwise
+func (m Mailer) parseTemplate(templateFile string) (*template.Template, error) {
+	if templateFile != filepath.Base(templateFile) || !strings.HasSuffix(templateFile, ".tmpl") {
+		return nil, fmt.Errorf("invalid template name %q", templateFile)
+	}
+
+	if m.templateDir != "" {
+		path := filepath.Join(m.templateDir, templateFile)
+		_, err := os.Stat(path)
+		switch {
+		case err == nil:
+			return template.New("email").ParseFiles(path)
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
 		}
+	}
 
-		time.Sleep(500 * time.Millisecond)
+	return template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+}
+
+// TemplateExists reports whether a template exists in the template directory or among the
+// embedded templates
+func (m Mailer) TemplateExists(templateFile string) bool {
+	_, err := m.parseTemplate(templateFile)
+	return err == nil
+}
+
+// IsPermanent reports whether sending an email failed for a reason which retrying cannot fix,
+// such as a broken template or a mail server rejecting the recipient
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrTemplate) {
+		return true
 	}
-	return err
+
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		err = sendErr.Cause
+	}
+
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
 }
@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// Transport delivers composed messages, e.g. to an SMTP server
+type Transport interface {
+	Send(msg *mail.Message) error
+}
+
+// SMTPTransport sends messages to an SMTP server
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPTransport{dialer: dialer}
+}
+
+func (t *SMTPTransport) Send(msg *mail.Message) error {
+	return t.dialer.DialAndSend(msg)
+}
+
+// FileTransport writes messages to a maildir instead of sending them, so that emails can be
+// read with a mail client during development
+type FileTransport struct {
+	dir string
+}
+
+// NewFileTransport returns a transport which writes messages to the maildir at dir, creating
+// the maildir if needed
+func NewFileTransport(dir string) (*FileTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(msg *mail.Message) error {
+	randomBytes := make([]byte, 8)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	// Messages are written to tmp/ first and then moved into new/, so that mail clients never
+	// see a partially written message
+	name := fmt.Sprintf("%d.%s.semaphore.eml", time.Now().UnixNano(), hex.EncodeToString(randomBytes))
+	tmpPath := filepath.Join(t.dir, "tmp", name)
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(t.dir, "new", name))
+}
+
+// LogTransport logs messages instead of sending them
+type LogTransport struct {
+	logger *slog.Logger
+}
+
+func NewLogTransport(logger *slog.Logger) *LogTransport {
+	return &LogTransport{logger: logger}
+}
+
+func (t *LogTransport) Send(msg *mail.Message) error {
+	var raw strings.Builder
+	_, err := msg.WriteTo(&raw)
+	if err != nil {
+		return err
+	}
+
+	t.logger.Info("email not sent by the log transport",
+		"to", strings.Join(msg.GetHeader("To"), ", "),
+		"subject", strings.Join(msg.GetHeader("Subject"), " "),
+		"message", raw.String(),
+	)
+	return nil
+}
+
+// MemoryTransport records messages in memory instead of sending them. It is used in tests,
+// where no SMTP server is available.
+type MemoryTransport struct {
+	// Err is returned by Send when set, to simulate an unavailable mail server
+	Err error
+
+	mu       sync.Mutex
+	messages []*mail.Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(msg *mail.Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (t *MemoryTransport) Messages() []*mail.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*mail.Message(nil), t.messages...)
+}
@@ -0,0 +1,30 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/aravindmathradan/semaphore/internal/mailer"
+)
+
+// EmailDigestProvider sends the notifications of a recipient as a single digest email
+type EmailDigestProvider struct {
+	mailer mailer.Mailer
+}
+
+func NewEmailDigestProvider(m mailer.Mailer) *EmailDigestProvider {
+	return &EmailDigestProvider{mailer: m}
+}
+
+func (p *EmailDigestProvider) Deliver(ctx context.Context, recipient Recipient, notifications []Notification) error {
+	if recipient.Email == "" {
+		return ErrNoDestination
+	}
+
+	data := map[string]any{
+		"name":          recipient.Name,
+		"username":      recipient.Username,
+		"notifications": notifications,
+	}
+
+	return p.mailer.Send(recipient.Email, "notification_digest.tmpl", data)
+}
@@ -0,0 +1,52 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+)
+
+// Delivery is a call to Deliver recorded by FakeProvider
+type Delivery struct {
+	Channel       string
+	Recipient     Recipient
+	Notifications []Notification
+}
+
+// FakeProvider records deliveries in memory instead of sending them. It is used for local
+// development and tests, where no FCM project, SMTP server or webhook receiver is available.
+type FakeProvider struct {
+	Channel string
+	// Err is returned by Deliver when set, to simulate failing deliveries
+	Err error
+
+	mu         sync.Mutex
+	deliveries []Delivery
+}
+
+func NewFakeProvider(channel string) *FakeProvider {
+	return &FakeProvider{Channel: channel}
+}
+
+func (p *FakeProvider) Deliver(ctx context.Context, recipient Recipient, notifications []Notification) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Err != nil {
+		return p.Err
+	}
+
+	p.deliveries = append(p.deliveries, Delivery{
+		Channel:       p.Channel,
+		Recipient:     recipient,
+		Notifications: notifications,
+	})
+	return nil
+}
+
+// Deliveries returns the deliveries recorded so far
+func (p *FakeProvider) Deliveries() []Delivery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Delivery(nil), p.deliveries...)
+}
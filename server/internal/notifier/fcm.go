@@ -0,0 +1,139 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMProvider sends push notifications to the registered devices of a user through the
+// Firebase Cloud Messaging HTTP v1 API
+type FCMProvider struct {
+	client    *http.Client
+	projectID string
+
+	// OnInvalidToken is called with the tokens FCM reports as no longer registered
+	OnInvalidToken func(token string)
+}
+
+// NewFCMProvider creates a provider from the JSON key of a Firebase service account
+func NewFCMProvider(ctx context.Context, credentialsJSON []byte) (*FCMProvider, error) {
+	creds, err := google.CredentialsFromJSON(ctx, credentialsJSON, fcmScope)
+	if err != nil {
+		return nil, err
+	}
+	if creds.ProjectID == "" {
+		return nil, errors.New("notifier: FCM credentials do not contain a project id")
+	}
+
+	return &FCMProvider{
+		client:    oauth2.NewClient(ctx, creds.TokenSource),
+		projectID: creds.ProjectID,
+	}, nil
+}
+
+type fcmMessage struct {
+	Message struct {
+		Token        string            `json:"token"`
+		Notification fcmNotification   `json:"notification"`
+		Data         map[string]string `json:"data"`
+	} `json:"message"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Image string `json:"image,omitempty"`
+}
+
+// Deliver sends every notification to every device of the recipient. It fails only if
+// a notification could not be delivered to any of the devices.
+func (p *FCMProvider) Deliver(ctx context.Context, recipient Recipient, notifications []Notification) error {
+	if len(recipient.DeviceTokens) == 0 {
+		return ErrNoDestination
+	}
+
+	for _, n := range notifications {
+		var delivered bool
+		var lastErr error
+		for _, token := range recipient.DeviceTokens {
+			var msg fcmMessage
+			msg.Message.Token = token
+			msg.Message.Notification = fcmNotification{
+				Title: n.FeedTitle,
+				Body:  n.Title,
+				Image: n.ImageURL,
+			}
+			msg.Message.Data = map[string]string{
+				"item_id": strconv.FormatInt(n.ItemID, 10),
+				"feed_id": strconv.FormatInt(n.FeedID, 10),
+				"link":    n.Link,
+			}
+
+			err := p.send(ctx, msg)
+			if err != nil {
+				var tokenErr *invalidTokenError
+				if errors.As(err, &tokenErr) && p.OnInvalidToken != nil {
+					p.OnInvalidToken(token)
+				}
+				lastErr = err
+				continue
+			}
+			delivered = true
+		}
+
+		if !delivered {
+			return lastErr
+		}
+	}
+
+	return nil
+}
+
+type invalidTokenError struct {
+	status int
+}
+
+func (e *invalidTokenError) Error() string {
+	return fmt.Sprintf("notifier: FCM token is not registered (status %d)", e.status)
+}
+
+func (p *FCMProvider) send(ctx context.Context, msg fcmMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", p.projectID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return nil
+	case res.StatusCode == http.StatusNotFound:
+		return &invalidTokenError{status: res.StatusCode}
+	default:
+		resBody, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("notifier: FCM responded with status %d: %s", res.StatusCode, resBody)
+	}
+}
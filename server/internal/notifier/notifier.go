@@ -0,0 +1,38 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoDestination is returned by providers when the recipient has nowhere to receive the
+// notifications, e.g. no registered devices. Such notifications are not retried.
+var ErrNoDestination = errors.New("notifier: recipient has no destination")
+
+// Notification tells a user about a new item
+type Notification struct {
+	ItemID    int64     `json:"item_id"`
+	Title     string    `json:"title"`
+	Link      string    `json:"link"`
+	FeedID    int64     `json:"feed_id"`
+	FeedTitle string    `json:"feed_title"`
+	ImageURL  string    `json:"image_url,omitempty"`
+	PubDate   time.Time `json:"pub_date"`
+}
+
+// Recipient is a user with the destinations a provider may deliver to
+type Recipient struct {
+	UserID       int64
+	Name         string
+	Username     string
+	Email        string
+	DeviceTokens []string
+	WebhookURL   string
+}
+
+// Provider delivers notifications through a channel. All the notifications passed in a single
+// call are for the same recipient, and providers may deliver them together (e.g. as a digest).
+type Provider interface {
+	Deliver(ctx context.Context, recipient Recipient, notifications []Notification) error
+}
@@ -0,0 +1,50 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aravindmathradan/semaphore/internal/webhooks"
+)
+
+// WebhookProvider posts the notifications of a recipient as JSON to the webhook URL of the
+// recipient. Webhook URLs are set by users, so they are sent with the webhook sender which only
+// reaches public addresses and does not follow redirects.
+type WebhookProvider struct {
+	sender *webhooks.Sender
+}
+
+func NewWebhookProvider(sender *webhooks.Sender) *WebhookProvider {
+	return &WebhookProvider{sender: sender}
+}
+
+func (p *WebhookProvider) Deliver(ctx context.Context, recipient Recipient, notifications []Notification) error {
+	if recipient.WebhookURL == "" {
+		return ErrNoDestination
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"event":         "notifications",
+		"notifications": notifications,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := webhooks.Message{
+		Event: "notifications",
+		Body:  body,
+	}
+
+	result, err := p.sender.Send(ctx, recipient.WebhookURL, "", msg, false)
+	if err != nil {
+		return err
+	}
+
+	if !result.OK() {
+		return fmt.Errorf("notifier: webhook responded with status %d", result.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aravindmathradan/semaphore/internal/webhooks"
+)
+
+func TestWebhookProviderRefusesPrivateAddresses(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer srv.Close()
+
+	provider := NewWebhookProvider(webhooks.NewSender(""))
+	err := provider.Deliver(context.Background(), Recipient{WebhookURL: srv.URL}, []Notification{{ItemID: 1}})
+	if err == nil {
+		t.Fatal("delivered a webhook to a loopback address")
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("got %d requests to the loopback address; want none", n)
+	}
+}
@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const minRSAKeyBits = 2048
+
+// signingAlgorithms are the JWS algorithms ID tokens may be signed with. Symmetric algorithms
+// are not supported since they would require the client secret, and "none" never is.
+var signingAlgorithms = map[string]struct {
+	hash  crypto.Hash
+	curve elliptic.Curve
+}{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"ES256": {hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+// jwt is a signed JSON Web Token in compact serialization
+type jwt struct {
+	header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	signingInput string
+	payload      []byte
+	signature    []byte
+}
+
+func parseJWT(raw string) (*jwt, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var token jwt
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	err = json.Unmarshal(header, &token.header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	token.payload, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed payload", ErrInvalidToken)
+	}
+
+	token.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	token.signingInput = parts[0] + "." + parts[1]
+	return &token, nil
+}
+
+func (t *jwt) verify(key publicKey) error {
+	algorithm, ok := signingAlgorithms[t.header.Algorithm]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, t.header.Algorithm)
+	}
+	if key.algorithm != "" && key.algorithm != t.header.Algorithm {
+		return fmt.Errorf("%w: key is not used with algorithm %q", ErrInvalidToken, t.header.Algorithm)
+	}
+
+	h := algorithm.hash.New()
+	h.Write([]byte(t.signingInput))
+	digest := h.Sum(nil)
+
+	switch k := key.key.(type) {
+	case *rsa.PublicKey:
+		if algorithm.curve == nil && rsa.VerifyPKCS1v15(k, algorithm.hash, digest, t.signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// JWS encodes ECDSA signatures as the concatenated r and s, not in ASN.1
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if algorithm.curve == k.Curve && len(t.signature) == 2*size {
+			r := new(big.Int).SetBytes(t.signature[:size])
+			s := new(big.Int).SetBytes(t.signature[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+}
+
+// publicKey is a signing key of a provider
+type publicKey struct {
+	// algorithm is the algorithm the key is restricted to, if any
+	algorithm string
+	key       crypto.PublicKey
+}
+
+// jsonWebKeySet is the set of signing keys a provider publishes at its JWKS URI (RFC 7517)
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// publicKeys returns the signing keys of the set by ID. Keys which are not used for signatures
+// or cannot be parsed are skipped, since providers may publish keys of other types too.
+func (s jsonWebKeySet) publicKeys() map[string]publicKey {
+	keys := make(map[string]publicKey, len(s.Keys))
+	for _, jwk := range s.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = publicKey{algorithm: jwk.Algorithm, key: key}
+	}
+	return keys
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		modulus := new(big.Int).SetBytes(n)
+		exponent := new(big.Int).SetBytes(e)
+		if modulus.BitLen() < minRSAKeyBits || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 || exponent.Bit(0) == 0 {
+			return nil, fmt.Errorf("invalid RSA key %q", k.KeyID)
+		}
+
+		return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Curve {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("invalid EC key %q", k.KeyID)
+		}
+
+		// Check that the point is on the curve before using it
+		point := append(append([]byte{0x04}, x...), y...)
+		_, err = ecdhCurve.NewPublicKey(point)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
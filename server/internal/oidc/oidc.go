@@ -0,0 +1,281 @@
+// Package oidc verifies the ID tokens of OpenID Connect providers. Providers are configured by
+// their issuer URL, from which the signing keys are discovered, so that any standard provider
+// (Google, Keycloak, Authentik, ...) can be used to log in.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// leeway is the clock skew tolerated when checking the validity period of ID tokens
+	leeway = time.Minute
+	// keysMaxAge is the time after which the signing keys of a provider are fetched again
+	keysMaxAge = 24 * time.Hour
+	// keysMinRefreshInterval limits how often the signing keys are fetched again because a token
+	// was signed with an unknown key, so that forged tokens cannot flood the provider
+	keysMinRefreshInterval = time.Minute
+)
+
+var (
+	// ErrInvalidToken is returned when an ID token is malformed, has an invalid signature or
+	// claims, or was not issued by the provider for one of its clients
+	ErrInvalidToken = errors.New("oidc: invalid ID token")
+	// ErrDiscovery is returned when the configuration or the signing keys of a provider cannot
+	// be fetched
+	ErrDiscovery = errors.New("oidc: discovery failed")
+)
+
+// Claims are the claims of a verified ID token which are used to log a user in
+type Claims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Audience      audience    `json:"aud"`
+	AuthorizedBy  string      `json:"azp"`
+	Expiry        numericDate `json:"exp"`
+	IssuedAt      numericDate `json:"iat"`
+	NotBefore     numericDate `json:"nbf"`
+	Email         string      `json:"email"`
+	EmailVerified bool        `json:"-"`
+	Name          string      `json:"name"`
+	Picture       string      `json:"picture"`
+}
+
+// Provider is an OpenID Connect provider users can log in with. Its configuration and signing
+// keys are discovered from the issuer on first use and cached.
+type Provider struct {
+	Name      string
+	Issuer    string
+	ClientIDs []string
+
+	client *http.Client
+
+	mu          sync.Mutex
+	jwksURI     string
+	keys        map[string]publicKey
+	keysFetched time.Time
+}
+
+// NewProvider returns a provider which accepts the ID tokens the issuer issued for any of the
+// client IDs
+func NewProvider(name, issuer string, clientIDs []string) *Provider {
+	return &Provider{
+		Name:      name,
+		Issuer:    strings.TrimSuffix(issuer, "/"),
+		ClientIDs: clientIDs,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Verify checks the signature and the claims of an ID token and returns its claims. It returns
+// ErrInvalidToken if the token is not valid, and ErrDiscovery if the provider could not be
+// reached to check it.
+func (p *Provider) Verify(ctx context.Context, rawIDToken string) (*Claims, error) {
+	token, err := parseJWT(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(ctx, token.header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = token.verify(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	err = json.Unmarshal(token.payload, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	var emailVerified struct {
+		EmailVerified looseBool `json:"email_verified"`
+	}
+	err = json.Unmarshal(token.payload, &emailVerified)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	claims.EmailVerified = bool(emailVerified.EmailVerified)
+
+	err = p.checkClaims(&claims, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (p *Provider) checkClaims(claims *Claims, now time.Time) error {
+	switch {
+	case claims.Issuer != p.Issuer:
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	case claims.Subject == "":
+		return fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case claims.Expiry == 0 || claims.IssuedAt == 0:
+		return fmt.Errorf("%w: missing validity period", ErrInvalidToken)
+	case now.After(claims.Expiry.Time().Add(leeway)):
+		return fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	case now.Add(leeway).Before(claims.IssuedAt.Time()):
+		return fmt.Errorf("%w: token is issued in the future", ErrInvalidToken)
+	case claims.NotBefore != 0 && now.Add(leeway).Before(claims.NotBefore.Time()):
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(p.ClientIDs, aud) }) {
+		return fmt.Errorf("%w: token is not issued for this client", ErrInvalidToken)
+	}
+
+	// A token issued for several audiences must name the client it was issued to
+	if len(claims.Audience) > 1 && !slices.Contains(p.ClientIDs, claims.AuthorizedBy) {
+		return fmt.Errorf("%w: token is not authorized for this client", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// key returns the signing key with the given ID. Keys are fetched again when they are stale or
+// when the key is unknown, since providers rotate their keys.
+func (p *Provider) key(ctx context.Context, keyID string) (publicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[keyID]
+	stale := time.Since(p.keysFetched) >= keysMaxAge
+	if ok && !stale {
+		return key, nil
+	}
+
+	if p.keys == nil || stale || time.Since(p.keysFetched) >= keysMinRefreshInterval {
+		err := p.fetchKeys(ctx)
+		if err != nil {
+			return publicKey{}, err
+		}
+		key, ok = p.keys[keyID]
+	}
+
+	if !ok {
+		return publicKey{}, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, keyID)
+	}
+	return key, nil
+}
+
+// fetchKeys fetches the signing keys of the provider, discovering where they are published
+// first if needed. It must be called with the mutex held.
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	if p.jwksURI == "" {
+		var configuration struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &configuration)
+		if err != nil {
+			return err
+		}
+
+		// The issuer of the configuration must be the configured one, otherwise the provider
+		// would accept tokens which are checked against the keys of another issuer
+		if strings.TrimSuffix(configuration.Issuer, "/") != p.Issuer || configuration.JWKSURI == "" {
+			return fmt.Errorf("%w: invalid configuration of issuer %q", ErrDiscovery, p.Issuer)
+		}
+		p.jwksURI = configuration.JWKSURI
+	}
+
+	var set jsonWebKeySet
+	err := p.getJSON(ctx, p.jwksURI, &set)
+	if err != nil {
+		return err
+	}
+
+	p.keys = set.publicKeys()
+	p.keysFetched = time.Now()
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned status %d", ErrDiscovery, url, resp.StatusCode)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1_048_576)).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+
+	return nil
+}
+
+// audience is the aud claim, which is either a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	err := json.Unmarshal(b, &multiple)
+	if err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// numericDate is a time in seconds since the epoch, which may have a fractional part
+type numericDate int64
+
+func (d *numericDate) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	err := json.Unmarshal(b, &seconds)
+	if err != nil {
+		return err
+	}
+	*d = numericDate(seconds)
+	return nil
+}
+
+func (d numericDate) Time() time.Time {
+	return time.Unix(int64(d), 0)
+}
+
+// looseBool is a boolean claim which some providers (such as AWS Cognito) send as a string
+type looseBool bool
+
+func (b *looseBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aravindmathradan/semaphore/internal/oidc/oidctest"
+)
+
+const testClientID = "client"
+
+// newTestProvider serves a local issuer and returns a provider for it, along with the number of
+// times the provider fetched the signing keys
+func newTestProvider(t *testing.T) (*oidctest.Issuer, *Provider, *atomic.Int32) {
+	t.Helper()
+
+	issuer, err := oidctest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keyFetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			keyFetches.Add(1)
+		}
+		issuer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	issuer.URL = srv.URL
+
+	return issuer, NewProvider("local", srv.URL, []string{testClientID}), &keyFetches
+}
+
+func newTestIDToken(t *testing.T, issuer *oidctest.Issuer, claims map[string]any) string {
+	t.Helper()
+
+	token, err := issuer.IDToken(testClientID, "subject", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// signWithHeader issues an ID token with the claims which is signed with the key of the issuer,
+// but has the given header instead of the one the issuer would use
+func signWithHeader(t *testing.T, issuer *oidctest.Issuer, header map[string]string, claims map[string]any) string {
+	t.Helper()
+
+	token, err := issuer.IDTokenWithHeader(header, testClientID, "subject", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// checkInvalidToken checks that a token was rejected for the given reason
+func checkInvalidToken(t *testing.T, err error, reason string) {
+	t.Helper()
+
+	if !errors.Is(err, ErrInvalidToken) || !strings.Contains(err.Error(), reason) {
+		t.Fatalf("got error %v; want ErrInvalidToken for %q", err, reason)
+	}
+}
+
+func TestProviderVerify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("valid token", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+		token := newTestIDToken(t, issuer, map[string]any{
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"name":           "Alice",
+		})
+
+		claims, err := provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if claims.Issuer != issuer.URL || claims.Subject != "subject" {
+			t.Errorf("got issuer %q and subject %q; want %q and subject", claims.Issuer, claims.Subject, issuer.URL)
+		}
+		if claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Name != "Alice" {
+			t.Errorf("got claims %+v; want the verified email address and name", claims)
+		}
+	})
+
+	t.Run("email verified as a string", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+		token := newTestIDToken(t, issuer, map[string]any{"email": "alice@example.com", "email_verified": "true"})
+
+		claims, err := provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if !claims.EmailVerified {
+			t.Error("got an unverified email address; want it verified")
+		}
+	})
+
+	t.Run("several audiences authorized for the client", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+		token := newTestIDToken(t, issuer, map[string]any{"aud": []string{testClientID, "other"}, "azp": testClientID})
+
+		_, err := provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	})
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims map[string]any
+		reason string
+	}{
+		{
+			name:   "wrong issuer",
+			claims: map[string]any{"iss": "https://evil.example"},
+			reason: "unexpected issuer",
+		},
+		{
+			name:   "wrong audience",
+			claims: map[string]any{"aud": "other"},
+			reason: "not issued for this client",
+		},
+		{
+			name:   "several audiences without azp",
+			claims: map[string]any{"aud": []string{testClientID, "other"}},
+			reason: "not authorized for this client",
+		},
+		{
+			name:   "several audiences authorized for another client",
+			claims: map[string]any{"aud": []string{testClientID, "other"}, "azp": "other"},
+			reason: "not authorized for this client",
+		},
+		{
+			name:   "expired",
+			claims: map[string]any{"iat": now.Add(-2 * time.Hour).Unix(), "exp": now.Add(-leeway - time.Minute).Unix()},
+			reason: "expired",
+		},
+		{
+			name:   "issued in the future",
+			claims: map[string]any{"iat": now.Add(leeway + time.Minute).Unix()},
+			reason: "issued in the future",
+		},
+		{
+			name:   "not valid yet",
+			claims: map[string]any{"nbf": now.Add(leeway + time.Minute).Unix()},
+			reason: "not valid yet",
+		},
+		{
+			name:   "missing subject",
+			claims: map[string]any{"sub": ""},
+			reason: "missing subject",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, provider, _ := newTestProvider(t)
+
+			_, err := provider.Verify(ctx, newTestIDToken(t, issuer, tt.claims))
+			checkInvalidToken(t, err, tt.reason)
+		})
+	}
+
+	t.Run("tolerates clock skew", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+		token := newTestIDToken(t, issuer, map[string]any{
+			"iat": now.Add(leeway / 2).Unix(),
+			"exp": now.Add(-leeway / 2).Unix(),
+		})
+
+		_, err := provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+	})
+
+	t.Run("unknown key is fetched again", func(t *testing.T) {
+		issuer, provider, keyFetches := newTestProvider(t)
+
+		_, err := provider.Verify(ctx, newTestIDToken(t, issuer, nil))
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+
+		err = issuer.RotateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := newTestIDToken(t, issuer, nil)
+
+		// Keys are not fetched again right away, so that forged tokens cannot flood the provider
+		_, err = provider.Verify(ctx, token)
+		checkInvalidToken(t, err, "unknown signing key")
+		if n := keyFetches.Load(); n != 1 {
+			t.Fatalf("got %d key fetches; want 1", n)
+		}
+
+		provider.keysFetched = time.Now().Add(-keysMinRefreshInterval)
+
+		_, err = provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed after the key rotation: %v", err)
+		}
+		if n := keyFetches.Load(); n != 2 {
+			t.Errorf("got %d key fetches; want 2", n)
+		}
+	})
+
+	t.Run("stale keys are fetched again", func(t *testing.T) {
+		issuer, provider, keyFetches := newTestProvider(t)
+		token := newTestIDToken(t, issuer, nil)
+
+		_, err := provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		_, err = provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if n := keyFetches.Load(); n != 1 {
+			t.Fatalf("got %d key fetches; want the keys cached", n)
+		}
+
+		provider.keysFetched = time.Now().Add(-keysMaxAge)
+
+		_, err = provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if n := keyFetches.Load(); n != 2 {
+			t.Errorf("got %d key fetches; want 2", n)
+		}
+	})
+
+	t.Run("algorithm mismatch with the key", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+
+		for _, alg := range []string{"ES384", "RS256"} {
+			token := signWithHeader(t, issuer, map[string]string{"alg": alg, "typ": "JWT", "kid": issuer.KeyID()}, nil)
+
+			_, err := provider.Verify(ctx, token)
+			checkInvalidToken(t, err, "key is not used with algorithm")
+		}
+	})
+
+	t.Run("unsigned token", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+		token := signWithHeader(t, issuer, map[string]string{"alg": "none", "kid": issuer.KeyID()}, nil)
+
+		_, err := provider.Verify(ctx, token)
+		checkInvalidToken(t, err, "unsupported algorithm")
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		issuer, provider, _ := newTestProvider(t)
+		token := newTestIDToken(t, issuer, nil)
+
+		// Sign the token of one issuer with the key of another
+		other, err := oidctest.NewIssuer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		other.URL = issuer.URL
+		forged := signWithHeader(t, other, map[string]string{"alg": "ES256", "typ": "JWT", "kid": issuer.KeyID()}, nil)
+
+		_, err = provider.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		_, err = provider.Verify(ctx, forged)
+		checkInvalidToken(t, err, "invalid signature")
+	})
+}
@@ -0,0 +1,157 @@
+// Package oidctest provides an OpenID Connect issuer for tests, where no provider can be reached
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Issuer is a stand-in OpenID Connect provider which signs ID tokens with an ES256 key kept in
+// memory. It serves its configuration and signing keys like a real provider:
+//
+//	issuer, err := oidctest.NewIssuer()
+//	srv := httptest.NewServer(issuer)
+//	issuer.URL = srv.URL
+//	provider := oidc.NewProvider("local", srv.URL, []string{"client"})
+type Issuer struct {
+	// URL is the issuer identifier, the URL the issuer is served at
+	URL string
+
+	mu    sync.Mutex
+	key   *ecdsa.PrivateKey
+	keyID string
+}
+
+func NewIssuer() (*Issuer, error) {
+	iss := &Issuer{}
+	err := iss.RotateKey()
+	if err != nil {
+		return nil, err
+	}
+	return iss, nil
+}
+
+// RotateKey replaces the signing key of the issuer with a new one, as providers do from time to
+// time. Only the new key is served afterwards.
+func (iss *Issuer) RotateKey() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	keyID := make([]byte, 8)
+	_, err = rand.Read(keyID)
+	if err != nil {
+		return err
+	}
+
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	iss.key = key
+	iss.keyID = base64.RawURLEncoding.EncodeToString(keyID)
+	return nil
+}
+
+// KeyID returns the ID of the current signing key
+func (iss *Issuer) KeyID() string {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	return iss.keyID
+}
+
+// ServeHTTP serves the configuration and the signing keys of the issuer
+func (iss *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	var body any
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		body = map[string]any{
+			"issuer":                                iss.URL,
+			"jwks_uri":                              iss.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		}
+	case "/jwks":
+		body = map[string]any{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": iss.keyID,
+				"use": "sig",
+				"alg": "ES256",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(fillBytes(iss.key.X, 32)),
+				"y":   base64.RawURLEncoding.EncodeToString(fillBytes(iss.key.Y, 32)),
+			}},
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// IDToken issues an ID token for the subject to the client, valid for an hour. The claims are
+// added to the token and override the default ones, which lets tests issue invalid tokens too.
+func (iss *Issuer) IDToken(clientID, subject string, claims map[string]any) (string, error) {
+	return iss.IDTokenWithHeader(nil, clientID, subject, claims)
+}
+
+// IDTokenWithHeader issues an ID token like IDToken, signed with the key of the issuer but with
+// the given header, e.g. one which names another algorithm or key. A nil header is the one the
+// issuer uses.
+func (iss *Issuer) IDTokenWithHeader(header map[string]string, clientID, subject string, claims map[string]any) (string, error) {
+	now := time.Now()
+	payload := map[string]any{
+		"iss": iss.URL,
+		"sub": subject,
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		payload[name] = value
+	}
+
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	if header == nil {
+		header = map[string]string{"alg": "ES256", "typ": "JWT", "kid": iss.keyID}
+	}
+
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(body)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, iss.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := append(fillBytes(r, 32), fillBytes(s, 32)...)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func fillBytes(n *big.Int, size int) []byte {
+	return n.FillBytes(make([]byte, size))
+}
@@ -0,0 +1,297 @@
+// Package syndication renders feeds in the RSS 2.0, Atom 1.0 and JSON Feed 1.1 formats
+package syndication
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+const (
+	ContentTypeRSS  = "application/rss+xml; charset=utf-8"
+	ContentTypeAtom = "application/atom+xml; charset=utf-8"
+	ContentTypeJSON = "application/feed+json; charset=utf-8"
+)
+
+// Feed is a format independent representation of a feed
+type Feed struct {
+	ID          string
+	Title       string
+	Description string
+	Link        string
+	FeedLink    string
+	Updated     time.Time
+	Items       []*Item
+}
+
+type Item struct {
+	ID          string
+	Title       string
+	Link        string
+	Description string
+	Content     string
+	ImageURL    string
+	Authors     []string
+	Categories  []string
+	Source      string
+	SourceURL   string
+	Published   time.Time
+	Updated     time.Time
+}
+
+type rss struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	DCNS      string     `xml:"xmlns:dc,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      rssLink   `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description,omitempty"`
+	Content     *cdata     `xml:"content:encoded,omitempty"`
+	Authors     []string   `xml:"dc:creator,omitempty"`
+	Categories  []string   `xml:"category,omitempty"`
+	GUID        rssGUID    `xml:"guid"`
+	PubDate     string     `xml:"pubDate,omitempty"`
+	Source      *rssSource `xml:"source,omitempty"`
+}
+
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssSource struct {
+	Value string `xml:",chardata"`
+	URL   string `xml:"url,attr"`
+}
+
+// RSS renders the feed in the RSS 2.0 format
+func RSS(feed *Feed) ([]byte, error) {
+	channel := rssChannel{
+		Title:         feed.Title,
+		Link:          feed.Link,
+		Description:   feed.Description,
+		AtomLink:      rssLink{Href: feed.FeedLink, Rel: "self", Type: "application/rss+xml"},
+		LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+		Generator:     "Semaphore",
+		Items:         make([]rssItem, len(feed.Items)),
+	}
+
+	for i, item := range feed.Items {
+		rssItem := rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Description: item.Description,
+			Authors:     item.Authors,
+			Categories:  item.Categories,
+			GUID:        rssGUID{Value: item.ID},
+		}
+		if item.Content != "" {
+			rssItem.Content = &cdata{Value: item.Content}
+		}
+		if !item.Published.IsZero() {
+			rssItem.PubDate = item.Published.UTC().Format(time.RFC1123Z)
+		}
+		// The source element requires the URL of the feed that the item came from
+		if item.Source != "" && item.SourceURL != "" {
+			rssItem.Source = &rssSource{Value: item.Source, URL: item.SourceURL}
+		}
+		channel.Items[i] = rssItem
+	}
+
+	return marshalXML(rss{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		Channel:   channel,
+	})
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category,omitempty"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+	Source     *atomSource    `xml:"source,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomSource struct {
+	Title string `xml:"title"`
+}
+
+// Atom renders the feed in the Atom 1.0 format
+func Atom(feed *Feed) ([]byte, error) {
+	atom := atomFeed{
+		ID:       feed.ID,
+		Title:    feed.Title,
+		Subtitle: feed.Description,
+		Updated:  feed.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+			{Href: feed.FeedLink, Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: make([]atomEntry, len(feed.Items)),
+	}
+
+	for i, item := range feed.Items {
+		entry := atomEntry{
+			ID:      item.ID,
+			Title:   item.Title,
+			Link:    atomLink{Href: item.Link, Rel: "alternate"},
+			Updated: item.Updated.UTC().Format(time.RFC3339),
+		}
+		if !item.Published.IsZero() {
+			entry.Published = item.Published.UTC().Format(time.RFC3339)
+		}
+		for _, author := range item.Authors {
+			entry.Authors = append(entry.Authors, atomPerson{Name: author})
+		}
+		for _, category := range item.Categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: category})
+		}
+		if item.Description != "" {
+			entry.Summary = &atomText{Type: "html", Value: item.Description}
+		}
+		if item.Content != "" {
+			entry.Content = &atomText{Type: "html", Value: item.Content}
+		}
+		if item.Source != "" {
+			entry.Source = &atomSource{Title: item.Source}
+		}
+		atom.Entries[i] = entry
+	}
+
+	return marshalXML(atom)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	Summary       string           `json:"summary,omitempty"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// JSONFeed renders the feed in the JSON Feed 1.1 format
+func JSONFeed(feed *Feed) ([]byte, error) {
+	jf := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link,
+		FeedURL:     feed.FeedLink,
+		Description: feed.Description,
+		Items:       make([]jsonFeedItem, len(feed.Items)),
+	}
+
+	for i, item := range feed.Items {
+		jfItem := jsonFeedItem{
+			ID:           item.ID,
+			URL:          item.Link,
+			Title:        item.Title,
+			ContentHTML:  item.Content,
+			Summary:      item.Description,
+			Image:        item.ImageURL,
+			DateModified: item.Updated.UTC().Format(time.RFC3339),
+			Tags:         item.Categories,
+		}
+		// content_html is required when there is no other content
+		if jfItem.ContentHTML == "" {
+			jfItem.ContentHTML = item.Description
+		}
+		if !item.Published.IsZero() {
+			jfItem.DatePublished = item.Published.UTC().Format(time.RFC3339)
+		}
+		for _, author := range item.Authors {
+			jfItem.Authors = append(jfItem.Authors, jsonFeedAuthor{Name: author})
+		}
+		jf.Items[i] = jfItem
+	}
+
+	return json.Marshal(jf)
+}
+
+func marshalXML(v any) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
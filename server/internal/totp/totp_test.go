@@ -0,0 +1,159 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors in RFC 6238 Appendix B
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The RFC lists 8 digit codes, of which 6 digit codes are the last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if got != tt.want {
+			t.Errorf("got code %q at %d; want %q", got, tt.unix, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	t.Run("Accepts codes within the skew", func(t *testing.T) {
+		for _, offset := range []int64{-1, 0, 1} {
+			got, ok := Validate(rfcSecret, Code(rfcSecret, step+offset), now, 1)
+			if !ok {
+				t.Errorf("rejected the code of step offset %d", offset)
+				continue
+			}
+			if got != step+offset {
+				t.Errorf("got step %d; want %d", got, step+offset)
+			}
+		}
+	})
+
+	t.Run("Rejects codes outside the skew", func(t *testing.T) {
+		for _, offset := range []int64{-2, 2} {
+			if _, ok := Validate(rfcSecret, Code(rfcSecret, step+offset), now, 1); ok {
+				t.Errorf("accepted the code of step offset %d", offset)
+			}
+		}
+	})
+
+	t.Run("Rejects codes of adjacent steps without skew", func(t *testing.T) {
+		if _, ok := Validate(rfcSecret, Code(rfcSecret, step+1), now, 0); ok {
+			t.Error("accepted the code of the next step")
+		}
+	})
+
+	t.Run("Uses the step boundaries of the period", func(t *testing.T) {
+		// 1111111109 and 1111111111 are in different steps
+		code := Code(rfcSecret, Step(time.Unix(1111111109, 0)))
+		if _, ok := Validate(rfcSecret, code, time.Unix(1111111110, 0), 0); ok {
+			t.Error("accepted the code of the previous step at the start of a step")
+		}
+		if _, ok := Validate(rfcSecret, code, time.Unix(1111111109, 0), 0); !ok {
+			t.Error("rejected the code at the end of its step")
+		}
+	})
+
+	t.Run("Rejects malformed codes", func(t *testing.T) {
+		code := Code(rfcSecret, step)
+		for _, input := range []string{
+			"",
+			code[:Digits-1],
+			code + "0",
+			"0" + code,
+			"abcdef",
+			code[:Digits-1] + "x",
+			" " + code[1:],
+		} {
+			if _, ok := Validate(rfcSecret, input, now, 1); ok {
+				t.Errorf("accepted the code %q", input)
+			}
+		}
+	})
+}
+
+func TestEncodeSecret(t *testing.T) {
+	t.Run("Round trips through base32", func(t *testing.T) {
+		secret, err := NewSecret()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded := EncodeSecret(secret)
+		if strings.Contains(encoded, "=") {
+			t.Errorf("got padded secret %q; want no padding", encoded)
+		}
+
+		decoded, err := encoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != string(secret) {
+			t.Errorf("got secret %x; want %x", decoded, secret)
+		}
+	})
+
+	t.Run("Encodes the RFC secret", func(t *testing.T) {
+		got := EncodeSecret(rfcSecret)
+		want := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+		if got != want {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	})
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Semaphore", "alice@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("got %s://%s; want otpauth://totp", u.Scheme, u.Host)
+	}
+	if u.Path != "/Semaphore:alice@example.com" {
+		t.Errorf("got label %q; want %q", u.Path, "/Semaphore:alice@example.com")
+	}
+
+	params := u.Query()
+	decoded, err := encoding.DecodeString(params.Get("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != string(rfcSecret) {
+		t.Errorf("got secret %x; want %x", decoded, rfcSecret)
+	}
+
+	want := map[string]string{
+		"issuer":    "Semaphore",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for name, value := range want {
+		if got := params.Get(name); got != value {
+			t.Errorf("got %s %q; want %q", name, got, value)
+		}
+	}
+}
@@ -16,6 +16,8 @@ var (
 	HasUpperRX      = regexp.MustCompile(`[A-Z]`)
 	HasDigitRX      = regexp.MustCompile(`\d`)
 	HasSpecialRX    = regexp.MustCompile(`[!@#$&*]`)
+	HexColorRX      = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	SlugRX          = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
 )
 
 type Validator struct {
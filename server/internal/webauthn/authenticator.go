@@ -0,0 +1,177 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// SoftwareAuthenticator is a passkey authenticator which keeps ES256 keys in memory. It is used
+// in tests, where no browser or hardware authenticator is available.
+type SoftwareAuthenticator struct {
+	// Origin is the origin reported in the client data, as a browser would
+	Origin string
+
+	mu          sync.Mutex
+	credentials map[string]*softwareCredential
+}
+
+type softwareCredential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+func NewSoftwareAuthenticator(origin string) *SoftwareAuthenticator {
+	return &SoftwareAuthenticator{
+		Origin:      origin,
+		credentials: make(map[string]*softwareCredential),
+	}
+}
+
+// Register creates a passkey for the options of a registration ceremony
+func (a *SoftwareAuthenticator) Register(options CreationOptions) (*RegistrationCredential, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, excluded := range options.ExcludeCredentials {
+		if _, ok := a.credentials[string(excluded.ID)]; ok {
+			return nil, errors.New("webauthn: authenticator is already registered")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &softwareCredential{
+		id:         id,
+		rpID:       options.RP.ID,
+		userHandle: options.User.ID,
+		key:        key,
+	}
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	coseKey := encodeCBOR([]cborPair{
+		{coseKeyType, coseKeyTypeEC2},
+		{coseKeyAlg, AlgES256},
+		{coseEC2Curve, coseCurveP256},
+		{coseEC2X, x},
+		{coseEC2Y, y},
+	})
+
+	attestedCredData := make([]byte, 16)
+	attestedCredData = binary.BigEndian.AppendUint16(attestedCredData, uint16(len(id)))
+	attestedCredData = append(attestedCredData, id...)
+	attestedCredData = append(attestedCredData, coseKey...)
+
+	authData := cred.authenticatorData(flagAttestedCredData)
+	authData = append(authData, attestedCredData...)
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials[string(id)] = cred
+
+	var credential RegistrationCredential
+	credential.ID = Base64URL(id).String()
+	credential.RawID = id
+	credential.Type = credentialType
+	credential.Response.ClientDataJSON = clientDataJSON
+	credential.Response.AttestationObject = encodeCBOR([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", authData},
+	})
+	credential.Response.Transports = []string{"internal"}
+	return &credential, nil
+}
+
+// Login asserts a passkey for the options of an authentication ceremony. Like a platform
+// authenticator, it uses the first of its passkeys for the relying party which is allowed.
+func (a *SoftwareAuthenticator) Login(options RequestOptions) (*AssertionCredential, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *softwareCredential
+	for _, c := range a.credentials {
+		if c.rpID != options.RPID {
+			continue
+		}
+		if len(options.AllowCredentials) > 0 && !allowed(options.AllowCredentials, c.id) {
+			continue
+		}
+		cred = c
+		break
+	}
+	if cred == nil {
+		return nil, errors.New("webauthn: no passkey for the relying party")
+	}
+
+	cred.signCount++
+	authData := cred.authenticatorData(0)
+
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	hash := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var credential AssertionCredential
+	credential.ID = Base64URL(cred.id).String()
+	credential.RawID = cred.id
+	credential.Type = credentialType
+	credential.Response.ClientDataJSON = clientDataJSON
+	credential.Response.AuthenticatorData = authData
+	credential.Response.Signature = signature
+	credential.Response.UserHandle = cred.userHandle
+	return &credential, nil
+}
+
+func (c *softwareCredential) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flagUserPresent|flagUserVerified|flags)
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
+
+func (a *SoftwareAuthenticator) clientData(ceremony string, challenge []byte) ([]byte, error) {
+	return json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+}
+
+func allowed(descriptors []CredentialDescriptor, id []byte) bool {
+	for _, d := range descriptors {
+		if string(d.ID) == string(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Authenticators encode attestation objects and public keys in the canonical CBOR encoding of
+// CTAP2, which only uses definite lengths. The decoder supports that subset: integers, byte and
+// text strings, arrays, maps and the simple values false, true and null. Integers are decoded
+// as int64, byte strings as []byte, text strings as string, arrays as []any and maps as
+// map[any]any.
+
+const cborMaxDepth = 16
+
+var errCBOR = errors.New("webauthn: invalid CBOR")
+
+// decodeCBOR decodes the first CBOR value of b and returns the bytes which follow it
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORValue(b, 0)
+}
+
+func decodeCBORValue(b []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+		}
+	}
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24 && len(b) >= 1:
+		arg = uint64(b[0])
+		b = b[1:]
+	case info == 25 && len(b) >= 2:
+		arg = uint64(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	case info == 26 && len(b) >= 4:
+		arg = uint64(binary.BigEndian.Uint32(b))
+		b = b[4:]
+	case info == 27 && len(b) >= 8:
+		arg = binary.BigEndian.Uint64(b)
+		b = b[8:]
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported argument %d", errCBOR, info)
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		if major == 2 {
+			return append([]byte(nil), b[:arg]...), b[arg:], nil
+		}
+		return string(b[:arg]), b[arg:], nil
+	case 4:
+		// Every element takes at least one byte
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		array := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var value any
+			var err error
+			value, b, err = decodeCBORValue(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			array = append(array, value)
+		}
+		return array, b, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value any
+			var err error
+			key, b, err = decodeCBORValue(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key", errCBOR)
+			}
+			value, b, err = decodeCBORValue(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := m[key]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key", errCBOR)
+			}
+			m[key] = value
+		}
+		return m, b, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+	}
+}
+
+// cborPair is an entry of a CBOR map. Maps are encoded from slices of pairs, so that the caller
+// controls the order of the keys.
+type cborPair struct {
+	Key   any
+	Value any
+}
+
+// encodeCBOR encodes int, int64, string, []byte and []cborPair values. It is used by the
+// software authenticator.
+func encodeCBOR(v any) []byte {
+	return appendCBOR(nil, v)
+}
+
+func appendCBOR(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case int:
+		return appendCBOR(b, int64(v))
+	case int64:
+		if v < 0 {
+			return appendCBORHead(b, 1, uint64(-1-v))
+		}
+		return appendCBORHead(b, 0, uint64(v))
+	case []byte:
+		return append(appendCBORHead(b, 2, uint64(len(v))), v...)
+	case string:
+		return append(appendCBORHead(b, 3, uint64(len(v))), v...)
+	case []cborPair:
+		b = appendCBORHead(b, 5, uint64(len(v)))
+		for _, pair := range v {
+			b = appendCBOR(b, pair.Key)
+			b = appendCBOR(b, pair.Value)
+		}
+		return b
+	default:
+		panic(fmt.Sprintf("webauthn: cannot encode %T as CBOR", v))
+	}
+}
+
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+	}
+}
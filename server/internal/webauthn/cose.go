@@ -0,0 +1,118 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms and key parameters used by passkeys (RFC 9053)
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+
+	coseKeyType      = 1
+	coseKeyAlg       = 3
+	coseKeyTypeEC2   = 2
+	coseKeyTypeRSA   = 3
+	coseEC2Curve     = -1
+	coseEC2X         = -2
+	coseEC2Y         = -3
+	coseRSAModulus   = -1
+	coseRSAExponent  = -2
+	coseCurveP256    = 1
+	minRSAKeyBits    = 2048
+	maxRSAExponentSz = 4
+)
+
+var errUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// publicKey is a credential public key which can verify assertion signatures
+type publicKey interface {
+	verify(data, signature []byte) bool
+}
+
+type ec2PublicKey struct {
+	key *ecdsa.PublicKey
+}
+
+func (k ec2PublicKey) verify(data, signature []byte) bool {
+	hash := sha256.Sum256(data)
+	return ecdsa.VerifyASN1(k.key, hash[:], signature)
+}
+
+type rsaPublicKey struct {
+	key *rsa.PublicKey
+}
+
+func (k rsaPublicKey) verify(data, signature []byte) bool {
+	hash := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(k.key, crypto.SHA256, hash[:], signature) == nil
+}
+
+// parsePublicKey parses a COSE_Key. Only ES256 keys on P-256 and RS256 keys are supported,
+// which are the algorithms requested in the creation options.
+func parsePublicKey(coseKey []byte) (publicKey, error) {
+	value, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", errUnsupportedKey)
+	}
+
+	m, ok := value.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a map", errUnsupportedKey)
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseKeyAlg)].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseEC2Curve)].(int64)
+		x, _ := m[int64(coseEC2X)].([]byte)
+		y, _ := m[int64(coseEC2Y)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid P-256 key", errUnsupportedKey)
+		}
+
+		// Check that the point is on the curve before using it
+		point := append(append([]byte{0x04}, x...), y...)
+		_, err := ecdh.P256().NewPublicKey(point)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errUnsupportedKey, err)
+		}
+
+		return ec2PublicKey{key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := m[int64(coseRSAModulus)].([]byte)
+		e, _ := m[int64(coseRSAExponent)].([]byte)
+		if len(n)*8 < minRSAKeyBits || len(e) == 0 || len(e) > maxRSAExponentSz {
+			return nil, fmt.Errorf("%w: invalid RSA key", errUnsupportedKey)
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 || exponent%2 == 0 {
+			return nil, fmt.Errorf("%w: invalid RSA exponent", errUnsupportedKey)
+		}
+
+		return rsaPublicKey{key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exponent,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("%w: key type %d with algorithm %d", errUnsupportedKey, kty, alg)
+	}
+}
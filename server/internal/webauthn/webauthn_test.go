@@ -0,0 +1,480 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+func newTestRelyingParty() *RelyingParty {
+	return NewRelyingParty(testRPID, "Example", []string{testOrigin})
+}
+
+func newTestChallenge(t *testing.T) []byte {
+	t.Helper()
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+// roundTrip sends a credential through its JSON serialization, as a client would
+func roundTrip[T any](t *testing.T, v *T) *T {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded T
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+// checkVerificationError checks that a ceremony failed verification for the given reason
+func checkVerificationError(t *testing.T, err error, reason string) {
+	t.Helper()
+
+	if !errors.Is(err, ErrVerification) || !strings.Contains(err.Error(), reason) {
+		t.Fatalf("got error %v; want ErrVerification for %q", err, reason)
+	}
+}
+
+// register registers a passkey of the authenticator with the relying party
+func register(t *testing.T, rp *RelyingParty, authenticator *SoftwareAuthenticator) *Credential {
+	t.Helper()
+
+	challenge := newTestChallenge(t)
+	options := rp.CreationOptions(UserEntity{ID: []byte("user-1"), Name: "alice", DisplayName: "Alice"}, challenge, nil)
+
+	created, err := authenticator.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, roundTrip(t, created))
+	if err != nil {
+		t.Fatalf("VerifyRegistration failed: %v", err)
+	}
+	return credential
+}
+
+// resign changes the authenticator data of an assertion and signs it again with the key of the
+// passkey, as an authenticator which reports that data would
+func resign(t *testing.T, authenticator *SoftwareAuthenticator, asserted *AssertionCredential, change func(authData []byte)) {
+	t.Helper()
+
+	cred, ok := authenticator.credentials[string(asserted.RawID)]
+	if !ok {
+		t.Fatal("the authenticator has no passkey for the assertion")
+	}
+
+	authData := append([]byte(nil), asserted.Response.AuthenticatorData...)
+	change(authData)
+
+	clientDataHash := sha256.Sum256(asserted.Response.ClientDataJSON)
+	hash := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asserted.Response.AuthenticatorData = authData
+	asserted.Response.Signature = signature
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		encoded := encodeCBOR([]cborPair{
+			{"fmt", "none"},
+			{1, int64(-7)},
+			{"authData", []byte{1, 2, 3}},
+		})
+
+		value, rest, err := decodeCBOR(append(encoded, 0xff))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 1 || rest[0] != 0xff {
+			t.Errorf("got rest %x; want the byte after the value", rest)
+		}
+
+		m, ok := value.(map[any]any)
+		if !ok {
+			t.Fatalf("got %T; want a map", value)
+		}
+		authData, _ := m["authData"].([]byte)
+		if m["fmt"] != "none" || m[int64(1)] != int64(-7) || string(authData) != "\x01\x02\x03" {
+			t.Errorf("got %v; want the encoded map", m)
+		}
+	})
+
+	huge := binary.BigEndian.AppendUint64(nil, 1<<63)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated byte string", []byte{0x58, 0x10, 1, 2, 3}},
+		{"truncated text string", []byte{0x65, 'a', 'b'}},
+		{"oversized byte string", append([]byte{0x5b}, huge...)},
+		{"oversized array", append([]byte{0x9b}, huge...)},
+		{"oversized map", append([]byte{0xbb}, huge...)},
+		{"truncated length", []byte{0x59, 0x01}},
+		{"truncated array", []byte{0x83, 0x01, 0x02}},
+		{"truncated map", []byte{0xa1, 0x01}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"integer overflow", append([]byte{0x1b}, huge...)},
+		{"duplicate map key", []byte{0xa2, 0x01, 0x00, 0x01, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeCBOR(tt.data)
+			if !errors.Is(err, errCBOR) {
+				t.Errorf("got error %v; want errCBOR", err)
+			}
+		})
+	}
+
+	t.Run("nested too deeply", func(t *testing.T) {
+		data := make([]byte, cborMaxDepth+2)
+		for i := range data {
+			data[i] = 0x81
+		}
+
+		_, _, err := decodeCBOR(append(data, 0x00))
+		if !errors.Is(err, errCBOR) {
+			t.Errorf("got error %v; want errCBOR", err)
+		}
+	})
+}
+
+func TestRegistration(t *testing.T) {
+	rp := newTestRelyingParty()
+
+	t.Run("round trip", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator)
+
+		if len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+			t.Fatalf("got credential %+v; want an id and a public key", credential)
+		}
+		if credential.SignCount != 0 {
+			t.Errorf("got sign count %d; want 0", credential.SignCount)
+		}
+		if len(credential.Transports) != 1 || credential.Transports[0] != "internal" {
+			t.Errorf("got transports %v; want the transports of the authenticator", credential.Transports)
+		}
+	})
+
+	t.Run("excluded credentials", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator)
+
+		options := rp.CreationOptions(UserEntity{ID: []byte("user-1"), Name: "alice"}, newTestChallenge(t),
+			[]CredentialDescriptor{{Type: credentialType, ID: credential.ID}})
+		_, err := authenticator.Register(options)
+		if err == nil {
+			t.Fatal("authenticator registered a second passkey for an excluded credential")
+		}
+	})
+
+	t.Run("truncated attestation object", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		challenge := newTestChallenge(t)
+		options := rp.CreationOptions(UserEntity{ID: []byte("user-1"), Name: "alice"}, challenge, nil)
+
+		created, err := authenticator.Register(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created.Response.AttestationObject = created.Response.AttestationObject[:len(created.Response.AttestationObject)-10]
+
+		_, err = rp.VerifyRegistration(challenge, roundTrip(t, created))
+		checkVerificationError(t, err, "unexpected end of data")
+	})
+
+	tests := []struct {
+		name   string
+		rp     *RelyingParty
+		origin string
+		reason string
+		// challenge returns the challenge the response is verified with
+		challenge func(sent []byte) []byte
+	}{
+		{
+			name:      "wrong challenge",
+			rp:        rp,
+			origin:    testOrigin,
+			reason:    "challenge mismatch",
+			challenge: func([]byte) []byte { return newTestChallenge(t) },
+		},
+		{
+			name:      "wrong origin",
+			rp:        rp,
+			origin:    "https://evil.example",
+			reason:    "unexpected origin",
+			challenge: func(sent []byte) []byte { return sent },
+		},
+		{
+			name:      "wrong relying party id",
+			rp:        NewRelyingParty("evil.example", "Evil", []string{testOrigin}),
+			origin:    testOrigin,
+			reason:    "relying party id mismatch",
+			challenge: func(sent []byte) []byte { return sent },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := NewSoftwareAuthenticator(tt.origin)
+			challenge := newTestChallenge(t)
+			options := tt.rp.CreationOptions(UserEntity{ID: []byte("user-1"), Name: "alice"}, challenge, nil)
+
+			created, err := authenticator.Register(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = rp.VerifyRegistration(tt.challenge(challenge), roundTrip(t, created))
+			checkVerificationError(t, err, tt.reason)
+		})
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	rp := newTestRelyingParty()
+
+	t.Run("round trip", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator)
+		signCount := credential.SignCount
+
+		for range 2 {
+			challenge := newTestChallenge(t)
+			asserted, err := authenticator.Login(rp.RequestOptions(challenge, []CredentialDescriptor{{Type: credentialType, ID: credential.ID}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			asserted = roundTrip(t, asserted)
+			if string(asserted.Response.UserHandle) != "user-1" {
+				t.Errorf("got user handle %q; want user-1", asserted.Response.UserHandle)
+			}
+
+			newSignCount, err := rp.VerifyAssertion(challenge, credential.PublicKey, signCount, asserted)
+			if err != nil {
+				t.Fatalf("VerifyAssertion failed: %v", err)
+			}
+			if newSignCount <= signCount {
+				t.Errorf("got sign count %d; want more than %d", newSignCount, signCount)
+			}
+			signCount = newSignCount
+		}
+	})
+
+	t.Run("discoverable credentials", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator)
+
+		challenge := newTestChallenge(t)
+		asserted, err := authenticator.Login(rp.RequestOptions(challenge, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, roundTrip(t, asserted))
+		if err != nil {
+			t.Fatalf("VerifyAssertion failed: %v", err)
+		}
+	})
+
+	// assertWith registers a passkey of the authenticator and asserts it from the origin,
+	// returning what the relying party needs to verify the assertion
+	assertWith := func(t *testing.T, authenticator *SoftwareAuthenticator, origin string) (*Credential, []byte, *AssertionCredential) {
+		t.Helper()
+
+		credential := register(t, rp, authenticator)
+
+		// The authenticator reports the origin it is used from
+		authenticator.Origin = origin
+
+		challenge := newTestChallenge(t)
+		asserted, err := authenticator.Login(RequestOptions{
+			Challenge:        challenge,
+			RPID:             testRPID,
+			AllowCredentials: []CredentialDescriptor{{Type: credentialType, ID: credential.ID}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return credential, challenge, roundTrip(t, asserted)
+	}
+
+	assert := func(t *testing.T, origin string) (*Credential, []byte, *AssertionCredential) {
+		t.Helper()
+		return assertWith(t, NewSoftwareAuthenticator(testOrigin), origin)
+	}
+
+	t.Run("wrong challenge", func(t *testing.T) {
+		credential, _, asserted := assert(t, testOrigin)
+
+		_, err := rp.VerifyAssertion(newTestChallenge(t), credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "challenge mismatch")
+	})
+
+	t.Run("wrong origin", func(t *testing.T) {
+		credential, challenge, asserted := assert(t, "https://evil.example")
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "unexpected origin")
+	})
+
+	t.Run("wrong relying party id", func(t *testing.T) {
+		credential, challenge, asserted := assert(t, testOrigin)
+
+		otherRP := NewRelyingParty("other.example", "Other", []string{testOrigin})
+		_, err := otherRP.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "relying party id mismatch")
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		credential, challenge, asserted := assert(t, testOrigin)
+
+		signature := asserted.Response.Signature
+		signature[len(signature)-1] ^= 0xff
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "invalid signature")
+	})
+
+	t.Run("tampered authenticator data", func(t *testing.T) {
+		credential, challenge, asserted := assert(t, testOrigin)
+
+		// Raise the sign count without signing the authenticator data again
+		asserted.Response.AuthenticatorData[36]++
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "invalid signature")
+	})
+
+	t.Run("key of another passkey", func(t *testing.T) {
+		_, challenge, asserted := assert(t, testOrigin)
+		other := register(t, rp, NewSoftwareAuthenticator(testOrigin))
+
+		_, err := rp.VerifyAssertion(challenge, other.PublicKey, other.SignCount, asserted)
+		checkVerificationError(t, err, "invalid signature")
+	})
+
+	t.Run("non-increasing sign count", func(t *testing.T) {
+		credential, challenge, asserted := assert(t, testOrigin)
+
+		// The passkey was last used with a higher count, e.g. from a clone of the authenticator
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, 5, asserted)
+		if !errors.Is(err, ErrSignCount) {
+			t.Fatalf("got error %v; want ErrSignCount", err)
+		}
+
+		_, err = rp.VerifyAssertion(challenge, credential.PublicKey, 1, asserted)
+		if !errors.Is(err, ErrSignCount) {
+			t.Fatalf("got error %v for an equal sign count; want ErrSignCount", err)
+		}
+	})
+
+	t.Run("wrong relying party id hash", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential, challenge, asserted := assertWith(t, authenticator, testOrigin)
+
+		// A correctly signed assertion which is scoped to another site
+		resign(t, authenticator, asserted, func(authData []byte) {
+			rpIDHash := sha256.Sum256([]byte("evil.example"))
+			copy(authData[:32], rpIDHash[:])
+		})
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "relying party id mismatch")
+	})
+
+	t.Run("user not present", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential, challenge, asserted := assertWith(t, authenticator, testOrigin)
+
+		resign(t, authenticator, asserted, func(authData []byte) {
+			authData[32] &^= flagUserPresent
+		})
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "user not present")
+	})
+
+	t.Run("user not verified", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential, challenge, asserted := assertWith(t, authenticator, testOrigin)
+
+		resign(t, authenticator, asserted, func(authData []byte) {
+			authData[32] &^= flagUserVerified
+		})
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "user not verified")
+	})
+
+	t.Run("truncated authenticator data", func(t *testing.T) {
+		credential, challenge, asserted := assert(t, testOrigin)
+
+		asserted.Response.AuthenticatorData = asserted.Response.AuthenticatorData[:36]
+
+		_, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, asserted)
+		checkVerificationError(t, err, "authenticator data too short")
+	})
+
+	t.Run("sign count going backwards", func(t *testing.T) {
+		authenticator := NewSoftwareAuthenticator(testOrigin)
+		credential := register(t, rp, authenticator)
+		allow := []CredentialDescriptor{{Type: credentialType, ID: credential.ID}}
+
+		signCount := credential.SignCount
+		for range 3 {
+			challenge := newTestChallenge(t)
+			asserted, err := authenticator.Login(rp.RequestOptions(challenge, allow))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signCount, err = rp.VerifyAssertion(challenge, credential.PublicKey, signCount, roundTrip(t, asserted))
+			if err != nil {
+				t.Fatalf("VerifyAssertion failed: %v", err)
+			}
+		}
+
+		// A clone of the authenticator which was made before the last logins
+		authenticator.credentials[string(credential.ID)].signCount = 1
+
+		challenge := newTestChallenge(t)
+		asserted, err := authenticator.Login(rp.RequestOptions(challenge, allow))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = rp.VerifyAssertion(challenge, credential.PublicKey, signCount, roundTrip(t, asserted))
+		if !errors.Is(err, ErrSignCount) {
+			t.Fatalf("got error %v for sign count 2 after %d; want ErrSignCount", err, signCount)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	HeaderEvent     = "X-Semaphore-Event"
+	HeaderDelivery  = "X-Semaphore-Delivery"
+	HeaderTimestamp = "X-Semaphore-Timestamp"
+	HeaderSignature = "X-Semaphore-Signature"
+
+	// maxResponseBody is the number of bytes of the response body kept in the delivery log
+	maxResponseBody = 1024
+)
+
+var ErrPrivateAddress = errors.New("webhooks: endpoint resolves to a private address")
+
+// Message is a webhook request body along with the event and delivery it belongs to
+type Message struct {
+	DeliveryID int64
+	Event      string
+	Body       []byte
+}
+
+// Result is the outcome of a delivery attempt which got a response
+type Result struct {
+	StatusCode int
+	Body       string
+	Duration   time.Duration
+}
+
+// OK reports whether the endpoint accepted the message
+func (r Result) OK() bool {
+	return r.StatusCode >= 200 && r.StatusCode <= 299
+}
+
+// Sender posts signed messages to webhook endpoints. Endpoints of users may only resolve to
+// public addresses, so that webhooks cannot be used to reach the internal network of the
+// server. Redirects are not followed.
+type Sender struct {
+	publicClient   *http.Client
+	internalClient *http.Client
+	userAgent      string
+}
+
+func NewSender(userAgent string) *Sender {
+	publicDialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	publicTransport := http.DefaultTransport.(*http.Transport).Clone()
+	publicTransport.Proxy = nil
+	publicTransport.DialContext = publicDialer.DialContext
+
+	noRedirects := func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Sender{
+		publicClient: &http.Client{
+			Timeout:       10 * time.Second,
+			Transport:     publicTransport,
+			CheckRedirect: noRedirects,
+		},
+		internalClient: &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: noRedirects,
+		},
+		userAgent: userAgent,
+	}
+}
+
+// Send posts a message to an endpoint, signed with the secret of the endpoint. An error is
+// returned only if no response was received. allowInternal permits endpoints on private
+// addresses, and is meant for the system-wide endpoints managed by admins. Messages which do
+// not belong to a logged delivery, or are sent to endpoints without a secret, are sent without
+// the delivery and signature headers.
+func (s *Sender) Send(ctx context.Context, url, secret string, msg Message, allowInternal bool) (Result, error) {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(msg.Body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.userAgent != "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+	req.Header.Set(HeaderEvent, msg.Event)
+	if msg.DeliveryID != 0 {
+		req.Header.Set(HeaderDelivery, strconv.FormatInt(msg.DeliveryID, 10))
+	}
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, timestamp, msg.Body))
+	}
+
+	client := s.publicClient
+	if allowInternal {
+		client = s.internalClient
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	// Drain the rest of the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	return Result{
+		StatusCode: res.StatusCode,
+		Body:       string(bytes.ToValidUTF8(body, nil)),
+		Duration:   time.Since(start),
+	}, nil
+}
+
+// Sign returns the signature of a message body sent at the given unix timestamp. Receivers
+// verify a message by computing the HMAC-SHA256 of "<timestamp>.<body>" with the secret of the
+// endpoint and comparing it with the X-Semaphore-Signature header.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random secret for signing the messages of an endpoint
+func NewSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS feed_recommendations (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    feed_id bigint NOT NULL REFERENCES feeds ON DELETE CASCADE,
+    PRIMARY KEY (user_id, feed_id),
+    score double precision NOT NULL,
+    co_follow_score double precision NOT NULL DEFAULT 0,
+    topic_score double precision NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS feed_recommendations_user_id_score_idx ON feed_recommendations (user_id, score DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS feed_recommendations_user_id_score_idx;
+DROP TABLE IF EXISTS feed_recommendations;
+-- +goose StatementEnd
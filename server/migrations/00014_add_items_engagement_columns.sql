@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE items ADD COLUMN like_count integer NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN save_count integer NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN hot_score double precision NOT NULL DEFAULT 0;
+
+UPDATE items
+SET like_count = lc.like_count
+FROM (SELECT item_id, COUNT(*) AS like_count FROM liked_items GROUP BY item_id) lc
+WHERE lc.item_id = items.id;
+
+UPDATE items
+SET save_count = sc.save_count
+FROM (SELECT item_id, COUNT(*) AS save_count FROM saved_items GROUP BY item_id) sc
+WHERE sc.item_id = items.id;
+
+-- Same formula as buildHotItemsScoreCalculationQuery
+UPDATE items
+SET hot_score = (1 + LOG(like_count + 1) * 1 + LOG(save_count + 1) * 3)::float /
+    POWER(EXTRACT(EPOCH FROM (NOW() - LEAST(COALESCE(pub_date, created_at), created_at)))/3600 + 10, 1.5);
+
+CREATE INDEX IF NOT EXISTS items_feed_id_hot_score_idx ON items (feed_id, hot_score DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS items_feed_id_hot_score_idx;
+
+ALTER TABLE items DROP COLUMN hot_score;
+ALTER TABLE items DROP COLUMN save_count;
+ALTER TABLE items DROP COLUMN like_count;
+-- +goose StatementEnd
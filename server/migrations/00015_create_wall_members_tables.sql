@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS wall_members (
+    wall_id bigint NOT NULL REFERENCES walls ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (wall_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS wall_members_user_id_idx ON wall_members(user_id);
+
+INSERT INTO wall_members (wall_id, user_id, role)
+SELECT id, user_id, 'owner' FROM walls
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS wall_invitations (
+    id bigserial PRIMARY KEY,
+    wall_id bigint NOT NULL REFERENCES walls ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('editor', 'viewer')),
+    invited_by bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (wall_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS wall_invitations_user_id_idx ON wall_invitations(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS wall_invitations_user_id_idx;
+DROP TABLE IF EXISTS wall_invitations;
+DROP INDEX IF EXISTS wall_members_user_id_idx;
+DROP TABLE IF EXISTS wall_members;
+-- +goose StatementEnd
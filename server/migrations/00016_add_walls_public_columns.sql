@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE walls ADD COLUMN is_public bool NOT NULL DEFAULT false;
+ALTER TABLE walls ADD COLUMN public_id text UNIQUE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE walls DROP COLUMN public_id;
+ALTER TABLE walls DROP COLUMN is_public;
+-- +goose StatementEnd
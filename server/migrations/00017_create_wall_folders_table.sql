@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS wall_folders (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    parent_id bigint REFERENCES wall_folders ON DELETE SET NULL,
+    name text NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS wall_folders_user_id_idx ON wall_folders (user_id);
+
+ALTER TABLE walls ADD COLUMN emoji text;
+ALTER TABLE walls ADD COLUMN color text;
+ALTER TABLE walls ADD COLUMN icon text;
+
+-- Pins, positions and folders are specific to each member of a wall
+ALTER TABLE wall_members ADD COLUMN is_pinned bool NOT NULL DEFAULT false;
+ALTER TABLE wall_members ADD COLUMN position integer NOT NULL DEFAULT 0;
+ALTER TABLE wall_members ADD COLUMN folder_id bigint REFERENCES wall_folders ON DELETE SET NULL;
+
+UPDATE wall_members wm
+SET is_pinned = w.is_pinned
+FROM walls w
+WHERE w.id = wm.wall_id AND wm.role = 'owner';
+
+ALTER TABLE walls DROP COLUMN is_pinned;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE walls ADD COLUMN is_pinned bool NOT NULL DEFAULT false;
+
+UPDATE walls w
+SET is_pinned = wm.is_pinned
+FROM wall_members wm
+WHERE wm.wall_id = w.id AND wm.role = 'owner';
+
+ALTER TABLE wall_members DROP COLUMN folder_id;
+ALTER TABLE wall_members DROP COLUMN position;
+ALTER TABLE wall_members DROP COLUMN is_pinned;
+
+ALTER TABLE walls DROP COLUMN icon;
+ALTER TABLE walls DROP COLUMN color;
+ALTER TABLE walls DROP COLUMN emoji;
+
+DROP TABLE IF EXISTS wall_folders;
+-- +goose StatementEnd
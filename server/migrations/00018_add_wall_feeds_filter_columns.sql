@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE wall_feeds ADD COLUMN is_muted bool NOT NULL DEFAULT false;
+ALTER TABLE wall_feeds ADD COLUMN categories text[] NOT NULL DEFAULT '{}';
+ALTER TABLE wall_feeds ADD COLUMN keywords text[] NOT NULL DEFAULT '{}';
+ALTER TABLE wall_feeds ADD COLUMN authors text[] NOT NULL DEFAULT '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE wall_feeds DROP COLUMN authors;
+ALTER TABLE wall_feeds DROP COLUMN keywords;
+ALTER TABLE wall_feeds DROP COLUMN categories;
+ALTER TABLE wall_feeds DROP COLUMN is_muted;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS read_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    item_id bigint NOT NULL REFERENCES items ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS read_items_item_id_idx ON read_items (item_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS read_items_item_id_idx;
+DROP TABLE IF EXISTS read_items;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_change_sequences (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    seq bigint NOT NULL DEFAULT 0,
+    pruned_seq bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS user_changes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    seq bigint NOT NULL,
+    entity text NOT NULL,
+    entity_id bigint NOT NULL,
+    op text NOT NULL CHECK (op IN ('upsert', 'delete')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX IF NOT EXISTS user_changes_created_at_idx ON user_changes (created_at);
+CREATE INDEX IF NOT EXISTS items_feed_id_updated_at_idx ON items (feed_id, updated_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS items_feed_id_updated_at_idx;
+DROP INDEX IF EXISTS user_changes_created_at_idx;
+DROP TABLE IF EXISTS user_changes;
+DROP TABLE IF EXISTS user_change_sequences;
+-- +goose StatementEnd
@@ -0,0 +1,64 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS notification_settings (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    channels text[] NOT NULL DEFAULT '{push}',
+    quiet_hours_start text CHECK (quiet_hours_start ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$'),
+    quiet_hours_end text CHECK (quiet_hours_end ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$'),
+    timezone text NOT NULL DEFAULT 'UTC',
+    max_per_hour integer NOT NULL DEFAULT 10,
+    webhook_url text,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS notification_subscriptions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    feed_id bigint REFERENCES feeds ON DELETE CASCADE,
+    keywords text[] NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (feed_id IS NOT NULL OR cardinality(keywords) > 0)
+);
+CREATE INDEX IF NOT EXISTS notification_subscriptions_user_id_idx ON notification_subscriptions (user_id);
+CREATE INDEX IF NOT EXISTS notification_subscriptions_feed_id_idx ON notification_subscriptions (feed_id);
+
+CREATE TABLE IF NOT EXISTS push_devices (
+    token text PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    platform text NOT NULL CHECK (platform IN ('android', 'ios', 'web')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS push_devices_user_id_idx ON push_devices (user_id);
+
+CREATE TABLE IF NOT EXISTS notification_jobs (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    item_id bigint NOT NULL REFERENCES items ON DELETE CASCADE,
+    channel text NOT NULL CHECK (channel IN ('push', 'email', 'webhook')),
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'skipped', 'failed')),
+    attempts integer NOT NULL DEFAULT 0,
+    run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error text,
+    sent_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, item_id, channel)
+);
+CREATE INDEX IF NOT EXISTS notification_jobs_pending_run_at_idx ON notification_jobs (run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS notification_jobs_sent_at_idx ON notification_jobs (user_id, channel, sent_at) WHERE status = 'sent';
+CREATE INDEX IF NOT EXISTS notification_jobs_item_id_idx ON notification_jobs (item_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS notification_jobs_item_id_idx;
+DROP INDEX IF EXISTS notification_jobs_sent_at_idx;
+DROP INDEX IF EXISTS notification_jobs_pending_run_at_idx;
+DROP TABLE IF EXISTS notification_jobs;
+DROP INDEX IF EXISTS push_devices_user_id_idx;
+DROP TABLE IF EXISTS push_devices;
+DROP INDEX IF EXISTS notification_subscriptions_feed_id_idx;
+DROP INDEX IF EXISTS notification_subscriptions_user_id_idx;
+DROP TABLE IF EXISTS notification_subscriptions;
+DROP TABLE IF EXISTS notification_settings;
+-- +goose StatementEnd
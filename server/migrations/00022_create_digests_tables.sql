@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS digest_subscriptions (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    frequency text NOT NULL CHECK (frequency IN ('daily', 'weekly')),
+    content text NOT NULL DEFAULT 'unread' CHECK (content IN ('unread', 'hot')),
+    wall_ids bigint[] NOT NULL DEFAULT '{}',
+    timezone text NOT NULL DEFAULT 'UTC',
+    send_hour integer NOT NULL DEFAULT 8 CHECK (send_hour BETWEEN 0 AND 23),
+    send_weekday integer NOT NULL DEFAULT 1 CHECK (send_weekday BETWEEN 0 AND 6),
+    max_items integer NOT NULL DEFAULT 10,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS digest_sends (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    period_start timestamp(0) with time zone NOT NULL,
+    period_end timestamp(0) with time zone NOT NULL,
+    status text NOT NULL DEFAULT 'sending' CHECK (status IN ('sending', 'sent', 'empty', 'failed')),
+    attempts integer NOT NULL DEFAULT 1,
+    item_count integer NOT NULL DEFAULT 0,
+    last_error text,
+    sent_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, period_end)
+);
+CREATE INDEX IF NOT EXISTS digest_sends_created_at_idx ON digest_sends (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS digest_sends;
+DROP TABLE IF EXISTS digest_subscriptions;
+-- +goose StatementEnd
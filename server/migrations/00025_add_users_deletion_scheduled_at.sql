@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN deletion_scheduled_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_at_idx ON users (deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+-- Feeds are shared by all of their followers, so deleting the user who added a feed must not
+-- delete the feed
+ALTER TABLE feeds DROP CONSTRAINT IF EXISTS feeds_added_by_fkey;
+ALTER TABLE feeds ADD CONSTRAINT feeds_added_by_fkey FOREIGN KEY (added_by) REFERENCES users ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE feeds DROP CONSTRAINT IF EXISTS feeds_added_by_fkey;
+ALTER TABLE feeds ADD CONSTRAINT feeds_added_by_fkey FOREIGN KEY (added_by) REFERENCES users ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS users_deletion_scheduled_at_idx;
+ALTER TABLE users DROP COLUMN deletion_scheduled_at;
+-- +goose StatementEnd
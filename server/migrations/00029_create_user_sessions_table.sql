@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    ip_address text NOT NULL DEFAULT '',
+    expiry timestamp(0) with time zone NOT NULL,
+    last_used_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS user_sessions_user_id_idx ON user_sessions (user_id);
+
+-- Refresh and authentication tokens belong to the session they were issued for. Used refresh
+-- tokens are kept until they expire, so that their reuse can be detected.
+ALTER TABLE tokens ADD COLUMN session_id bigint REFERENCES user_sessions ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN used_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS tokens_session_id_idx ON tokens (session_id) WHERE session_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS tokens_session_id_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS used_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS user_sessions;
+-- +goose StatementEnd